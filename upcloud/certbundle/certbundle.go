@@ -0,0 +1,367 @@
+// Package certbundle validates the certificates and private keys of load balancer certificate bundles before they are
+// sent to the API, which rejects malformed input late and without details.
+//
+// Validation decodes and parses the certificate, the intermediates and the private key, checks that the private key
+// matches the certificate and that each certificate of the chain is signed by the next one, and compares the subject
+// alternative names of the certificate with the intended hostnames. The findings are returned as diagnostics.
+package certbundle
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// DefaultMinValidity is the remaining validity below which a warning is given when Options.MinValidity is not set
+const DefaultMinValidity = 30 * 24 * time.Hour
+
+// ErrInvalidCertificateBundle is returned by Diagnostics.Err when any of the diagnostics is an error
+var ErrInvalidCertificateBundle = errors.New("invalid certificate bundle")
+
+// Severity represents the severity of a diagnostic
+type Severity int
+
+const (
+	// SeverityWarning is used for findings the API accepts but that are likely mistakes, e.g. a certificate about to
+	// expire
+	SeverityWarning Severity = iota
+	// SeverityError is used for findings that make the bundle unusable, e.g. a private key that does not match the
+	// certificate
+	SeverityError
+)
+
+// String returns the severity in lower case
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Code identifies the kind of a diagnostic
+type Code string
+
+const (
+	// CodeInvalidEncoding is used for input that is not base64 or PEM encoded
+	CodeInvalidEncoding Code = "invalid-encoding"
+	// CodeInvalidCertificate is used for certificates that cannot be parsed
+	CodeInvalidCertificate Code = "invalid-certificate"
+	// CodeInvalidPrivateKey is used for private keys that cannot be parsed
+	CodeInvalidPrivateKey Code = "invalid-private-key"
+	// CodeKeyMismatch is used when the private key does not match the certificate
+	CodeKeyMismatch Code = "key-mismatch"
+	// CodeChainOrder is used when a certificate of the chain is not signed by the next certificate
+	CodeChainOrder Code = "chain-order"
+	// CodeRootInChain is used when the intermediates contain a self-signed root certificate
+	CodeRootInChain Code = "root-in-chain"
+	// CodeNoSubjectAltNames is used for certificates without subject alternative names
+	CodeNoSubjectAltNames Code = "no-subject-alt-names"
+	// CodeHostnameMismatch is used for intended hostnames the certificate is not valid for
+	CodeHostnameMismatch Code = "hostname-mismatch"
+	// CodeNotYetValid is used for certificates whose validity period has not started
+	CodeNotYetValid Code = "not-yet-valid"
+	// CodeExpired is used for expired certificates
+	CodeExpired Code = "expired"
+	// CodeShortValidity is used for certificates expiring within the minimum validity
+	CodeShortValidity Code = "short-validity"
+)
+
+// Fields of the certificate bundle the diagnostics refer to
+const (
+	FieldCertificate   = "certificate"
+	FieldIntermediates = "intermediates"
+	FieldPrivateKey    = "private_key"
+)
+
+// Diagnostic represents a finding of the validation
+type Diagnostic struct {
+	Severity Severity
+	Code     Code
+	// Field is the field of the bundle the diagnostic refers to, e.g. FieldCertificate
+	Field   string
+	Message string
+}
+
+// String returns the diagnostic in a compiler-like format
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s: %s (%s)", d.Field, d.Severity, d.Message, d.Code)
+}
+
+// Diagnostics is a list of diagnostics
+type Diagnostics []Diagnostic
+
+// HasErrors tells whether any of the diagnostics is an error
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Err returns an error wrapping ErrInvalidCertificateBundle with the messages of the errors, or nil if none of the
+// diagnostics is an error
+func (d Diagnostics) Err() error {
+	var messages []string
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			messages = append(messages, diagnostic.Field+": "+diagnostic.Message)
+		}
+	}
+	if len(messages) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrInvalidCertificateBundle, strings.Join(messages, "; "))
+}
+
+// String returns the diagnostics one per line
+func (d Diagnostics) String() string {
+	var sb strings.Builder
+	for _, diagnostic := range d {
+		sb.WriteString(diagnostic.String() + "\n")
+	}
+	return sb.String()
+}
+
+// Options represents the expectations of a validation
+type Options struct {
+	// Hostnames are the hostnames the certificate is intended for. Wildcard certificates cover the hostnames of
+	// their wildcard.
+	Hostnames []string
+	// MinValidity is the remaining validity below which a warning is given. Defaults to DefaultMinValidity.
+	MinValidity time.Duration
+	// Now is the time the validity is checked at. Defaults to the current time.
+	Now time.Time
+}
+
+// Bundle represents the parsed contents of a certificate bundle
+type Bundle struct {
+	Certificate   *x509.Certificate
+	Intermediates []*x509.Certificate
+	PrivateKey    crypto.Signer
+	// Hostnames contains the DNS names and IP addresses of the subject alternative names of the certificate
+	Hostnames []string
+}
+
+// Validate validates PEM encoded certificate, intermediates and private key. Intermediates are optional and ordered
+// from the issuer of the certificate towards the root. The parsed bundle is returned when the certificate can be
+// parsed, even if there are errors.
+func Validate(certificate, intermediates, privateKey []byte, opts Options) (*Bundle, Diagnostics) {
+	v := &validation{opts: opts}
+	if v.opts.MinValidity == 0 {
+		v.opts.MinValidity = DefaultMinValidity
+	}
+	if v.opts.Now.IsZero() {
+		v.opts.Now = time.Now()
+	}
+
+	certs := v.parseCertificates(FieldCertificate, certificate)
+	if len(certs) == 0 {
+		return nil, v.diagnostics
+	}
+	if len(certs) > 1 {
+		v.add(SeverityError, CodeInvalidCertificate, FieldCertificate, fmt.Sprintf("expected one certificate, found %d; intermediates belong to the intermediates", len(certs)))
+	}
+	bundle := &Bundle{Certificate: certs[0]}
+	if len(intermediates) > 0 {
+		bundle.Intermediates = v.parseCertificates(FieldIntermediates, intermediates)
+	}
+	if len(privateKey) == 0 {
+		v.add(SeverityError, CodeInvalidPrivateKey, FieldPrivateKey, "private key is required")
+	} else {
+		bundle.PrivateKey = v.parsePrivateKey(privateKey)
+	}
+
+	v.checkKey(bundle)
+	v.checkChain(bundle)
+	bundle.Hostnames = v.checkHostnames(bundle.Certificate)
+	v.checkValidity(FieldCertificate, bundle.Certificate, true)
+	for _, cert := range bundle.Intermediates {
+		v.checkValidity(FieldIntermediates, cert, false)
+	}
+	return bundle, v.diagnostics
+}
+
+// ValidateRequest validates the base64 encoded certificate, intermediates and private key of a request to create a
+// manual certificate bundle. Authority bundles only have their certificates parsed, and dynamic bundles are not
+// validated.
+func ValidateRequest(r *request.CreateLoadBalancerCertificateBundleRequest, opts Options) (*Bundle, Diagnostics) {
+	if r.Type == upcloud.LoadBalancerCertificateBundleTypeDynamic {
+		return nil, nil
+	}
+	v := &validation{}
+	certificate := v.decodeBase64(FieldCertificate, r.Certificate)
+	if r.Type == upcloud.LoadBalancerCertificateBundleTypeAuthority {
+		certs := v.parseCertificates(FieldCertificate, certificate)
+		if len(certs) == 0 {
+			return nil, v.diagnostics
+		}
+		return &Bundle{Certificate: certs[0], Intermediates: certs[1:]}, v.diagnostics
+	}
+	intermediates := v.decodeBase64(FieldIntermediates, r.Intermediates)
+	privateKey := v.decodeBase64(FieldPrivateKey, r.PrivateKey)
+	if v.diagnostics.HasErrors() {
+		return nil, v.diagnostics
+	}
+	return Validate(certificate, intermediates, privateKey, opts)
+}
+
+type validation struct {
+	opts        Options
+	diagnostics Diagnostics
+}
+
+func (v *validation) add(severity Severity, code Code, field, message string) {
+	v.diagnostics = append(v.diagnostics, Diagnostic{Severity: severity, Code: code, Field: field, Message: message})
+}
+
+func (v *validation) decodeBase64(field, s string) []byte {
+	if s == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		v.add(SeverityError, CodeInvalidEncoding, field, "value is not base64 encoded")
+		return nil
+	}
+	return data
+}
+
+func (v *validation) parseCertificates(field string, data []byte) []*x509.Certificate {
+	var certs []*x509.Certificate
+	for n := 1; ; n++ {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			v.add(SeverityError, CodeInvalidEncoding, field, fmt.Sprintf("PEM block %d is %q, expected \"CERTIFICATE\"", n, block.Type))
+			return nil
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			v.add(SeverityError, CodeInvalidCertificate, field, fmt.Sprintf("certificate %d: %s", n, err))
+			return nil
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		v.add(SeverityError, CodeInvalidEncoding, field, "no PEM encoded certificates found")
+	}
+	return certs
+}
+
+func (v *validation) parsePrivateKey(data []byte) crypto.Signer {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		v.add(SeverityError, CodeInvalidEncoding, FieldPrivateKey, "no PEM encoded private key found")
+		return nil
+	}
+	var key any
+	var err error
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	default:
+		v.add(SeverityError, CodeInvalidEncoding, FieldPrivateKey, fmt.Sprintf("PEM block is %q, expected a private key", block.Type))
+		return nil
+	}
+	if err != nil {
+		v.add(SeverityError, CodeInvalidPrivateKey, FieldPrivateKey, err.Error())
+		return nil
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		v.add(SeverityError, CodeInvalidPrivateKey, FieldPrivateKey, fmt.Sprintf("unsupported private key type %T", key))
+		return nil
+	}
+	return signer
+}
+
+func (v *validation) checkKey(bundle *Bundle) {
+	if bundle.PrivateKey == nil {
+		return
+	}
+	public, ok := bundle.PrivateKey.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(bundle.Certificate.PublicKey) {
+		v.add(SeverityError, CodeKeyMismatch, FieldPrivateKey, "private key does not match the certificate")
+	}
+}
+
+// checkChain checks that each certificate is signed by the next one. When the issuer is elsewhere in the
+// intermediates, the intermediates are out of order.
+func (v *validation) checkChain(bundle *Bundle) {
+	chain := append([]*x509.Certificate{bundle.Certificate}, bundle.Intermediates...)
+	for i := 0; i < len(chain)-1; i++ {
+		cert, next := chain[i], chain[i+1]
+		if cert.CheckSignatureFrom(next) == nil {
+			continue
+		}
+		message := fmt.Sprintf("%s is not signed by the next certificate %s", describe(cert), describe(next))
+		for j, issuer := range chain[1:] {
+			if j+1 != i && j+1 != i+1 && cert.CheckSignatureFrom(issuer) == nil {
+				message = fmt.Sprintf("%s is signed by intermediate %d %s; intermediates must be ordered from the issuer of the certificate towards the root", describe(cert), j+1, describe(issuer))
+				break
+			}
+		}
+		v.add(SeverityError, CodeChainOrder, FieldIntermediates, message)
+	}
+	for _, cert := range bundle.Intermediates {
+		if cert.CheckSignatureFrom(cert) == nil {
+			v.add(SeverityWarning, CodeRootInChain, FieldIntermediates, fmt.Sprintf("root certificate %s does not need to be included", describe(cert)))
+		}
+	}
+}
+
+// checkHostnames compares the subject alternative names of the certificate with the intended hostnames and returns
+// the subject alternative names
+func (v *validation) checkHostnames(cert *x509.Certificate) []string {
+	hostnames := append([]string{}, cert.DNSNames...)
+	for _, ip := range cert.IPAddresses {
+		hostnames = append(hostnames, ip.String())
+	}
+	if len(hostnames) == 0 {
+		v.add(SeverityWarning, CodeNoSubjectAltNames, FieldCertificate, "certificate has no subject alternative names; clients do not accept the common name as a hostname")
+	}
+	for _, hostname := range v.opts.Hostnames {
+		if err := cert.VerifyHostname(hostname); err != nil {
+			v.add(SeverityError, CodeHostnameMismatch, FieldCertificate, fmt.Sprintf("certificate is not valid for %s", hostname))
+		}
+	}
+	return hostnames
+}
+
+func (v *validation) checkValidity(field string, cert *x509.Certificate, leaf bool) {
+	now := v.opts.Now
+	switch {
+	case now.Before(cert.NotBefore):
+		v.add(SeverityError, CodeNotYetValid, field, fmt.Sprintf("%s is not valid before %s", describe(cert), cert.NotBefore.UTC().Format(time.RFC3339)))
+	case now.After(cert.NotAfter):
+		v.add(SeverityError, CodeExpired, field, fmt.Sprintf("%s expired at %s", describe(cert), cert.NotAfter.UTC().Format(time.RFC3339)))
+	case leaf && cert.NotAfter.Sub(now) < v.opts.MinValidity:
+		days := int(cert.NotAfter.Sub(now).Hours() / 24)
+		v.add(SeverityWarning, CodeShortValidity, field, fmt.Sprintf("%s expires in %d days", describe(cert), days))
+	}
+}
+
+// describe returns a short description of the certificate for messages
+func describe(cert *x509.Certificate) string {
+	if cert.Subject.CommonName != "" {
+		return fmt.Sprintf("%q", cert.Subject.CommonName)
+	}
+	return fmt.Sprintf("%q", cert.Subject.String())
+}
@@ -0,0 +1,230 @@
+package certbundle
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pem  []byte
+}
+
+func (c testCert) keyPEM(t *testing.T) []byte {
+	t.Helper()
+
+	der, err := x509.MarshalECPrivateKey(c.key)
+	require.NoError(t, err)
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+}
+
+// newCert creates a certificate signed by the parent, or a self-signed certificate if parent is nil
+func newCert(t *testing.T, template *x509.Certificate, parent *testCert) testCert {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template.SerialNumber = big.NewInt(time.Now().UnixNano())
+	if template.NotBefore.IsZero() {
+		template.NotBefore = now.AddDate(0, -1, 0)
+	}
+	if template.NotAfter.IsZero() {
+		template.NotAfter = now.AddDate(1, 0, 0)
+	}
+	issuer, signer := template, key
+	if parent != nil {
+		issuer, signer = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return testCert{cert: cert, key: key, pem: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})}
+}
+
+func newCA(t *testing.T, name string, parent *testCert) testCert {
+	return newCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, parent)
+}
+
+func testChain(t *testing.T) (root, intermediate, leaf testCert) {
+	root = newCA(t, "Root CA", nil)
+	intermediate = newCA(t, "Intermediate CA", &root)
+	leaf = newCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "example.com"},
+		DNSNames:    []string{"example.com", "*.example.com"},
+		IPAddresses: []net.IP{net.ParseIP("192.0.2.1")},
+	}, &intermediate)
+	return root, intermediate, leaf
+}
+
+func codes(diagnostics Diagnostics) []Code {
+	c := make([]Code, 0, len(diagnostics))
+	for _, d := range diagnostics {
+		c = append(c, d.Code)
+	}
+	return c
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	root, intermediate, leaf := testChain(t)
+	bundle, diagnostics := Validate(leaf.pem, intermediate.pem, leaf.keyPEM(t), Options{
+		Hostnames: []string{"example.com", "www.example.com", "192.0.2.1"},
+		Now:       now,
+	})
+	assert.Empty(t, diagnostics)
+	require.NoError(t, diagnostics.Err())
+	assert.Equal(t, []string{"example.com", "*.example.com", "192.0.2.1"}, bundle.Hostnames)
+	assert.Equal(t, leaf.cert, bundle.Certificate)
+	assert.Equal(t, []*x509.Certificate{intermediate.cert}, bundle.Intermediates)
+
+	_, diagnostics = Validate(leaf.pem, append(intermediate.pem, root.pem...), leaf.keyPEM(t), Options{Now: now})
+	assert.Equal(t, []Code{CodeRootInChain}, codes(diagnostics))
+	assert.False(t, diagnostics.HasErrors())
+	assert.Equal(t, `intermediates: warning: root certificate "Root CA" does not need to be included (root-in-chain)`+"\n", diagnostics.String())
+}
+
+func TestValidate_Errors(t *testing.T) {
+	t.Parallel()
+
+	root, intermediate, leaf := testChain(t)
+	other := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "other"}, DNSNames: []string{"other"}}, nil)
+
+	for _, test := range []struct {
+		name          string
+		certificate   []byte
+		intermediates []byte
+		privateKey    []byte
+		opts          Options
+		want          string
+	}{
+		{
+			name:          "chain order",
+			certificate:   leaf.pem,
+			intermediates: append(root.pem, intermediate.pem...),
+			privateKey:    leaf.keyPEM(t),
+			want: `invalid certificate bundle: intermediates: "example.com" is signed by intermediate 2 "Intermediate CA"; intermediates must be ordered from the issuer of the certificate towards the root; ` +
+				`intermediates: "Root CA" is not signed by the next certificate "Intermediate CA"`,
+		},
+		{
+			name:        "key mismatch and hostname",
+			certificate: leaf.pem,
+			privateKey:  other.keyPEM(t),
+			opts:        Options{Hostnames: []string{"example.org", "a.b.example.com"}},
+			want: "invalid certificate bundle: private_key: private key does not match the certificate; " +
+				"certificate: certificate is not valid for example.org; certificate: certificate is not valid for a.b.example.com",
+		},
+		{
+			name:        "several certificates",
+			certificate: append(leaf.pem, intermediate.pem...),
+			privateKey:  leaf.keyPEM(t),
+			want:        "invalid certificate bundle: certificate: expected one certificate, found 2; intermediates belong to the intermediates",
+		},
+		{
+			name:        "invalid key",
+			certificate: leaf.pem,
+			privateKey:  leaf.pem,
+			want:        `invalid certificate bundle: private_key: PEM block is "CERTIFICATE", expected a private key`,
+		},
+		{
+			name:        "expired",
+			certificate: leaf.pem,
+			privateKey:  leaf.keyPEM(t),
+			opts:        Options{Now: now.AddDate(2, 0, 0)},
+			want:        `invalid certificate bundle: certificate: "example.com" expired at 2025-06-01T00:00:00Z`,
+		},
+		{
+			name:        "not a certificate",
+			certificate: []byte("-----BEGIN CERTIFICATE-----\nAAAA\n-----END CERTIFICATE-----\n"),
+			want:        "invalid certificate bundle: certificate: certificate 1: x509: malformed certificate",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			if test.opts.Now.IsZero() {
+				test.opts.Now = now
+			}
+			_, diagnostics := Validate(test.certificate, test.intermediates, test.privateKey, test.opts)
+			err := diagnostics.Err()
+			assert.ErrorIs(t, err, ErrInvalidCertificateBundle)
+			assert.EqualError(t, err, test.want)
+		})
+	}
+}
+
+func TestValidate_Warnings(t *testing.T) {
+	t.Parallel()
+
+	cert := newCert(t, &x509.Certificate{Subject: pkix.Name{CommonName: "example.com"}, NotAfter: now.AddDate(0, 0, 10)}, nil)
+	_, diagnostics := Validate(cert.pem, nil, cert.keyPEM(t), Options{Now: now})
+	assert.Equal(t, Diagnostics{
+		{Severity: SeverityWarning, Code: CodeNoSubjectAltNames, Field: FieldCertificate, Message: "certificate has no subject alternative names; clients do not accept the common name as a hostname"},
+		{Severity: SeverityWarning, Code: CodeShortValidity, Field: FieldCertificate, Message: `"example.com" expires in 10 days`},
+	}, diagnostics)
+	assert.NoError(t, diagnostics.Err())
+
+	_, diagnostics = Validate(cert.pem, nil, cert.keyPEM(t), Options{Now: now, MinValidity: 24 * time.Hour})
+	assert.Equal(t, []Code{CodeNoSubjectAltNames}, codes(diagnostics))
+}
+
+func TestValidateRequest(t *testing.T) {
+	t.Parallel()
+
+	root, intermediate, leaf := testChain(t)
+	encode := base64.StdEncoding.EncodeToString
+
+	bundle, diagnostics := ValidateRequest(&request.CreateLoadBalancerCertificateBundleRequest{
+		Type:          upcloud.LoadBalancerCertificateBundleTypeManual,
+		Certificate:   encode(leaf.pem),
+		Intermediates: encode(intermediate.pem),
+		PrivateKey:    encode(leaf.keyPEM(t)),
+	}, Options{Now: now, Hostnames: []string{"www.example.com"}})
+	assert.Empty(t, diagnostics)
+	assert.Equal(t, leaf.cert, bundle.Certificate)
+
+	_, diagnostics = ValidateRequest(&request.CreateLoadBalancerCertificateBundleRequest{
+		Type:        upcloud.LoadBalancerCertificateBundleTypeManual,
+		Certificate: string(leaf.pem),
+		PrivateKey:  encode(leaf.keyPEM(t)),
+	}, Options{Now: now})
+	assert.EqualError(t, diagnostics.Err(), "invalid certificate bundle: certificate: value is not base64 encoded")
+
+	bundle, diagnostics = ValidateRequest(&request.CreateLoadBalancerCertificateBundleRequest{
+		Type:        upcloud.LoadBalancerCertificateBundleTypeAuthority,
+		Certificate: encode(append(root.pem, intermediate.pem...)),
+	}, Options{})
+	assert.Empty(t, diagnostics)
+	assert.Equal(t, root.cert, bundle.Certificate)
+	assert.Len(t, bundle.Intermediates, 1)
+
+	bundle, diagnostics = ValidateRequest(&request.CreateLoadBalancerCertificateBundleRequest{
+		Type:      upcloud.LoadBalancerCertificateBundleTypeDynamic,
+		Hostnames: []string{"example.com"},
+	}, Options{})
+	assert.Nil(t, bundle)
+	assert.Empty(t, diagnostics)
+}
@@ -0,0 +1,123 @@
+// Package certinventory builds an inventory of the load balancer certificate bundles of an account, joined to the
+// TLS configs using them, finds the bundles that are about to expire and renews manual bundles with a user provided
+// issuer.
+//
+// Dynamic bundles are renewed by UpCloud and authority bundles contain CA certificates, so only manual bundles are
+// renewed. A manual bundle is renewed by creating a new bundle with the issued certificate, pointing the TLS configs
+// using the old bundle to the new one and deleting the old bundle.
+package certinventory
+
+import (
+	"cmp"
+	"context"
+	"slices"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Client is the client needed to collect the inventory.
+type Client interface {
+	GetLoadBalancers(ctx context.Context, r *request.GetLoadBalancersRequest) ([]upcloud.LoadBalancer, error)
+	GetLoadBalancerCertificateBundles(ctx context.Context, r *request.GetLoadBalancerCertificateBundlesRequest) ([]upcloud.LoadBalancerCertificateBundle, error)
+}
+
+// Reference represents a TLS config of a frontend or a backend using a certificate bundle
+type Reference struct {
+	LoadBalancerUUID string
+	LoadBalancerName string
+	// Frontend or Backend is the name of the frontend or backend of the TLS config
+	Frontend  string
+	Backend   string
+	TLSConfig string
+}
+
+// Entry represents a certificate bundle in the inventory
+type Entry struct {
+	Bundle     upcloud.LoadBalancerCertificateBundle
+	References []Reference
+}
+
+// ExpiresWithin tells whether the bundle expires within the given number of days from now, or has already expired
+func (e Entry) ExpiresWithin(now time.Time, days int) bool {
+	return !e.Bundle.NotAfter.IsZero() && e.Bundle.NotAfter.Before(now.AddDate(0, 0, days))
+}
+
+// Unused tells whether no TLS config uses the bundle
+func (e Entry) Unused() bool {
+	return len(e.References) == 0
+}
+
+// Inventory represents the certificate bundles of an account
+type Inventory struct {
+	Entries []Entry
+}
+
+// Collect builds the inventory from the certificate bundles of the account. The bundles are joined to the frontend
+// and backend TLS configs of all load balancers by the bundle UUID. The entries are ordered by expiry time.
+func Collect(ctx context.Context, c Client) (*Inventory, error) {
+	bundles, err := c.GetLoadBalancerCertificateBundles(ctx, &request.GetLoadBalancerCertificateBundlesRequest{})
+	if err != nil {
+		return nil, err
+	}
+	loadBalancers, err := c.GetLoadBalancers(ctx, &request.GetLoadBalancersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	references := make(map[string][]Reference)
+	for _, lb := range loadBalancers {
+		for _, frontend := range lb.Frontends {
+			for _, tlsConfig := range frontend.TLSConfigs {
+				references[tlsConfig.CertificateBundleUUID] = append(references[tlsConfig.CertificateBundleUUID], Reference{
+					LoadBalancerUUID: lb.UUID,
+					LoadBalancerName: lb.Name,
+					Frontend:         frontend.Name,
+					TLSConfig:        tlsConfig.Name,
+				})
+			}
+		}
+		for _, backend := range lb.Backends {
+			for _, tlsConfig := range backend.TLSConfigs {
+				references[tlsConfig.CertificateBundleUUID] = append(references[tlsConfig.CertificateBundleUUID], Reference{
+					LoadBalancerUUID: lb.UUID,
+					LoadBalancerName: lb.Name,
+					Backend:          backend.Name,
+					TLSConfig:        tlsConfig.Name,
+				})
+			}
+		}
+	}
+
+	inventory := &Inventory{Entries: make([]Entry, 0, len(bundles))}
+	for _, bundle := range bundles {
+		inventory.Entries = append(inventory.Entries, Entry{Bundle: bundle, References: references[bundle.UUID]})
+	}
+	slices.SortStableFunc(inventory.Entries, func(a, b Entry) int {
+		return cmp.Or(a.Bundle.NotAfter.Compare(b.Bundle.NotAfter), cmp.Compare(a.Bundle.Name, b.Bundle.Name))
+	})
+	return inventory, nil
+}
+
+// Entry returns the entry of the bundle
+func (i *Inventory) Entry(bundleUUID string) (Entry, bool) {
+	for _, entry := range i.Entries {
+		if entry.Bundle.UUID == bundleUUID {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// ExpiringWithin returns the bundles that expire within the given number of days from now, including the bundles
+// that have already expired
+func (i *Inventory) ExpiringWithin(now time.Time, days int) []Entry {
+	var entries []Entry
+	for _, entry := range i.Entries {
+		if entry.ExpiresWithin(now, days) {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,207 @@
+package certinventory
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ Client  = (*service.Service)(nil)
+	_ Renewer = (*service.Service)(nil)
+)
+
+var now = time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+
+func testCertificate(t *testing.T, notAfter time.Time, hostnames ...string) Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hostnames[0]},
+		DNSNames:     hostnames,
+		NotBefore:    notAfter.AddDate(0, -3, 0),
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	return Certificate{
+		Certificate: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		PrivateKey:  pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+var testResources = fakeservice.Resources{
+	LoadBalancers: []upcloud.LoadBalancer{{
+		UUID: "lb-1",
+		Name: "web",
+		Frontends: []upcloud.LoadBalancerFrontend{
+			{Name: "https", TLSConfigs: []upcloud.LoadBalancerFrontendTLSConfig{
+				{Name: "example", CertificateBundleUUID: "manual-1"},
+				{Name: "dynamic", CertificateBundleUUID: "dynamic-1"},
+			}},
+		},
+		Backends: []upcloud.LoadBalancerBackend{
+			{Name: "api", TLSConfigs: []upcloud.LoadBalancerBackendTLSConfig{{Name: "client", CertificateBundleUUID: "manual-1"}}},
+		},
+	}},
+	CertificateBundles: []upcloud.LoadBalancerCertificateBundle{
+		{UUID: "unused", Name: "unused", Type: upcloud.LoadBalancerCertificateBundleTypeManual, NotAfter: now.AddDate(1, 0, 0)},
+		{UUID: "dynamic-1", Name: "dynamic", Type: upcloud.LoadBalancerCertificateBundleTypeDynamic, NotAfter: now.AddDate(0, 0, 5)},
+		{UUID: "manual-1", Name: "example-20240610", Type: upcloud.LoadBalancerCertificateBundleTypeManual, NotAfter: now.AddDate(0, 0, 9)},
+		{UUID: "authority", Name: "ca", Type: upcloud.LoadBalancerCertificateBundleTypeAuthority},
+	},
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	inventory, err := Collect(context.Background(), fakeservice.New(testResources))
+	require.NoError(t, err)
+	names := make([]string, 0, len(inventory.Entries))
+	for _, entry := range inventory.Entries {
+		names = append(names, entry.Bundle.Name)
+	}
+	assert.Equal(t, []string{"ca", "dynamic", "example-20240610", "unused"}, names)
+
+	entry, ok := inventory.Entry("manual-1")
+	require.True(t, ok)
+	assert.Equal(t, []Reference{
+		{LoadBalancerUUID: "lb-1", LoadBalancerName: "web", Frontend: "https", TLSConfig: "example"},
+		{LoadBalancerUUID: "lb-1", LoadBalancerName: "web", Backend: "api", TLSConfig: "client"},
+	}, entry.References)
+
+	unused, _ := inventory.Entry("unused")
+	assert.True(t, unused.Unused())
+
+	expiring := inventory.ExpiringWithin(now, 7)
+	require.Len(t, expiring, 1)
+	assert.Equal(t, "dynamic", expiring[0].Bundle.Name)
+	assert.Len(t, inventory.ExpiringWithin(now, 30), 2)
+}
+
+func TestRenewExpiring(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	inventory, err := Collect(context.Background(), c)
+	require.NoError(t, err)
+
+	renewals, err := inventory.RenewExpiring(context.Background(), c, nil, now, 30, true)
+	require.NoError(t, err)
+	require.Len(t, renewals, 1)
+	assert.Equal(t, "example-20240610 (expires 2024-06-10)", renewals[0].String())
+	assert.Empty(t, c.Calls("CreateLoadBalancerCertificateBundle"))
+
+	var issued []string
+	notAfter := time.Now().AddDate(0, 3, 0)
+	issuer := func(_ context.Context, bundle upcloud.LoadBalancerCertificateBundle) (Certificate, error) {
+		issued = append(issued, bundle.Name)
+		return testCertificate(t, notAfter, "example.com"), nil
+	}
+	renewals, err = inventory.RenewExpiring(context.Background(), c, issuer, now, 30, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example-20240610"}, issued)
+	require.Len(t, renewals, 1)
+	require.NotNil(t, renewals[0].New)
+	newUUID := renewals[0].New.UUID
+	assert.Equal(t, []string{
+		"CreateLoadBalancerCertificateBundle example-" + notAfter.UTC().Format("20060102"),
+		"ModifyLoadBalancerFrontendTLSConfig lb-1/https/example",
+		"ModifyLoadBalancerBackendTLSConfig lb-1/api/client",
+		"DeleteLoadBalancerCertificateBundle manual-1",
+	}, c.Calls("CreateLoadBalancerCertificateBundle", "ModifyLoadBalancerFrontendTLSConfig", "ModifyLoadBalancerBackendTLSConfig", "DeleteLoadBalancerCertificateBundle"))
+	assert.Equal(t, newUUID, c.LoadBalancers[0].Frontends[0].TLSConfigs[0].CertificateBundleUUID)
+	assert.Equal(t, newUUID, c.LoadBalancers[0].Backends[0].TLSConfigs[0].CertificateBundleUUID)
+	assert.True(t, renewals[0].Deleted)
+	assert.Equal(t, "example-20240610 (expires 2024-06-10) -> "+renewals[0].New.Name+" (expires "+notAfter.UTC().Format(time.DateOnly)+"), 2 TLS configs swapped", renewals[0].String())
+
+	entry, ok := inventory.Entry(newUUID)
+	require.True(t, ok)
+	assert.Len(t, entry.References, 2)
+	assert.Len(t, inventory.ExpiringWithin(now, 30), 1)
+}
+
+func TestRotate_Errors(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	c.Errors = map[string]error{"ModifyLoadBalancerBackendTLSConfig": errors.New("not found")}
+	inventory, err := Collect(context.Background(), c)
+	require.NoError(t, err)
+	entry, _ := inventory.Entry("manual-1")
+
+	notAfter := time.Now().AddDate(0, 3, 0)
+	renewal := Rotate(context.Background(), c, entry, testCertificate(t, notAfter, "example.com"))
+	assert.EqualError(t, renewal.Error, "modifying TLS config client of backend api of web: not found\nold certificate bundle manual-1 is kept")
+	assert.False(t, renewal.Deleted)
+	assert.Len(t, renewal.Swapped, 1)
+	assert.NotContains(t, c.Calls(), "DeleteLoadBalancerCertificateBundle manual-1")
+
+	mismatched := testCertificate(t, notAfter, "example.com")
+	mismatched.PrivateKey = testCertificate(t, notAfter, "example.com").PrivateKey
+	renewal = Rotate(context.Background(), c, entry, mismatched)
+	assert.EqualError(t, renewal.Error, "invalid certificate bundle: private_key: private key does not match the certificate")
+	assert.Nil(t, renewal.New)
+}
+
+func TestCheckPEM(t *testing.T) {
+	t.Parallel()
+
+	cert := testCertificate(t, now, "example.com", "www.example.com")
+	parsed, err := CheckPEM(cert)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, parsed.DNSNames)
+
+	_, err = CheckPEM(Certificate{Certificate: []byte("not a certificate"), PrivateKey: cert.PrivateKey})
+	assert.EqualError(t, err, "invalid certificate bundle: certificate: no PEM encoded certificates found")
+
+	_, err = CheckPEM(Certificate{Certificate: cert.Certificate, Intermediates: cert.PrivateKey, PrivateKey: cert.PrivateKey})
+	assert.EqualError(t, err, `invalid certificate bundle: intermediates: PEM block 1 is "PRIVATE KEY", expected "CERTIFICATE"`)
+
+	_, err = CheckPEM(Certificate{Certificate: cert.Certificate})
+	assert.EqualError(t, err, "invalid certificate bundle: private_key: private key is required")
+
+	other := testCertificate(t, now, "example.com")
+	_, err = CheckPEM(Certificate{Certificate: cert.Certificate, PrivateKey: other.PrivateKey})
+	assert.EqualError(t, err, "invalid certificate bundle: private_key: private key does not match the certificate")
+}
+
+func TestValidateCertificate(t *testing.T) {
+	t.Parallel()
+
+	cert := testCertificate(t, time.Now().AddDate(0, 0, 10), "example.com", "www.example.com")
+	parsed, err := validateCertificate(cert, []string{"www.example.com"})
+	require.NoError(t, err, "warnings must not fail the validation")
+	assert.Equal(t, []string{"example.com", "www.example.com"}, parsed.DNSNames)
+
+	_, err = validateCertificate(cert, []string{"api.example.com"})
+	assert.EqualError(t, err, "invalid certificate bundle: certificate: certificate is not valid for api.example.com")
+}
+
+func TestRotatedName(t *testing.T) {
+	t.Parallel()
+
+	notAfter := time.Date(2025, 1, 31, 12, 0, 0, 0, time.UTC)
+	assert.Equal(t, "example-20250131", rotatedName("example", notAfter))
+	assert.Equal(t, "example-20250131", rotatedName("example-20241031", notAfter))
+	assert.Equal(t, "example-2024-20250131", rotatedName("example-2024", notAfter))
+}
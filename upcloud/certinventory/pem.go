@@ -0,0 +1,48 @@
+package certinventory
+
+import (
+	"crypto/x509"
+	"slices"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/certbundle"
+)
+
+// Certificate represents an issued certificate in PEM format
+type Certificate struct {
+	Certificate []byte
+	// Intermediates contains the intermediate certificates, if any
+	Intermediates []byte
+	PrivateKey    []byte
+}
+
+// CheckPEM checks that the certificates and the private key parse and that the private key matches the certificate.
+// The parsed certificate is returned.
+func CheckPEM(cert Certificate) (*x509.Certificate, error) {
+	bundle, diagnostics := certbundle.Validate(cert.Certificate, cert.Intermediates, cert.PrivateKey, certbundle.Options{})
+	// Other findings, e.g. of the chain or the validity, are left to validateCertificate
+	diagnostics = slices.DeleteFunc(diagnostics, func(d certbundle.Diagnostic) bool {
+		return !slices.Contains(pemCodes, d.Code)
+	})
+	if err := diagnostics.Err(); err != nil {
+		return nil, err
+	}
+	return bundle.Certificate, nil
+}
+
+// pemCodes are the codes of the diagnostics CheckPEM fails on
+var pemCodes = []certbundle.Code{
+	certbundle.CodeInvalidEncoding,
+	certbundle.CodeInvalidCertificate,
+	certbundle.CodeInvalidPrivateKey,
+	certbundle.CodeKeyMismatch,
+}
+
+// validateCertificate validates the certificate with certbundle.Validate and returns the parsed certificate. Only
+// errors fail the validation; warnings, e.g. of a short validity, are ignored.
+func validateCertificate(cert Certificate, hostnames []string) (*x509.Certificate, error) {
+	bundle, diagnostics := certbundle.Validate(cert.Certificate, cert.Intermediates, cert.PrivateKey, certbundle.Options{Hostnames: hostnames})
+	if err := diagnostics.Err(); err != nil {
+		return nil, err
+	}
+	return bundle.Certificate, nil
+}
@@ -0,0 +1,169 @@
+package certinventory
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Renewer is the client needed to rotate certificate bundles.
+type Renewer interface {
+	CreateLoadBalancerCertificateBundle(ctx context.Context, r *request.CreateLoadBalancerCertificateBundleRequest) (*upcloud.LoadBalancerCertificateBundle, error)
+	ModifyLoadBalancerFrontendTLSConfig(ctx context.Context, r *request.ModifyLoadBalancerFrontendTLSConfigRequest) (*upcloud.LoadBalancerFrontendTLSConfig, error)
+	ModifyLoadBalancerBackendTLSConfig(ctx context.Context, r *request.ModifyLoadBalancerBackendTLSConfigRequest) (*upcloud.LoadBalancerBackendTLSConfig, error)
+	DeleteLoadBalancerCertificateBundle(ctx context.Context, r *request.DeleteLoadBalancerCertificateBundleRequest) error
+}
+
+// Issuer issues a new certificate for the hostnames of a bundle, e.g. with an ACME client
+type Issuer func(ctx context.Context, bundle upcloud.LoadBalancerCertificateBundle) (Certificate, error)
+
+// Renewal represents the renewal of a certificate bundle
+type Renewal struct {
+	Old upcloud.LoadBalancerCertificateBundle
+	// New is the created bundle, or nil if the bundle was not created
+	New *upcloud.LoadBalancerCertificateBundle
+	// Swapped contains the TLS configs that were changed to use the new bundle
+	Swapped []Reference
+	// Deleted tells whether the old bundle was deleted
+	Deleted bool
+	Error   error
+}
+
+// String describes the renewal
+func (r Renewal) String() string {
+	s := fmt.Sprintf("%s (expires %s)", r.Old.Name, r.Old.NotAfter.Format(time.DateOnly))
+	if r.New != nil {
+		s += fmt.Sprintf(" -> %s (expires %s), %d TLS configs swapped", r.New.Name, r.New.NotAfter.Format(time.DateOnly), len(r.Swapped))
+	}
+	if r.Error != nil {
+		s += ": " + r.Error.Error()
+	}
+	return s
+}
+
+// RenewExpiring renews the manual bundles that expire within the given number of days from now. With dryRun set,
+// the bundles to renew are only reported and the issuer is not called. The renewals are returned in the order of
+// the entries, together with the errors joined together. The entries of the inventory are updated with the new
+// bundles.
+func (i *Inventory) RenewExpiring(ctx context.Context, c Renewer, issuer Issuer, now time.Time, days int, dryRun bool) ([]Renewal, error) {
+	var renewals []Renewal
+	var errs []error
+	for idx := range i.Entries {
+		entry := &i.Entries[idx]
+		if entry.Bundle.Type != upcloud.LoadBalancerCertificateBundleTypeManual || !entry.ExpiresWithin(now, days) {
+			continue
+		}
+		if dryRun {
+			renewals = append(renewals, Renewal{Old: entry.Bundle})
+			continue
+		}
+		renewal := renew(ctx, c, issuer, *entry)
+		if renewal.Error != nil {
+			errs = append(errs, fmt.Errorf("renewing certificate bundle %s: %w", entry.Bundle.Name, renewal.Error))
+		}
+		if renewal.New != nil {
+			entry.Bundle = *renewal.New
+		}
+		renewals = append(renewals, renewal)
+	}
+	return renewals, errors.Join(errs...)
+}
+
+func renew(ctx context.Context, c Renewer, issuer Issuer, entry Entry) Renewal {
+	renewal := Renewal{Old: entry.Bundle}
+	cert, err := issuer(ctx, entry.Bundle)
+	if err != nil {
+		renewal.Error = fmt.Errorf("issuing certificate: %w", err)
+		return renewal
+	}
+	return Rotate(ctx, c, entry, cert)
+}
+
+// Rotate replaces the bundle of the entry with a new manual bundle containing the certificate. The certificate is
+// validated with certbundle.Validate against the hostnames of the old bundle before the bundle is created. The TLS
+// configs using the old bundle are changed to use the new bundle, and the old bundle is deleted if all of them were
+// changed.
+func Rotate(ctx context.Context, c Renewer, entry Entry, cert Certificate) Renewal {
+	renewal := Renewal{Old: entry.Bundle}
+	parsed, err := validateCertificate(cert, entry.Bundle.Hostnames)
+	if err != nil {
+		renewal.Error = err
+		return renewal
+	}
+
+	bundle, err := c.CreateLoadBalancerCertificateBundle(ctx, &request.CreateLoadBalancerCertificateBundleRequest{
+		Type:          upcloud.LoadBalancerCertificateBundleTypeManual,
+		Name:          rotatedName(entry.Bundle.Name, parsed.NotAfter),
+		Certificate:   base64.StdEncoding.EncodeToString(cert.Certificate),
+		Intermediates: base64.StdEncoding.EncodeToString(cert.Intermediates),
+		PrivateKey:    base64.StdEncoding.EncodeToString(cert.PrivateKey),
+	})
+	if err != nil {
+		renewal.Error = fmt.Errorf("creating certificate bundle: %w", err)
+		return renewal
+	}
+	renewal.New = bundle
+
+	var errs []error
+	for _, ref := range entry.References {
+		if err := swap(ctx, c, ref, bundle.UUID); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		renewal.Swapped = append(renewal.Swapped, ref)
+	}
+	if len(errs) > 0 {
+		errs = append(errs, fmt.Errorf("old certificate bundle %s is kept", entry.Bundle.UUID))
+		renewal.Error = errors.Join(errs...)
+		return renewal
+	}
+
+	err = c.DeleteLoadBalancerCertificateBundle(ctx, &request.DeleteLoadBalancerCertificateBundleRequest{UUID: entry.Bundle.UUID})
+	if err != nil {
+		renewal.Error = fmt.Errorf("deleting old certificate bundle: %w", err)
+		return renewal
+	}
+	renewal.Deleted = true
+	return renewal
+}
+
+func swap(ctx context.Context, c Renewer, ref Reference, bundleUUID string) error {
+	var err error
+	if ref.Backend != "" {
+		_, err = c.ModifyLoadBalancerBackendTLSConfig(ctx, &request.ModifyLoadBalancerBackendTLSConfigRequest{
+			ServiceUUID: ref.LoadBalancerUUID,
+			BackendName: ref.Backend,
+			Name:        ref.TLSConfig,
+			Config:      request.LoadBalancerBackendTLSConfig{Name: ref.TLSConfig, CertificateBundleUUID: bundleUUID},
+		})
+		if err != nil {
+			return fmt.Errorf("modifying TLS config %s of backend %s of %s: %w", ref.TLSConfig, ref.Backend, ref.LoadBalancerName, err)
+		}
+		return nil
+	}
+	_, err = c.ModifyLoadBalancerFrontendTLSConfig(ctx, &request.ModifyLoadBalancerFrontendTLSConfigRequest{
+		ServiceUUID:  ref.LoadBalancerUUID,
+		FrontendName: ref.Frontend,
+		Name:         ref.TLSConfig,
+		Config:       request.LoadBalancerFrontendTLSConfig{Name: ref.TLSConfig, CertificateBundleUUID: bundleUUID},
+	})
+	if err != nil {
+		return fmt.Errorf("modifying TLS config %s of frontend %s of %s: %w", ref.TLSConfig, ref.Frontend, ref.LoadBalancerName, err)
+	}
+	return nil
+}
+
+// rotatedNameSuffix matches the expiry date suffix added to the names of rotated bundles
+var rotatedNameSuffix = regexp.MustCompile(`-[0-9]{8}$`)
+
+// rotatedName returns the name of the bundle replacing the named bundle. Bundle names are unique, so the expiry date
+// of the new certificate is appended to the name, replacing the date of a previous rotation.
+func rotatedName(name string, notAfter time.Time) string {
+	return rotatedNameSuffix.ReplaceAllString(name, "") + "-" + notAfter.UTC().Format("20060102")
+}
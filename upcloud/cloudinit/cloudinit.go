@@ -0,0 +1,207 @@
+// Package cloudinit provides builders for cloud-init user data of servers created from cloud-init templates.
+package cloudinit
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// CloudConfigHeader is the first line of cloud-config user data
+	CloudConfigHeader = "#cloud-config"
+
+	// MaxUserDataSize is the maximum size of user data in bytes
+	MaxUserDataSize = 16384
+
+	FileEncodingBase64     = "b64"
+	FileEncodingGzip       = "gzip"
+	FileEncodingGzipBase64 = "gz+b64"
+
+	// DefaultUser is the entry of the users list that keeps the default user of the image
+	DefaultUser = "default"
+)
+
+var permissionsPattern = regexp.MustCompile(`^0[0-7]{3,4}$`)
+
+// UserDataProvider is implemented by the builders in this package.
+type UserDataProvider interface {
+	UserData() (string, error)
+}
+
+// User represents an entry in the users list of cloud-config
+type User struct {
+	Name              string   `yaml:"name"`
+	Gecos             string   `yaml:"gecos,omitempty"`
+	Groups            []string `yaml:"groups,omitempty,flow"`
+	Shell             string   `yaml:"shell,omitempty"`
+	Sudo              string   `yaml:"sudo,omitempty"`
+	LockPassword      *bool    `yaml:"lock_passwd,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+}
+
+// File represents an entry in the write_files list of cloud-config
+type File struct {
+	Path        string `yaml:"path"`
+	Content     string `yaml:"content"`
+	Owner       string `yaml:"owner,omitempty"`
+	Permissions string `yaml:"permissions,omitempty"`
+	Encoding    string `yaml:"encoding,omitempty"`
+	Append      bool   `yaml:"append,omitempty"`
+	Defer       bool   `yaml:"defer,omitempty"`
+}
+
+// Config represents a cloud-config document. The fields can be set directly or with the builder methods.
+type Config struct {
+	Hostname          string   `yaml:"hostname,omitempty"`
+	Users             []User   `yaml:"users,omitempty"`
+	SSHAuthorizedKeys []string `yaml:"ssh_authorized_keys,omitempty"`
+	PackageUpdate     bool     `yaml:"package_update,omitempty"`
+	PackageUpgrade    bool     `yaml:"package_upgrade,omitempty"`
+	Packages          []string `yaml:"packages,omitempty"`
+	WriteFiles        []File   `yaml:"write_files,omitempty"`
+	RunCmd            []string `yaml:"runcmd,omitempty"`
+}
+
+// NewConfig returns an empty cloud-config document.
+func NewConfig() *Config {
+	return &Config{}
+}
+
+// WithHostname sets the hostname of the server.
+func (c *Config) WithHostname(hostname string) *Config {
+	c.Hostname = hostname
+	return c
+}
+
+// AddUser adds a user. cloud-init only creates the default user of the image if it is in the users list, so
+// DefaultUser is written as the first entry of the list to keep it, and the keys of AddSSHKeys.
+func (c *Config) AddUser(user User) *Config {
+	c.Users = append(c.Users, user)
+	return c
+}
+
+// AddSSHKeys adds SSH keys for the default user of the image.
+func (c *Config) AddSSHKeys(keys ...string) *Config {
+	c.SSHAuthorizedKeys = append(c.SSHAuthorizedKeys, keys...)
+	return c
+}
+
+// AddPackages adds packages to install. The package database is updated before the installation.
+func (c *Config) AddPackages(packages ...string) *Config {
+	c.PackageUpdate = true
+	c.Packages = append(c.Packages, packages...)
+	return c
+}
+
+// AddFile adds a file to write.
+func (c *Config) AddFile(file File) *Config {
+	c.WriteFiles = append(c.WriteFiles, file)
+	return c
+}
+
+// AddCommands adds shell commands to run on the first boot.
+func (c *Config) AddCommands(commands ...string) *Config {
+	c.RunCmd = append(c.RunCmd, commands...)
+	return c
+}
+
+// Validate checks the document for errors cloud-init would only report on the server.
+func (c *Config) Validate() error {
+	var errs []error
+	users := make(map[string]bool)
+	for i, user := range c.Users {
+		switch {
+		case user.Name == "":
+			errs = append(errs, fmt.Errorf("users[%d]: name is required", i))
+		case user.Name == DefaultUser:
+			errs = append(errs, fmt.Errorf("users[%d]: name %s is reserved for the default user of the image", i, DefaultUser))
+		case users[user.Name]:
+			errs = append(errs, fmt.Errorf("users[%d]: user %s is defined more than once", i, user.Name))
+		}
+		users[user.Name] = true
+	}
+
+	for i, file := range c.WriteFiles {
+		if !path.IsAbs(file.Path) {
+			errs = append(errs, fmt.Errorf("write_files[%d]: path %q must be absolute", i, file.Path))
+		}
+		if file.Permissions != "" && !permissionsPattern.MatchString(file.Permissions) {
+			errs = append(errs, fmt.Errorf("write_files[%d]: permissions %q must be an octal string, e.g. 0644", i, file.Permissions))
+		}
+		switch file.Encoding {
+		case "", FileEncodingBase64, FileEncodingGzip, FileEncodingGzipBase64:
+		default:
+			errs = append(errs, fmt.Errorf("write_files[%d]: unsupported encoding %q", i, file.Encoding))
+		}
+	}
+
+	for i, pkg := range c.Packages {
+		if strings.TrimSpace(pkg) == "" {
+			errs = append(errs, fmt.Errorf("packages[%d]: package name is empty", i))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// MarshalYAML writes DefaultUser as the first entry of the users list.
+func (c Config) MarshalYAML() (any, error) {
+	type config Config
+	var node yaml.Node
+	if err := node.Encode(config(c)); err != nil {
+		return nil, err
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == "users" {
+			users := node.Content[i+1]
+			users.Content = append([]*yaml.Node{{Kind: yaml.ScalarNode, Value: DefaultUser}}, users.Content...)
+		}
+	}
+	return &node, nil
+}
+
+// UserData validates the document and returns it as cloud-config user data.
+func (c *Config) UserData() (string, error) {
+	if err := c.Validate(); err != nil {
+		return "", err
+	}
+
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	userData := CloudConfigHeader + "\n" + string(b)
+	return userData, checkSize(userData)
+}
+
+// ApplyTo sets the user data of the create server request. The template the server is created from, if given, must be
+// a cloud-init template. The metadata service, which cloud-init reads the user data from, is enabled.
+func ApplyTo(r *request.CreateServerRequest, template *upcloud.Storage, p UserDataProvider) error {
+	if template != nil && template.TemplateType != upcloud.StorageTemplateTypeCloudInit {
+		return fmt.Errorf("template %s (%s) does not support cloud-init user data", template.Title, template.UUID)
+	}
+
+	userData, err := p.UserData()
+	if err != nil {
+		return err
+	}
+
+	r.UserData = userData
+	r.Metadata = upcloud.True
+	return nil
+}
+
+func checkSize(userData string) error {
+	if len(userData) > MaxUserDataSize {
+		return fmt.Errorf("user data is %d bytes, which exceeds the limit of %d bytes", len(userData), MaxUserDataSize)
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package cloudinit
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConfigUserData(t *testing.T) {
+	t.Parallel()
+
+	userData, err := NewConfig().
+		WithHostname("web-1").
+		AddUser(User{
+			Name:              "deploy",
+			Groups:            []string{"sudo", "docker"},
+			Shell:             "/bin/bash",
+			Sudo:              "ALL=(ALL) NOPASSWD:ALL",
+			SSHAuthorizedKeys: []string{"ssh-ed25519 AAAA deploy@example.com"},
+		}).
+		AddPackages("nginx", "curl").
+		AddFile(File{Path: "/etc/motd", Content: "Welcome\n", Permissions: "0644"}).
+		AddCommands("systemctl enable --now nginx").
+		UserData()
+	require.NoError(t, err)
+
+	expected := `#cloud-config
+hostname: web-1
+users:
+    - default
+    - name: deploy
+      groups: [sudo, docker]
+      shell: /bin/bash
+      sudo: ALL=(ALL) NOPASSWD:ALL
+      ssh_authorized_keys:
+        - ssh-ed25519 AAAA deploy@example.com
+package_update: true
+packages:
+    - nginx
+    - curl
+write_files:
+    - path: /etc/motd
+      content: |
+        Welcome
+      permissions: "0644"
+runcmd:
+    - systemctl enable --now nginx
+`
+	assert.Equal(t, expected, userData)
+}
+
+func TestConfigUserDataDefaultUser(t *testing.T) {
+	t.Parallel()
+
+	userData, err := NewConfig().AddSSHKeys("ssh-ed25519 AAAA admin@example.com").UserData()
+	require.NoError(t, err)
+	assert.Equal(t, "#cloud-config\nssh_authorized_keys:\n    - ssh-ed25519 AAAA admin@example.com\n", userData)
+
+	// The keys of the default user are only installed if the default user is kept in the users list
+	userData, err = NewConfig().
+		AddSSHKeys("ssh-ed25519 AAAA admin@example.com").
+		AddUser(User{Name: "deploy"}).
+		UserData()
+	require.NoError(t, err)
+	assert.Equal(t, `#cloud-config
+users:
+    - default
+    - name: deploy
+ssh_authorized_keys:
+    - ssh-ed25519 AAAA admin@example.com
+`, userData)
+
+	_, err = NewConfig().AddUser(User{Name: DefaultUser}).UserData()
+	assert.EqualError(t, err, "users[0]: name default is reserved for the default user of the image")
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	err := NewConfig().
+		AddUser(User{Name: "deploy"}).
+		AddUser(User{Name: "deploy"}).
+		AddUser(User{}).
+		AddFile(File{Path: "etc/motd", Permissions: "644", Encoding: "base32"}).
+		AddPackages(" ").
+		Validate()
+	require.Error(t, err)
+	for _, msg := range []string{
+		"users[1]: user deploy is defined more than once",
+		"users[2]: name is required",
+		`write_files[0]: path "etc/motd" must be absolute`,
+		`write_files[0]: permissions "644" must be an octal string`,
+		`write_files[0]: unsupported encoding "base32"`,
+		"packages[0]: package name is empty",
+	} {
+		assert.ErrorContains(t, err, msg)
+	}
+}
+
+func TestConfigUserDataSizeLimit(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewConfig().AddFile(File{Path: "/tmp/large", Content: strings.Repeat("x", MaxUserDataSize)}).UserData()
+	assert.ErrorContains(t, err, "exceeds the limit")
+}
+
+func TestApplyTo(t *testing.T) {
+	t.Parallel()
+
+	config := NewConfig().AddSSHKeys("ssh-ed25519 AAAA user@example.com")
+
+	r := &request.CreateServerRequest{}
+	err := ApplyTo(r, &upcloud.Storage{UUID: "uuid", TemplateType: upcloud.StorageTemplateTypeCloudInit}, config)
+	require.NoError(t, err)
+	assert.Equal(t, upcloud.True, r.Metadata)
+	assert.Equal(t, "#cloud-config\nssh_authorized_keys:\n    - ssh-ed25519 AAAA user@example.com\n", r.UserData)
+
+	r = &request.CreateServerRequest{}
+	err = ApplyTo(r, &upcloud.Storage{UUID: "uuid", Title: "Legacy", TemplateType: upcloud.StorageTemplateTypeNative}, config)
+	assert.EqualError(t, err, "template Legacy (uuid) does not support cloud-init user data")
+	assert.Empty(t, r.UserData)
+}
@@ -0,0 +1,100 @@
+package cloudinit
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/textproto"
+)
+
+const (
+	ContentTypeCloudConfig = "text/cloud-config"
+	ContentTypeShellScript = "text/x-shellscript"
+	ContentTypeBoothook    = "text/cloud-boothook"
+)
+
+// Part represents a part of multipart user data
+type Part struct {
+	ContentType string
+	Filename    string
+	Content     string
+
+	// config is rendered into the content when the user data is built
+	config *Config
+}
+
+// Multipart represents multipart MIME user data, e.g. a cloud-config document combined with shell scripts.
+type Multipart struct {
+	Parts []Part
+	// Boundary is the MIME boundary between the parts. A random boundary is used when empty.
+	Boundary string
+}
+
+// NewMultipart returns multipart user data with the given parts.
+func NewMultipart(parts ...Part) *Multipart {
+	return &Multipart{Parts: parts}
+}
+
+// AddCloudConfig adds a cloud-config document. The document is validated when the user data is built.
+func (m *Multipart) AddCloudConfig(c *Config) *Multipart {
+	m.Parts = append(m.Parts, Part{ContentType: ContentTypeCloudConfig, Filename: "cloud-config.yaml", config: c})
+	return m
+}
+
+// AddShellScript adds a script which is run on the first boot.
+func (m *Multipart) AddShellScript(filename, script string) *Multipart {
+	m.Parts = append(m.Parts, Part{ContentType: ContentTypeShellScript, Filename: filename, Content: script})
+	return m
+}
+
+// UserData returns the parts as a multipart MIME document.
+func (m *Multipart) UserData() (string, error) {
+	if len(m.Parts) == 0 {
+		return "", errors.New("multipart user data must have at least one part")
+	}
+
+	body := &bytes.Buffer{}
+	w := multipart.NewWriter(body)
+	if m.Boundary != "" {
+		if err := w.SetBoundary(m.Boundary); err != nil {
+			return "", err
+		}
+	}
+
+	for i, part := range m.Parts {
+		content := part.Content
+		if part.config != nil {
+			userData, err := part.config.UserData()
+			if err != nil {
+				return "", fmt.Errorf("part %d: %w", i, err)
+			}
+			content = userData
+		}
+		if part.ContentType == "" {
+			return "", fmt.Errorf("part %d: content type is required", i)
+		}
+
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", fmt.Sprintf("%s; charset=\"utf-8\"", part.ContentType))
+		header.Set("MIME-Version", "1.0")
+		header.Set("Content-Transfer-Encoding", "7bit")
+		if part.Filename != "" {
+			header.Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", part.Filename))
+		}
+
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			return "", err
+		}
+		if _, err := pw.Write([]byte(content)); err != nil {
+			return "", err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+
+	userData := fmt.Sprintf("Content-Type: multipart/mixed; boundary=%q\nMIME-Version: 1.0\n\n%s", w.Boundary(), body.String())
+	return userData, checkSize(userData)
+}
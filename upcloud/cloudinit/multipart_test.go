@@ -0,0 +1,51 @@
+package cloudinit
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMultipartUserData(t *testing.T) {
+	t.Parallel()
+
+	m := NewMultipart().
+		AddCloudConfig(NewConfig().AddPackages("nginx")).
+		AddShellScript("setup.sh", "#!/bin/sh\necho done\n")
+	m.Boundary = "BOUNDARY"
+
+	userData, err := m.UserData()
+	require.NoError(t, err)
+
+	expected := "Content-Type: multipart/mixed; boundary=\"BOUNDARY\"\nMIME-Version: 1.0\n\n" +
+		"--BOUNDARY\r\n" +
+		"Content-Disposition: attachment; filename=\"cloud-config.yaml\"\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/cloud-config; charset=\"utf-8\"\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"\r\n" +
+		"#cloud-config\npackage_update: true\npackages:\n    - nginx\n" +
+		"\r\n--BOUNDARY\r\n" +
+		"Content-Disposition: attachment; filename=\"setup.sh\"\r\n" +
+		"Content-Transfer-Encoding: 7bit\r\n" +
+		"Content-Type: text/x-shellscript; charset=\"utf-8\"\r\n" +
+		"Mime-Version: 1.0\r\n" +
+		"\r\n" +
+		"#!/bin/sh\necho done\n" +
+		"\r\n--BOUNDARY--\r\n"
+	assert.Equal(t, expected, userData)
+}
+
+func TestMultipartUserDataErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewMultipart().UserData()
+	assert.Error(t, err)
+
+	_, err = NewMultipart().AddCloudConfig(NewConfig().AddUser(User{})).UserData()
+	assert.ErrorContains(t, err, "part 0: users[0]: name is required")
+
+	_, err = NewMultipart(Part{Content: "#!/bin/sh"}).UserData()
+	assert.ErrorContains(t, err, "part 0: content type is required")
+}
@@ -0,0 +1,327 @@
+// Package failover contains a controller that moves a floating IP address to a healthy server when the server
+// holding it fails.
+//
+// The controller checks the server the floating IP address is attached to with a user supplied health check. When
+// the check fails FailureThreshold times in a row, the controller fences the failed server, if a fence function is
+// set, and moves the address to the first healthy candidate server with ModifyIPAddress. Failovers are not done
+// more often than the cooldown allows, which keeps the address from flapping between servers.
+//
+//	controller, err := failover.NewController(svc, failover.Config{
+//		IPAddress:   "192.0.2.10",
+//		Servers:     []string{primaryUUID, secondaryUUID},
+//		HealthCheck: checkHTTP,
+//		Fence:       failover.StopServerFence(svc),
+//		OnEvent:     alert,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	return controller.Run(ctx)
+package failover
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+const (
+	DefaultInterval         = 10 * time.Second
+	DefaultFailureThreshold = 3
+	DefaultCooldown         = 5 * time.Minute
+)
+
+// EventType is the type of a controller event
+type EventType string
+
+const (
+	// EventHealthCheckFailed is sent when the health check of the active server fails
+	EventHealthCheckFailed EventType = "health-check-failed"
+	// EventHealthCheckRecovered is sent when the health check of the active server passes after failing
+	EventHealthCheckRecovered EventType = "health-check-recovered"
+	// EventFailoverStarted is sent when the controller starts moving the floating IP address
+	EventFailoverStarted EventType = "failover-started"
+	// EventFailoverSuppressed is sent when a failover is needed but the cooldown has not passed
+	EventFailoverSuppressed EventType = "failover-suppressed"
+	// EventNoHealthyCandidate is sent when none of the other candidate servers passes the health check
+	EventNoHealthyCandidate EventType = "no-healthy-candidate"
+	// EventFenced is sent when the failed server has been fenced
+	EventFenced EventType = "fenced"
+	// EventFenceFailed is sent when fencing the failed server fails. The floating IP address is not moved.
+	EventFenceFailed EventType = "fence-failed"
+	// EventFailoverSucceeded is sent when the floating IP address has been moved
+	EventFailoverSucceeded EventType = "failover-succeeded"
+	// EventFailoverFailed is sent when moving the floating IP address fails
+	EventFailoverFailed EventType = "failover-failed"
+)
+
+// ErrNoHealthyCandidate is returned by Check when a failover is needed but no candidate server is healthy
+var ErrNoHealthyCandidate = errors.New("no healthy candidate server")
+
+// Event represents something that happened in the controller
+type Event struct {
+	Type      EventType
+	Time      time.Time
+	IPAddress string
+	// From is the server the floating IP address was attached to, if any
+	From string
+	// To is the server the floating IP address is moved to, if any
+	To    string
+	Error error
+}
+
+// String describes the event
+func (e Event) String() string {
+	s := fmt.Sprintf("%s: %s", e.IPAddress, e.Type)
+	if e.From != "" {
+		s += " from " + e.From
+	}
+	if e.To != "" {
+		s += " to " + e.To
+	}
+	if e.Error != nil {
+		s += ": " + e.Error.Error()
+	}
+	return s
+}
+
+// HealthCheck checks the health of a server. A nil error means the server is healthy.
+type HealthCheck func(ctx context.Context, serverUUID string) error
+
+// Fence makes sure a failed server no longer serves traffic, e.g. by stopping it. A non-nil error aborts the
+// failover.
+type Fence func(ctx context.Context, serverUUID string) error
+
+// Client is the client needed by the controller.
+type Client interface {
+	GetIPAddressDetails(ctx context.Context, r *request.GetIPAddressDetailsRequest) (*upcloud.IPAddress, error)
+	ModifyIPAddress(ctx context.Context, r *request.ModifyIPAddressRequest) (*upcloud.IPAddress, error)
+	GetServerNetworks(ctx context.Context, r *request.GetServerNetworksRequest) (*upcloud.Networking, error)
+}
+
+// ServerStopper is the client needed by StopServerFence.
+type ServerStopper interface {
+	StopServer(ctx context.Context, r *request.StopServerRequest) (*upcloud.ServerDetails, error)
+}
+
+// StopServerFence returns a fence that hard stops the failed server
+func StopServerFence(c ServerStopper) Fence {
+	return func(ctx context.Context, serverUUID string) error {
+		_, err := c.StopServer(ctx, &request.StopServerRequest{
+			UUID:     serverUUID,
+			StopType: request.ServerStopTypeHard,
+		})
+		return err
+	}
+}
+
+// Config represents the configuration of a controller
+type Config struct {
+	// IPAddress is the floating IP address to manage
+	IPAddress string
+	// Servers are the UUIDs of the candidate servers in order of preference
+	Servers []string
+	// HealthCheck is required
+	HealthCheck HealthCheck
+	// Fence is optional. Without it, the failed server is not fenced before the address is moved.
+	Fence Fence
+	// Interval is the time between checks in Run. Defaults to DefaultInterval.
+	Interval time.Duration
+	// FailureThreshold is the number of consecutive failed health checks that triggers a failover. Defaults to
+	// DefaultFailureThreshold.
+	FailureThreshold int
+	// Cooldown is the minimum time between failovers. Defaults to DefaultCooldown.
+	Cooldown time.Duration
+	// OnEvent is called for each event of a check in the goroutine of the check once the check has finished, so it
+	// may call the methods of the controller.
+	OnEvent func(Event)
+}
+
+// Controller moves a floating IP address between candidate servers. It is safe for concurrent use.
+type Controller struct {
+	client Client
+	config Config
+	now    func() time.Time
+
+	mu           sync.Mutex
+	active       string
+	failures     int
+	lastFailover time.Time
+	// events are the events of the running check, delivered once the lock is released
+	events []Event
+}
+
+// NewController returns a controller with the given configuration
+func NewController(c Client, config Config) (*Controller, error) {
+	addr, err := netip.ParseAddr(config.IPAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid floating IP address: %w", err)
+	}
+	config.IPAddress = addr.String()
+	if len(config.Servers) < 2 {
+		return nil, errors.New("at least two candidate servers are required")
+	}
+	if config.HealthCheck == nil {
+		return nil, errors.New("health check is required")
+	}
+	if config.Interval <= 0 {
+		config.Interval = DefaultInterval
+	}
+	if config.FailureThreshold <= 0 {
+		config.FailureThreshold = DefaultFailureThreshold
+	}
+	if config.Cooldown <= 0 {
+		config.Cooldown = DefaultCooldown
+	}
+	return &Controller{client: c, config: config, now: time.Now}, nil
+}
+
+// Active returns the UUID of the server the floating IP address was attached to in the last check
+func (c *Controller) Active() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// Run checks the active server every interval until the context is cancelled. Errors from individual checks are
+// reported as events and do not stop the controller.
+func (c *Controller) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+	for {
+		_ = c.Check(ctx)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check runs one health check of the server the floating IP address is attached to and fails over if needed. An
+// address that is not attached to any server is moved to the first healthy candidate right away.
+func (c *Controller) Check(ctx context.Context) error {
+	c.mu.Lock()
+	err := c.check(ctx)
+	events := c.events
+	c.events = nil
+	c.mu.Unlock()
+
+	if c.config.OnEvent != nil {
+		for _, e := range events {
+			c.config.OnEvent(e)
+		}
+	}
+	return err
+}
+
+func (c *Controller) check(ctx context.Context) error {
+	ipAddress, err := c.client.GetIPAddressDetails(ctx, &request.GetIPAddressDetailsRequest{Address: c.config.IPAddress})
+	if err != nil {
+		return err
+	}
+	if ipAddress.ServerUUID != c.active {
+		c.active = ipAddress.ServerUUID
+		c.failures = 0
+	}
+
+	if c.active != "" {
+		if err := c.config.HealthCheck(ctx, c.active); err != nil {
+			c.failures++
+			c.emit(Event{Type: EventHealthCheckFailed, From: c.active, Error: err})
+		} else {
+			if c.failures > 0 {
+				c.emit(Event{Type: EventHealthCheckRecovered, From: c.active})
+			}
+			c.failures = 0
+			return nil
+		}
+		if c.failures < c.config.FailureThreshold {
+			return nil
+		}
+	}
+
+	if !c.lastFailover.IsZero() && c.now().Sub(c.lastFailover) < c.config.Cooldown {
+		c.emit(Event{Type: EventFailoverSuppressed, From: c.active})
+		return nil
+	}
+	return c.failover(ctx)
+}
+
+func (c *Controller) failover(ctx context.Context) error {
+	target := ""
+	for _, server := range c.config.Servers {
+		if server != c.active && c.config.HealthCheck(ctx, server) == nil {
+			target = server
+			break
+		}
+	}
+	if target == "" {
+		c.emit(Event{Type: EventNoHealthyCandidate, From: c.active, Error: ErrNoHealthyCandidate})
+		return ErrNoHealthyCandidate
+	}
+
+	c.emit(Event{Type: EventFailoverStarted, From: c.active, To: target})
+	// The cooldown also applies to failed attempts so that a persistent error does not cause a request storm
+	c.lastFailover = c.now()
+
+	if c.active != "" && c.config.Fence != nil {
+		if err := c.config.Fence(ctx, c.active); err != nil {
+			c.emit(Event{Type: EventFenceFailed, From: c.active, To: target, Error: err})
+			return fmt.Errorf("fencing server %s failed: %w", c.active, err)
+		}
+		c.emit(Event{Type: EventFenced, From: c.active, To: target})
+	}
+
+	mac, err := c.publicMAC(ctx, target)
+	if err == nil {
+		_, err = c.client.ModifyIPAddress(ctx, &request.ModifyIPAddressRequest{IPAddress: c.config.IPAddress, MAC: mac})
+	}
+	if err != nil {
+		c.emit(Event{Type: EventFailoverFailed, From: c.active, To: target, Error: err})
+		return err
+	}
+
+	c.emit(Event{Type: EventFailoverSucceeded, From: c.active, To: target})
+	c.active = target
+	c.failures = 0
+	return nil
+}
+
+// publicMAC returns the MAC address of the public network interface of the server that can hold the floating IP
+// address
+func (c *Controller) publicMAC(ctx context.Context, serverUUID string) (string, error) {
+	networking, err := c.client.GetServerNetworks(ctx, &request.GetServerNetworksRequest{ServerUUID: serverUUID})
+	if err != nil {
+		return "", err
+	}
+	family := upcloud.IPAddressFamilyIPv4
+	if netip.MustParseAddr(c.config.IPAddress).Is6() {
+		family = upcloud.IPAddressFamilyIPv6
+	}
+	for _, iface := range networking.Interfaces {
+		if iface.Type == upcloud.NetworkTypePublic && slices.ContainsFunc(iface.IPAddresses, func(ip upcloud.IPAddress) bool {
+			return ip.Family == family
+		}) {
+			return iface.MAC, nil
+		}
+	}
+	return "", fmt.Errorf("server %s has no public %s network interface", serverUUID, family)
+}
+
+// emit queues an event for delivery by Check. The caller must hold the lock.
+func (c *Controller) emit(e Event) {
+	if c.config.OnEvent == nil {
+		return
+	}
+	e.Time = c.now()
+	e.IPAddress = c.config.IPAddress
+	c.events = append(c.events, e)
+}
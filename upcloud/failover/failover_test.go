@@ -0,0 +1,223 @@
+package failover
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ Client        = (*service.Service)(nil)
+	_ ServerStopper = (*service.Service)(nil)
+)
+
+func testServer(suffix string) upcloud.ServerDetails {
+	return upcloud.ServerDetails{
+		Server: upcloud.Server{UUID: "server-" + suffix},
+		Networking: upcloud.ServerNetworking{Interfaces: upcloud.ServerInterfaceSlice{
+			{Type: upcloud.NetworkTypeUtility, MAC: "utility-" + suffix, IPAddresses: upcloud.IPAddressSlice{{Family: upcloud.IPAddressFamilyIPv4}}},
+			{Type: upcloud.NetworkTypePublic, MAC: "ipv6-" + suffix, IPAddresses: upcloud.IPAddressSlice{{Family: upcloud.IPAddressFamilyIPv6}}},
+			{Type: upcloud.NetworkTypePublic, MAC: "mac-" + suffix, IPAddresses: upcloud.IPAddressSlice{{Family: upcloud.IPAddressFamilyIPv4}}},
+		}},
+	}
+}
+
+var testResources = fakeservice.Resources{
+	Servers:     []upcloud.ServerDetails{testServer("a"), testServer("b"), testServer("c")},
+	IPAddresses: []upcloud.IPAddress{{Address: "192.0.2.10", MAC: "mac-a", ServerUUID: "server-a"}},
+}
+
+type testController struct {
+	*Controller
+	client  *fakeservice.Service
+	healthy map[string]bool
+	fenced  []string
+	events  []EventType
+	now     time.Time
+}
+
+// attached returns the UUID of the server the floating IP address is attached to
+func (tc *testController) attached() string {
+	return tc.client.IPAddresses[0].ServerUUID
+}
+
+func newTestController(t *testing.T) *testController {
+	tc := &testController{
+		client:  fakeservice.New(testResources),
+		healthy: map[string]bool{"server-a": true, "server-b": true, "server-c": true},
+		now:     time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	controller, err := NewController(tc.client, Config{
+		IPAddress: "192.0.2.10",
+		Servers:   []string{"server-a", "server-b", "server-c"},
+		HealthCheck: func(_ context.Context, serverUUID string) error {
+			if !tc.healthy[serverUUID] {
+				return errors.New("unhealthy")
+			}
+			return nil
+		},
+		Fence: func(_ context.Context, serverUUID string) error {
+			tc.fenced = append(tc.fenced, serverUUID)
+			return nil
+		},
+		FailureThreshold: 2,
+		Cooldown:         time.Minute,
+		OnEvent: func(e Event) {
+			tc.events = append(tc.events, e.Type)
+		},
+	})
+	require.NoError(t, err)
+	controller.now = func() time.Time { return tc.now }
+	tc.Controller = controller
+	return tc
+}
+
+func TestNewController(t *testing.T) {
+	t.Parallel()
+
+	healthCheck := func(context.Context, string) error { return nil }
+	_, err := NewController(fakeservice.New(testResources), Config{IPAddress: "invalid", Servers: []string{"a", "b"}, HealthCheck: healthCheck})
+	assert.Error(t, err)
+	_, err = NewController(fakeservice.New(testResources), Config{IPAddress: "192.0.2.10", Servers: []string{"a"}, HealthCheck: healthCheck})
+	assert.Error(t, err)
+	_, err = NewController(fakeservice.New(testResources), Config{IPAddress: "192.0.2.10", Servers: []string{"a", "b"}})
+	assert.Error(t, err)
+
+	c, err := NewController(fakeservice.New(testResources), Config{IPAddress: "192.0.2.10", Servers: []string{"a", "b"}, HealthCheck: healthCheck})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultInterval, c.config.Interval)
+	assert.Equal(t, DefaultFailureThreshold, c.config.FailureThreshold)
+	assert.Equal(t, DefaultCooldown, c.config.Cooldown)
+}
+
+func TestControllerFailover(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tc := newTestController(t)
+	require.NoError(t, tc.Check(ctx))
+	assert.Equal(t, "server-a", tc.Active())
+
+	// A single failure below the threshold does not trigger failover
+	tc.healthy["server-a"] = false
+	require.NoError(t, tc.Check(ctx))
+	tc.healthy["server-a"] = true
+	require.NoError(t, tc.Check(ctx))
+	assert.Equal(t, []EventType{EventHealthCheckFailed, EventHealthCheckRecovered}, tc.events)
+
+	// The first healthy candidate gets the address after the failed server is fenced
+	tc.events = nil
+	tc.healthy["server-a"] = false
+	tc.healthy["server-b"] = false
+	require.NoError(t, tc.Check(ctx))
+	require.NoError(t, tc.Check(ctx))
+	assert.Equal(t, "server-c", tc.Active())
+	assert.Equal(t, "server-c", tc.attached())
+	assert.Equal(t, []string{"server-a"}, tc.fenced)
+	assert.Equal(t, []EventType{
+		EventHealthCheckFailed,
+		EventHealthCheckFailed,
+		EventFailoverStarted,
+		EventFenced,
+		EventFailoverSucceeded,
+	}, tc.events)
+
+	// Another failure within the cooldown is suppressed
+	tc.events = nil
+	tc.healthy["server-b"] = true
+	tc.healthy["server-c"] = false
+	tc.now = tc.now.Add(30 * time.Second)
+	require.NoError(t, tc.Check(ctx))
+	require.NoError(t, tc.Check(ctx))
+	assert.Equal(t, "server-c", tc.attached())
+	assert.Equal(t, []EventType{EventHealthCheckFailed, EventHealthCheckFailed, EventFailoverSuppressed}, tc.events)
+
+	tc.events = nil
+	tc.now = tc.now.Add(time.Minute)
+	require.NoError(t, tc.Check(ctx))
+	assert.Equal(t, "server-b", tc.attached())
+	assert.Equal(t, []EventType{EventHealthCheckFailed, EventFailoverStarted, EventFenced, EventFailoverSucceeded}, tc.events)
+}
+
+func TestControllerFailoverErrors(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	tc := newTestController(t)
+	tc.healthy = map[string]bool{}
+	require.NoError(t, tc.Check(ctx))
+	assert.ErrorIs(t, tc.Check(ctx), ErrNoHealthyCandidate)
+	assert.Equal(t, []EventType{EventHealthCheckFailed, EventHealthCheckFailed, EventNoHealthyCandidate}, tc.events)
+
+	tc = newTestController(t)
+	tc.healthy["server-a"] = false
+	tc.config.Fence = func(context.Context, string) error { return errors.New("stop failed") }
+	require.NoError(t, tc.Check(ctx))
+	assert.EqualError(t, tc.Check(ctx), "fencing server server-a failed: stop failed")
+	assert.Equal(t, "server-a", tc.attached())
+	assert.Contains(t, tc.events, EventFenceFailed)
+
+	tc = newTestController(t)
+	tc.healthy["server-a"] = false
+	tc.client.Errors = map[string]error{"ModifyIPAddress": errors.New("modify failed")}
+	require.NoError(t, tc.Check(ctx))
+	assert.EqualError(t, tc.Check(ctx), "modify failed")
+	assert.Equal(t, EventFailoverFailed, tc.events[len(tc.events)-1])
+}
+
+func TestControllerUnattachedAddress(t *testing.T) {
+	t.Parallel()
+
+	tc := newTestController(t)
+	tc.client.IPAddresses[0] = upcloud.IPAddress{Address: "192.0.2.10"}
+	require.NoError(t, tc.Check(context.Background()))
+	assert.Equal(t, "server-a", tc.attached())
+	assert.Empty(t, tc.fenced)
+	assert.Equal(t, []EventType{EventFailoverStarted, EventFailoverSucceeded}, tc.events)
+}
+
+func TestControllerRun(t *testing.T) {
+	t.Parallel()
+
+	tc := newTestController(t)
+	tc.config.Interval = time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	assert.ErrorIs(t, tc.Run(ctx), context.DeadlineExceeded)
+	assert.Equal(t, "server-a", tc.Active())
+}
+
+func TestControllerOnEventCallsController(t *testing.T) {
+	t.Parallel()
+
+	tc := newTestController(t)
+	tc.healthy["server-a"] = false
+	var active []string
+	tc.config.OnEvent = func(Event) {
+		active = append(active, tc.Active())
+	}
+
+	done := make(chan error)
+	go func() {
+		ctx := context.Background()
+		if err := tc.Check(ctx); err != nil {
+			done <- err
+			return
+		}
+		done <- tc.Check(ctx)
+	}()
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("check did not return")
+	}
+	assert.Equal(t, []string{"server-a", "server-b", "server-b", "server-b", "server-b"}, active)
+}
@@ -1,6 +1,12 @@
 package upcloud
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+)
 
 // Constants
 const (
@@ -15,6 +21,10 @@ const (
 	FirewallRuleProtocolTCP  = "tcp"
 	FirewallRuleProtocolUDP  = "udp"
 	FirewallRuleProtocolICMP = "icmp"
+
+	FirewallRuleChangeActionKeep   FirewallRuleChangeAction = "keep"
+	FirewallRuleChangeActionAdd    FirewallRuleChangeAction = "add"
+	FirewallRuleChangeActionRemove FirewallRuleChangeAction = "remove"
 )
 
 // FirewallRules represents a list of firewall rules
@@ -81,3 +91,237 @@ func (s *FirewallRule) UnmarshalJSON(b []byte) error {
 
 	return nil
 }
+
+// Normalize returns a copy of the rule in the form the API stores it, so that rules can be compared field by field.
+// Enum values are lowercased, single-ended port and address ranges are completed, addresses are written in their
+// canonical form, the family is inferred from the addresses, and fields that do not apply to the protocol are cleared.
+// The position is cleared as it is not part of the rule itself.
+func (r FirewallRule) Normalize() FirewallRule {
+	r.Position = 0
+	r.Action = strings.ToLower(strings.TrimSpace(r.Action))
+	r.Direction = strings.ToLower(strings.TrimSpace(r.Direction))
+	r.Protocol = strings.ToLower(strings.TrimSpace(r.Protocol))
+
+	r.SourceAddressStart, r.SourceAddressEnd = normalizeFirewallRange(r.SourceAddressStart, r.SourceAddressEnd, normalizeFirewallAddress)
+	r.DestinationAddressStart, r.DestinationAddressEnd = normalizeFirewallRange(r.DestinationAddressStart, r.DestinationAddressEnd, normalizeFirewallAddress)
+	r.SourcePortStart, r.SourcePortEnd = normalizeFirewallRange(r.SourcePortStart, r.SourcePortEnd, strings.TrimSpace)
+	r.DestinationPortStart, r.DestinationPortEnd = normalizeFirewallRange(r.DestinationPortStart, r.DestinationPortEnd, strings.TrimSpace)
+
+	switch strings.ToLower(strings.TrimSpace(r.Family)) {
+	case "ipv4":
+		r.Family = IPAddressFamilyIPv4
+	case "ipv6":
+		r.Family = IPAddressFamilyIPv6
+	case "":
+		r.Family = firewallRuleAddressFamily(r)
+	}
+
+	if r.Protocol == FirewallRuleProtocolICMP {
+		r.SourcePortStart, r.SourcePortEnd = "", ""
+		r.DestinationPortStart, r.DestinationPortEnd = "", ""
+	} else {
+		r.ICMPType = ""
+	}
+
+	return r
+}
+
+// String returns a short human-readable description of the rule
+func (r FirewallRule) String() string {
+	parts := []string{r.Direction, r.Action}
+	if r.Family != "" {
+		parts = append(parts, r.Family)
+	}
+	if r.Protocol != "" {
+		parts = append(parts, r.Protocol)
+	}
+	if r.ICMPType != "" {
+		parts = append(parts, "type "+r.ICMPType)
+	}
+	if src := describeFirewallEndpoint(r.SourceAddressStart, r.SourceAddressEnd, r.SourcePortStart, r.SourcePortEnd); src != "" {
+		parts = append(parts, "from "+src)
+	}
+	if dst := describeFirewallEndpoint(r.DestinationAddressStart, r.DestinationAddressEnd, r.DestinationPortStart, r.DestinationPortEnd); dst != "" {
+		parts = append(parts, "to "+dst)
+	}
+	if r.Comment != "" {
+		parts = append(parts, fmt.Sprintf("%q", r.Comment))
+	}
+	return strings.Join(parts, " ")
+}
+
+func normalizeFirewallRange(start, end string, normalize func(string) string) (string, string) {
+	start, end = normalize(start), normalize(end)
+	if start == "" {
+		start = end
+	}
+	if end == "" {
+		end = start
+	}
+	return start, end
+}
+
+func normalizeFirewallAddress(address string) string {
+	address = strings.TrimSpace(address)
+	if addr, err := netip.ParseAddr(address); err == nil {
+		return addr.Unmap().String()
+	}
+	return address
+}
+
+func firewallRuleAddressFamily(r FirewallRule) string {
+	for _, address := range []string{r.SourceAddressStart, r.DestinationAddressStart} {
+		if addr, err := netip.ParseAddr(address); err == nil {
+			if addr.Is4() {
+				return IPAddressFamilyIPv4
+			}
+			return IPAddressFamilyIPv6
+		}
+	}
+	return IPAddressFamilyIPv4
+}
+
+func describeFirewallEndpoint(addressStart, addressEnd, portStart, portEnd string) string {
+	var s string
+	if addressStart != "" {
+		s = addressStart
+		if addressEnd != addressStart {
+			s += "-" + addressEnd
+		}
+	}
+	if portStart != "" {
+		port := portStart
+		if portEnd != portStart {
+			port += "-" + portEnd
+		}
+		if s == "" {
+			return "port " + port
+		}
+		s += " port " + port
+	}
+	return s
+}
+
+// FirewallRuleChangeAction represents what happens to a firewall rule when a rule set is reconciled
+type FirewallRuleChangeAction string
+
+// FirewallRuleChange represents a single entry in a firewall change plan
+type FirewallRuleChange struct {
+	Action FirewallRuleChangeAction
+	Rule   FirewallRule
+	// CurrentPosition is the position of a kept or removed rule in the current rule set
+	CurrentPosition int
+	// DesiredPosition is the position of a kept or added rule in the desired rule set
+	DesiredPosition int
+}
+
+// FirewallRulesChangePlan represents the changes needed to turn the current firewall rules of a server into the desired rules
+type FirewallRulesChangePlan struct {
+	ServerUUID string
+	Changes    []FirewallRuleChange
+	// Applied tells whether the desired rule set was written to the server
+	Applied bool
+}
+
+// HasChanges tells whether the plan adds or removes any rules
+func (p *FirewallRulesChangePlan) HasChanges() bool {
+	for _, change := range p.Changes {
+		if change.Action != FirewallRuleChangeActionKeep {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the plan in a diff-like format, with one rule per line in the order of the desired rule set
+func (p *FirewallRulesChangePlan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Firewall rules of server %s:\n", p.ServerUUID)
+	if !p.HasChanges() {
+		sb.WriteString("  no changes\n")
+	}
+	for _, change := range p.Changes {
+		switch change.Action {
+		case FirewallRuleChangeActionAdd:
+			fmt.Fprintf(&sb, "+ %3d %s\n", change.DesiredPosition, change.Rule)
+		case FirewallRuleChangeActionRemove:
+			fmt.Fprintf(&sb, "- %3d %s\n", change.CurrentPosition, change.Rule)
+		default:
+			fmt.Fprintf(&sb, "  %3d %s\n", change.DesiredPosition, change.Rule)
+		}
+	}
+	return sb.String()
+}
+
+// FirewallPolicy represents a named firewall rule template that can be applied to multiple servers
+type FirewallPolicy struct {
+	Name  string
+	Rules []FirewallRule
+}
+
+// FirewallPolicyOverride represents server specific changes to a firewall policy
+type FirewallPolicyOverride struct {
+	// RulesBefore are evaluated before the rules of the policy, e.g. to allow traffic the policy drops
+	RulesBefore []FirewallRule
+	// RulesAfter are evaluated after the rules of the policy
+	RulesAfter []FirewallRule
+	// Exclude leaves the server out of the policy, its rules are neither checked nor modified
+	Exclude bool
+}
+
+// RulesFor returns the rule set of a server with the given override, which may be nil
+func (p FirewallPolicy) RulesFor(override *FirewallPolicyOverride) []FirewallRule {
+	if override == nil {
+		return slices.Clone(p.Rules)
+	}
+	rules := make([]FirewallRule, 0, len(override.RulesBefore)+len(p.Rules)+len(override.RulesAfter))
+	rules = append(rules, override.RulesBefore...)
+	rules = append(rules, p.Rules...)
+	return append(rules, override.RulesAfter...)
+}
+
+// FirewallPolicyServerResult represents the result of applying a firewall policy to a single server
+type FirewallPolicyServerResult struct {
+	ServerUUID string
+	Hostname   string
+	// Plan is nil if the rules of the server could not be compared to the policy
+	Plan *FirewallRulesChangePlan
+	// FirewallDisabled tells that the firewall of the server is off, so the rules of the server are not enforced
+	FirewallDisabled bool
+}
+
+// Drifted tells whether the rules of the server differed from the policy
+func (r FirewallPolicyServerResult) Drifted() bool {
+	return r.Plan != nil && r.Plan.HasChanges()
+}
+
+// FirewallPolicyResult represents the result of applying a firewall policy to the matching servers
+type FirewallPolicyResult struct {
+	PolicyName string
+	Servers    []FirewallPolicyServerResult
+	// Excluded contains the UUIDs of matching servers that were left out by an override
+	Excluded []string
+}
+
+// Drifted returns the results of the servers whose rules differed from the policy
+func (r *FirewallPolicyResult) Drifted() []FirewallPolicyServerResult {
+	var drifted []FirewallPolicyServerResult
+	for _, server := range r.Servers {
+		if server.Drifted() {
+			drifted = append(drifted, server)
+		}
+	}
+	return drifted
+}
+
+// FirewallDisabled returns the results of the servers whose firewall is off. The policy is stored on these servers,
+// but it has no effect until the firewall is turned on.
+func (r *FirewallPolicyResult) FirewallDisabled() []FirewallPolicyServerResult {
+	var disabled []FirewallPolicyServerResult
+	for _, server := range r.Servers {
+		if server.FirewallDisabled {
+			disabled = append(disabled, server)
+		}
+	}
+	return disabled
+}
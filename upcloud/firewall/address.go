@@ -0,0 +1,100 @@
+package firewall
+
+import (
+	"fmt"
+	"math/big"
+	"net/netip"
+	"strings"
+)
+
+// AddressRange represents an inclusive range of IP addresses, as used in the address fields of firewall rules
+type AddressRange struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+// ParseAddressRange parses a single address, a CIDR prefix or a range of addresses separated by a dash.
+func ParseAddressRange(s string) (AddressRange, error) {
+	if start, end, ok := strings.Cut(s, "-"); ok {
+		r := AddressRange{}
+		var err error
+		if r.Start, err = netip.ParseAddr(start); err != nil {
+			return r, err
+		}
+		if r.End, err = netip.ParseAddr(end); err != nil {
+			return r, err
+		}
+		if r.Start.Is4() != r.End.Is4() {
+			return r, fmt.Errorf("address range %s mixes IPv4 and IPv6 addresses", s)
+		}
+		if r.End.Less(r.Start) {
+			return r, fmt.Errorf("address range %s ends before it starts", s)
+		}
+		return r, nil
+	}
+
+	if strings.Contains(s, "/") {
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return AddressRange{}, err
+		}
+		return PrefixRange(prefix), nil
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return AddressRange{}, err
+	}
+	addr = addr.Unmap()
+	return AddressRange{Start: addr, End: addr}, nil
+}
+
+// PrefixRange returns the first and the last address of the prefix.
+func PrefixRange(prefix netip.Prefix) AddressRange {
+	prefix = prefix.Masked()
+	start := prefix.Addr()
+	bits := start.BitLen() - prefix.Bits()
+
+	end := new(big.Int).SetBytes(start.AsSlice())
+	end.Or(end, new(big.Int).Sub(new(big.Int).Lsh(big.NewInt(1), uint(bits)), big.NewInt(1)))
+	b := make([]byte, start.BitLen()/8)
+	end.FillBytes(b)
+	endAddr, _ := netip.AddrFromSlice(b)
+
+	return AddressRange{Start: start, End: endAddr}
+}
+
+// Prefix returns the prefix the range covers exactly, if there is one.
+func (r AddressRange) Prefix() (netip.Prefix, bool) {
+	for bits := 0; bits <= r.Start.BitLen(); bits++ {
+		prefix := netip.PrefixFrom(r.Start, bits)
+		if prefix.Masked().Addr() != r.Start {
+			continue
+		}
+		if PrefixRange(prefix).End == r.End {
+			return prefix, true
+		}
+	}
+	return netip.Prefix{}, false
+}
+
+// Contains tells whether the other range is fully inside this range.
+func (r AddressRange) Contains(other AddressRange) bool {
+	return r.Start.Is4() == other.Start.Is4() && !other.Start.Less(r.Start) && !r.End.Less(other.End)
+}
+
+// Overlaps tells whether the ranges have any addresses in common.
+func (r AddressRange) Overlaps(other AddressRange) bool {
+	return r.Start.Is4() == other.Start.Is4() && !r.End.Less(other.Start) && !other.End.Less(r.Start)
+}
+
+// String returns the range as a single address, a CIDR prefix or a dash-separated range.
+func (r AddressRange) String() string {
+	if r.Start == r.End {
+		return r.Start.String()
+	}
+	if prefix, ok := r.Prefix(); ok {
+		return prefix.String()
+	}
+	return r.Start.String() + "-" + r.End.String()
+}
@@ -0,0 +1,59 @@
+package firewall
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseAddressRange(t *testing.T) {
+	t.Parallel()
+
+	for input, expected := range map[string][2]string{
+		"10.0.0.0/8":               {"10.0.0.0", "10.255.255.255"},
+		"10.1.2.3/8":               {"10.0.0.0", "10.255.255.255"},
+		"192.168.1.10":             {"192.168.1.10", "192.168.1.10"},
+		"192.168.1.10-192.168.2.1": {"192.168.1.10", "192.168.2.1"},
+		"2001:db8::/32":            {"2001:db8::", "2001:db8:ffff:ffff:ffff:ffff:ffff:ffff"},
+		"::/0":                     {"::", "ffff:ffff:ffff:ffff:ffff:ffff:ffff:ffff"},
+		"0.0.0.0/0":                {"0.0.0.0", "255.255.255.255"},
+	} {
+		r, err := ParseAddressRange(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected[0], r.Start.String(), input)
+		assert.Equal(t, expected[1], r.End.String(), input)
+	}
+
+	for _, input := range []string{"10.0.0.0/33", "10.0.0.1-2001:db8::1", "10.0.0.9-10.0.0.1", "example.com"} {
+		_, err := ParseAddressRange(input)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestAddressRangeString(t *testing.T) {
+	t.Parallel()
+
+	for _, input := range []string{"10.0.0.0/8", "192.168.1.10", "192.168.1.10-192.168.2.1", "2001:db8::/32", "0.0.0.0/0"} {
+		r, err := ParseAddressRange(input)
+		require.NoError(t, err)
+		assert.Equal(t, input, r.String())
+	}
+}
+
+func TestAddressRangeContainsAndOverlaps(t *testing.T) {
+	t.Parallel()
+
+	wide := PrefixRange(netip.MustParsePrefix("10.0.0.0/8"))
+	narrow := PrefixRange(netip.MustParsePrefix("10.1.0.0/16"))
+	other := PrefixRange(netip.MustParsePrefix("192.168.0.0/16"))
+	v6 := PrefixRange(netip.MustParsePrefix("::/0"))
+
+	assert.True(t, wide.Contains(narrow))
+	assert.False(t, narrow.Contains(wide))
+	assert.True(t, narrow.Overlaps(wide))
+	assert.False(t, wide.Overlaps(other))
+	assert.False(t, v6.Contains(narrow))
+	assert.False(t, v6.Overlaps(narrow))
+}
@@ -0,0 +1,414 @@
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+// Severity represents the severity of a lint finding
+type Severity int
+
+const (
+	// SeverityInfo is the severity of findings that do not need any action
+	SeverityInfo Severity = iota
+	// SeverityWarning is the severity of findings that are likely mistakes but do not prevent using the rules
+	SeverityWarning
+	// SeverityError is the severity of findings that make the API reject the rules or make rules never match
+	SeverityError
+)
+
+// Identifiers of the checks, set as Finding.Check
+const (
+	// CheckInvalidRule reports rules with invalid or inconsistent fields
+	CheckInvalidRule = "invalid-rule"
+	// CheckFamilyMismatch reports addresses whose family differs from the family of the rule
+	CheckFamilyMismatch = "family-mismatch"
+	// CheckInvalidICMPType reports ICMP types that are malformed, unknown or set on non-ICMP rules
+	CheckInvalidICMPType = "invalid-icmp-type"
+	// CheckShadowedRule reports rules that never match because an earlier rule with another action covers them
+	CheckShadowedRule = "shadowed-rule"
+	// CheckRedundantRule reports rules that are covered by an earlier rule with the same action
+	CheckRedundantRule = "redundant-rule"
+	// CheckConflictingOverlap reports rules that partly overlap an earlier rule with another action
+	CheckConflictingOverlap = "conflicting-overlap"
+	// CheckMissingDefaultDrop reports address families whose incoming rules do not end with a rule dropping all traffic
+	CheckMissingDefaultDrop = "missing-default-drop"
+)
+
+var (
+	icmpTypesIPv4 = []int{0, 3, 4, 5, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 30}
+	icmpTypesIPv6 = []int{1, 2, 3, 4, 128, 129, 130, 131, 132, 133, 134, 135, 136, 137, 143}
+)
+
+// String returns the severity in lower case
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+// Finding represents a single problem found in a rule set
+type Finding struct {
+	Severity Severity
+	// Check is the identifier of the check that produced the finding
+	Check string
+	// Position is the 1-based position of the rule in the rule set, or 0 for findings about the whole rule set
+	Position int
+	// RelatedPosition is the position of the rule the finding refers to, e.g. the rule shadowing this rule
+	RelatedPosition int
+	Message         string
+}
+
+// String returns the finding in a compiler-like format
+func (f Finding) String() string {
+	if f.Position == 0 {
+		return fmt.Sprintf("%s: %s (%s)", f.Severity, f.Message, f.Check)
+	}
+	return fmt.Sprintf("%s: rule %d: %s (%s)", f.Severity, f.Position, f.Message, f.Check)
+}
+
+// Findings is a list of lint findings
+type Findings []Finding
+
+// Max returns the highest severity of the findings. An empty list has the severity info.
+func (f Findings) Max() Severity {
+	highest := SeverityInfo
+	for _, finding := range f {
+		if finding.Severity > highest {
+			highest = finding.Severity
+		}
+	}
+	return highest
+}
+
+// AtLeast returns the findings that have the given severity or a higher one.
+func (f Findings) AtLeast(severity Severity) Findings {
+	var findings Findings
+	for _, finding := range f {
+		if finding.Severity >= severity {
+			findings = append(findings, finding)
+		}
+	}
+	return findings
+}
+
+// String returns the findings one per line
+func (f Findings) String() string {
+	var sb strings.Builder
+	for _, finding := range f {
+		sb.WriteString(finding.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+type portRangeValue struct {
+	start, end uint64
+}
+
+func (p *portRangeValue) contains(other *portRangeValue) bool {
+	return p == nil || (other != nil && p.start <= other.start && other.end <= p.end)
+}
+
+func (p *portRangeValue) overlaps(other *portRangeValue) bool {
+	return p == nil || other == nil || (p.start <= other.end && other.start <= p.end)
+}
+
+// analyzedRule holds the parsed values of a rule that passed the validity checks
+type analyzedRule struct {
+	position        int
+	rule            upcloud.FirewallRule
+	source          *AddressRange
+	destination     *AddressRange
+	sourcePort      *portRangeValue
+	destinationPort *portRangeValue
+}
+
+func (a *analyzedRule) isCatchAll() bool {
+	return a.rule.Protocol == "" && a.source == nil && a.destination == nil && a.sourcePort == nil && a.destinationPort == nil
+}
+
+func (a *analyzedRule) sameTraffic(other *analyzedRule) bool {
+	return a.rule.Direction == other.rule.Direction && a.rule.Family == other.rule.Family
+}
+
+// covers tells whether every packet matching the other rule also matches this rule.
+func (a *analyzedRule) covers(other *analyzedRule) bool {
+	return a.sameTraffic(other) &&
+		(a.rule.Protocol == "" || a.rule.Protocol == other.rule.Protocol) &&
+		(a.rule.ICMPType == "" || a.rule.ICMPType == other.rule.ICMPType) &&
+		addressContains(a.source, other.source) &&
+		addressContains(a.destination, other.destination) &&
+		a.sourcePort.contains(other.sourcePort) &&
+		a.destinationPort.contains(other.destinationPort)
+}
+
+// overlaps tells whether some packets match both rules.
+func (a *analyzedRule) overlaps(other *analyzedRule) bool {
+	return a.sameTraffic(other) &&
+		(a.rule.Protocol == "" || other.rule.Protocol == "" || a.rule.Protocol == other.rule.Protocol) &&
+		(a.rule.ICMPType == "" || other.rule.ICMPType == "" || a.rule.ICMPType == other.rule.ICMPType) &&
+		addressOverlaps(a.source, other.source) &&
+		addressOverlaps(a.destination, other.destination) &&
+		a.sourcePort.overlaps(other.sourcePort) &&
+		a.destinationPort.overlaps(other.destinationPort)
+}
+
+func addressContains(a, b *AddressRange) bool {
+	return a == nil || (b != nil && a.Contains(*b))
+}
+
+func addressOverlaps(a, b *AddressRange) bool {
+	return a == nil || b == nil || a.Overlaps(*b)
+}
+
+// Lint analyses the rule set in the order the firewall evaluates it. It reports invalid rules, addresses that do
+// not match the rule family, invalid ICMP types, rules shadowed by earlier broader rules, overlapping rules with
+// conflicting actions, and missing or misplaced default drop rules for incoming traffic.
+func Lint(rules []upcloud.FirewallRule) Findings {
+	var findings Findings
+	var analyzed []*analyzedRule
+	for i, rule := range rules {
+		a, ruleFindings := analyzeRule(i+1, rule)
+		findings = append(findings, ruleFindings...)
+		if a != nil {
+			analyzed = append(analyzed, a)
+		}
+	}
+
+	for j, later := range analyzed {
+		earlierRules := analyzed[:j]
+		if i := slices.IndexFunc(earlierRules, func(earlier *analyzedRule) bool { return earlier.covers(later) }); i >= 0 {
+			earlier := earlierRules[i]
+			if earlier.rule.Action == later.rule.Action {
+				findings = append(findings, Finding{
+					Severity:        SeverityWarning,
+					Check:           CheckRedundantRule,
+					Position:        later.position,
+					RelatedPosition: earlier.position,
+					Message:         fmt.Sprintf("rule is redundant, all matching traffic is already %s by rule %d", actionPastTense(earlier.rule.Action), earlier.position),
+				})
+			} else {
+				findings = append(findings, Finding{
+					Severity:        SeverityError,
+					Check:           CheckShadowedRule,
+					Position:        later.position,
+					RelatedPosition: earlier.position,
+					Message:         fmt.Sprintf("rule never matches, all matching traffic is %s by rule %d", actionPastTense(earlier.rule.Action), earlier.position),
+				})
+			}
+			continue
+		}
+		// A broader rule after narrower exceptions is the usual way to write a rule set, so only partial overlaps
+		// where neither rule contains the other are reported.
+		for _, earlier := range earlierRules {
+			if earlier.rule.Action != later.rule.Action && earlier.overlaps(later) && !later.covers(earlier) {
+				findings = append(findings, Finding{
+					Severity:        SeverityWarning,
+					Check:           CheckConflictingOverlap,
+					Position:        later.position,
+					RelatedPosition: earlier.position,
+					Message:         fmt.Sprintf("rule partially overlaps rule %d which %s part of the same traffic", earlier.position, actionPresentTense(earlier.rule.Action)),
+				})
+			}
+		}
+	}
+
+	families := []string{upcloud.IPAddressFamilyIPv4}
+	for _, a := range analyzed {
+		if a.rule.Family == upcloud.IPAddressFamilyIPv6 {
+			families = append(families, upcloud.IPAddressFamilyIPv6)
+			break
+		}
+	}
+	for _, family := range families {
+		// The default drop rule must be the last incoming rule of the family, rules after it never match
+		var incoming []*analyzedRule
+		for _, a := range analyzed {
+			if a.rule.Direction == upcloud.FirewallRuleDirectionIn && a.rule.Family == family {
+				incoming = append(incoming, a)
+			}
+		}
+		isDefaultDrop := func(a *analyzedRule) bool {
+			return a.rule.Action == upcloud.FirewallRuleActionDrop && a.isCatchAll()
+		}
+		switch i := slices.IndexFunc(incoming, isDefaultDrop); {
+		case i < 0:
+			findings = append(findings, Finding{
+				Severity: SeverityWarning,
+				Check:    CheckMissingDefaultDrop,
+				Message:  fmt.Sprintf("incoming %s traffic not matched by any rule is accepted, add \"in drop %s\" as the last rule", family, strings.ToLower(family)),
+			})
+		case i < len(incoming)-1:
+			findings = append(findings, Finding{
+				Severity:        SeverityWarning,
+				Check:           CheckMissingDefaultDrop,
+				Position:        incoming[i].position,
+				RelatedPosition: incoming[len(incoming)-1].position,
+				Message:         fmt.Sprintf("default drop rule for incoming %s traffic is not the last incoming %s rule, move it after rule %d", family, family, incoming[len(incoming)-1].position),
+			})
+		}
+	}
+
+	slices.SortStableFunc(findings, func(a, b Finding) int {
+		if a.Position == 0 || b.Position == 0 {
+			return b.Position - a.Position
+		}
+		return a.Position - b.Position
+	})
+	return findings
+}
+
+// analyzeRule validates a single rule and parses its values. The returned rule is nil when the rule is invalid.
+func analyzeRule(position int, rule upcloud.FirewallRule) (*analyzedRule, Findings) {
+	var findings Findings
+	report := func(severity Severity, check, format string, a ...any) {
+		findings = append(findings, Finding{Severity: severity, Check: check, Position: position, Message: fmt.Sprintf(format, a...)})
+	}
+
+	normalized := rule.Normalize()
+	switch normalized.Direction {
+	case upcloud.FirewallRuleDirectionIn, upcloud.FirewallRuleDirectionOut:
+	default:
+		report(SeverityError, CheckInvalidRule, "invalid direction %q", rule.Direction)
+	}
+	switch normalized.Action {
+	case upcloud.FirewallRuleActionAccept, upcloud.FirewallRuleActionDrop:
+	default:
+		report(SeverityError, CheckInvalidRule, "invalid action %q", rule.Action)
+	}
+	switch normalized.Protocol {
+	case "", upcloud.FirewallRuleProtocolTCP, upcloud.FirewallRuleProtocolUDP, upcloud.FirewallRuleProtocolICMP:
+	default:
+		report(SeverityError, CheckInvalidRule, "invalid protocol %q", rule.Protocol)
+	}
+	switch normalized.Family {
+	case upcloud.IPAddressFamilyIPv4, upcloud.IPAddressFamilyIPv6:
+	default:
+		report(SeverityError, CheckInvalidRule, "invalid family %q", rule.Family)
+	}
+
+	a := &analyzedRule{position: position, rule: normalized}
+	a.source = analyzeAddresses("source", normalized.SourceAddressStart, normalized.SourceAddressEnd, normalized.Family, report)
+	a.destination = analyzeAddresses("destination", normalized.DestinationAddressStart, normalized.DestinationAddressEnd, normalized.Family, report)
+	// Normalize drops the ports of ICMP rules, so the ports are read from the rule as given
+	a.sourcePort = analyzePorts("source", rule.SourcePortStart, rule.SourcePortEnd, report)
+	a.destinationPort = analyzePorts("destination", rule.DestinationPortStart, rule.DestinationPortEnd, report)
+
+	if rule.ICMPType != "" && normalized.Protocol != upcloud.FirewallRuleProtocolICMP {
+		report(SeverityError, CheckInvalidICMPType, "ICMP type %q is set but the protocol is %q", rule.ICMPType, rule.Protocol)
+	}
+	if normalized.ICMPType != "" {
+		icmpType, err := strconv.Atoi(normalized.ICMPType)
+		knownTypes := icmpTypesIPv4
+		if normalized.Family == upcloud.IPAddressFamilyIPv6 {
+			knownTypes = icmpTypesIPv6
+		}
+		switch {
+		case err != nil || icmpType < 0 || icmpType > 255:
+			report(SeverityError, CheckInvalidICMPType, "ICMP type %q is not a number between 0 and 255", normalized.ICMPType)
+		case !slices.Contains(knownTypes, icmpType):
+			report(SeverityWarning, CheckInvalidICMPType, "ICMP type %d is not a known %s ICMP type", icmpType, normalized.Family)
+		}
+	}
+	if normalized.Protocol != upcloud.FirewallRuleProtocolTCP && normalized.Protocol != upcloud.FirewallRuleProtocolUDP &&
+		(a.sourcePort != nil || a.destinationPort != nil) {
+		report(SeverityWarning, CheckInvalidRule, "ports are set but the protocol is neither tcp nor udp")
+	}
+	if normalized.Protocol == upcloud.FirewallRuleProtocolICMP {
+		// The ports of ICMP rules are not matched
+		a.sourcePort, a.destinationPort = nil, nil
+	}
+
+	for _, finding := range findings {
+		if finding.Severity == SeverityError {
+			return nil, findings
+		}
+	}
+	return a, findings
+}
+
+func analyzeAddresses(name, start, end, family string, report func(Severity, string, string, ...any)) *AddressRange {
+	if start == "" {
+		return nil
+	}
+
+	var addrs [2]netip.Addr
+	for i, s := range []string{start, end} {
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			report(SeverityError, CheckInvalidRule, "invalid %s address %q", name, s)
+			return nil
+		}
+		if addrFamily := addressFamily(addr); addrFamily != family {
+			report(SeverityError, CheckFamilyMismatch, "%s address %s is an %s address in an %s rule", name, s, addrFamily, family)
+			return nil
+		}
+		addrs[i] = addr
+	}
+	if addrs[1].Less(addrs[0]) {
+		report(SeverityError, CheckInvalidRule, "%s address range %s-%s ends before it starts", name, start, end)
+		return nil
+	}
+	return &AddressRange{Start: addrs[0], End: addrs[1]}
+}
+
+func analyzePorts(name, start, end string, report func(Severity, string, string, ...any)) *portRangeValue {
+	start, end = strings.TrimSpace(start), strings.TrimSpace(end)
+	if start == "" {
+		start = end
+	}
+	if end == "" {
+		end = start
+	}
+	if start == "" {
+		return nil
+	}
+
+	startPort, err := strconv.ParseUint(start, 10, 16)
+	if err != nil || startPort == 0 {
+		report(SeverityError, CheckInvalidRule, "invalid %s port %q", name, start)
+		return nil
+	}
+	endPort, err := strconv.ParseUint(end, 10, 16)
+	if err != nil || endPort == 0 {
+		report(SeverityError, CheckInvalidRule, "invalid %s port %q", name, end)
+		return nil
+	}
+	if endPort < startPort {
+		report(SeverityError, CheckInvalidRule, "%s port range %s-%s ends before it starts", name, start, end)
+		return nil
+	}
+	return &portRangeValue{start: startPort, end: endPort}
+}
+
+func actionPastTense(action string) string {
+	if action == upcloud.FirewallRuleActionDrop {
+		return "dropped"
+	}
+	return "accepted"
+}
+
+func actionPresentTense(action string) string {
+	if action == upcloud.FirewallRuleActionDrop {
+		return "drops"
+	}
+	return "accepts"
+}
+
+func addressFamily(addr netip.Addr) string {
+	if addr.Is4() || addr.Is4In6() {
+		return upcloud.IPAddressFamilyIPv4
+	}
+	return upcloud.IPAddressFamilyIPv6
+}
@@ -0,0 +1,127 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLint(t *testing.T) {
+	t.Parallel()
+
+	rules, err := Parse(`
+in accept tcp from 10.0.0.0/8 to port 22
+in accept tcp from 10.1.0.0/16 to port 22
+in drop tcp from 10.2.0.0/16 to port 22
+in drop tcp from 192.168.0.0/24 to port 1-1024
+in accept tcp from 192.168.0.128/25 to port 443-8443
+in accept icmp icmp-type 8
+in drop
+in accept tcp to port 80
+`)
+	require.NoError(t, err)
+
+	findings := Lint(rules)
+	assert.Equal(t, Findings{
+		{
+			Severity:        SeverityWarning,
+			Check:           CheckRedundantRule,
+			Position:        2,
+			RelatedPosition: 1,
+			Message:         "rule is redundant, all matching traffic is already accepted by rule 1",
+		},
+		{
+			Severity:        SeverityError,
+			Check:           CheckShadowedRule,
+			Position:        3,
+			RelatedPosition: 1,
+			Message:         "rule never matches, all matching traffic is accepted by rule 1",
+		},
+		{
+			Severity:        SeverityWarning,
+			Check:           CheckConflictingOverlap,
+			Position:        5,
+			RelatedPosition: 4,
+			Message:         "rule partially overlaps rule 4 which drops part of the same traffic",
+		},
+		{
+			Severity:        SeverityWarning,
+			Check:           CheckMissingDefaultDrop,
+			Position:        7,
+			RelatedPosition: 8,
+			Message:         "default drop rule for incoming IPv4 traffic is not the last incoming IPv4 rule, move it after rule 8",
+		},
+		{
+			Severity:        SeverityError,
+			Check:           CheckShadowedRule,
+			Position:        8,
+			RelatedPosition: 7,
+			Message:         "rule never matches, all matching traffic is dropped by rule 7",
+		},
+	}, findings)
+	assert.Equal(t, SeverityError, findings.Max())
+	assert.Len(t, findings.AtLeast(SeverityError), 2)
+}
+
+func TestLintInvalidRules(t *testing.T) {
+	t.Parallel()
+
+	findings := Lint([]upcloud.FirewallRule{
+		{
+			Action:             upcloud.FirewallRuleActionAccept,
+			Direction:          upcloud.FirewallRuleDirectionIn,
+			Family:             upcloud.IPAddressFamilyIPv6,
+			Protocol:           upcloud.FirewallRuleProtocolTCP,
+			SourceAddressStart: "10.0.0.1",
+		},
+		{
+			Action:    upcloud.FirewallRuleActionAccept,
+			Direction: upcloud.FirewallRuleDirectionIn,
+			Protocol:  upcloud.FirewallRuleProtocolICMP,
+			ICMPType:  "300",
+		},
+		{
+			Action:    upcloud.FirewallRuleActionAccept,
+			Direction: upcloud.FirewallRuleDirectionIn,
+			Family:    upcloud.IPAddressFamilyIPv6,
+			Protocol:  upcloud.FirewallRuleProtocolICMP,
+			ICMPType:  "8",
+		},
+		{
+			Action:    upcloud.FirewallRuleActionAccept,
+			Direction: upcloud.FirewallRuleDirectionIn,
+			Protocol:  upcloud.FirewallRuleProtocolUDP,
+			ICMPType:  "0",
+		},
+		{
+			Action:               upcloud.FirewallRuleActionDrop,
+			Direction:            upcloud.FirewallRuleDirectionOut,
+			Protocol:             upcloud.FirewallRuleProtocolTCP,
+			DestinationPortStart: "8080",
+			DestinationPortEnd:   "80",
+		},
+		{
+			Action:               upcloud.FirewallRuleActionAccept,
+			Direction:            upcloud.FirewallRuleDirectionIn,
+			Protocol:             upcloud.FirewallRuleProtocolICMP,
+			DestinationPortStart: "22",
+		},
+	})
+
+	checks := make([]string, 0, len(findings))
+	for _, finding := range findings {
+		checks = append(checks, finding.String())
+	}
+	assert.Equal(t, []string{
+		"error: rule 1: source address 10.0.0.1 is an IPv4 address in an IPv6 rule (family-mismatch)",
+		"error: rule 2: ICMP type \"300\" is not a number between 0 and 255 (invalid-icmp-type)",
+		"warning: rule 3: ICMP type 8 is not a known IPv6 ICMP type (invalid-icmp-type)",
+		"error: rule 4: ICMP type \"0\" is set but the protocol is \"udp\" (invalid-icmp-type)",
+		"error: rule 5: destination port range 8080-80 ends before it starts (invalid-rule)",
+		"warning: rule 6: ports are set but the protocol is neither tcp nor udp (invalid-rule)",
+		"warning: incoming IPv4 traffic not matched by any rule is accepted, add \"in drop ipv4\" as the last rule (missing-default-drop)",
+		"warning: incoming IPv6 traffic not matched by any rule is accepted, add \"in drop ipv6\" as the last rule (missing-default-drop)",
+	}, checks)
+}
@@ -0,0 +1,364 @@
+// Package firewall provides tools for working with server firewall rules offline: a compact text syntax for
+// writing and reviewing rules, and a linter for rule sets.
+//
+// The syntax has one rule per line:
+//
+//	<in|out> <accept|drop> [ipv4|ipv6] [tcp|udp|icmp] [icmp-type <type>] [from <addresses>] [port <ports>] [to <addresses>] [port <ports>] [comment "<text>"]
+//
+// Addresses are single addresses, CIDR prefixes or dash-separated ranges, and ports are single ports or
+// dash-separated ranges. Both can be given as comma-separated lists, in which case the line expands into one rule
+// per combination. For example:
+//
+//	in accept tcp from 10.0.0.0/8 to port 22,443 comment "ssh and https"
+//	in accept icmp icmp-type 8
+//	in drop
+//
+// Empty lines and lines starting with # are ignored.
+package firewall
+
+import (
+	"fmt"
+	"net/netip"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+// SyntaxError represents an error in firewall rule syntax
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+// Error implements the error interface
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+type endpoint struct {
+	addresses []AddressRange
+	ports     []string
+}
+
+// Parse parses rules written one per line. The returned rules are normalized and in the order they were written.
+func Parse(s string) ([]upcloud.FirewallRule, error) {
+	var rules []upcloud.FirewallRule
+	for i, line := range strings.Split(s, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parsed, err := parseRule(line, i+1)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, parsed...)
+	}
+	return rules, nil
+}
+
+// ParseRule parses a single line. A line with address or port lists expands into multiple rules.
+func ParseRule(line string) ([]upcloud.FirewallRule, error) {
+	return parseRule(strings.TrimSpace(line), 1)
+}
+
+func parseRule(line string, lineNumber int) ([]upcloud.FirewallRule, error) {
+	syntaxError := func(format string, a ...any) error {
+		return &SyntaxError{Line: lineNumber, Msg: fmt.Sprintf(format, a...)}
+	}
+
+	tokens, err := tokenize(line)
+	if err != nil {
+		return nil, syntaxError("%s", err)
+	}
+	if len(tokens) < 2 {
+		return nil, syntaxError("rule must start with direction and action")
+	}
+
+	rule := upcloud.FirewallRule{}
+	tokens[0], tokens[1] = strings.ToLower(tokens[0]), strings.ToLower(tokens[1])
+	switch tokens[0] {
+	case upcloud.FirewallRuleDirectionIn, upcloud.FirewallRuleDirectionOut:
+		rule.Direction = tokens[0]
+	default:
+		return nil, syntaxError("unknown direction %q, expected in or out", tokens[0])
+	}
+	switch tokens[1] {
+	case upcloud.FirewallRuleActionAccept, upcloud.FirewallRuleActionDrop:
+		rule.Action = tokens[1]
+	default:
+		return nil, syntaxError("unknown action %q, expected accept or drop", tokens[1])
+	}
+
+	var source, destination endpoint
+	var current *endpoint
+	seen := make(map[string]bool)
+	for i := 2; i < len(tokens); i++ {
+		token := tokens[i]
+		next := func() (string, error) {
+			if i+1 >= len(tokens) {
+				return "", syntaxError("%s requires a value", token)
+			}
+			i++
+			return tokens[i], nil
+		}
+
+		keyword := strings.ToLower(token)
+		if keyword != "port" && seen[keyword] {
+			return nil, syntaxError("%s is given more than once", token)
+		}
+		seen[keyword] = true
+
+		switch keyword {
+		case "ipv4", "ipv6":
+			if rule.Family != "" {
+				return nil, syntaxError("family is given more than once")
+			}
+			rule.Family = upcloud.IPAddressFamilyIPv4
+			if keyword == "ipv6" {
+				rule.Family = upcloud.IPAddressFamilyIPv6
+			}
+		case upcloud.FirewallRuleProtocolTCP, upcloud.FirewallRuleProtocolUDP, upcloud.FirewallRuleProtocolICMP:
+			if rule.Protocol != "" {
+				return nil, syntaxError("protocol is given more than once")
+			}
+			rule.Protocol = keyword
+		case "icmp-type":
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := strconv.ParseUint(value, 10, 8); err != nil {
+				return nil, syntaxError("invalid ICMP type %q", value)
+			}
+			rule.ICMPType = value
+		case "from", "to":
+			current = &source
+			if keyword == "to" {
+				current = &destination
+			}
+			// The address list is optional, e.g. "to port 22"
+			if i+1 < len(tokens) && tokens[i+1] != "port" && !isKeyword(tokens[i+1]) {
+				value, _ := next()
+				for _, s := range strings.Split(value, ",") {
+					if s == "any" {
+						continue
+					}
+					addressRange, err := ParseAddressRange(s)
+					if err != nil {
+						return nil, syntaxError("invalid address %q: %s", s, err)
+					}
+					current.addresses = append(current.addresses, addressRange)
+				}
+			}
+		case "port":
+			if current == nil {
+				return nil, syntaxError("port must follow from or to")
+			}
+			if current.ports != nil {
+				return nil, syntaxError("port is given more than once for the same endpoint")
+			}
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			for _, s := range strings.Split(value, ",") {
+				if err := validatePorts(s); err != nil {
+					return nil, syntaxError("%s", err)
+				}
+				current.ports = append(current.ports, s)
+			}
+		case "comment":
+			value, err := next()
+			if err != nil {
+				return nil, err
+			}
+			rule.Comment = value
+		default:
+			return nil, syntaxError("unexpected %q", token)
+		}
+	}
+
+	if rule.Protocol != upcloud.FirewallRuleProtocolICMP && rule.ICMPType != "" {
+		return nil, syntaxError("icmp-type requires protocol icmp")
+	}
+	if rule.Protocol == upcloud.FirewallRuleProtocolICMP && (source.ports != nil || destination.ports != nil) {
+		return nil, syntaxError("ports cannot be used with protocol icmp")
+	}
+
+	for _, addressRange := range append(append([]AddressRange{}, source.addresses...), destination.addresses...) {
+		family := upcloud.IPAddressFamilyIPv4
+		if addressRange.Start.Is6() {
+			family = upcloud.IPAddressFamilyIPv6
+		}
+		if rule.Family != "" && rule.Family != family {
+			return nil, syntaxError("%s address %s cannot be used in %s rule", family, addressRange, rule.Family)
+		}
+		rule.Family = family
+	}
+
+	var rules []upcloud.FirewallRule
+	for _, src := range optional(source.addresses) {
+		for _, srcPort := range optional(source.ports) {
+			for _, dst := range optional(destination.addresses) {
+				for _, dstPort := range optional(destination.ports) {
+					r := rule
+					if src != nil {
+						r.SourceAddressStart, r.SourceAddressEnd = src.Start.String(), src.End.String()
+					}
+					if dst != nil {
+						r.DestinationAddressStart, r.DestinationAddressEnd = dst.Start.String(), dst.End.String()
+					}
+					if srcPort != nil {
+						r.SourcePortStart, r.SourcePortEnd = portRange(*srcPort)
+					}
+					if dstPort != nil {
+						r.DestinationPortStart, r.DestinationPortEnd = portRange(*dstPort)
+					}
+					rules = append(rules, r.Normalize())
+				}
+			}
+		}
+	}
+	return rules, nil
+}
+
+// Format returns the rule in the syntax accepted by Parse.
+func Format(rule upcloud.FirewallRule) string {
+	rule = rule.Normalize()
+	parts := []string{rule.Direction, rule.Action}
+
+	if rule.Family != inferredFamily(rule) {
+		parts = append(parts, strings.ToLower(rule.Family))
+	}
+	if rule.Protocol != "" {
+		parts = append(parts, rule.Protocol)
+	}
+	if rule.ICMPType != "" {
+		parts = append(parts, "icmp-type", rule.ICMPType)
+	}
+	parts = append(parts, formatEndpoint("from", rule.SourceAddressStart, rule.SourceAddressEnd, rule.SourcePortStart, rule.SourcePortEnd)...)
+	parts = append(parts, formatEndpoint("to", rule.DestinationAddressStart, rule.DestinationAddressEnd, rule.DestinationPortStart, rule.DestinationPortEnd)...)
+	if rule.Comment != "" {
+		parts = append(parts, "comment", strconv.Quote(rule.Comment))
+	}
+	return strings.Join(parts, " ")
+}
+
+// FormatRules returns the rules in the syntax accepted by Parse, one rule per line.
+func FormatRules(rules []upcloud.FirewallRule) string {
+	var sb strings.Builder
+	for _, rule := range rules {
+		sb.WriteString(Format(rule))
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func formatEndpoint(keyword, addressStart, addressEnd, portStart, portEnd string) []string {
+	if addressStart == "" && portStart == "" {
+		return nil
+	}
+
+	parts := []string{keyword}
+	if addressStart != "" {
+		if addressRange, err := ParseAddressRange(addressStart + "-" + addressEnd); err == nil {
+			parts = append(parts, addressRange.String())
+		} else {
+			parts = append(parts, addressStart+"-"+addressEnd)
+		}
+	}
+	if portStart != "" {
+		port := portStart
+		if portEnd != portStart {
+			port += "-" + portEnd
+		}
+		parts = append(parts, "port", port)
+	}
+	return parts
+}
+
+// inferredFamily returns the family Parse assigns to the rule when no family is given.
+func inferredFamily(rule upcloud.FirewallRule) string {
+	for _, address := range []string{rule.SourceAddressStart, rule.DestinationAddressStart} {
+		if addr, err := netip.ParseAddr(address); err == nil && addr.Is6() {
+			return upcloud.IPAddressFamilyIPv6
+		}
+	}
+	return upcloud.IPAddressFamilyIPv4
+}
+
+func isKeyword(token string) bool {
+	switch strings.ToLower(token) {
+	case "ipv4", "ipv6", "tcp", "udp", "icmp", "icmp-type", "from", "to", "port", "comment":
+		return true
+	}
+	return false
+}
+
+func validatePorts(s string) error {
+	start, end := portRange(s)
+	startPort, err := strconv.ParseUint(start, 10, 16)
+	if err != nil || startPort == 0 {
+		return fmt.Errorf("invalid port %q", s)
+	}
+	endPort, err := strconv.ParseUint(end, 10, 16)
+	if err != nil || endPort == 0 {
+		return fmt.Errorf("invalid port %q", s)
+	}
+	if endPort < startPort {
+		return fmt.Errorf("port range %q ends before it starts", s)
+	}
+	return nil
+}
+
+func portRange(s string) (string, string) {
+	if start, end, ok := strings.Cut(s, "-"); ok {
+		return start, end
+	}
+	return s, s
+}
+
+// optional returns pointers to the values, or a single nil pointer if there are no values.
+func optional[T any](values []T) []*T {
+	if len(values) == 0 {
+		return []*T{nil}
+	}
+	pointers := make([]*T, len(values))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	return pointers
+}
+
+// tokenize splits the line at whitespace. Double-quoted strings are kept together and unquoted.
+func tokenize(line string) ([]string, error) {
+	var tokens []string
+	for {
+		line = strings.TrimLeftFunc(line, unicode.IsSpace)
+		if line == "" {
+			return tokens, nil
+		}
+
+		if line[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(line)
+			if err != nil {
+				return nil, fmt.Errorf("unterminated string %s", line)
+			}
+			value, _ := strconv.Unquote(quoted)
+			tokens = append(tokens, value)
+			line = line[len(quoted):]
+			continue
+		}
+
+		end := strings.IndexFunc(line, unicode.IsSpace)
+		if end < 0 {
+			end = len(line)
+		}
+		tokens = append(tokens, line[:end])
+		line = line[end:]
+	}
+}
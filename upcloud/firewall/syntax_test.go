@@ -0,0 +1,133 @@
+package firewall
+
+import (
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	rules, err := Parse(`
+# Administration
+in accept tcp from 10.0.0.0/8 to port 22,443 comment "ssh and https"
+in accept ipv6 icmp icmp-type 128
+out accept udp from 2001:db8::/64 port 1024-65535 to 2001:db8:1::53 port 53
+in drop
+`)
+	require.NoError(t, err)
+	assert.Equal(t, []upcloud.FirewallRule{
+		{
+			Action:               upcloud.FirewallRuleActionAccept,
+			Comment:              "ssh and https",
+			DestinationPortStart: "22",
+			DestinationPortEnd:   "22",
+			Direction:            upcloud.FirewallRuleDirectionIn,
+			Family:               upcloud.IPAddressFamilyIPv4,
+			Protocol:             upcloud.FirewallRuleProtocolTCP,
+			SourceAddressStart:   "10.0.0.0",
+			SourceAddressEnd:     "10.255.255.255",
+		},
+		{
+			Action:               upcloud.FirewallRuleActionAccept,
+			Comment:              "ssh and https",
+			DestinationPortStart: "443",
+			DestinationPortEnd:   "443",
+			Direction:            upcloud.FirewallRuleDirectionIn,
+			Family:               upcloud.IPAddressFamilyIPv4,
+			Protocol:             upcloud.FirewallRuleProtocolTCP,
+			SourceAddressStart:   "10.0.0.0",
+			SourceAddressEnd:     "10.255.255.255",
+		},
+		{
+			Action:    upcloud.FirewallRuleActionAccept,
+			Direction: upcloud.FirewallRuleDirectionIn,
+			Family:    upcloud.IPAddressFamilyIPv6,
+			Protocol:  upcloud.FirewallRuleProtocolICMP,
+			ICMPType:  "128",
+		},
+		{
+			Action:                  upcloud.FirewallRuleActionAccept,
+			DestinationAddressStart: "2001:db8:1::53",
+			DestinationAddressEnd:   "2001:db8:1::53",
+			DestinationPortStart:    "53",
+			DestinationPortEnd:      "53",
+			Direction:               upcloud.FirewallRuleDirectionOut,
+			Family:                  upcloud.IPAddressFamilyIPv6,
+			Protocol:                upcloud.FirewallRuleProtocolUDP,
+			SourceAddressStart:      "2001:db8::",
+			SourceAddressEnd:        "2001:db8::ffff:ffff:ffff:ffff",
+			SourcePortStart:         "1024",
+			SourcePortEnd:           "65535",
+		},
+		{
+			Action:    upcloud.FirewallRuleActionDrop,
+			Direction: upcloud.FirewallRuleDirectionIn,
+			Family:    upcloud.IPAddressFamilyIPv4,
+		},
+	}, rules)
+}
+
+func TestParseErrors(t *testing.T) {
+	t.Parallel()
+
+	for input, expected := range map[string]string{
+		"in":                                        "line 1: rule must start with direction and action",
+		"up accept":                                 `line 1: unknown direction "up", expected in or out`,
+		"in allow":                                  `line 1: unknown action "allow", expected accept or drop`,
+		"in accept tcp udp":                         "line 1: protocol is given more than once",
+		"in accept ipv4 ipv6":                       "line 1: family is given more than once",
+		"in accept port 22":                         "line 1: port must follow from or to",
+		"in accept tcp to port 0":                   `line 1: invalid port "0"`,
+		"in accept tcp to port 70000":               `line 1: invalid port "70000"`,
+		"in accept tcp to port 90-80":               `line 1: port range "90-80" ends before it starts`,
+		"in accept tcp from 10.0.0.0/33":            `line 1: invalid address "10.0.0.0/33"`,
+		"in accept ipv6 from 10.0.0.0/8":            "line 1: IPv4 address 10.0.0.0/8 cannot be used in IPv6 rule",
+		"in accept from 10.0.0.1 to 2001:db8::1":    "line 1: IPv6 address 2001:db8::1 cannot be used in IPv4 rule",
+		"in accept icmp to port 22":                 "line 1: ports cannot be used with protocol icmp",
+		"in accept tcp icmp-type 8":                 "line 1: icmp-type requires protocol icmp",
+		"in accept icmp icmp-type echo":             `line 1: invalid ICMP type "echo"`,
+		`in accept comment "ssh`:                    "line 1: unterminated string",
+		"in accept tcp to port 22 whatever":         `line 1: unexpected "whatever"`,
+		"in accept comment":                         "line 1: comment requires a value",
+		"\n\nin accept from 10.0.0.1 from 10.0.0.2": "line 3: from is given more than once",
+	} {
+		_, err := Parse(input)
+		assert.ErrorContains(t, err, expected, input)
+	}
+}
+
+func TestFormatRoundTrip(t *testing.T) {
+	t.Parallel()
+
+	input := `in accept tcp from 10.0.0.0/8 to port 22 comment "ssh \"admin\""
+in accept ipv6 icmp icmp-type 128
+out accept udp from 2001:db8::/64 port 1024-65535 to 2001:db8:1::53 port 53
+in accept tcp from 192.168.1.10-192.168.1.20 to 192.168.2.1 port 8000-8080
+in drop ipv6
+in drop
+`
+	rules, err := Parse(input)
+	require.NoError(t, err)
+	assert.Equal(t, input, FormatRules(rules))
+
+	// Rules as returned by the API
+	rule := upcloud.FirewallRule{
+		Action:               "accept",
+		Direction:            "in",
+		Family:               "IPv4",
+		Position:             1,
+		Protocol:             "tcp",
+		SourceAddressStart:   "192.168.1.0",
+		SourceAddressEnd:     "192.168.1.255",
+		DestinationPortStart: "80",
+		DestinationPortEnd:   "80",
+	}
+	assert.Equal(t, "in accept tcp from 192.168.1.0/24 to port 80", Format(rule))
+	parsed, err := ParseRule(Format(rule))
+	require.NoError(t, err)
+	assert.Equal(t, []upcloud.FirewallRule{rule.Normalize()}, parsed)
+}
@@ -202,3 +202,102 @@ func TestUnmarshalFirewallRule(t *testing.T) {
 
 	assert.Equal(t, expectedRule, actualRule)
 }
+
+// TestFirewallRuleNormalize tests that semantically equal rules are equal after normalization
+func TestFirewallRuleNormalize(t *testing.T) {
+	t.Parallel()
+
+	expected := FirewallRule{
+		Action:                  FirewallRuleActionAccept,
+		Direction:               FirewallRuleDirectionIn,
+		Family:                  IPAddressFamilyIPv6,
+		Protocol:                FirewallRuleProtocolTCP,
+		SourceAddressStart:      "2a04:3540::1",
+		SourceAddressEnd:        "2a04:3540::1",
+		DestinationPortStart:    "22",
+		DestinationPortEnd:      "22",
+		DestinationAddressStart: "",
+		DestinationAddressEnd:   "",
+	}
+	assert.Equal(t, expected, FirewallRule{
+		Action:             "ACCEPT",
+		Direction:          "in",
+		Position:           3,
+		Protocol:           "tcp",
+		SourceAddressStart: "2a04:3540:0000::0001",
+		DestinationPortEnd: "22",
+		ICMPType:           "8",
+	}.Normalize())
+
+	assert.Equal(t, FirewallRule{
+		Action:    FirewallRuleActionAccept,
+		Direction: FirewallRuleDirectionIn,
+		Family:    IPAddressFamilyIPv4,
+		Protocol:  FirewallRuleProtocolICMP,
+		ICMPType:  "8",
+	}, FirewallRule{
+		Action:               FirewallRuleActionAccept,
+		Direction:            FirewallRuleDirectionIn,
+		Family:               "ipv4",
+		Protocol:             FirewallRuleProtocolICMP,
+		ICMPType:             "8",
+		DestinationPortStart: "22",
+	}.Normalize())
+}
+
+// TestFirewallRulesChangePlanString tests the human-readable format of firewall change plans
+func TestFirewallRulesChangePlanString(t *testing.T) {
+	t.Parallel()
+
+	plan := FirewallRulesChangePlan{
+		ServerUUID: "uuid",
+		Changes: []FirewallRuleChange{
+			{
+				Action:          FirewallRuleChangeActionKeep,
+				Rule:            FirewallRule{Direction: "in", Action: "accept", Family: "IPv4", Protocol: "tcp", DestinationPortStart: "22", DestinationPortEnd: "22", Comment: "ssh"},
+				CurrentPosition: 1,
+				DesiredPosition: 1,
+			},
+			{
+				Action:          FirewallRuleChangeActionRemove,
+				Rule:            FirewallRule{Direction: "in", Action: "accept", Family: "IPv4", Protocol: "tcp", SourceAddressStart: "10.0.0.0", SourceAddressEnd: "10.255.255.255", DestinationPortStart: "80", DestinationPortEnd: "80"},
+				CurrentPosition: 2,
+			},
+			{
+				Action:          FirewallRuleChangeActionAdd,
+				Rule:            FirewallRule{Direction: "in", Action: "drop", Family: "IPv4"},
+				DesiredPosition: 2,
+			},
+		},
+	}
+	assert.True(t, plan.HasChanges())
+	assert.Equal(t, `Firewall rules of server uuid:
+    1 in accept IPv4 tcp to port 22 "ssh"
+-   2 in accept IPv4 tcp from 10.0.0.0-10.255.255.255 to port 80
++   2 in drop IPv4
+`, plan.String())
+
+	plan.Changes = plan.Changes[:1]
+	assert.False(t, plan.HasChanges())
+	assert.Contains(t, plan.String(), "no changes")
+}
+
+// TestFirewallPolicyRulesFor tests that override rules are placed around the policy rules
+func TestFirewallPolicyRulesFor(t *testing.T) {
+	policy := FirewallPolicy{
+		Name:  "baseline",
+		Rules: []FirewallRule{{Direction: "in", Action: "drop"}},
+	}
+	assert.Equal(t, policy.Rules, policy.RulesFor(nil))
+
+	rules := policy.RulesFor(&FirewallPolicyOverride{
+		RulesBefore: []FirewallRule{{Direction: "in", Action: "accept", Protocol: "tcp", DestinationPortStart: "22"}},
+		RulesAfter:  []FirewallRule{{Direction: "out", Action: "accept"}},
+	})
+	assert.Equal(t, []FirewallRule{
+		{Direction: "in", Action: "accept", Protocol: "tcp", DestinationPortStart: "22"},
+		{Direction: "in", Action: "drop"},
+		{Direction: "out", Action: "accept"},
+	}, rules)
+	assert.Len(t, policy.Rules, 1)
+}
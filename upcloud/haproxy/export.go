@@ -0,0 +1,356 @@
+package haproxy
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+type exporter struct {
+	sb          strings.Builder
+	diagnostics Diagnostics
+	section     string
+}
+
+// Export translates a load balancer configuration to an HAProxy configuration. Certificates of frontend TLS configs
+// are referred to as files named after the TLS config, e.g. "crt example.pem"; the certificates need to be exported
+// separately. Constructs that have no HAProxy equivalent are returned as diagnostics.
+func Export(lb *upcloud.LoadBalancer) (string, Diagnostics) {
+	ex := &exporter{}
+	if lb.Name != "" {
+		ex.line(0, "# Load balancer %s", lb.Name)
+		ex.line(0, "")
+	}
+	for _, r := range lb.Resolvers {
+		ex.resolver(r)
+	}
+	for _, f := range lb.Frontends {
+		ex.frontend(f)
+	}
+	for _, b := range lb.Backends {
+		ex.backend(b)
+	}
+	return strings.TrimSuffix(ex.sb.String(), "\n"), ex.diagnostics
+}
+
+func (ex *exporter) line(indent int, format string, a ...any) {
+	ex.sb.WriteString(strings.Repeat("    ", indent) + fmt.Sprintf(format, a...) + "\n")
+}
+
+func (ex *exporter) startSection(kind, name string) {
+	ex.section = kind + " " + name
+	ex.line(0, "%s", ex.section)
+}
+
+func (ex *exporter) endSection() {
+	ex.line(0, "")
+	ex.section = ""
+}
+
+func (ex *exporter) diagnose(severity Severity, format string, a ...any) {
+	ex.diagnostics = append(ex.diagnostics, Diagnostic{
+		Severity: severity,
+		Section:  ex.section,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (ex *exporter) resolver(r upcloud.LoadBalancerResolver) {
+	ex.startSection("resolvers", r.Name)
+	for i, ns := range r.Nameservers {
+		address := ns
+		if addr, err := netip.ParseAddr(ns); err == nil {
+			address = netip.AddrPortFrom(addr, 53).String()
+		}
+		ex.line(1, "nameserver ns%d %s", i+1, address)
+	}
+	if r.Retries > 0 {
+		ex.line(1, "resolve_retries %d", r.Retries)
+	}
+	if r.Timeout > 0 {
+		ex.line(1, "timeout resolve %ds", r.Timeout)
+	}
+	if r.TimeoutRetry > 0 {
+		ex.line(1, "timeout retry %ds", r.TimeoutRetry)
+	}
+	if r.CacheValid > 0 {
+		ex.line(1, "hold valid %ds", r.CacheValid)
+	}
+	if r.CacheInvalid > 0 {
+		ex.line(1, "hold nx %ds", r.CacheInvalid)
+	}
+	ex.endSection()
+}
+
+func (ex *exporter) frontend(f upcloud.LoadBalancerFrontend) {
+	ex.startSection("frontend", f.Name)
+	if f.Mode != "" {
+		ex.line(1, "mode %s", f.Mode)
+	}
+
+	bind := []string{fmt.Sprintf("bind :%d", f.Port)}
+	if len(f.TLSConfigs) > 0 {
+		bind = append(bind, "ssl")
+		for _, tls := range f.TLSConfigs {
+			bind = append(bind, "crt", quote(tls.Name+".pem"))
+			ex.diagnose(SeverityWarning, "certificate bundle of TLS config %s needs to be exported as %s.pem", tls.Name, tls.Name)
+		}
+	}
+	if p := f.Properties; p != nil {
+		if p.HTTP2Enabled != nil && *p.HTTP2Enabled {
+			if len(f.TLSConfigs) > 0 {
+				bind = append(bind, "alpn", "h2,http/1.1")
+			} else {
+				bind = append(bind, "proto", "h2")
+			}
+		}
+		if p.InboundProxyProtocol != nil && *p.InboundProxyProtocol {
+			bind = append(bind, "accept-proxy")
+		}
+	}
+	ex.line(1, "%s", strings.Join(bind, " "))
+	if f.Properties != nil && f.Properties.TimeoutClient > 0 {
+		ex.line(1, "timeout client %ds", f.Properties.TimeoutClient)
+	}
+
+	rules := slices.Clone(f.Rules)
+	slices.SortStableFunc(rules, func(a, b upcloud.LoadBalancerFrontendRule) int {
+		if a.Priority != b.Priority {
+			return b.Priority - a.Priority
+		}
+		return strings.Compare(a.Name, b.Name)
+	})
+	// HAProxy evaluates the directives by their class before the order they are written in
+	maxClass, maxClassRule := directiveClassTCPRequest, ""
+	for _, rule := range rules {
+		for _, action := range rule.Actions {
+			class := actionClass(action)
+			if class < maxClass {
+				ex.diagnose(SeverityWarning, "HAProxy evaluates the %s action of rule %s before rule %s, which has a higher priority",
+					action.Type, rule.Name, maxClassRule)
+			}
+			if class > maxClass {
+				maxClass, maxClassRule = class, rule.Name
+			}
+		}
+		ex.rule(rule)
+	}
+	if f.DefaultBackend != "" {
+		ex.line(1, "default_backend %s", f.DefaultBackend)
+	}
+	ex.endSection()
+}
+
+// rule writes the ACLs and directives of a rule. A rule matching any of its non-inverted matchers is written as a
+// single ACL with multiple criteria, which HAProxy matches if any of them matches.
+func (ex *exporter) rule(rule upcloud.LoadBalancerFrontendRule) {
+	var acls, condition []string
+	anyOf := rule.MatchingCondition == upcloud.LoadBalancerMatchingConditionOr && len(rule.Matchers) > 1 &&
+		!slices.ContainsFunc(rule.Matchers, func(m upcloud.LoadBalancerMatcher) bool { return m.Inverse != nil && *m.Inverse })
+	for i, m := range rule.Matchers {
+		criterion, err := formatMatcher(m)
+		if err != nil {
+			ex.diagnose(SeverityError, "rule %s is not exported: %s", rule.Name, err)
+			return
+		}
+		inverse := m.Inverse != nil && *m.Inverse
+		name := rule.Name
+		switch {
+		case anyOf:
+			if i > 0 {
+				acls = append(acls, fmt.Sprintf("acl %s %s", name, criterion))
+				continue
+			}
+		case len(rule.Matchers) > 1:
+			name = fmt.Sprintf("%s_%d", rule.Name, i+1)
+		case inverse:
+			// Imports name rules with a negated ACL after the ACL prefixed with not-
+			name = strings.TrimPrefix(rule.Name, "not-")
+		}
+		acls = append(acls, fmt.Sprintf("acl %s %s", name, criterion))
+		if inverse {
+			name = "!" + name
+		}
+		condition = append(condition, name)
+	}
+
+	cond := ""
+	if len(condition) > 0 {
+		separator := " "
+		if rule.MatchingCondition == upcloud.LoadBalancerMatchingConditionOr {
+			separator = " || "
+		}
+		cond = " if " + strings.Join(condition, separator)
+	}
+
+	var directives []string
+	for _, action := range rule.Actions {
+		directive, err := formatAction(action, len(rule.Matchers) > 0)
+		if err != nil {
+			ex.diagnose(SeverityError, "action %s of rule %s is not exported: %s", action.Type, rule.Name, err)
+			continue
+		}
+		if directive == "option forwardfor" {
+			directives = append(directives, directive)
+			continue
+		}
+		directives = append(directives, directive+cond)
+	}
+	if len(directives) == 0 {
+		return
+	}
+	for _, acl := range acls {
+		ex.line(1, "%s", acl)
+	}
+	for _, directive := range directives {
+		ex.line(1, "%s", directive)
+	}
+}
+
+// actionClass returns the class of the directive the action is exported as
+func actionClass(action upcloud.LoadBalancerAction) directiveClass {
+	switch {
+	case action.UseBackend != nil:
+		return directiveClassUseBackend
+	case action.TCPReject != nil:
+		return directiveClassTCPRequest
+	default:
+		return directiveClassHTTPRequest
+	}
+}
+
+// formatAction translates an action to a directive without a condition
+func formatAction(action upcloud.LoadBalancerAction, conditional bool) (string, error) {
+	switch {
+	case action.UseBackend != nil:
+		return "use_backend " + action.UseBackend.Backend, nil
+	case action.TCPReject != nil:
+		return "tcp-request content reject", nil
+	case action.HTTPReturn != nil:
+		words := []string{"http-request return", fmt.Sprintf("status %d", action.HTTPReturn.Status)}
+		if action.HTTPReturn.ContentType != "" {
+			words = append(words, "content-type "+quote(action.HTTPReturn.ContentType))
+		}
+		if action.HTTPReturn.Payload != "" {
+			payload, err := base64.StdEncoding.DecodeString(action.HTTPReturn.Payload)
+			if err != nil {
+				return "", fmt.Errorf("payload is not base64 encoded")
+			}
+			words = append(words, "string "+quote(string(payload)))
+		}
+		return strings.Join(words, " "), nil
+	case action.HTTPRedirect != nil:
+		directive := "http-request redirect location " + quote(action.HTTPRedirect.Location)
+		if action.HTTPRedirect.Scheme != "" {
+			directive = "http-request redirect scheme " + string(action.HTTPRedirect.Scheme)
+		}
+		if action.HTTPRedirect.Status > 0 {
+			directive += fmt.Sprintf(" code %d", action.HTTPRedirect.Status)
+		}
+		return directive, nil
+	case action.HTTPRewritePath != nil:
+		return fmt.Sprintf("http-request replace-path %s %s", quote(action.HTTPRewritePath.MatchPattern), quote(action.HTTPRewritePath.RewriteTo)), nil
+	case action.HTTPRewriteURI != nil:
+		return fmt.Sprintf("http-request replace-uri %s %s", quote(action.HTTPRewriteURI.MatchPattern), quote(action.HTTPRewriteURI.RewriteTo)), nil
+	case action.SetForwardedHeaders != nil:
+		if conditional {
+			return "", fmt.Errorf("option forwardfor cannot have a condition")
+		}
+		return "option forwardfor", nil
+	case action.SetRequestHeader != nil:
+		return fmt.Sprintf("http-request set-header %s %s", action.SetRequestHeader.Header, quote(action.SetRequestHeader.Value)), nil
+	case action.SetResponseHeader != nil:
+		return fmt.Sprintf("http-response set-header %s %s", action.SetResponseHeader.Header, quote(action.SetResponseHeader.Value)), nil
+	}
+	return "", fmt.Errorf("no HAProxy equivalent")
+}
+
+func (ex *exporter) backend(b upcloud.LoadBalancerBackend) {
+	ex.startSection("backend", b.Name)
+	ex.line(1, "balance roundrobin")
+
+	p := b.Properties
+	if p == nil {
+		p = &upcloud.LoadBalancerBackendProperties{}
+	}
+	if p.HealthCheckType == upcloud.LoadBalancerHealthCheckTypeHTTP {
+		ex.line(1, "%s", strings.TrimSpace("option httpchk "+quote(p.HealthCheckURL)))
+		if p.HealthCheckExpectedStatus > 0 {
+			ex.line(1, "http-check expect status %d", p.HealthCheckExpectedStatus)
+		}
+	}
+	if p.StickySessionCookieName != "" {
+		ex.line(1, "cookie %s insert indirect nocache", p.StickySessionCookieName)
+	}
+	if p.TimeoutServer > 0 {
+		ex.line(1, "timeout server %ds", p.TimeoutServer)
+	}
+	if p.TimeoutTunnel > 0 {
+		ex.line(1, "timeout tunnel %ds", p.TimeoutTunnel)
+	}
+	if p.HealthCheckTLSVerify != nil {
+		ex.diagnose(SeverityWarning, "health check TLS verification is not exported")
+	}
+	for _, tls := range b.TLSConfigs {
+		ex.diagnose(SeverityWarning, "TLS config %s is not exported, add its certificate as ca-file of the servers", tls.Name)
+	}
+
+	// Server options shared by all members
+	var options []string
+	if p.HealthCheckType == upcloud.LoadBalancerHealthCheckTypeHTTP || p.HealthCheckType == upcloud.LoadBalancerHealthCheckTypeTCP {
+		options = append(options, "check")
+		if p.HealthCheckInterval > 0 {
+			options = append(options, fmt.Sprintf("inter %ds", p.HealthCheckInterval))
+		}
+		if p.HealthCheckFall > 0 {
+			options = append(options, fmt.Sprintf("fall %d", p.HealthCheckFall))
+		}
+		if p.HealthCheckRise > 0 {
+			options = append(options, fmt.Sprintf("rise %d", p.HealthCheckRise))
+		}
+	}
+	if p.TLSEnabled != nil && *p.TLSEnabled {
+		options = append(options, "ssl")
+		if p.TLSVerify != nil && *p.TLSVerify {
+			options = append(options, "verify required")
+		} else {
+			options = append(options, "verify none")
+		}
+		if p.TLSUseSystemCA != nil && *p.TLSUseSystemCA {
+			options = append(options, "ca-file @system-ca")
+		}
+		if p.HTTP2Enabled != nil && *p.HTTP2Enabled {
+			options = append(options, "alpn h2")
+		}
+	}
+	switch p.OutboundProxyProtocol {
+	case upcloud.LoadBalancerProxyProtocolVersion1:
+		options = append(options, "send-proxy")
+	case upcloud.LoadBalancerProxyProtocolVersion2:
+		options = append(options, "send-proxy-v2")
+	}
+	if b.Resolver != "" {
+		options = append(options, "resolvers "+b.Resolver)
+	}
+
+	for _, m := range b.Members {
+		if m.Type == upcloud.LoadBalancerBackendMemberTypeDynamic {
+			ex.diagnose(SeverityError, "dynamic member %s is not exported, HAProxy servers need a static address", m.Name)
+			continue
+		}
+		address := fmt.Sprintf("%s:%d", m.IP, m.Port)
+		if addr, err := netip.ParseAddr(m.IP); err == nil {
+			address = netip.AddrPortFrom(addr, uint16(m.Port)).String()
+		}
+		words := []string{"server", m.Name, address, fmt.Sprintf("weight %d", m.Weight), fmt.Sprintf("maxconn %d", m.MaxSessions)}
+		if !m.Enabled {
+			words = append(words, "disabled")
+		}
+		ex.line(1, "%s", strings.Join(append(words, options...), " "))
+	}
+	ex.endSection()
+}
@@ -0,0 +1,145 @@
+package haproxy
+
+import (
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exampleLoadBalancer() *upcloud.LoadBalancer {
+	return &upcloud.LoadBalancer{
+		Name:      "example",
+		Resolvers: []upcloud.LoadBalancerResolver{{Name: "dns", Nameservers: []string{"10.0.0.1"}, Retries: 5, Timeout: 30}},
+		Frontends: []upcloud.LoadBalancerFrontend{{
+			Name:           "https",
+			Mode:           upcloud.LoadBalancerModeHTTP,
+			Port:           443,
+			DefaultBackend: "web",
+			TLSConfigs:     []upcloud.LoadBalancerFrontendTLSConfig{{Name: "example"}},
+			Properties:     &upcloud.LoadBalancerFrontendProperties{TimeoutClient: 10, HTTP2Enabled: upcloud.BoolPtr(true)},
+			Rules: []upcloud.LoadBalancerFrontendRule{
+				{
+					Name:              "static",
+					Priority:          99,
+					MatchingCondition: upcloud.LoadBalancerMatchingConditionOr,
+					Matchers: []upcloud.LoadBalancerMatcher{
+						request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/static", nil),
+						request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodEnds, ".css", upcloud.BoolPtr(true)),
+					},
+					Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("static")},
+				},
+				{
+					Name:              "maintenance",
+					Priority:          100,
+					MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+					Matchers: []upcloud.LoadBalancerMatcher{
+						request.NewLoadBalancerNumMembersUpMatcher(upcloud.LoadBalancerIntegerMatcherMethodLess, 1, "web"),
+					},
+					Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerHTTPReturnAction(503, "text/plain", "c29ycnkh")},
+				},
+				{
+					Name:              "rule-3",
+					Priority:          98,
+					MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+					Matchers: []upcloud.LoadBalancerMatcher{
+						request.NewLoadBalancerHostMatcher("old.example.com"),
+						request.NewLoadBalancerInverseMatcher(request.NewLoadBalancerHTTPMethodMatcher(upcloud.LoadBalancerHTTPMatcherMethodPost)),
+					},
+					Actions: []upcloud.LoadBalancerAction{
+						request.NewLoadBalancerHTTPRedirectActionWithStatus("https://example.com", 301),
+					},
+				},
+			},
+		}},
+		Backends: []upcloud.LoadBalancerBackend{
+			{
+				Name: "web",
+				Members: []upcloud.LoadBalancerBackendMember{
+					{Name: "w1", IP: "10.0.0.10", Port: 80, Weight: 100, MaxSessions: 1000, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+				},
+				Properties: &upcloud.LoadBalancerBackendProperties{
+					HealthCheckType:           upcloud.LoadBalancerHealthCheckTypeHTTP,
+					HealthCheckURL:            "/health",
+					HealthCheckExpectedStatus: 200,
+					HealthCheckInterval:       10,
+					TimeoutServer:             30,
+				},
+			},
+			{
+				Name:     "static",
+				Resolver: "dns",
+				Members: []upcloud.LoadBalancerBackendMember{
+					{Name: "s1", IP: "static.internal", Port: 443, Weight: 1, MaxSessions: 500, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+				},
+				Properties: &upcloud.LoadBalancerBackendProperties{TLSEnabled: upcloud.BoolPtr(true), TLSVerify: upcloud.BoolPtr(false), TLSUseSystemCA: upcloud.BoolPtr(true)},
+			},
+		},
+	}
+}
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	lb := exampleLoadBalancer()
+	lb.Backends[0].Members = append(lb.Backends[0].Members, upcloud.LoadBalancerBackendMember{Name: "dynamic", Type: upcloud.LoadBalancerBackendMemberTypeDynamic})
+	cfg, diagnostics := Export(lb)
+	assert.Equal(t, `# Load balancer example
+
+resolvers dns
+    nameserver ns1 10.0.0.1:53
+    resolve_retries 5
+    timeout resolve 30s
+
+frontend https
+    mode http
+    bind :443 ssl crt example.pem alpn h2,http/1.1
+    timeout client 10s
+    acl maintenance nbsrv(web) lt 1
+    http-request return status 503 content-type text/plain string sorry! if maintenance
+    acl static path_beg /static
+    acl static path_end -i .css
+    use_backend static if static
+    acl rule-3_1 hdr(host) -i old.example.com
+    acl rule-3_2 method POST
+    http-request redirect location https://example.com code 301 if rule-3_1 !rule-3_2
+    default_backend web
+
+backend web
+    balance roundrobin
+    option httpchk /health
+    http-check expect status 200
+    timeout server 30s
+    server w1 10.0.0.10:80 weight 100 maxconn 1000 check inter 10s
+
+backend static
+    balance roundrobin
+    server s1 static.internal:443 weight 1 maxconn 500 ssl verify none ca-file @system-ca resolvers dns
+`, cfg)
+	assert.Equal(t, `frontend https: warning: certificate bundle of TLS config example needs to be exported as example.pem
+frontend https: warning: HAProxy evaluates the http_redirect action of rule rule-3 before rule static, which has a higher priority
+backend web: error: dynamic member dynamic is not exported, HAProxy servers need a static address
+`, diagnostics.String())
+}
+
+func TestExport_RoundTrip(t *testing.T) {
+	t.Parallel()
+
+	want := exampleLoadBalancer()
+	cfg, _ := Export(want)
+	got, diagnostics := Import(cfg)
+	assert.False(t, diagnostics.HasErrors(), diagnostics.String())
+
+	want.Name = ""
+	// Imported rules get descending priorities starting from the highest, in the order HAProxy evaluates them: the
+	// redirect of rule-3 before the use_backend of static
+	rules := want.Frontends[0].Rules
+	want.Frontends[0].Rules = []upcloud.LoadBalancerFrontendRule{rules[1], rules[2], rules[0]}
+	want.Frontends[0].Rules[1].Priority = 99
+	want.Frontends[0].Rules[2].Priority = 98
+	require.Equal(t, want.Frontends, got.Frontends)
+	assert.Equal(t, want.Resolvers, got.Resolvers)
+	assert.Equal(t, want.Backends, got.Backends)
+}
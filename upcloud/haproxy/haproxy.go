@@ -0,0 +1,185 @@
+// Package haproxy converts between a subset of the HAProxy configuration format and the load balancer model of the
+// API, to help moving from a self-managed HAProxy to a managed load balancer and back.
+//
+// The following sections and directives are translated:
+//
+//	defaults   mode, timeout client, timeout server, timeout tunnel
+//	frontend   bind, mode, default_backend, acl, use_backend, redirect, http-request redirect|return|deny|set-header|
+//	           replace-path|replace-uri, http-response set-header, tcp-request connection|content reject,
+//	           option forwardfor, timeout client
+//	backend    server, balance roundrobin, option httpchk, http-check expect status, cookie, timeout server,
+//	           timeout tunnel
+//	resolvers  nameserver, resolve_retries, timeout resolve|retry, hold valid|nx
+//
+// ACLs are translated to rule matchers. HAProxy conditions combine ACLs with both and and or, whereas a rule matches
+// either all or any of its matchers, so conditions mixing the two cannot be translated. Each directive with a
+// condition becomes a rule, and consecutive directives with the same condition are merged into a single rule with
+// multiple actions. Rules get descending priorities in the order HAProxy evaluates the directives: tcp-request rules
+// first, then http-request rules, redirect rules and use_backend rules, each in the order they are written.
+//
+// Everything else is reported as a diagnostic instead of being dropped silently: an error for directives that affect
+// routing or access control, such as use-server or stick, and a warning for the rest. Certificates of bind lines are
+// translated to TLS configs named after the certificate file; the certificate bundles need to be set separately.
+package haproxy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Severity represents the severity of a diagnostic
+type Severity int
+
+const (
+	// SeverityWarning is used for constructs that were left out without affecting how traffic is routed, e.g.
+	// logging options
+	SeverityWarning Severity = iota
+	// SeverityError is used for constructs that affect how traffic is routed but could not be translated, e.g. rules
+	// with unsupported conditions
+	SeverityError
+)
+
+// String returns the severity in lower case
+func (s Severity) String() string {
+	if s == SeverityError {
+		return "error"
+	}
+	return "warning"
+}
+
+// Diagnostic represents a construct that could not be translated
+type Diagnostic struct {
+	Severity Severity
+	// Line is the 1-based line number in the HAProxy configuration, or 0 for diagnostics of an export
+	Line int
+	// Section is the section the construct belongs to, e.g. "frontend https"
+	Section string
+	Message string
+}
+
+// String returns the diagnostic in a compiler-like format
+func (d Diagnostic) String() string {
+	s := ""
+	if d.Line > 0 {
+		s = fmt.Sprintf("line %d: ", d.Line)
+	}
+	if d.Section != "" {
+		s += d.Section + ": "
+	}
+	return fmt.Sprintf("%s%s: %s", s, d.Severity, d.Message)
+}
+
+// Diagnostics is a list of diagnostics
+type Diagnostics []Diagnostic
+
+// HasErrors tells whether any of the diagnostics is an error
+func (d Diagnostics) HasErrors() bool {
+	for _, diagnostic := range d {
+		if diagnostic.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the diagnostics one per line
+func (d Diagnostics) String() string {
+	var sb strings.Builder
+	for _, diagnostic := range d {
+		sb.WriteString(diagnostic.String() + "\n")
+	}
+	return sb.String()
+}
+
+// splitLine splits a configuration line into words. Words are separated by spaces and tabs, can be quoted with
+// double or single quotes and can contain backslash escaped characters. A # outside quotes starts a comment.
+func splitLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == '#':
+			if inWord {
+				words = append(words, word.String())
+			}
+			return words, nil
+		case r == ' ' || r == '\t':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote %c", quote)
+	}
+	if escaped {
+		return nil, fmt.Errorf("line ends with an escape character")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// quote returns the word quoted if it contains characters that would otherwise split it or start a comment
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\"'#\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// parseSeconds parses an HAProxy time value and rounds it up to whole seconds. Values without a unit are in
+// milliseconds.
+func parseSeconds(s string) (int, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"us", time.Microsecond},
+		{"ms", time.Millisecond},
+		{"s", time.Second},
+		{"m", time.Minute},
+		{"h", time.Hour},
+		{"d", 24 * time.Hour},
+	}
+	value, unit := s, time.Millisecond
+	for _, u := range units {
+		if strings.HasSuffix(s, u.suffix) {
+			value = strings.TrimSuffix(s, u.suffix)
+			unit = u.unit
+			break
+		}
+	}
+	v, err := strconv.ParseUint(value, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return int(math.Ceil((time.Duration(v) * unit).Seconds())), nil
+}
@@ -0,0 +1,960 @@
+package haproxy
+
+import (
+	"cmp"
+	"encoding/base64"
+	"fmt"
+	"net/netip"
+	"path"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// DefaultMaxSessions is the maximum number of sessions of members translated from servers without maxconn
+const DefaultMaxSessions = 1000
+
+// DefaultWeight is the weight of members translated from servers without weight, same as in HAProxy
+const DefaultWeight = 1
+
+// maxRulePriority is the priority of the first rule of a frontend. The API evaluates rules with higher priority first.
+const maxRulePriority = 100
+
+var (
+	sectionKeywords = []string{
+		"global", "defaults", "frontend", "backend", "listen", "resolvers", "userlist", "peers", "mailers", "cache",
+		"program", "http-errors", "ring", "log-forward", "fcgi-app", "crt-store", "traces",
+	}
+	// routingKeywords are directives that decide where requests go or whether they are accepted. Dropping them
+	// changes the behaviour of the load balancer, so they are reported as errors when they are not translated.
+	routingKeywords = []string{
+		"use_backend", "default_backend", "use-server", "redirect", "http-request", "http-response", "tcp-request",
+		"tcp-response", "stick", "force-persist", "ignore-persist", "persist", "dispatch", "block", "reqdeny",
+		"reqideny", "reqtarpit", "reqitarpit", "reqallow", "reqiallow", "monitor",
+	}
+	invalidNameCharacters = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+)
+
+type sectionDefaults struct {
+	mode          upcloud.LoadBalancerMode
+	timeoutClient int
+	timeoutServer int
+	timeoutTunnel int
+}
+
+// directiveClass orders the rules of a frontend the way HAProxy evaluates the directives they are translated from,
+// regardless of the order in which the directives are written
+type directiveClass int
+
+const (
+	directiveClassTCPRequest directiveClass = iota
+	directiveClassHTTPRequest
+	directiveClassRedirect
+	directiveClassUseBackend
+)
+
+type pendingRule struct {
+	rule upcloud.LoadBalancerFrontendRule
+	// condition is the condition of the directives the rule was translated from
+	condition string
+	class     directiveClass
+}
+
+type frontendState struct {
+	frontend upcloud.LoadBalancerFrontend
+	acls     map[string][]upcloud.LoadBalancerMatcher
+	rules    []pendingRule
+	bound    bool
+}
+
+type serverOptions struct {
+	check      bool
+	properties upcloud.LoadBalancerBackendProperties
+	resolver   string
+}
+
+type backendState struct {
+	backend        upcloud.LoadBalancerBackend
+	defaultServer  []string
+	httpCheck      bool
+	firstServer    string
+	firstOptions   serverOptions
+	warnedOptions  bool
+	serverHasCheck bool
+}
+
+type backendReference struct {
+	line    int
+	section string
+	name    string
+}
+
+type importer struct {
+	lb          *upcloud.LoadBalancer
+	diagnostics Diagnostics
+	line        int
+	section     string
+	kind        string
+	defaults    sectionDefaults
+	frontend    *frontendState
+	backend     *backendState
+	resolver    *upcloud.LoadBalancerResolver
+	references  []backendReference
+}
+
+// Import translates an HAProxy configuration to a load balancer configuration. The returned load balancer has no
+// name, zone, plan or networks; those need to be set before creating it. Constructs that could not be translated
+// are returned as diagnostics.
+func Import(cfg string) (*upcloud.LoadBalancer, Diagnostics) {
+	im := &importer{
+		lb:       &upcloud.LoadBalancer{},
+		defaults: sectionDefaults{mode: upcloud.LoadBalancerModeTCP},
+	}
+	for i, line := range strings.Split(cfg, "\n") {
+		im.line = i + 1
+		words, err := splitLine(line)
+		if err != nil {
+			im.errorf("%s", err)
+			continue
+		}
+		if len(words) == 0 {
+			continue
+		}
+		if slices.Contains(sectionKeywords, words[0]) {
+			im.startSection(words)
+			continue
+		}
+
+		switch im.kind {
+		case "":
+			im.errorf("directive %q is outside of a section", words[0])
+		case "defaults":
+			im.defaultsDirective(words)
+		case "frontend":
+			im.frontendDirective(words)
+		case "backend":
+			im.backendDirective(words)
+		case "resolvers":
+			im.resolversDirective(words)
+		}
+	}
+	im.endSection()
+
+	for _, ref := range im.references {
+		if !slices.ContainsFunc(im.lb.Backends, func(b upcloud.LoadBalancerBackend) bool { return b.Name == ref.name }) {
+			im.diagnostics = append(im.diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Line:     ref.line,
+				Section:  ref.section,
+				Message:  fmt.Sprintf("backend %q is not defined", ref.name),
+			})
+		}
+	}
+	return im.lb, im.diagnostics
+}
+
+func (im *importer) diagnose(severity Severity, format string, a ...any) {
+	im.diagnostics = append(im.diagnostics, Diagnostic{
+		Severity: severity,
+		Line:     im.line,
+		Section:  im.section,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+func (im *importer) warnf(format string, a ...any) {
+	im.diagnose(SeverityWarning, format, a...)
+}
+
+func (im *importer) errorf(format string, a ...any) {
+	im.diagnose(SeverityError, format, a...)
+}
+
+func (im *importer) notTranslated(words []string) {
+	if slices.Contains(routingKeywords, words[0]) {
+		im.errorf("directive %q is not supported, it affects routing or access control", strings.Join(words, " "))
+		return
+	}
+	im.warnf("directive %q is not translated", strings.Join(words, " "))
+}
+
+func (im *importer) startSection(words []string) {
+	im.endSection()
+	im.section = strings.Join(words[:min(len(words), 2)], " ")
+	// Directives of sections that are not translated are skipped
+	im.kind = "skipped"
+
+	switch words[0] {
+	case "defaults":
+		im.kind = "defaults"
+		return
+	case "global":
+		im.warnf("global section is not translated")
+		return
+	case "listen":
+		im.errorf("listen sections are not supported, split them into a frontend and a backend")
+		return
+	case "frontend", "backend", "resolvers":
+	default:
+		im.warnf("%s sections are not supported", words[0])
+		return
+	}
+
+	if len(words) != 2 {
+		im.errorf("%s section requires a name", words[0])
+		return
+	}
+	im.kind = words[0]
+	name := words[1]
+	switch words[0] {
+	case "frontend":
+		im.frontend = &frontendState{
+			frontend: upcloud.LoadBalancerFrontend{Name: name, Mode: im.defaults.mode},
+			acls:     make(map[string][]upcloud.LoadBalancerMatcher),
+		}
+		if im.defaults.timeoutClient > 0 {
+			im.frontend.frontend.Properties = &upcloud.LoadBalancerFrontendProperties{TimeoutClient: im.defaults.timeoutClient}
+		}
+	case "backend":
+		im.backend = &backendState{backend: upcloud.LoadBalancerBackend{Name: name, Members: []upcloud.LoadBalancerBackendMember{}}}
+		if im.defaults.timeoutServer > 0 || im.defaults.timeoutTunnel > 0 {
+			im.backend.backend.Properties = &upcloud.LoadBalancerBackendProperties{
+				TimeoutServer: im.defaults.timeoutServer,
+				TimeoutTunnel: im.defaults.timeoutTunnel,
+			}
+		}
+	case "resolvers":
+		im.resolver = &upcloud.LoadBalancerResolver{Name: name}
+	}
+}
+
+func (im *importer) endSection() {
+	if f := im.frontend; f != nil {
+		if !f.bound {
+			im.diagnostics = append(im.diagnostics, Diagnostic{Severity: SeverityError, Section: im.section, Message: "frontend has no bind line"})
+		}
+		if len(f.rules) > maxRulePriority+1 {
+			im.diagnostics = append(im.diagnostics, Diagnostic{
+				Severity: SeverityError,
+				Section:  im.section,
+				Message:  fmt.Sprintf("frontend has %d rules, only %d rules can have distinct priorities", len(f.rules), maxRulePriority+1),
+			})
+		}
+		slices.SortStableFunc(f.rules, func(a, b pendingRule) int { return cmp.Compare(a.class, b.class) })
+		for i, r := range f.rules {
+			r.rule.Priority = max(maxRulePriority-i, 0)
+			f.frontend.Rules = append(f.frontend.Rules, r.rule)
+		}
+		im.lb.Frontends = append(im.lb.Frontends, f.frontend)
+		im.frontend = nil
+	}
+	if b := im.backend; b != nil {
+		if b.serverHasCheck {
+			b.properties().HealthCheckType = upcloud.LoadBalancerHealthCheckTypeTCP
+			if b.httpCheck {
+				b.properties().HealthCheckType = upcloud.LoadBalancerHealthCheckTypeHTTP
+			}
+		}
+		im.lb.Backends = append(im.lb.Backends, b.backend)
+		im.backend = nil
+	}
+	if im.resolver != nil {
+		im.lb.Resolvers = append(im.lb.Resolvers, *im.resolver)
+		im.resolver = nil
+	}
+}
+
+func (im *importer) parseMode(words []string) (upcloud.LoadBalancerMode, bool) {
+	if len(words) == 2 && (words[1] == "http" || words[1] == "tcp") {
+		return upcloud.LoadBalancerMode(words[1]), true
+	}
+	im.errorf("unsupported mode %q", strings.Join(words[1:], " "))
+	return "", false
+}
+
+func (im *importer) parseSeconds(words []string, value string) (int, bool) {
+	seconds, err := parseSeconds(value)
+	if err != nil {
+		im.errorf("%s: %s", strings.Join(words, " "), err)
+		return 0, false
+	}
+	return seconds, true
+}
+
+func (im *importer) defaultsDirective(words []string) {
+	switch {
+	case words[0] == "mode":
+		if mode, ok := im.parseMode(words); ok {
+			im.defaults.mode = mode
+		}
+	case words[0] == "timeout" && len(words) == 3 && slices.Contains([]string{"client", "server", "tunnel"}, words[1]):
+		seconds, ok := im.parseSeconds(words, words[2])
+		if !ok {
+			return
+		}
+		switch words[1] {
+		case "client":
+			im.defaults.timeoutClient = seconds
+		case "server":
+			im.defaults.timeoutServer = seconds
+		default:
+			im.defaults.timeoutTunnel = seconds
+		}
+	default:
+		im.notTranslated(words)
+	}
+}
+
+func (im *importer) frontendDirective(words []string) {
+	f := im.frontend
+	switch words[0] {
+	case "bind":
+		im.bind(words)
+	case "mode":
+		if mode, ok := im.parseMode(words); ok {
+			f.frontend.Mode = mode
+		}
+	case "default_backend":
+		if len(words) != 2 {
+			im.errorf("default_backend requires a single backend name")
+			return
+		}
+		f.frontend.DefaultBackend = words[1]
+		im.references = append(im.references, backendReference{line: im.line, section: im.section, name: words[1]})
+	case "acl":
+		if len(words) < 3 {
+			im.errorf("acl requires a name and a criterion")
+			return
+		}
+		matchers, err := parseMatchers(words[2:])
+		if err != nil {
+			im.errorf("acl %s: %s", words[1], err)
+			// Remember the ACL so that conditions using it are reported as untranslatable rather than unknown
+			if _, ok := f.acls[words[1]]; !ok {
+				f.acls[words[1]] = nil
+			}
+			return
+		}
+		if existing, ok := f.acls[words[1]]; ok && existing == nil {
+			return
+		}
+		// Multiple acl lines with the same name match any of them
+		f.acls[words[1]] = append(f.acls[words[1]], matchers...)
+	case "use_backend":
+		args, keyword, condition := splitCondition(words[1:])
+		if len(args) != 1 || strings.Contains(args[0], "%[") {
+			im.errorf("use_backend requires a single static backend name")
+			return
+		}
+		im.references = append(im.references, backendReference{line: im.line, section: im.section, name: args[0]})
+		im.addRule(directiveClassUseBackend, request.NewLoadBalancerUseBackendAction(args[0]), keyword, condition)
+	case "redirect":
+		args, keyword, condition := splitCondition(words[1:])
+		im.redirect(directiveClassRedirect, words, args, keyword, condition)
+	case "http-request":
+		im.httpRequest(words)
+	case "http-response":
+		args, keyword, condition := splitCondition(words[1:])
+		if len(args) == 3 && args[0] == "set-header" {
+			im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerSetResponseHeaderAction(args[1], args[2]), keyword, condition)
+			return
+		}
+		im.errorf("directive %q is not supported", strings.Join(words, " "))
+	case "tcp-request":
+		args, keyword, condition := splitCondition(words[1:])
+		if len(args) == 2 && (args[0] == "connection" || args[0] == "content") && args[1] == "reject" {
+			im.addRule(directiveClassTCPRequest, request.NewLoadBalancerTCPRejectAction(), keyword, condition)
+			return
+		}
+		im.errorf("directive %q is not supported", strings.Join(words, " "))
+	case "option":
+		if len(words) >= 2 && words[1] == "forwardfor" {
+			if len(words) > 2 {
+				im.warnf("options of option forwardfor are not translated")
+			}
+			im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerSetForwardedHeadersAction(), "", nil)
+			return
+		}
+		im.notTranslated(words)
+	case "timeout":
+		if len(words) == 3 && words[1] == "client" {
+			if seconds, ok := im.parseSeconds(words, words[2]); ok {
+				f.properties().TimeoutClient = seconds
+			}
+			return
+		}
+		im.notTranslated(words)
+	default:
+		im.notTranslated(words)
+	}
+}
+
+func (f *frontendState) properties() *upcloud.LoadBalancerFrontendProperties {
+	if f.frontend.Properties == nil {
+		f.frontend.Properties = &upcloud.LoadBalancerFrontendProperties{}
+	}
+	return f.frontend.Properties
+}
+
+func (im *importer) bind(words []string) {
+	f := im.frontend
+	if len(words) < 2 {
+		im.errorf("bind requires an address")
+		return
+	}
+	address := strings.Split(words[1], ",")
+	if len(address) > 1 {
+		im.errorf("only the first address of bind %s is translated, a frontend listens on a single port", words[1])
+	}
+	idx := strings.LastIndex(address[0], ":")
+	port, err := strconv.Atoi(address[0][idx+1:])
+	if idx < 0 || err != nil {
+		im.errorf("bind address %s does not have a single port", address[0])
+		return
+	}
+	if host := strings.Trim(address[0][:idx], "[]"); host != "" && host != "*" {
+		if addr, err := netip.ParseAddr(host); err != nil || !addr.IsUnspecified() {
+			im.warnf("bind address %s is not translated, frontends listen on the networks of the load balancer", host)
+		}
+	}
+	if f.bound {
+		if port != f.frontend.Port {
+			im.errorf("bind %s is not translated, a frontend listens on a single port", words[1])
+		}
+		return
+	}
+	f.bound = true
+	f.frontend.Port = port
+
+	for i := 2; i < len(words); i++ {
+		option := words[i]
+		value := ""
+		if slices.Contains([]string{"crt", "alpn", "proto", "crt-list", "ca-file", "ciphers", "ssl-min-ver", "ssl-max-ver", "name", "process"}, option) {
+			if i+1 >= len(words) {
+				im.errorf("bind option %s requires a value", option)
+				return
+			}
+			i++
+			value = words[i]
+		}
+		switch option {
+		case "ssl":
+		case "crt":
+			name := invalidNameCharacters.ReplaceAllString(strings.TrimSuffix(path.Base(value), path.Ext(value)), "-")
+			f.frontend.TLSConfigs = append(f.frontend.TLSConfigs, upcloud.LoadBalancerFrontendTLSConfig{Name: name})
+			im.warnf("certificate %s is translated to TLS config %q, which needs a certificate bundle", value, name)
+		case "alpn", "proto":
+			if slices.Contains(strings.Split(value, ","), "h2") {
+				f.properties().HTTP2Enabled = upcloud.BoolPtr(true)
+			}
+		case "accept-proxy":
+			f.properties().InboundProxyProtocol = upcloud.BoolPtr(true)
+		case "crt-list":
+			im.errorf("bind option crt-list is not supported")
+		default:
+			im.warnf("bind option %s is not translated", option)
+		}
+	}
+}
+
+// splitCondition splits the arguments of a directive from its if or unless condition
+func splitCondition(words []string) (args []string, keyword string, condition []string) {
+	for i, w := range words {
+		if w == "if" || w == "unless" {
+			return words[:i], w, words[i+1:]
+		}
+	}
+	return words, "", nil
+}
+
+func (im *importer) httpRequest(words []string) {
+	args, keyword, condition := splitCondition(words[1:])
+	if len(args) == 0 {
+		im.errorf("http-request requires an action")
+		return
+	}
+	switch {
+	case args[0] == "redirect":
+		im.redirect(directiveClassHTTPRequest, words, args[1:], keyword, condition)
+		return
+	case args[0] == "return":
+		im.httpReturn(args[1:], keyword, condition)
+		return
+	case args[0] == "deny":
+		status := 403
+		if len(args) == 3 && args[1] == "deny_status" {
+			var err error
+			if status, err = strconv.Atoi(args[2]); err != nil {
+				im.errorf("invalid deny_status %q", args[2])
+				return
+			}
+		} else if len(args) != 1 {
+			im.errorf("only the deny_status option of http-request deny is supported")
+			return
+		}
+		im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerHTTPReturnAction(status, "text/plain", ""), keyword, condition)
+		return
+	case args[0] == "set-header" && len(args) == 3:
+		im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerSetRequestHeaderAction(args[1], args[2]), keyword, condition)
+		return
+	case args[0] == "replace-path" && len(args) == 3:
+		im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerHTTPRewritePathAction(args[1], args[2]), keyword, condition)
+		return
+	case args[0] == "replace-uri" && len(args) == 3:
+		im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerHTTPRewriteURIAction(args[1], args[2]), keyword, condition)
+		return
+	}
+	im.errorf("directive %q is not supported", strings.Join(words, " "))
+}
+
+func (im *importer) redirect(class directiveClass, words, args []string, keyword string, condition []string) {
+	if len(args) < 2 {
+		im.errorf("redirect requires a type and a target")
+		return
+	}
+	action := upcloud.LoadBalancerActionHTTPRedirect{}
+	switch args[0] {
+	case "location":
+		action.Location = args[1]
+	case "scheme":
+		if args[1] != "http" && args[1] != "https" {
+			im.errorf("redirect scheme %s is not supported", args[1])
+			return
+		}
+		action.Scheme = upcloud.LoadBalancerActionHTTPRedirectScheme(args[1])
+	default:
+		im.errorf("directive %q is not supported, only location and scheme redirects are", strings.Join(words, " "))
+		return
+	}
+	for i := 2; i < len(args); i++ {
+		if args[i] == "code" && i+1 < len(args) {
+			code, err := strconv.Atoi(args[i+1])
+			if err != nil {
+				im.errorf("invalid redirect code %q", args[i+1])
+				return
+			}
+			action.Status = code
+			i++
+			continue
+		}
+		im.warnf("redirect option %s is not translated", args[i])
+	}
+	im.addRule(class, upcloud.LoadBalancerAction{Type: upcloud.LoadBalancerActionTypeHTTPRedirect, HTTPRedirect: &action}, keyword, condition)
+}
+
+func (im *importer) httpReturn(args []string, keyword string, condition []string) {
+	status, contentType, payload := 200, "", ""
+	for i := 0; i < len(args); i++ {
+		if i+1 >= len(args) {
+			im.errorf("http-request return option %s requires a value", args[i])
+			return
+		}
+		option, value := args[i], args[i+1]
+		i++
+		switch option {
+		case "status":
+			var err error
+			if status, err = strconv.Atoi(value); err != nil {
+				im.errorf("invalid return status %q", value)
+				return
+			}
+		case "content-type":
+			contentType = value
+		case "string":
+			payload = base64.StdEncoding.EncodeToString([]byte(value))
+		default:
+			im.errorf("http-request return option %s is not supported", option)
+			return
+		}
+	}
+	im.addRule(directiveClassHTTPRequest, request.NewLoadBalancerHTTPReturnAction(status, contentType, payload), keyword, condition)
+}
+
+// addRule adds the action to the last rule if it has the same directive class, the same condition and no action of
+// the same type, and to a new rule otherwise
+func (im *importer) addRule(class directiveClass, action upcloud.LoadBalancerAction, keyword string, condition []string) {
+	f := im.frontend
+	key := strings.TrimSpace(keyword + " " + strings.Join(condition, " "))
+	if n := len(f.rules); n > 0 && f.rules[n-1].class == class && f.rules[n-1].condition == key && !slices.ContainsFunc(f.rules[n-1].rule.Actions, func(a upcloud.LoadBalancerAction) bool {
+		return a.Type == action.Type
+	}) {
+		f.rules[n-1].rule.Actions = append(f.rules[n-1].rule.Actions, action)
+		return
+	}
+
+	rule := upcloud.LoadBalancerFrontendRule{
+		MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+		Matchers:          []upcloud.LoadBalancerMatcher{},
+		Actions:           []upcloud.LoadBalancerAction{action},
+	}
+	if keyword != "" {
+		matchers, matchingCondition, err := im.condition(keyword, condition)
+		if err != nil {
+			im.errorf("condition %q cannot be translated: %s", key, err)
+			return
+		}
+		rule.Matchers = matchers
+		rule.MatchingCondition = matchingCondition
+	}
+
+	name := fmt.Sprintf("rule-%d", len(f.rules)+1)
+	if len(condition) == 1 {
+		if acl, negated := strings.CutPrefix(condition[0], "!"); f.acls[acl] != nil {
+			name = acl
+			if negated != (keyword == "unless") {
+				name = "not-" + acl
+			}
+		}
+	}
+	name = invalidNameCharacters.ReplaceAllString(name, "-")
+	rule.Name = name
+	for i := 2; slices.ContainsFunc(f.rules, func(r pendingRule) bool { return r.rule.Name == rule.Name }); i++ {
+		rule.Name = fmt.Sprintf("%s-%d", name, i)
+	}
+	f.rules = append(f.rules, pendingRule{rule: rule, condition: key, class: class})
+}
+
+type conditionTerm struct {
+	matchers []upcloud.LoadBalancerMatcher
+	negated  bool
+}
+
+// condition translates an if or unless condition to matchers. A term with multiple matchers, i.e. an ACL with
+// multiple values, matches any of them, or none of them when negated.
+func (im *importer) condition(keyword string, words []string) ([]upcloud.LoadBalancerMatcher, upcloud.LoadBalancerMatchingCondition, error) {
+	groups := [][]conditionTerm{nil}
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		if w == "||" || w == "or" {
+			groups = append(groups, nil)
+			continue
+		}
+
+		term := conditionTerm{}
+		if w == "!" {
+			if i++; i >= len(words) {
+				return nil, "", fmt.Errorf("negation without a term")
+			}
+			w = words[i]
+			term.negated = true
+		} else if after, ok := strings.CutPrefix(w, "!"); ok {
+			w = after
+			term.negated = true
+		}
+
+		if w == "{" {
+			end := slices.Index(words[i:], "}")
+			if end < 0 {
+				return nil, "", fmt.Errorf("anonymous ACL is not closed")
+			}
+			matchers, err := parseMatchers(words[i+1 : i+end])
+			if err != nil {
+				return nil, "", err
+			}
+			term.matchers = matchers
+			i += end
+		} else {
+			matchers, ok := im.frontend.acls[w]
+			if !ok {
+				return nil, "", fmt.Errorf("ACL %s is not defined", w)
+			}
+			if matchers == nil {
+				return nil, "", fmt.Errorf("ACL %s could not be translated", w)
+			}
+			term.matchers = matchers
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], term)
+	}
+
+	errMixed := fmt.Errorf("conditions combining and and or are not supported")
+	var matchers []upcloud.LoadBalancerMatcher
+	matchingCondition := upcloud.LoadBalancerMatchingConditionAnd
+	if len(groups) > 1 {
+		matchingCondition = upcloud.LoadBalancerMatchingConditionOr
+	}
+	for _, group := range groups {
+		if len(group) == 0 {
+			return nil, "", fmt.Errorf("empty condition")
+		}
+		for _, term := range group {
+			// A term with multiple matchers is an or of the matchers, or an and of the inverted matchers
+			termCondition := upcloud.LoadBalancerMatchingConditionOr
+			if term.negated {
+				termCondition = upcloud.LoadBalancerMatchingConditionAnd
+			}
+			switch {
+			case len(groups) > 1 && len(group) > 1:
+				return nil, "", errMixed
+			case len(term.matchers) > 1 && termCondition != matchingCondition:
+				if len(groups) > 1 || len(group) > 1 {
+					return nil, "", errMixed
+				}
+				matchingCondition = termCondition
+			}
+			for _, m := range term.matchers {
+				if term.negated {
+					m = invertMatcher(m)
+				}
+				matchers = append(matchers, m)
+			}
+		}
+	}
+
+	if keyword == "unless" {
+		for i, m := range matchers {
+			matchers[i] = invertMatcher(m)
+		}
+		if matchingCondition == upcloud.LoadBalancerMatchingConditionAnd {
+			matchingCondition = upcloud.LoadBalancerMatchingConditionOr
+		} else {
+			matchingCondition = upcloud.LoadBalancerMatchingConditionAnd
+		}
+	}
+	if len(matchers) == 1 {
+		matchingCondition = upcloud.LoadBalancerMatchingConditionAnd
+	}
+	return matchers, matchingCondition, nil
+}
+
+func invertMatcher(m upcloud.LoadBalancerMatcher) upcloud.LoadBalancerMatcher {
+	if m.Inverse != nil && *m.Inverse {
+		m.Inverse = nil
+	} else {
+		m.Inverse = upcloud.BoolPtr(true)
+	}
+	return m
+}
+
+func (b *backendState) properties() *upcloud.LoadBalancerBackendProperties {
+	if b.backend.Properties == nil {
+		b.backend.Properties = &upcloud.LoadBalancerBackendProperties{}
+	}
+	return b.backend.Properties
+}
+
+func (im *importer) backendDirective(words []string) {
+	b := im.backend
+	switch {
+	case words[0] == "server":
+		im.server(words)
+	case words[0] == "default-server":
+		b.defaultServer = append(b.defaultServer, words[1:]...)
+	case words[0] == "mode":
+		// Backends take the mode of the frontend using them
+	case words[0] == "balance":
+		if len(words) != 2 || words[1] != "roundrobin" {
+			im.warnf("balance %s is not translated, members are balanced by weight", strings.Join(words[1:], " "))
+		}
+	case words[0] == "option" && len(words) >= 2 && words[1] == "httpchk":
+		b.httpCheck = true
+		switch len(words) {
+		case 2:
+		case 3:
+			b.properties().HealthCheckURL = words[2]
+		default:
+			if words[2] != "GET" {
+				im.warnf("health check method %s is not translated", words[2])
+			}
+			b.properties().HealthCheckURL = words[3]
+		}
+	case words[0] == "http-check" && len(words) == 4 && words[1] == "expect" && words[2] == "status":
+		status, err := strconv.Atoi(words[3])
+		if err != nil {
+			im.errorf("invalid expected status %q", words[3])
+			return
+		}
+		b.properties().HealthCheckExpectedStatus = status
+	case words[0] == "cookie" && len(words) >= 2:
+		b.properties().StickySessionCookieName = words[1]
+	case words[0] == "timeout" && len(words) == 3 && (words[1] == "server" || words[1] == "tunnel"):
+		seconds, ok := im.parseSeconds(words, words[2])
+		if !ok {
+			return
+		}
+		if words[1] == "server" {
+			b.properties().TimeoutServer = seconds
+		} else {
+			b.properties().TimeoutTunnel = seconds
+		}
+	case words[0] == "server-template":
+		im.errorf("server-template is not supported, list the servers individually")
+	default:
+		im.notTranslated(words)
+	}
+}
+
+func (im *importer) server(words []string) {
+	b := im.backend
+	if len(words) < 3 {
+		im.errorf("server requires a name and an address")
+		return
+	}
+	name := words[1]
+	idx := strings.LastIndex(words[2], ":")
+	port, err := strconv.Atoi(words[2][idx+1:])
+	if idx < 0 || err != nil {
+		im.errorf("server %s: address %s does not have a port", name, words[2])
+		return
+	}
+	member := upcloud.LoadBalancerBackendMember{
+		Name:        name,
+		IP:          strings.Trim(words[2][:idx], "[]"),
+		Port:        port,
+		Weight:      DefaultWeight,
+		MaxSessions: DefaultMaxSessions,
+		Type:        upcloud.LoadBalancerBackendMemberTypeStatic,
+		Enabled:     true,
+	}
+
+	options := serverOptions{}
+	params := append(slices.Clone(b.defaultServer), words[3:]...)
+	for i := 0; i < len(params); i++ {
+		param := params[i]
+		value := ""
+		if slices.Contains([]string{"weight", "maxconn", "inter", "fall", "rise", "verify", "ca-file", "alpn", "resolvers", "cookie", "init-addr", "resolve-prefer", "sni", "check-sni"}, param) {
+			if i+1 >= len(params) {
+				im.errorf("server %s: option %s requires a value", name, param)
+				return
+			}
+			i++
+			value = params[i]
+		}
+		integer := func() int {
+			v, err := strconv.Atoi(value)
+			if err != nil {
+				im.errorf("server %s: invalid %s %q", name, param, value)
+			}
+			return v
+		}
+		switch param {
+		case "weight":
+			member.Weight = integer()
+			if member.Weight > 100 {
+				im.warnf("server %s: weight %d is limited to 100", name, member.Weight)
+				member.Weight = 100
+			}
+		case "maxconn":
+			member.MaxSessions = integer()
+		case "check":
+			options.check = true
+		case "disabled":
+			member.Enabled = false
+		case "enabled":
+			member.Enabled = true
+		case "backup":
+			member.Enabled = false
+			im.errorf("server %s: backup servers are not supported, the member is disabled", name)
+		case "inter":
+			if seconds, ok := im.parseSeconds(words, value); ok {
+				options.properties.HealthCheckInterval = seconds
+			}
+		case "fall":
+			options.properties.HealthCheckFall = integer()
+		case "rise":
+			options.properties.HealthCheckRise = integer()
+		case "ssl":
+			options.properties.TLSEnabled = upcloud.BoolPtr(true)
+		case "verify":
+			options.properties.TLSVerify = upcloud.BoolPtr(value != "none")
+		case "ca-file":
+			if value == "@system-ca" {
+				options.properties.TLSUseSystemCA = upcloud.BoolPtr(true)
+			} else {
+				im.warnf("server %s: ca-file %s is not translated, add the CA certificate as a backend TLS config", name, value)
+			}
+		case "alpn":
+			if slices.Contains(strings.Split(value, ","), "h2") {
+				options.properties.HTTP2Enabled = upcloud.BoolPtr(true)
+			}
+		case "send-proxy":
+			options.properties.OutboundProxyProtocol = upcloud.LoadBalancerProxyProtocolVersion1
+		case "send-proxy-v2":
+			options.properties.OutboundProxyProtocol = upcloud.LoadBalancerProxyProtocolVersion2
+		case "resolvers":
+			options.resolver = value
+		case "cookie":
+			// The cookie value of sticky sessions is managed by the load balancer
+		default:
+			im.warnf("server %s: option %s is not translated", name, param)
+		}
+	}
+
+	if options.resolver == "" {
+		if _, err := netip.ParseAddr(member.IP); err != nil {
+			im.errorf("server %s: hostname %s requires a resolver", name, member.IP)
+		}
+	}
+	b.backend.Members = append(b.backend.Members, member)
+	b.serverHasCheck = b.serverHasCheck || options.check
+
+	// Health check, TLS and proxy protocol options are set per server in HAProxy but per backend in the API
+	if b.firstServer == "" {
+		b.firstServer = name
+		b.firstOptions = options
+		b.backend.Resolver = options.resolver
+		if !reflect.ValueOf(options.properties).IsZero() {
+			mergeProperties(b.properties(), options.properties)
+		}
+		return
+	}
+	if !b.warnedOptions && !reflect.DeepEqual(b.firstOptions, options) {
+		b.warnedOptions = true
+		im.warnf("options of server %s differ from server %s, the options of %s apply to the whole backend", name, b.firstServer, b.firstServer)
+	}
+}
+
+func (im *importer) resolversDirective(words []string) {
+	r := im.resolver
+	switch {
+	case words[0] == "nameserver" && len(words) == 3:
+		address := words[2]
+		if addrPort, err := netip.ParseAddrPort(address); err == nil && addrPort.Port() == 53 {
+			address = addrPort.Addr().String()
+		}
+		r.Nameservers = append(r.Nameservers, address)
+	case words[0] == "resolve_retries" && len(words) == 2:
+		retries, err := strconv.Atoi(words[1])
+		if err != nil {
+			im.errorf("invalid resolve_retries %q", words[1])
+			return
+		}
+		r.Retries = retries
+	case words[0] == "timeout" && len(words) == 3 && (words[1] == "resolve" || words[1] == "retry"):
+		seconds, ok := im.parseSeconds(words, words[2])
+		if !ok {
+			return
+		}
+		if words[1] == "resolve" {
+			r.Timeout = seconds
+		} else {
+			r.TimeoutRetry = seconds
+		}
+	case words[0] == "hold" && len(words) == 3 && (words[1] == "valid" || words[1] == "nx"):
+		seconds, ok := im.parseSeconds(words, words[2])
+		if !ok {
+			return
+		}
+		if words[1] == "valid" {
+			r.CacheValid = seconds
+		} else {
+			r.CacheInvalid = seconds
+		}
+	default:
+		im.notTranslated(words)
+	}
+}
+
+// mergeProperties copies the non-zero fields of src to dst
+func mergeProperties[T any](dst *T, src T) {
+	d, v := reflect.ValueOf(dst).Elem(), reflect.ValueOf(src)
+	for i := range v.NumField() {
+		if !v.Field(i).IsZero() {
+			d.Field(i).Set(v.Field(i))
+		}
+	}
+}
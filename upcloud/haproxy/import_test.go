@@ -0,0 +1,307 @@
+package haproxy
+
+import (
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	lb, diagnostics := Import(`
+global
+    maxconn 4096
+
+defaults
+    mode http
+    timeout client 30s
+    timeout server 30000
+
+resolvers dns
+    nameserver ns1 10.0.0.1:53
+    resolve_retries 3
+    hold valid 10s
+
+frontend https
+    bind *:443 ssl crt /etc/haproxy/certs/example.com.pem alpn h2,http/1.1
+    acl api path_beg -i /api
+    acl static path_beg /static /assets
+    acl internal src 10.0.0.0/8
+    acl post method POST
+    http-request redirect scheme https code 301 if !{ ssl_fc }
+    http-request deny if { path_beg /admin } !internal
+    use_backend api if api post
+    http-request set-header X-Static 1 if static
+    use_backend static if static
+    use_backend api if { hdr(host) -i api.example.com } || { hdr_end(host) .api.example.com }
+    option forwardfor
+    default_backend web
+
+backend web
+    balance leastconn
+    option httpchk GET /health
+    http-check expect status 200
+    default-server check inter 5s
+    server w1 10.0.0.10:80 weight 50
+    server w2 10.0.0.11:80 weight 50 disabled
+
+backend api
+    server a1 api.internal:8000 resolvers dns maxconn 200
+
+backend static
+    cookie SERVERID insert indirect nocache
+    server s1 10.0.0.30:80
+`)
+
+	assert.Equal(t, `line 2: global: warning: global section is not translated
+line 16: frontend https: warning: certificate /etc/haproxy/certs/example.com.pem is translated to TLS config "example-com", which needs a certificate bundle
+line 21: frontend https: error: condition "if !{ ssl_fc }" cannot be translated: unsupported sample fetch "ssl_fc"
+line 31: backend web: warning: balance leastconn is not translated, members are balanced by weight
+`, diagnostics.String())
+	assert.True(t, diagnostics.HasErrors())
+
+	require.Len(t, lb.Resolvers, 1)
+	assert.Equal(t, upcloud.LoadBalancerResolver{Name: "dns", Nameservers: []string{"10.0.0.1"}, Retries: 3, CacheValid: 10}, lb.Resolvers[0])
+
+	require.Len(t, lb.Frontends, 1)
+	f := lb.Frontends[0]
+	assert.Equal(t, "https", f.Name)
+	assert.Equal(t, upcloud.LoadBalancerModeHTTP, f.Mode)
+	assert.Equal(t, 443, f.Port)
+	assert.Equal(t, "web", f.DefaultBackend)
+	assert.Equal(t, []upcloud.LoadBalancerFrontendTLSConfig{{Name: "example-com"}}, f.TLSConfigs)
+	assert.Equal(t, &upcloud.LoadBalancerFrontendProperties{TimeoutClient: 30, HTTP2Enabled: upcloud.BoolPtr(true)}, f.Properties)
+
+	inverse := func(m upcloud.LoadBalancerMatcher) upcloud.LoadBalancerMatcher {
+		m.Inverse = upcloud.BoolPtr(true)
+		return m
+	}
+	assert.Equal(t, []upcloud.LoadBalancerFrontendRule{
+		{
+			Name:              "rule-1",
+			Priority:          100,
+			MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+			Matchers: []upcloud.LoadBalancerMatcher{
+				request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/admin", nil),
+				inverse(request.NewLoadBalancerSrcIPMatcher("10.0.0.0/8")),
+			},
+			Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerHTTPReturnAction(403, "text/plain", "")},
+		},
+		{
+			Name:              "static",
+			Priority:          99,
+			MatchingCondition: upcloud.LoadBalancerMatchingConditionOr,
+			Matchers: []upcloud.LoadBalancerMatcher{
+				request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/static", nil),
+				request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/assets", nil),
+			},
+			Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerSetRequestHeaderAction("X-Static", "1")},
+		},
+		{
+			Name:              "rule-6",
+			Priority:          98,
+			MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+			Matchers:          []upcloud.LoadBalancerMatcher{},
+			Actions:           []upcloud.LoadBalancerAction{request.NewLoadBalancerSetForwardedHeadersAction()},
+		},
+		{
+			Name:              "rule-2",
+			Priority:          97,
+			MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+			Matchers: []upcloud.LoadBalancerMatcher{
+				request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/api", upcloud.BoolPtr(true)),
+				request.NewLoadBalancerHTTPMethodMatcher(upcloud.LoadBalancerHTTPMatcherMethodPost),
+			},
+			Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("api")},
+		},
+		{
+			Name:              "static-2",
+			Priority:          96,
+			MatchingCondition: upcloud.LoadBalancerMatchingConditionOr,
+			Matchers: []upcloud.LoadBalancerMatcher{
+				request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/static", nil),
+				request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/assets", nil),
+			},
+			Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("static")},
+		},
+		{
+			Name:              "rule-5",
+			Priority:          95,
+			MatchingCondition: upcloud.LoadBalancerMatchingConditionOr,
+			Matchers: []upcloud.LoadBalancerMatcher{
+				request.NewLoadBalancerHostMatcher("api.example.com"),
+				request.NewLoadBalancerRequestHeaderMatcher(upcloud.LoadBalancerStringMatcherMethodEnds, "host", ".api.example.com", nil),
+			},
+			Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("api")},
+		},
+	}, f.Rules)
+
+	require.Len(t, lb.Backends, 3)
+	assert.Equal(t, upcloud.LoadBalancerBackend{
+		Name: "web",
+		Members: []upcloud.LoadBalancerBackendMember{
+			{Name: "w1", IP: "10.0.0.10", Port: 80, Weight: 50, MaxSessions: DefaultMaxSessions, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+			{Name: "w2", IP: "10.0.0.11", Port: 80, Weight: 50, MaxSessions: DefaultMaxSessions, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: false},
+		},
+		Properties: &upcloud.LoadBalancerBackendProperties{
+			TimeoutServer:             30,
+			HealthCheckType:           upcloud.LoadBalancerHealthCheckTypeHTTP,
+			HealthCheckInterval:       5,
+			HealthCheckURL:            "/health",
+			HealthCheckExpectedStatus: 200,
+		},
+	}, lb.Backends[0])
+	assert.Equal(t, "dns", lb.Backends[1].Resolver)
+	assert.Equal(t, []upcloud.LoadBalancerBackendMember{
+		{Name: "a1", IP: "api.internal", Port: 8000, Weight: DefaultWeight, MaxSessions: 200, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+	}, lb.Backends[1].Members)
+	assert.Equal(t, "SERVERID", lb.Backends[2].Properties.StickySessionCookieName)
+}
+
+func TestImport_Errors(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name string
+		cfg  string
+		want string
+	}{
+		{
+			name: "listen",
+			cfg:  "listen stats\n    bind :8404",
+			want: "line 1: listen stats: error: listen sections are not supported, split them into a frontend and a backend\n",
+		},
+		{
+			name: "mixed and or",
+			cfg: `frontend http
+    bind :80
+    acl a path /a
+    acl b path /b
+    acl c path /c
+    use_backend web if a b || c
+backend web
+    server w1 10.0.0.1:80`,
+			want: `line 6: frontend http: error: condition "if a b || c" cannot be translated: conditions combining and and or are not supported` + "\n",
+		},
+		{
+			name: "unknown backend",
+			cfg:  "frontend http\n    bind :80\n    default_backend web",
+			want: "line 3: frontend http: error: backend \"web\" is not defined\n",
+		},
+		{
+			name: "second port",
+			cfg:  "frontend http\n    bind :80\n    bind :8080\n    http-request return status 200 string ok",
+			want: "line 3: frontend http: error: bind :8080 is not translated, a frontend listens on a single port\n",
+		},
+		{
+			name: "backup server",
+			cfg:  "backend web\n    server w1 10.0.0.1:80\n    server w2 10.0.0.2:80 backup check",
+			want: `line 3: backend web: error: server w2: backup servers are not supported, the member is disabled
+line 3: backend web: warning: options of server w2 differ from server w1, the options of w1 apply to the whole backend
+`,
+		},
+		{
+			name: "unsupported action",
+			cfg:  "frontend http\n    bind :80\n    http-request lua.auth",
+			want: "line 3: frontend http: error: directive \"http-request lua.auth\" is not supported\n",
+		},
+		{
+			name: "routing directives",
+			cfg:  "backend web\n    stick-table type ip size 1m\n    stick on src\n    use-server w1 if { path /a }\n    http-request deny\n    server w1 10.0.0.1:80",
+			want: `line 2: backend web: warning: directive "stick-table type ip size 1m" is not translated
+line 3: backend web: error: directive "stick on src" is not supported, it affects routing or access control
+line 4: backend web: error: directive "use-server w1 if { path /a }" is not supported, it affects routing or access control
+line 5: backend web: error: directive "http-request deny" is not supported, it affects routing or access control
+`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, diagnostics := Import(test.cfg)
+			assert.Equal(t, test.want, diagnostics.String())
+			assert.True(t, diagnostics.HasErrors())
+		})
+	}
+}
+
+func TestImport_Unless(t *testing.T) {
+	t.Parallel()
+
+	lb, diagnostics := Import(`
+frontend http
+    mode http
+    bind :80
+    acl internal src 10.0.0.0/8 192.168.0.0/16
+    http-request deny deny_status 401 unless internal
+`)
+	assert.Empty(t, diagnostics)
+	require.Len(t, lb.Frontends[0].Rules, 1)
+	rule := lb.Frontends[0].Rules[0]
+	assert.Equal(t, "not-internal", rule.Name)
+	assert.Equal(t, upcloud.LoadBalancerMatchingConditionAnd, rule.MatchingCondition)
+	assert.Equal(t, []upcloud.LoadBalancerMatcher{
+		request.NewLoadBalancerInverseMatcher(request.NewLoadBalancerSrcIPMatcher("10.0.0.0/8")),
+		request.NewLoadBalancerInverseMatcher(request.NewLoadBalancerSrcIPMatcher("192.168.0.0/16")),
+	}, rule.Matchers)
+	assert.Equal(t, []upcloud.LoadBalancerAction{request.NewLoadBalancerHTTPReturnAction(401, "text/plain", "")}, rule.Actions)
+}
+
+func TestImport_EvaluationOrder(t *testing.T) {
+	t.Parallel()
+
+	lb, diagnostics := Import(`
+frontend http
+    bind :80
+    acl old hdr(host) -i old.example.com
+    use_backend api if { path_beg /api }
+    redirect location https://example.com if old
+    http-request deny if { path_beg /admin }
+    tcp-request content reject if { src 192.0.2.0/24 }
+    use_backend web if old
+    http-request set-header X-Old 1 if old
+
+backend api
+    server a1 10.0.0.10:80
+
+backend web
+    server w1 10.0.0.20:80
+`)
+	assert.Empty(t, diagnostics.String())
+
+	type rule struct {
+		name     string
+		priority int
+		action   upcloud.LoadBalancerActionType
+	}
+	var got []rule
+	for _, r := range lb.Frontends[0].Rules {
+		for _, action := range r.Actions {
+			got = append(got, rule{r.Name, r.Priority, action.Type})
+		}
+	}
+	assert.Equal(t, []rule{
+		{"rule-4", 100, upcloud.LoadBalancerActionTypeTCPReject},
+		{"rule-3", 99, upcloud.LoadBalancerActionTypeHTTPReturn},
+		{"old-3", 98, upcloud.LoadBalancerActionTypeSetRequestHeader},
+		{"old", 97, upcloud.LoadBalancerActionTypeHTTPRedirect},
+		{"rule-1", 96, upcloud.LoadBalancerActionTypeUseBackend},
+		{"old-2", 95, upcloud.LoadBalancerActionTypeUseBackend},
+	}, got)
+}
+
+func TestParseSeconds(t *testing.T) {
+	t.Parallel()
+
+	for value, want := range map[string]int{"1500": 2, "30s": 30, "2m": 120, "1h": 3600, "100ms": 1} {
+		got, err := parseSeconds(value)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, value)
+	}
+	_, err := parseSeconds("1y")
+	assert.EqualError(t, err, `invalid time "1y"`)
+}
@@ -0,0 +1,339 @@
+package haproxy
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+// stringMethodSuffixes contains the fetch suffixes of the string matching methods, e.g. path_beg
+var stringMethodSuffixes = map[upcloud.LoadBalancerStringMatcherMethod]string{
+	upcloud.LoadBalancerStringMatcherMethodStarts:    "_beg",
+	upcloud.LoadBalancerStringMatcherMethodEnds:      "_end",
+	upcloud.LoadBalancerStringMatcherMethodSubstring: "_sub",
+	upcloud.LoadBalancerStringMatcherMethodRegexp:    "_reg",
+	upcloud.LoadBalancerStringMatcherMethodDomain:    "_dom",
+}
+
+// stringMethodFlags contains the values of the -m flag for the string matching methods
+var stringMethodFlags = map[string]upcloud.LoadBalancerStringMatcherMethod{
+	"str":   upcloud.LoadBalancerStringMatcherMethodExact,
+	"beg":   upcloud.LoadBalancerStringMatcherMethodStarts,
+	"end":   upcloud.LoadBalancerStringMatcherMethodEnds,
+	"sub":   upcloud.LoadBalancerStringMatcherMethodSubstring,
+	"reg":   upcloud.LoadBalancerStringMatcherMethodRegexp,
+	"dom":   upcloud.LoadBalancerStringMatcherMethodDomain,
+	"ip":    upcloud.LoadBalancerStringMatcherMethodIP,
+	"found": upcloud.LoadBalancerStringMatcherMethodExists,
+}
+
+// integerOperators contains the HAProxy operators of the integer matching methods
+var integerOperators = map[string]upcloud.LoadBalancerIntegerMatcherMethod{
+	"eq": upcloud.LoadBalancerIntegerMatcherMethodEqual,
+	"ge": upcloud.LoadBalancerIntegerMatcherMethodGreaterOrEqual,
+	"gt": upcloud.LoadBalancerIntegerMatcherMethodGreater,
+	"le": upcloud.LoadBalancerIntegerMatcherMethodLessOrEqual,
+	"lt": upcloud.LoadBalancerIntegerMatcherMethodLess,
+}
+
+// fetchTypes maps the supported sample fetches to matcher types
+var fetchTypes = map[string]upcloud.LoadBalancerMatcherType{
+	"path":          upcloud.LoadBalancerMatcherTypePath,
+	"url":           upcloud.LoadBalancerMatcherTypeURL,
+	"query":         upcloud.LoadBalancerMatcherTypeURLQuery,
+	"hdr":           upcloud.LoadBalancerMatcherTypeRequestHeader,
+	"req.hdr":       upcloud.LoadBalancerMatcherTypeRequestHeader,
+	"res.hdr":       upcloud.LoadBalancerMatcherTypeResponseHeader,
+	"cook":          upcloud.LoadBalancerMatcherTypeCookie,
+	"req.cook":      upcloud.LoadBalancerMatcherTypeCookie,
+	"urlp":          upcloud.LoadBalancerMatcherTypeURLParam,
+	"url_param":     upcloud.LoadBalancerMatcherTypeURLParam,
+	"src":           upcloud.LoadBalancerMatcherTypeSrcIP,
+	"src_port":      upcloud.LoadBalancerMatcherTypeSrcPort,
+	"status":        upcloud.LoadBalancerMatcherTypeHTTPStatus,
+	"req.body_size": upcloud.LoadBalancerMatcherTypeBodySize,
+	"nbsrv":         upcloud.LoadBalancerMatcherTypeNumMembersUp,
+	"method":        upcloud.LoadBalancerMatcherTypeHTTPMethod,
+}
+
+// suffixFetches are the fetches that have method suffix variants, e.g. hdr_beg(host)
+var suffixFetches = []string{"path", "url", "hdr", "cook", "urlp", "url_param"}
+
+var httpMethods = []upcloud.LoadBalancerHTTPMatcherMethod{
+	upcloud.LoadBalancerHTTPMatcherMethodGet,
+	upcloud.LoadBalancerHTTPMatcherMethodHead,
+	upcloud.LoadBalancerHTTPMatcherMethodPost,
+	upcloud.LoadBalancerHTTPMatcherMethodPut,
+	upcloud.LoadBalancerHTTPMatcherMethodPatch,
+	upcloud.LoadBalancerHTTPMatcherMethodDelete,
+	upcloud.LoadBalancerHTTPMatcherMethodConnect,
+	upcloud.LoadBalancerHTTPMatcherMethodOptions,
+	upcloud.LoadBalancerHTTPMatcherMethodTrace,
+}
+
+// parseMatchers translates an ACL criterion, i.e. a sample fetch followed by flags and values, to matchers. HAProxy
+// matches any of the values, so there is one matcher per value.
+func parseMatchers(words []string) ([]upcloud.LoadBalancerMatcher, error) {
+	if len(words) == 0 {
+		return nil, fmt.Errorf("missing sample fetch")
+	}
+	fetch, arg, hasArg := strings.Cut(words[0], "(")
+	if hasArg {
+		if !strings.HasSuffix(arg, ")") {
+			return nil, fmt.Errorf("invalid sample fetch %q", words[0])
+		}
+		arg = strings.TrimSuffix(arg, ")")
+	}
+
+	var method upcloud.LoadBalancerStringMatcherMethod
+	for m, suffix := range stringMethodSuffixes {
+		if base, ok := strings.CutSuffix(fetch, suffix); ok && slices.Contains(suffixFetches, base) {
+			fetch, method = base, m
+		}
+	}
+	matcherType, ok := fetchTypes[fetch]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sample fetch %q", words[0])
+	}
+
+	ignoreCase := false
+	values := words[1:]
+flags:
+	for len(values) > 0 && strings.HasPrefix(values[0], "-") {
+		flag := values[0]
+		values = values[1:]
+		switch flag {
+		case "-i":
+			ignoreCase = true
+		case "-n":
+		case "-m":
+			if len(values) == 0 {
+				return nil, fmt.Errorf("missing match method after -m")
+			}
+			m, ok := stringMethodFlags[values[0]]
+			if !ok && values[0] != "int" {
+				return nil, fmt.Errorf("unsupported match method %q", values[0])
+			}
+			method = m
+			values = values[1:]
+		case "--":
+			break flags
+		default:
+			return nil, fmt.Errorf("unsupported ACL flag %q", flag)
+		}
+	}
+	if method == "" {
+		method = upcloud.LoadBalancerStringMatcherMethodExact
+	}
+	if len(values) == 0 && method != upcloud.LoadBalancerStringMatcherMethodExists {
+		return nil, fmt.Errorf("missing value")
+	}
+	if method == upcloud.LoadBalancerStringMatcherMethodExists {
+		values = []string{""}
+	}
+
+	var matchers []upcloud.LoadBalancerMatcher
+	needsArg := func() error {
+		if arg == "" {
+			return fmt.Errorf("sample fetch %q requires an argument", fetch)
+		}
+		return nil
+	}
+	switch matcherType {
+	case upcloud.LoadBalancerMatcherTypeSrcIP:
+		for _, v := range values {
+			matchers = append(matchers, upcloud.LoadBalancerMatcher{Type: matcherType, SrcIP: &upcloud.LoadBalancerMatcherSourceIP{Value: v}})
+		}
+	case upcloud.LoadBalancerMatcherTypeHTTPMethod:
+		for _, v := range values {
+			m := upcloud.LoadBalancerHTTPMatcherMethod(strings.ToUpper(v))
+			if !slices.Contains(httpMethods, m) {
+				return nil, fmt.Errorf("unsupported HTTP method %q", v)
+			}
+			matchers = append(matchers, upcloud.LoadBalancerMatcher{Type: matcherType, HTTPMethod: &upcloud.LoadBalancerMatcherHTTPMethod{Value: m}})
+		}
+	case upcloud.LoadBalancerMatcherTypeSrcPort, upcloud.LoadBalancerMatcherTypeHTTPStatus, upcloud.LoadBalancerMatcherTypeBodySize, upcloud.LoadBalancerMatcherTypeNumMembersUp:
+		if matcherType == upcloud.LoadBalancerMatcherTypeNumMembersUp {
+			if err := needsArg(); err != nil {
+				return nil, err
+			}
+		}
+		integers, err := parseIntegerMatchers(values)
+		if err != nil {
+			return nil, err
+		}
+		for _, i := range integers {
+			m := upcloud.LoadBalancerMatcher{Type: matcherType}
+			switch matcherType {
+			case upcloud.LoadBalancerMatcherTypeSrcPort:
+				m.SrcPort = i
+			case upcloud.LoadBalancerMatcherTypeHTTPStatus:
+				m.HTTPStatus = i
+			case upcloud.LoadBalancerMatcherTypeBodySize:
+				m.BodySize = i
+			default:
+				if i.Method == upcloud.LoadBalancerIntegerMatcherMethodRange {
+					return nil, fmt.Errorf("ranges are not supported for %s", fetch)
+				}
+				m.NumMembersUp = &upcloud.LoadBalancerMatcherNumMembersUp{Method: i.Method, Value: i.Value, Backend: arg}
+			}
+			matchers = append(matchers, m)
+		}
+	case upcloud.LoadBalancerMatcherTypePath, upcloud.LoadBalancerMatcherTypeURL, upcloud.LoadBalancerMatcherTypeURLQuery:
+		for _, v := range values {
+			s := &upcloud.LoadBalancerMatcherString{Method: method, Value: v, IgnoreCase: ignoreCasePtr(ignoreCase)}
+			m := upcloud.LoadBalancerMatcher{Type: matcherType}
+			switch matcherType {
+			case upcloud.LoadBalancerMatcherTypePath:
+				m.Path = s
+			case upcloud.LoadBalancerMatcherTypeURL:
+				m.URL = s
+			default:
+				m.URLQuery = s
+			}
+			matchers = append(matchers, m)
+		}
+	default:
+		if err := needsArg(); err != nil {
+			return nil, err
+		}
+		for _, v := range values {
+			if matcherType == upcloud.LoadBalancerMatcherTypeRequestHeader && strings.EqualFold(arg, "host") && method == upcloud.LoadBalancerStringMatcherMethodExact {
+				matchers = append(matchers, upcloud.LoadBalancerMatcher{Type: upcloud.LoadBalancerMatcherTypeHost, Host: &upcloud.LoadBalancerMatcherHost{Value: v}})
+				continue
+			}
+			s := &upcloud.LoadBalancerMatcherStringWithArgument{Method: method, Name: arg, Value: v, IgnoreCase: ignoreCasePtr(ignoreCase)}
+			m := upcloud.LoadBalancerMatcher{Type: matcherType}
+			switch matcherType {
+			case upcloud.LoadBalancerMatcherTypeRequestHeader:
+				m.RequestHeader = s
+			case upcloud.LoadBalancerMatcherTypeResponseHeader:
+				m.ResponseHeader = s
+			case upcloud.LoadBalancerMatcherTypeCookie:
+				m.Cookie = s
+			default:
+				m.URLParam = s
+			}
+			matchers = append(matchers, m)
+		}
+	}
+	return matchers, nil
+}
+
+// parseIntegerMatchers parses integer values, which are either an operator followed by a single value, or a list of
+// values and ranges
+func parseIntegerMatchers(values []string) ([]*upcloud.LoadBalancerMatcherInteger, error) {
+	if method, ok := integerOperators[values[0]]; ok {
+		if len(values) != 2 {
+			return nil, fmt.Errorf("operator %s requires a single value", values[0])
+		}
+		v, err := strconv.Atoi(values[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", values[1])
+		}
+		return []*upcloud.LoadBalancerMatcherInteger{{Method: method, Value: v}}, nil
+	}
+
+	var integers []*upcloud.LoadBalancerMatcherInteger
+	for _, value := range values {
+		if start, end, ok := strings.Cut(value, ":"); ok {
+			s, errStart := strconv.Atoi(start)
+			e, errEnd := strconv.Atoi(end)
+			if errStart != nil || errEnd != nil {
+				return nil, fmt.Errorf("invalid range %q", value)
+			}
+			integers = append(integers, &upcloud.LoadBalancerMatcherInteger{Method: upcloud.LoadBalancerIntegerMatcherMethodRange, RangeStart: s, RangeEnd: e})
+			continue
+		}
+		v, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %q", value)
+		}
+		integers = append(integers, &upcloud.LoadBalancerMatcherInteger{Method: upcloud.LoadBalancerIntegerMatcherMethodEqual, Value: v})
+	}
+	return integers, nil
+}
+
+func ignoreCasePtr(ignoreCase bool) *bool {
+	if !ignoreCase {
+		return nil
+	}
+	return upcloud.BoolPtr(true)
+}
+
+// formatMatcher translates a matcher to an ACL criterion. The inverse flag of the matcher is not included.
+func formatMatcher(m upcloud.LoadBalancerMatcher) (string, error) {
+	formatString := func(fetch, arg string, method upcloud.LoadBalancerStringMatcherMethod, value string, ignoreCase *bool, hasSuffixes bool) string {
+		words := []string{fetch}
+		if suffix, ok := stringMethodSuffixes[method]; ok && hasSuffixes {
+			words[0] += suffix
+		} else if method != "" && method != upcloud.LoadBalancerStringMatcherMethodExact {
+			for flag, m := range stringMethodFlags {
+				if m == method {
+					words = append(words, "-m", flag)
+				}
+			}
+		}
+		if arg != "" {
+			words[0] += "(" + arg + ")"
+		}
+		if ignoreCase != nil && *ignoreCase {
+			words = append(words, "-i")
+		}
+		if method != upcloud.LoadBalancerStringMatcherMethodExists {
+			words = append(words, quote(value))
+		}
+		return strings.Join(words, " ")
+	}
+	formatInteger := func(fetch string, i *upcloud.LoadBalancerMatcherInteger) string {
+		switch i.Method {
+		case upcloud.LoadBalancerIntegerMatcherMethodRange:
+			return fmt.Sprintf("%s %d:%d", fetch, i.RangeStart, i.RangeEnd)
+		case upcloud.LoadBalancerIntegerMatcherMethodEqual, "":
+			return fmt.Sprintf("%s %d", fetch, i.Value)
+		}
+		for operator, method := range integerOperators {
+			if method == i.Method {
+				return fmt.Sprintf("%s %s %d", fetch, operator, i.Value)
+			}
+		}
+		return fmt.Sprintf("%s %d", fetch, i.Value)
+	}
+
+	switch {
+	case m.Path != nil:
+		return formatString("path", "", m.Path.Method, m.Path.Value, m.Path.IgnoreCase, true), nil
+	case m.URL != nil:
+		return formatString("url", "", m.URL.Method, m.URL.Value, m.URL.IgnoreCase, true), nil
+	case m.URLQuery != nil:
+		return formatString("query", "", m.URLQuery.Method, m.URLQuery.Value, m.URLQuery.IgnoreCase, false), nil
+	case m.Host != nil:
+		return "hdr(host) -i " + quote(m.Host.Value), nil
+	case m.RequestHeader != nil:
+		return formatString("hdr", m.RequestHeader.Name, m.RequestHeader.Method, m.RequestHeader.Value, m.RequestHeader.IgnoreCase, true), nil
+	case m.Header != nil:
+		return formatString("hdr", m.Header.Name, m.Header.Method, m.Header.Value, m.Header.IgnoreCase, true), nil
+	case m.ResponseHeader != nil:
+		return formatString("res.hdr", m.ResponseHeader.Name, m.ResponseHeader.Method, m.ResponseHeader.Value, m.ResponseHeader.IgnoreCase, false), nil
+	case m.Cookie != nil:
+		return formatString("cook", m.Cookie.Name, m.Cookie.Method, m.Cookie.Value, m.Cookie.IgnoreCase, true), nil
+	case m.URLParam != nil:
+		return formatString("urlp", m.URLParam.Name, m.URLParam.Method, m.URLParam.Value, m.URLParam.IgnoreCase, true), nil
+	case m.SrcIP != nil:
+		return "src " + m.SrcIP.Value, nil
+	case m.SrcPort != nil:
+		return formatInteger("src_port", m.SrcPort), nil
+	case m.HTTPStatus != nil:
+		return formatInteger("status", m.HTTPStatus), nil
+	case m.BodySize != nil:
+		return formatInteger("req.body_size", m.BodySize), nil
+	case m.NumMembersUp != nil:
+		return formatInteger("nbsrv("+m.NumMembersUp.Backend+")", &upcloud.LoadBalancerMatcherInteger{Method: m.NumMembersUp.Method, Value: m.NumMembersUp.Value}), nil
+	case m.HTTPMethod != nil:
+		return "method " + string(m.HTTPMethod.Value), nil
+	}
+	return "", fmt.Errorf("matcher of type %q has no HAProxy equivalent", m.Type)
+}
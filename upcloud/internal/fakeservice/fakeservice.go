@@ -0,0 +1,720 @@
+// Package fakeservice provides an in-memory fake of the UpCloud API for the tests of the helper packages.
+//
+// Service implements the methods of *service.Service that the helper packages use on top of the resources set in
+// its fields. Modifying methods update the resources, so a test can assert on the resulting state as well as on
+// the calls recorded by Calls.
+package fakeservice
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Call is a call made to the fake
+type Call struct {
+	Method string
+	// Target identifies the resource of the call, e.g. a server UUID or "<load balancer UUID>/<backend>/<member>"
+	Target  string
+	Request any
+}
+
+// String returns the method and the target of the call, e.g. "StopServer server-1"
+func (c Call) String() string {
+	if c.Target == "" {
+		return c.Method
+	}
+	return c.Method + " " + c.Target
+}
+
+// Resources are the resources of the account the fake serves
+type Resources struct {
+	Servers            []upcloud.ServerDetails
+	ServerGroups       []upcloud.ServerGroup
+	Networks           []upcloud.Network
+	Routers            []upcloud.Router
+	Gateways           []upcloud.Gateway
+	NetworkPeerings    upcloud.NetworkPeerings
+	IPAddresses        []upcloud.IPAddress
+	LoadBalancers      []upcloud.LoadBalancer
+	CertificateBundles []upcloud.LoadBalancerCertificateBundle
+	// KubernetesNodeGroups is keyed by "<cluster UUID>/<node group name>"
+	KubernetesNodeGroups map[string]upcloud.KubernetesNodeGroupDetails
+}
+
+// Service is an in-memory fake of *service.Service
+type Service struct {
+	Resources
+
+	// Errors makes calls fail without modifying the resources. A call fails with the error keyed by its string
+	// representation, e.g. "ModifyIPAddress 192.0.2.10", or by its method name.
+	Errors map[string]error
+
+	mu    sync.Mutex
+	calls []Call
+	uuids int
+}
+
+// New returns a fake serving a deep copy of the resources, so the same resources can be shared by parallel tests
+// that modify them.
+func New(resources Resources) *Service {
+	return &Service{
+		Resources: deepCopy(reflect.ValueOf(resources)).Interface().(Resources),
+		Errors:    map[string]error{},
+	}
+}
+
+func deepCopy(v reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		for i := range v.Len() {
+			c.Index(i).Set(deepCopy(v.Index(i)))
+		}
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		for iter := v.MapRange(); iter.Next(); {
+			c.SetMapIndex(iter.Key(), deepCopy(iter.Value()))
+		}
+		return c
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		if v.Kind() == reflect.Pointer {
+			c.Set(reflect.New(v.Type().Elem()))
+			c.Elem().Set(deepCopy(v.Elem()))
+		} else {
+			c.Set(deepCopy(v.Elem()))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := range v.NumField() {
+			if c.Field(i).CanSet() {
+				c.Field(i).Set(deepCopy(v.Field(i)))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+// Calls returns the string representation of the calls made to the given methods in order, or of all calls if no
+// methods are given.
+func (s *Service) Calls(methods ...string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var calls []string
+	for _, call := range s.calls {
+		if len(methods) == 0 || slices.Contains(methods, call.Method) {
+			calls = append(calls, call.String())
+		}
+	}
+	return calls
+}
+
+// Requests returns the requests of the calls made to the given method in order
+func (s *Service) Requests(method string) []any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var requests []any
+	for _, call := range s.calls {
+		if call.Method == method {
+			requests = append(requests, call.Request)
+		}
+	}
+	return requests
+}
+
+// call records a call and returns its configured error. The caller must hold the lock.
+func (s *Service) call(method, target string, r any) error {
+	call := Call{Method: method, Target: target, Request: r}
+	s.calls = append(s.calls, call)
+	if err, ok := s.Errors[call.String()]; ok {
+		return err
+	}
+	return s.Errors[method]
+}
+
+func (s *Service) newUUID() string {
+	s.uuids++
+	return fmt.Sprintf("uuid-%d", s.uuids)
+}
+
+func notFound(kind, id string) error {
+	return &upcloud.Problem{
+		Type:   "https://developers.upcloud.com/1.3/errors#ERROR_NOT_FOUND",
+		Title:  fmt.Sprintf("The %s %s does not exist.", kind, id),
+		Status: 404,
+	}
+}
+
+func (s *Service) GetServers(context.Context) (*upcloud.Servers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetServers", "", nil); err != nil {
+		return nil, err
+	}
+	servers := &upcloud.Servers{}
+	for _, server := range s.Servers {
+		servers.Servers = append(servers.Servers, server.Server)
+	}
+	return servers, nil
+}
+
+// GetServersWithFilters returns the servers matching the label filters. Other filters are ignored.
+func (s *Service) GetServersWithFilters(_ context.Context, r *request.GetServersWithFiltersRequest) (*upcloud.Servers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	params := make([]string, 0, len(r.Filters))
+	for _, filter := range r.Filters {
+		params = append(params, filter.ToQueryParam())
+	}
+	if err := s.call("GetServersWithFilters", strings.Join(params, "&"), r); err != nil {
+		return nil, err
+	}
+	servers := &upcloud.Servers{}
+	for _, server := range s.Servers {
+		if matchesFilters(server.Labels, r.Filters) {
+			servers.Servers = append(servers.Servers, server.Server)
+		}
+	}
+	return servers, nil
+}
+
+func matchesFilters(labels upcloud.LabelSlice, filters []request.QueryFilter) bool {
+	for _, filter := range filters {
+		switch f := filter.(type) {
+		case request.FilterLabel:
+			if !slices.Contains(labels, f.Label) {
+				return false
+			}
+		case request.FilterLabelKey:
+			if !slices.ContainsFunc(labels, func(l upcloud.Label) bool { return l.Key == f.Key }) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (s *Service) server(uuid string) (*upcloud.ServerDetails, error) {
+	for i := range s.Servers {
+		if s.Servers[i].UUID == uuid {
+			return &s.Servers[i], nil
+		}
+	}
+	return nil, notFound("server", uuid)
+}
+
+func (s *Service) GetServerDetails(_ context.Context, r *request.GetServerDetailsRequest) (*upcloud.ServerDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetServerDetails", r.UUID, r); err != nil {
+		return nil, err
+	}
+	server, err := s.server(r.UUID)
+	if err != nil {
+		return nil, err
+	}
+	details := *server
+	return &details, nil
+}
+
+func (s *Service) GetServerNetworks(_ context.Context, r *request.GetServerNetworksRequest) (*upcloud.Networking, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetServerNetworks", r.ServerUUID, r); err != nil {
+		return nil, err
+	}
+	server, err := s.server(r.ServerUUID)
+	if err != nil {
+		return nil, err
+	}
+	networking := upcloud.Networking(server.Networking)
+	return &networking, nil
+}
+
+// StopServer sets the state of the server to stopped right away
+func (s *Service) StopServer(_ context.Context, r *request.StopServerRequest) (*upcloud.ServerDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("StopServer", r.UUID, r); err != nil {
+		return nil, err
+	}
+	server, err := s.server(r.UUID)
+	if err != nil {
+		return nil, err
+	}
+	server.State = upcloud.ServerStateStopped
+	details := *server
+	return &details, nil
+}
+
+func (s *Service) GetServerGroup(_ context.Context, r *request.GetServerGroupRequest) (*upcloud.ServerGroup, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetServerGroup", r.UUID, r); err != nil {
+		return nil, err
+	}
+	for _, group := range s.ServerGroups {
+		if group.UUID == r.UUID {
+			return &group, nil
+		}
+	}
+	return nil, notFound("server group", r.UUID)
+}
+
+func (s *Service) GetKubernetesNodeGroup(_ context.Context, r *request.GetKubernetesNodeGroupRequest) (*upcloud.KubernetesNodeGroupDetails, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := r.ClusterUUID + "/" + r.Name
+	if err := s.call("GetKubernetesNodeGroup", key, r); err != nil {
+		return nil, err
+	}
+	group, ok := s.KubernetesNodeGroups[key]
+	if !ok {
+		return nil, notFound("node group", key)
+	}
+	return &group, nil
+}
+
+func (s *Service) GetNetworks(context.Context, ...request.QueryFilter) (*upcloud.Networks, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetNetworks", "", nil); err != nil {
+		return nil, err
+	}
+	return &upcloud.Networks{Networks: slices.Clone(s.Networks)}, nil
+}
+
+func (s *Service) GetNetworksInZone(_ context.Context, r *request.GetNetworksInZoneRequest) (*upcloud.Networks, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetNetworksInZone", r.Zone, r); err != nil {
+		return nil, err
+	}
+	networks := &upcloud.Networks{}
+	for _, network := range s.Networks {
+		if network.Zone == r.Zone {
+			networks.Networks = append(networks.Networks, network)
+		}
+	}
+	return networks, nil
+}
+
+func (s *Service) GetNetworkDetails(_ context.Context, r *request.GetNetworkDetailsRequest) (*upcloud.Network, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetNetworkDetails", r.UUID, r); err != nil {
+		return nil, err
+	}
+	for _, network := range s.Networks {
+		if network.UUID == r.UUID {
+			return &network, nil
+		}
+	}
+	return nil, notFound("network", r.UUID)
+}
+
+func (s *Service) GetRouters(context.Context, ...request.QueryFilter) (*upcloud.Routers, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetRouters", "", nil); err != nil {
+		return nil, err
+	}
+	return &upcloud.Routers{Routers: slices.Clone(s.Routers)}, nil
+}
+
+func (s *Service) GetGateways(context.Context, ...request.QueryFilter) ([]upcloud.Gateway, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetGateways", "", nil); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.Gateways), nil
+}
+
+func (s *Service) GetNetworkPeerings(context.Context, ...request.QueryFilter) (upcloud.NetworkPeerings, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetNetworkPeerings", "", nil); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.NetworkPeerings), nil
+}
+
+func (s *Service) GetIPAddresses(context.Context) (*upcloud.IPAddresses, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetIPAddresses", "", nil); err != nil {
+		return nil, err
+	}
+	return &upcloud.IPAddresses{IPAddresses: slices.Clone(s.IPAddresses)}, nil
+}
+
+func (s *Service) ipAddress(address string) (*upcloud.IPAddress, error) {
+	for i := range s.IPAddresses {
+		if s.IPAddresses[i].Address == address {
+			return &s.IPAddresses[i], nil
+		}
+	}
+	return nil, notFound("IP address", address)
+}
+
+func (s *Service) GetIPAddressDetails(_ context.Context, r *request.GetIPAddressDetailsRequest) (*upcloud.IPAddress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetIPAddressDetails", r.Address, r); err != nil {
+		return nil, err
+	}
+	ip, err := s.ipAddress(r.Address)
+	if err != nil {
+		return nil, err
+	}
+	details := *ip
+	return &details, nil
+}
+
+// ModifyIPAddress sets the PTR record and attaches the address to the server that has an interface with the MAC
+// address. An empty MAC address is ignored.
+func (s *Service) ModifyIPAddress(_ context.Context, r *request.ModifyIPAddressRequest) (*upcloud.IPAddress, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ModifyIPAddress", r.IPAddress, r); err != nil {
+		return nil, err
+	}
+	ip, err := s.ipAddress(r.IPAddress)
+	if err != nil {
+		return nil, err
+	}
+	if r.MAC != "" {
+		serverUUID, ok := s.serverWithMAC(r.MAC)
+		if !ok {
+			return nil, notFound("MAC address", r.MAC)
+		}
+		ip.MAC = r.MAC
+		ip.ServerUUID = serverUUID
+	}
+	if r.PTRRecord != "" {
+		ip.PTRRecord = r.PTRRecord
+	}
+	details := *ip
+	return &details, nil
+}
+
+func (s *Service) serverWithMAC(mac string) (string, bool) {
+	for _, server := range s.Servers {
+		for _, iface := range server.Networking.Interfaces {
+			if iface.MAC == mac {
+				return server.UUID, true
+			}
+		}
+	}
+	return "", false
+}
+
+func (s *Service) GetLoadBalancers(_ context.Context, r *request.GetLoadBalancersRequest) ([]upcloud.LoadBalancer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetLoadBalancers", "", r); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.LoadBalancers), nil
+}
+
+func (s *Service) loadBalancer(uuid string) (*upcloud.LoadBalancer, error) {
+	for i := range s.LoadBalancers {
+		if s.LoadBalancers[i].UUID == uuid {
+			return &s.LoadBalancers[i], nil
+		}
+	}
+	return nil, notFound("load balancer", uuid)
+}
+
+func (s *Service) GetLoadBalancer(_ context.Context, r *request.GetLoadBalancerRequest) (*upcloud.LoadBalancer, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetLoadBalancer", r.UUID, r); err != nil {
+		return nil, err
+	}
+	lb, err := s.loadBalancer(r.UUID)
+	if err != nil {
+		return nil, err
+	}
+	details := *lb
+	return &details, nil
+}
+
+// ApplyLoadBalancer only records the request, the returned plan has no changes
+func (s *Service) ApplyLoadBalancer(_ context.Context, r *request.ApplyLoadBalancerRequest) (*upcloud.LoadBalancerChangePlan, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ApplyLoadBalancer", r.LoadBalancer.Name, r); err != nil {
+		return nil, err
+	}
+	return &upcloud.LoadBalancerChangePlan{Name: r.LoadBalancer.Name, Applied: !r.DryRun}, nil
+}
+
+func (s *Service) backend(serviceUUID, name string) (*upcloud.LoadBalancerBackend, error) {
+	lb, err := s.loadBalancer(serviceUUID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lb.Backends {
+		if lb.Backends[i].Name == name {
+			return &lb.Backends[i], nil
+		}
+	}
+	return nil, notFound("backend", serviceUUID+"/"+name)
+}
+
+func (s *Service) GetLoadBalancerBackend(_ context.Context, r *request.GetLoadBalancerBackendRequest) (*upcloud.LoadBalancerBackend, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetLoadBalancerBackend", r.ServiceUUID+"/"+r.Name, r); err != nil {
+		return nil, err
+	}
+	backend, err := s.backend(r.ServiceUUID, r.Name)
+	if err != nil {
+		return nil, err
+	}
+	details := *backend
+	details.Members = slices.Clone(backend.Members)
+	return &details, nil
+}
+
+func (s *Service) CreateLoadBalancerBackendMember(_ context.Context, r *request.CreateLoadBalancerBackendMemberRequest) (*upcloud.LoadBalancerBackendMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateLoadBalancerBackendMember", r.ServiceUUID+"/"+r.BackendName+"/"+r.Member.Name, r); err != nil {
+		return nil, err
+	}
+	backend, err := s.backend(r.ServiceUUID, r.BackendName)
+	if err != nil {
+		return nil, err
+	}
+	member := upcloud.LoadBalancerBackendMember{
+		Name:        r.Member.Name,
+		IP:          r.Member.IP,
+		Port:        r.Member.Port,
+		Weight:      r.Member.Weight,
+		MaxSessions: r.Member.MaxSessions,
+		Type:        r.Member.Type,
+		Enabled:     r.Member.Enabled,
+	}
+	backend.Members = append(backend.Members, member)
+	return &member, nil
+}
+
+func (s *Service) ModifyLoadBalancerBackendMember(_ context.Context, r *request.ModifyLoadBalancerBackendMemberRequest) (*upcloud.LoadBalancerBackendMember, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ModifyLoadBalancerBackendMember", r.ServiceUUID+"/"+r.BackendName+"/"+r.Name, r); err != nil {
+		return nil, err
+	}
+	backend, err := s.backend(r.ServiceUUID, r.BackendName)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(backend.Members, func(m upcloud.LoadBalancerBackendMember) bool { return m.Name == r.Name })
+	if i < 0 {
+		return nil, notFound("member", r.Name)
+	}
+	member := &backend.Members[i]
+	if r.Member.Name != "" {
+		member.Name = r.Member.Name
+	}
+	if r.Member.Type != "" {
+		member.Type = r.Member.Type
+	}
+	if r.Member.Port != 0 {
+		member.Port = r.Member.Port
+	}
+	if r.Member.Weight != nil {
+		member.Weight = *r.Member.Weight
+	}
+	if r.Member.MaxSessions != nil {
+		member.MaxSessions = *r.Member.MaxSessions
+	}
+	if r.Member.Enabled != nil {
+		member.Enabled = *r.Member.Enabled
+	}
+	if r.Member.IP != nil {
+		member.IP = *r.Member.IP
+	}
+	modified := *member
+	return &modified, nil
+}
+
+func (s *Service) DeleteLoadBalancerBackendMember(_ context.Context, r *request.DeleteLoadBalancerBackendMemberRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("DeleteLoadBalancerBackendMember", r.ServiceUUID+"/"+r.BackendName+"/"+r.Name, r); err != nil {
+		return err
+	}
+	backend, err := s.backend(r.ServiceUUID, r.BackendName)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(backend.Members, func(m upcloud.LoadBalancerBackendMember) bool { return m.Name == r.Name })
+	if i < 0 {
+		return notFound("member", r.Name)
+	}
+	backend.Members = slices.Delete(backend.Members, i, i+1)
+	return nil
+}
+
+func (s *Service) ModifyLoadBalancerFrontendTLSConfig(_ context.Context, r *request.ModifyLoadBalancerFrontendTLSConfigRequest) (*upcloud.LoadBalancerFrontendTLSConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ModifyLoadBalancerFrontendTLSConfig", r.ServiceUUID+"/"+r.FrontendName+"/"+r.Name, r); err != nil {
+		return nil, err
+	}
+	lb, err := s.loadBalancer(r.ServiceUUID)
+	if err != nil {
+		return nil, err
+	}
+	for i := range lb.Frontends {
+		if lb.Frontends[i].Name != r.FrontendName {
+			continue
+		}
+		for j := range lb.Frontends[i].TLSConfigs {
+			config := &lb.Frontends[i].TLSConfigs[j]
+			if config.Name == r.Name {
+				config.CertificateBundleUUID = r.Config.CertificateBundleUUID
+				modified := *config
+				return &modified, nil
+			}
+		}
+	}
+	return nil, notFound("TLS config", r.FrontendName+"/"+r.Name)
+}
+
+func (s *Service) ModifyLoadBalancerBackendTLSConfig(_ context.Context, r *request.ModifyLoadBalancerBackendTLSConfigRequest) (*upcloud.LoadBalancerBackendTLSConfig, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("ModifyLoadBalancerBackendTLSConfig", r.ServiceUUID+"/"+r.BackendName+"/"+r.Name, r); err != nil {
+		return nil, err
+	}
+	backend, err := s.backend(r.ServiceUUID, r.BackendName)
+	if err != nil {
+		return nil, err
+	}
+	for i := range backend.TLSConfigs {
+		config := &backend.TLSConfigs[i]
+		if config.Name == r.Name {
+			config.CertificateBundleUUID = r.Config.CertificateBundleUUID
+			modified := *config
+			return &modified, nil
+		}
+	}
+	return nil, notFound("TLS config", r.BackendName+"/"+r.Name)
+}
+
+func (s *Service) GetLoadBalancerCertificateBundles(_ context.Context, r *request.GetLoadBalancerCertificateBundlesRequest) ([]upcloud.LoadBalancerCertificateBundle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("GetLoadBalancerCertificateBundles", "", r); err != nil {
+		return nil, err
+	}
+	return slices.Clone(s.CertificateBundles), nil
+}
+
+// CreateLoadBalancerCertificateBundle assigns a new UUID to the bundle. The expiry time and the hostnames are read
+// from the base64 encoded PEM certificate like the API does.
+func (s *Service) CreateLoadBalancerCertificateBundle(_ context.Context, r *request.CreateLoadBalancerCertificateBundleRequest) (*upcloud.LoadBalancerCertificateBundle, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("CreateLoadBalancerCertificateBundle", r.Name, r); err != nil {
+		return nil, err
+	}
+	bundle := upcloud.LoadBalancerCertificateBundle{UUID: s.newUUID(), Name: r.Name, Type: r.Type, Hostnames: r.Hostnames}
+	if r.Certificate != "" {
+		cert, err := parseCertificate(r.Certificate)
+		if err != nil {
+			return nil, &upcloud.Problem{Title: err.Error(), Status: 400}
+		}
+		bundle.NotBefore = cert.NotBefore
+		bundle.NotAfter = cert.NotAfter
+		bundle.Hostnames = cert.DNSNames
+	}
+	s.CertificateBundles = append(s.CertificateBundles, bundle)
+	return &bundle, nil
+}
+
+func parseCertificate(encoded string) (*x509.Certificate, error) {
+	b, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM encoded certificate found")
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func (s *Service) DeleteLoadBalancerCertificateBundle(_ context.Context, r *request.DeleteLoadBalancerCertificateBundleRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.call("DeleteLoadBalancerCertificateBundle", r.UUID, r); err != nil {
+		return err
+	}
+	i := slices.IndexFunc(s.CertificateBundles, func(b upcloud.LoadBalancerCertificateBundle) bool { return b.UUID == r.UUID })
+	if i < 0 {
+		return notFound("certificate bundle", r.UUID)
+	}
+	s.CertificateBundles = slices.Delete(s.CertificateBundles, i, i+1)
+	return nil
+}
@@ -0,0 +1,186 @@
+// Package ipinventory builds an inventory of the IP addresses of an account, joined to the servers and load
+// balancers using them, and manages the reverse DNS (PTR) records of the addresses.
+package ipinventory
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Client is the client needed to collect the inventory.
+type Client interface {
+	GetIPAddresses(ctx context.Context) (*upcloud.IPAddresses, error)
+	GetServers(ctx context.Context) (*upcloud.Servers, error)
+	GetLoadBalancers(ctx context.Context, r *request.GetLoadBalancersRequest) ([]upcloud.LoadBalancer, error)
+}
+
+// Entry represents an IP address in the inventory
+type Entry struct {
+	Address          string `json:"address"`
+	Family           string `json:"family"`
+	Access           string `json:"access"`
+	Zone             string `json:"zone,omitempty"`
+	Floating         bool   `json:"floating"`
+	PTRRecord        string `json:"ptr_record,omitempty"`
+	ServerUUID       string `json:"server_uuid,omitempty"`
+	ServerHostname   string `json:"server_hostname,omitempty"`
+	ServerTitle      string `json:"server_title,omitempty"`
+	LoadBalancerUUID string `json:"load_balancer_uuid,omitempty"`
+	LoadBalancerName string `json:"load_balancer_name,omitempty"`
+}
+
+// Unused tells whether the address is a floating IP address that is not attached to a server or a load balancer.
+// Unused floating IP addresses are billed even though they serve no traffic.
+func (e Entry) Unused() bool {
+	return e.Floating && e.ServerUUID == "" && e.LoadBalancerUUID == ""
+}
+
+// Inventory represents the IP addresses of an account
+type Inventory struct {
+	Entries []Entry `json:"ip_addresses"`
+}
+
+// Collect builds the inventory from the IP addresses of the account. The addresses are joined to the servers by
+// the server UUID of the address and to the load balancers by the addresses of the load balancer.
+func Collect(ctx context.Context, c Client) (*Inventory, error) {
+	ipAddresses, err := c.GetIPAddresses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	servers, err := c.GetServers(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loadBalancers, err := c.GetLoadBalancers(ctx, &request.GetLoadBalancersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	serversByUUID := make(map[string]upcloud.Server, len(servers.Servers))
+	for _, server := range servers.Servers {
+		serversByUUID[server.UUID] = server
+	}
+	loadBalancersByAddress := make(map[string]upcloud.LoadBalancer)
+	for _, lb := range loadBalancers {
+		for _, ip := range lb.IPAddresses {
+			loadBalancersByAddress[ip.Address] = lb
+		}
+		for _, network := range lb.Networks {
+			for _, ip := range network.IPAddresses {
+				loadBalancersByAddress[ip.Address] = lb
+			}
+		}
+	}
+
+	inventory := &Inventory{Entries: make([]Entry, 0, len(ipAddresses.IPAddresses))}
+	for _, ip := range ipAddresses.IPAddresses {
+		entry := Entry{
+			Address:    ip.Address,
+			Family:     ip.Family,
+			Access:     ip.Access,
+			Zone:       ip.Zone,
+			Floating:   ip.Floating.Bool(),
+			PTRRecord:  ip.PTRRecord,
+			ServerUUID: ip.ServerUUID,
+		}
+		if server, ok := serversByUUID[ip.ServerUUID]; ok {
+			entry.ServerHostname = server.Hostname
+			entry.ServerTitle = server.Title
+			if entry.Zone == "" {
+				entry.Zone = server.Zone
+			}
+		}
+		if lb, ok := loadBalancersByAddress[ip.Address]; ok {
+			entry.LoadBalancerUUID = lb.UUID
+			entry.LoadBalancerName = lb.Name
+			if entry.Zone == "" {
+				entry.Zone = lb.Zone
+			}
+		}
+		inventory.Entries = append(inventory.Entries, entry)
+	}
+	return inventory, nil
+}
+
+// Entry returns the entry of the address
+func (i *Inventory) Entry(address string) (Entry, bool) {
+	for _, entry := range i.Entries {
+		if entry.Address == address {
+			return entry, true
+		}
+	}
+	return Entry{}, false
+}
+
+// UnusedFloating returns the floating IP addresses that are not attached to a server or a load balancer
+func (i *Inventory) UnusedFloating() []Entry {
+	var entries []Entry
+	for _, entry := range i.Entries {
+		if entry.Unused() {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+var csvHeader = []string{
+	"address",
+	"family",
+	"access",
+	"zone",
+	"floating",
+	"unused",
+	"ptr_record",
+	"server_uuid",
+	"server_hostname",
+	"server_title",
+	"load_balancer_uuid",
+	"load_balancer_name",
+}
+
+// WriteCSV writes the inventory as CSV with a header row
+func (i *Inventory) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, e := range i.Entries {
+		if err := cw.Write([]string{
+			e.Address,
+			e.Family,
+			e.Access,
+			e.Zone,
+			strconv.FormatBool(e.Floating),
+			strconv.FormatBool(e.Unused()),
+			e.PTRRecord,
+			e.ServerUUID,
+			e.ServerHostname,
+			e.ServerTitle,
+			e.LoadBalancerUUID,
+			e.LoadBalancerName,
+		}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteJSON writes the inventory as indented JSON
+func (i *Inventory) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(i)
+}
+
+// normalizeHostname returns the hostname in lower case without the trailing dot
+func normalizeHostname(hostname string) string {
+	return strings.ToLower(strings.TrimSuffix(hostname, "."))
+}
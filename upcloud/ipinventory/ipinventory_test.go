@@ -0,0 +1,162 @@
+package ipinventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ Client      = (*service.Service)(nil)
+	_ PTRModifier = (*service.Service)(nil)
+)
+
+var testResources = fakeservice.Resources{
+	IPAddresses: []upcloud.IPAddress{
+		{Address: "192.0.2.10", Access: upcloud.IPAddressAccessPublic, Family: upcloud.IPAddressFamilyIPv4, ServerUUID: "server-1", PTRRecord: "192-0-2-10.example.net"},
+		{Address: "192.0.2.20", Access: upcloud.IPAddressAccessPublic, Family: upcloud.IPAddressFamilyIPv4, Floating: upcloud.True, Zone: "fi-hel1"},
+		{Address: "192.0.2.30", Access: upcloud.IPAddressAccessPublic, Family: upcloud.IPAddressFamilyIPv4, Floating: upcloud.True, Zone: "fi-hel1"},
+		{Address: "10.1.0.5", Access: upcloud.IPAddressAccessUtility, Family: upcloud.IPAddressFamilyIPv4, ServerUUID: "server-1"},
+	},
+	Servers: []upcloud.ServerDetails{
+		{Server: upcloud.Server{UUID: "server-1", Hostname: "web1.example.com", Title: "Web 1", Zone: "fi-hel1"}},
+	},
+	LoadBalancers: []upcloud.LoadBalancer{
+		{UUID: "lb-1", Name: "web", Zone: "fi-hel1", IPAddresses: []upcloud.LoadBalancerFloatingIPAddress{{Address: "192.0.2.30"}}},
+	},
+}
+
+// modifyBodies returns the JSON bodies of the ModifyIPAddress calls
+func modifyBodies(t *testing.T, c *fakeservice.Service) []string {
+	t.Helper()
+
+	var bodies []string
+	for _, r := range c.Requests("ModifyIPAddress") {
+		body, err := json.Marshal(r)
+		require.NoError(t, err)
+		bodies = append(bodies, string(body))
+	}
+	return bodies
+}
+
+func TestCollect(t *testing.T) {
+	t.Parallel()
+
+	inventory, err := Collect(context.Background(), fakeservice.New(testResources))
+	require.NoError(t, err)
+	require.Len(t, inventory.Entries, 4)
+	assert.Equal(t, Entry{
+		Address:        "192.0.2.10",
+		Family:         upcloud.IPAddressFamilyIPv4,
+		Access:         upcloud.IPAddressAccessPublic,
+		Zone:           "fi-hel1",
+		PTRRecord:      "192-0-2-10.example.net",
+		ServerUUID:     "server-1",
+		ServerHostname: "web1.example.com",
+		ServerTitle:    "Web 1",
+	}, inventory.Entries[0])
+	lb, ok := inventory.Entry("192.0.2.30")
+	require.True(t, ok)
+	assert.Equal(t, "web", lb.LoadBalancerName)
+	assert.False(t, lb.Unused())
+
+	unused := inventory.UnusedFloating()
+	require.Len(t, unused, 1)
+	assert.Equal(t, "192.0.2.20", unused[0].Address)
+}
+
+func TestInventoryExport(t *testing.T) {
+	t.Parallel()
+
+	inventory := &Inventory{Entries: []Entry{
+		{Address: "192.0.2.10", Family: "IPv4", Access: "public", Zone: "fi-hel1", ServerUUID: "server-1", ServerTitle: `Web "1", primary`},
+		{Address: "192.0.2.20", Family: "IPv4", Access: "public", Zone: "fi-hel1", Floating: true},
+	}}
+
+	var buf bytes.Buffer
+	require.NoError(t, inventory.WriteCSV(&buf))
+	assert.Equal(t, `address,family,access,zone,floating,unused,ptr_record,server_uuid,server_hostname,server_title,load_balancer_uuid,load_balancer_name
+192.0.2.10,IPv4,public,fi-hel1,false,false,,server-1,,"Web ""1"", primary",,
+192.0.2.20,IPv4,public,fi-hel1,true,true,,,,,,
+`, buf.String())
+
+	buf.Reset()
+	require.NoError(t, inventory.WriteJSON(&buf))
+	assert.JSONEq(t, `{"ip_addresses": [
+		{"address": "192.0.2.10", "family": "IPv4", "access": "public", "zone": "fi-hel1", "floating": false, "server_uuid": "server-1", "server_title": "Web \"1\", primary"},
+		{"address": "192.0.2.20", "family": "IPv4", "access": "public", "zone": "fi-hel1", "floating": true}
+	]}`, buf.String())
+}
+
+func TestReconcilePTRRecords(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	inventory, err := Collect(context.Background(), c)
+	require.NoError(t, err)
+	desired := map[string]string{
+		"192.0.2.10": "192-0-2-10.Example.net.",
+		"192.0.2.30": "www.example.com",
+		"192.0.2.20": "spare.example.com",
+		"10.1.0.5":   "web1.internal",
+		"192.0.2.99": "missing.example.com",
+	}
+
+	changes, err := inventory.ReconcilePTRRecords(context.Background(), c, desired, true)
+	assert.Error(t, err)
+	assert.Empty(t, c.Calls("ModifyIPAddress"))
+	descriptions := make([]string, 0, len(changes))
+	for _, change := range changes {
+		descriptions = append(descriptions, change.String())
+	}
+	assert.Equal(t, []string{
+		`10.1.0.5: "" -> "web1.internal": address 10.1.0.5 is not public`,
+		`192.0.2.20: "" -> "spare.example.com"`,
+		`192.0.2.30: "" -> "www.example.com"`,
+		`192.0.2.99: "" -> "missing.example.com": address 192.0.2.99 is not in the inventory`,
+	}, descriptions)
+
+	delete(desired, "10.1.0.5")
+	delete(desired, "192.0.2.99")
+	changes, err = inventory.ReconcilePTRRecords(context.Background(), c, desired, false)
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	assert.True(t, changes[0].Applied)
+	assert.Equal(t, []any{
+		&request.ModifyIPAddressRequest{IPAddress: "192.0.2.20", PTRRecord: "spare.example.com"},
+		&request.ModifyIPAddressRequest{IPAddress: "192.0.2.30", PTRRecord: "www.example.com"},
+	}, c.Requests("ModifyIPAddress"))
+	assert.Equal(t, []string{
+		`{"ip_address":{"ptr_record":"spare.example.com"}}`,
+		`{"ip_address":{"ptr_record":"www.example.com"}}`,
+	}, modifyBodies(t, c))
+
+	changes, err = inventory.ReconcilePTRRecords(context.Background(), c, desired, false)
+	require.NoError(t, err)
+	assert.Empty(t, changes)
+}
+
+func TestReconcilePTRRecords_Empty(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	inventory, err := Collect(context.Background(), c)
+	require.NoError(t, err)
+
+	// An empty modify request would unassign the address instead of clearing the PTR record
+	changes, err := inventory.ReconcilePTRRecords(context.Background(), c, map[string]string{"192.0.2.10": ""}, false)
+	assert.ErrorIs(t, err, ErrEmptyPTRRecord)
+	require.Len(t, changes, 1)
+	assert.False(t, changes[0].Applied)
+	assert.Empty(t, c.Calls("ModifyIPAddress"))
+	entry, _ := inventory.Entry("192.0.2.10")
+	assert.Equal(t, "192-0-2-10.example.net", entry.PTRRecord)
+}
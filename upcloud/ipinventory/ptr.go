@@ -0,0 +1,107 @@
+package ipinventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// ErrEmptyPTRRecord is returned for an empty desired hostname. A modify request without a PTR record unassigns the
+// address from its server, so PTR records can not be cleared by setting them to an empty value.
+var ErrEmptyPTRRecord = errors.New("desired PTR record is empty")
+
+// PTRModifier is the client needed to modify PTR records.
+type PTRModifier interface {
+	ModifyIPAddress(ctx context.Context, r *request.ModifyIPAddressRequest) (*upcloud.IPAddress, error)
+}
+
+// PTRChange represents a PTR record that differs from the desired hostname
+type PTRChange struct {
+	Address string
+	Current string
+	Desired string
+	// Applied tells whether the PTR record was modified
+	Applied bool
+	Error   error
+}
+
+// String describes the change
+func (c PTRChange) String() string {
+	s := fmt.Sprintf("%s: %q -> %q", c.Address, c.Current, c.Desired)
+	if c.Error != nil {
+		s += ": " + c.Error.Error()
+	}
+	return s
+}
+
+// ReconcilePTRRecords sets the PTR records of the addresses to the desired hostnames, keyed by address. Hostnames
+// are compared case insensitively and without the trailing dot. Addresses that are not in the inventory or are not
+// public cannot have their PTR record set and are reported with an error, as are empty hostnames. With dryRun set, the changes are only
+// reported. The entries of the inventory are updated with the applied records. The changes are returned in the
+// order of the addresses, together with the errors joined together.
+func (i *Inventory) ReconcilePTRRecords(ctx context.Context, c PTRModifier, desired map[string]string, dryRun bool) ([]PTRChange, error) {
+	addresses := make([]string, 0, len(desired))
+	for address := range desired {
+		addresses = append(addresses, address)
+	}
+	slices.SortFunc(addresses, compareAddresses)
+
+	var changes []PTRChange
+	var errs []error
+	for _, address := range addresses {
+		hostname := desired[address]
+		idx := slices.IndexFunc(i.Entries, func(e Entry) bool { return e.Address == address })
+		if idx < 0 {
+			err := fmt.Errorf("address %s is not in the inventory", address)
+			changes = append(changes, PTRChange{Address: address, Desired: hostname, Error: err})
+			errs = append(errs, err)
+			continue
+		}
+		entry := &i.Entries[idx]
+		if normalizeHostname(entry.PTRRecord) == normalizeHostname(hostname) {
+			continue
+		}
+
+		change := PTRChange{Address: address, Current: entry.PTRRecord, Desired: hostname}
+		switch {
+		case hostname == "":
+			change.Error = fmt.Errorf("%w: address %s", ErrEmptyPTRRecord, address)
+		case entry.Access != upcloud.IPAddressAccessPublic:
+			change.Error = fmt.Errorf("address %s is not public", address)
+		case !dryRun:
+			ip, err := c.ModifyIPAddress(ctx, &request.ModifyIPAddressRequest{IPAddress: address, PTRRecord: hostname})
+			if err != nil {
+				change.Error = err
+				break
+			}
+			change.Applied = true
+			entry.PTRRecord = ip.PTRRecord
+		}
+		if change.Error != nil {
+			errs = append(errs, change.Error)
+		}
+		changes = append(changes, change)
+	}
+	return changes, errors.Join(errs...)
+}
+
+// compareAddresses orders valid addresses numerically before invalid ones, which are ordered as strings
+func compareAddresses(a, b string) int {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	switch {
+	case errA == nil && errB == nil:
+		return addrA.Compare(addrB)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
@@ -0,0 +1,219 @@
+package lbportable
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Importer is the client needed to import a document.
+type Importer interface {
+	GetNetworksInZone(ctx context.Context, r *request.GetNetworksInZoneRequest) (*upcloud.Networks, error)
+	GetLoadBalancerCertificateBundles(ctx context.Context, r *request.GetLoadBalancerCertificateBundlesRequest) ([]upcloud.LoadBalancerCertificateBundle, error)
+	ApplyLoadBalancer(ctx context.Context, r *request.ApplyLoadBalancerRequest) (*upcloud.LoadBalancerChangePlan, error)
+}
+
+// ImportOptions represents the options of Import
+type ImportOptions struct {
+	// Name and Zone override the name and zone of the document
+	Name string
+	Zone string
+	// Networks maps the private network names of the document to network names in the target zone. Names that are
+	// not mapped are used as is.
+	Networks map[string]string
+	// CertificateBundles maps the certificate bundle names of the document to bundle names in the target account.
+	// Names that are not mapped are used as is.
+	CertificateBundles map[string]string
+	// DryRun computes the change plan without creating or modifying the load balancer
+	DryRun bool
+}
+
+// Import creates the load balancer of the document, or updates the load balancer with the same name, with
+// ApplyLoadBalancer. The private networks are looked up by name in the target zone and the certificate bundles by
+// name in the target account; all of them must exist.
+func Import(ctx context.Context, c Importer, doc *Document, opts ImportOptions) (*upcloud.LoadBalancerChangePlan, error) {
+	zone := cmp.Or(opts.Zone, doc.Zone)
+	if zone == "" {
+		return nil, errors.New("zone is required")
+	}
+
+	networks := make(map[string]string)
+	if hasPrivateNetworks(doc) {
+		list, err := c.GetNetworksInZone(ctx, &request.GetNetworksInZoneRequest{Zone: zone})
+		if err != nil {
+			return nil, err
+		}
+		counts := make(map[string]int)
+		for _, network := range list.Networks {
+			if network.Type == upcloud.NetworkTypePrivate {
+				networks[network.Name] = network.UUID
+				counts[network.Name]++
+			}
+		}
+		for _, n := range doc.Networks {
+			name := cmp.Or(opts.Networks[n.Network], n.Network)
+			if n.Type == upcloud.LoadBalancerNetworkTypePrivate && counts[name] > 1 {
+				return nil, fmt.Errorf("network name %s is not unique in zone %s", name, zone)
+			}
+		}
+	}
+
+	bundles := make(map[string]string)
+	if len(doc.CertificateBundles) > 0 {
+		list, err := c.GetLoadBalancerCertificateBundles(ctx, &request.GetLoadBalancerCertificateBundlesRequest{})
+		if err != nil {
+			return nil, err
+		}
+		for _, bundle := range list {
+			bundles[bundle.Name] = bundle.UUID
+		}
+	}
+
+	lb, err := doc.ToLoadBalancer(rebind(networks, opts.Networks), rebind(bundles, opts.CertificateBundles))
+	if err != nil {
+		return nil, err
+	}
+	lb.Name = cmp.Or(opts.Name, lb.Name)
+	lb.Zone = zone
+	return c.ApplyLoadBalancer(ctx, applyRequest(lb, opts.DryRun))
+}
+
+// applyRequest returns a request to apply the load balancer with its backend members moved to BackendMembers. The
+// document always sets the weight and the state of a member, so both are compared.
+func applyRequest(lb *upcloud.LoadBalancer, dryRun bool) *request.ApplyLoadBalancerRequest {
+	r := &request.ApplyLoadBalancerRequest{
+		LoadBalancer:   *lb,
+		BackendMembers: make(map[string][]request.ApplyLoadBalancerBackendMember),
+		DryRun:         dryRun,
+	}
+	r.LoadBalancer.Backends = slices.Clone(lb.Backends)
+	for i, backend := range r.LoadBalancer.Backends {
+		for _, m := range backend.Members {
+			r.BackendMembers[backend.Name] = append(r.BackendMembers[backend.Name], request.ApplyLoadBalancerBackendMember{
+				Name:        m.Name,
+				Type:        m.Type,
+				IP:          m.IP,
+				Port:        m.Port,
+				Weight:      &m.Weight,
+				MaxSessions: m.MaxSessions,
+				Enabled:     &m.Enabled,
+			})
+		}
+		r.LoadBalancer.Backends[i].Members = nil
+	}
+	return r
+}
+
+func hasPrivateNetworks(doc *Document) bool {
+	for _, network := range doc.Networks {
+		if network.Type == upcloud.LoadBalancerNetworkTypePrivate {
+			return true
+		}
+	}
+	return false
+}
+
+// rebind returns the UUIDs by the names of the document, given the UUIDs by the names of the target and the mapping
+// of document names to target names
+func rebind(uuids map[string]string, names map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		uuid, ok := uuids[cmp.Or(names[name], name)]
+		return uuid, ok
+	}
+}
+
+// ToLoadBalancer converts the document to a load balancer. The networkUUID and bundleUUID functions return the
+// UUIDs of the private networks and certificate bundles by the names used in the document.
+func (d *Document) ToLoadBalancer(networkUUID, bundleUUID func(name string) (string, bool)) (*upcloud.LoadBalancer, error) {
+	lb := &upcloud.LoadBalancer{
+		Name:             d.Name,
+		Zone:             d.Zone,
+		Plan:             d.Plan,
+		ConfiguredStatus: d.ConfiguredStatus,
+		MaintenanceDOW:   d.MaintenanceDOW,
+		MaintenanceTime:  d.MaintenanceTime,
+		Labels:           d.Labels,
+	}
+
+	var errs []error
+	for _, n := range d.Networks {
+		network := upcloud.LoadBalancerNetwork{Name: n.Name, Type: n.Type, Family: n.Family}
+		if n.Type == upcloud.LoadBalancerNetworkTypePrivate {
+			uuid, ok := networkUUID(n.Network)
+			if !ok {
+				errs = append(errs, fmt.Errorf("%w: %s, used by load balancer network %s", ErrNetworkNotFound, n.Network, n.Name))
+			}
+			network.UUID = uuid
+		}
+		lb.Networks = append(lb.Networks, network)
+	}
+
+	for _, r := range d.Resolvers {
+		lb.Resolvers = append(lb.Resolvers, upcloud.LoadBalancerResolver{
+			Name:         r.Name,
+			Nameservers:  r.Nameservers,
+			Retries:      r.Retries,
+			Timeout:      r.Timeout,
+			TimeoutRetry: r.TimeoutRetry,
+			CacheValid:   r.CacheValid,
+			CacheInvalid: r.CacheInvalid,
+		})
+	}
+
+	tlsConfigBundle := func(config TLSConfig) string {
+		uuid, ok := bundleUUID(config.CertificateBundle)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s, used by TLS config %s", ErrCertificateBundleNotFound, config.CertificateBundle, config.Name))
+		}
+		return uuid
+	}
+
+	for _, b := range d.Backends {
+		backend := upcloud.LoadBalancerBackend{Name: b.Name, Resolver: b.Resolver, Properties: b.Properties, Members: []upcloud.LoadBalancerBackendMember{}}
+		for _, m := range b.Members {
+			backend.Members = append(backend.Members, upcloud.LoadBalancerBackendMember{
+				Name:        m.Name,
+				Type:        m.Type,
+				IP:          m.IP,
+				Port:        m.Port,
+				Weight:      m.Weight,
+				MaxSessions: m.MaxSessions,
+				Enabled:     m.Enabled,
+			})
+		}
+		for _, config := range b.TLSConfigs {
+			backend.TLSConfigs = append(backend.TLSConfigs, upcloud.LoadBalancerBackendTLSConfig{Name: config.Name, CertificateBundleUUID: tlsConfigBundle(config)})
+		}
+		lb.Backends = append(lb.Backends, backend)
+	}
+
+	for _, f := range d.Frontends {
+		frontend := upcloud.LoadBalancerFrontend{Name: f.Name, Mode: f.Mode, Port: f.Port, DefaultBackend: f.DefaultBackend, Properties: f.Properties}
+		for _, name := range f.Networks {
+			frontend.Networks = append(frontend.Networks, upcloud.LoadBalancerFrontendNetwork{Name: name})
+		}
+		for _, r := range f.Rules {
+			frontend.Rules = append(frontend.Rules, upcloud.LoadBalancerFrontendRule{
+				Name:              r.Name,
+				Priority:          r.Priority,
+				MatchingCondition: r.MatchingCondition,
+				Matchers:          r.Matchers,
+				Actions:           r.Actions,
+			})
+		}
+		for _, config := range f.TLSConfigs {
+			frontend.TLSConfigs = append(frontend.TLSConfigs, upcloud.LoadBalancerFrontendTLSConfig{Name: config.Name, CertificateBundleUUID: tlsConfigBundle(config)})
+		}
+		lb.Frontends = append(lb.Frontends, frontend)
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return lb, nil
+}
@@ -0,0 +1,299 @@
+// Package lbportable exports the configuration of a load balancer as a portable YAML or JSON document and imports
+// it back, e.g. to copy a load balancer to another zone or from a staging account to a production account.
+//
+// The document contains the fully expanded configuration: networks, resolvers, backends with their members and TLS
+// configs, and frontends with their rules and TLS configs. It does not contain UUIDs or timestamps. Private networks
+// and certificate bundles are referenced by name and bound to the networks and bundles of the target account by name
+// when the document is imported. Floating IP addresses belong to the source account and are not exported.
+//
+// The lists of the document are sorted by name, and frontend rules by priority, so exporting the same configuration
+// twice produces the same document.
+package lbportable
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// DocumentVersion is the version of the document format written by Export
+const DocumentVersion = 1
+
+var (
+	// ErrUnsupportedVersion is returned when parsing a document of an unknown version
+	ErrUnsupportedVersion = errors.New("unsupported document version")
+	// ErrNetworkNotFound is returned when a network of the document does not exist in the target zone
+	ErrNetworkNotFound = errors.New("network not found")
+	// ErrCertificateBundleNotFound is returned when a certificate bundle of the document does not exist
+	ErrCertificateBundleNotFound = errors.New("certificate bundle not found")
+)
+
+// Document represents the portable configuration of a load balancer
+type Document struct {
+	Version          int                                  `json:"version"`
+	Name             string                               `json:"name"`
+	Zone             string                               `json:"zone,omitempty"`
+	Plan             string                               `json:"plan"`
+	ConfiguredStatus upcloud.LoadBalancerConfiguredStatus `json:"configured_status,omitempty"`
+	MaintenanceDOW   upcloud.LoadBalancerMaintenanceDOW   `json:"maintenance_dow,omitempty"`
+	MaintenanceTime  string                               `json:"maintenance_time,omitempty"`
+	Labels           []upcloud.Label                      `json:"labels,omitempty"`
+	Networks         []Network                            `json:"networks"`
+	Resolvers        []Resolver                           `json:"resolvers,omitempty"`
+	Backends         []Backend                            `json:"backends,omitempty"`
+	Frontends        []Frontend                           `json:"frontends,omitempty"`
+	// CertificateBundles lists the certificate bundles referenced by the TLS configs. The bundles are not created
+	// on import; bundles with the same names must exist in the target account.
+	CertificateBundles []CertificateBundle `json:"certificate_bundles,omitempty"`
+}
+
+// Network represents a network of the load balancer
+type Network struct {
+	Name   string                            `json:"name"`
+	Type   upcloud.LoadBalancerNetworkType   `json:"type"`
+	Family upcloud.LoadBalancerAddressFamily `json:"family,omitempty"`
+	// Network is the name of the private network the load balancer is attached to
+	Network string `json:"network,omitempty"`
+}
+
+// Resolver represents a DNS resolver of the load balancer
+type Resolver struct {
+	Name         string   `json:"name"`
+	Nameservers  []string `json:"nameservers"`
+	Retries      int      `json:"retries,omitempty"`
+	Timeout      int      `json:"timeout,omitempty"`
+	TimeoutRetry int      `json:"timeout_retry,omitempty"`
+	CacheValid   int      `json:"cache_valid,omitempty"`
+	CacheInvalid int      `json:"cache_invalid,omitempty"`
+}
+
+// Backend represents a backend of the load balancer
+type Backend struct {
+	Name       string                                 `json:"name"`
+	Resolver   string                                 `json:"resolver,omitempty"`
+	Properties *upcloud.LoadBalancerBackendProperties `json:"properties,omitempty"`
+	Members    []Member                               `json:"members"`
+	TLSConfigs []TLSConfig                            `json:"tls_configs,omitempty"`
+}
+
+// Member represents a backend member. The IP addresses of static members are exported as is and may need to be
+// changed before importing the document to another zone.
+type Member struct {
+	Name        string                                `json:"name"`
+	Type        upcloud.LoadBalancerBackendMemberType `json:"type"`
+	IP          string                                `json:"ip,omitempty"`
+	Port        int                                   `json:"port,omitempty"`
+	Weight      int                                   `json:"weight"`
+	MaxSessions int                                   `json:"max_sessions"`
+	Enabled     bool                                  `json:"enabled"`
+}
+
+// TLSConfig represents a frontend or backend TLS config
+type TLSConfig struct {
+	Name string `json:"name"`
+	// CertificateBundle is the name of the certificate bundle
+	CertificateBundle string `json:"certificate_bundle"`
+}
+
+// Frontend represents a frontend of the load balancer
+type Frontend struct {
+	Name           string                                  `json:"name"`
+	Mode           upcloud.LoadBalancerMode                `json:"mode"`
+	Port           int                                     `json:"port"`
+	DefaultBackend string                                  `json:"default_backend"`
+	Properties     *upcloud.LoadBalancerFrontendProperties `json:"properties,omitempty"`
+	// Networks contains the names of the load balancer networks the frontend listens on
+	Networks   []string    `json:"networks,omitempty"`
+	Rules      []Rule      `json:"rules,omitempty"`
+	TLSConfigs []TLSConfig `json:"tls_configs,omitempty"`
+}
+
+// Rule represents a frontend rule
+type Rule struct {
+	Name              string                                `json:"name"`
+	Priority          int                                   `json:"priority"`
+	MatchingCondition upcloud.LoadBalancerMatchingCondition `json:"matching_condition,omitempty"`
+	Matchers          []upcloud.LoadBalancerMatcher         `json:"matchers,omitempty"`
+	Actions           []upcloud.LoadBalancerAction          `json:"actions,omitempty"`
+}
+
+// CertificateBundle represents a certificate bundle referenced by the TLS configs
+type CertificateBundle struct {
+	Name      string                                    `json:"name"`
+	Type      upcloud.LoadBalancerCertificateBundleType `json:"type"`
+	Hostnames []string                                  `json:"hostnames,omitempty"`
+}
+
+// Client is the client needed to export a load balancer.
+type Client interface {
+	GetLoadBalancer(ctx context.Context, r *request.GetLoadBalancerRequest) (*upcloud.LoadBalancer, error)
+	GetNetworkDetails(ctx context.Context, r *request.GetNetworkDetailsRequest) (*upcloud.Network, error)
+	GetLoadBalancerCertificateBundles(ctx context.Context, r *request.GetLoadBalancerCertificateBundlesRequest) ([]upcloud.LoadBalancerCertificateBundle, error)
+}
+
+// Export exports the configuration of the load balancer. The names of the private networks and certificate bundles
+// are looked up from the API.
+func Export(ctx context.Context, c Client, uuid string) (*Document, error) {
+	lb, err := c.GetLoadBalancer(ctx, &request.GetLoadBalancerRequest{UUID: uuid})
+	if err != nil {
+		return nil, err
+	}
+
+	networks := make(map[string]string)
+	for _, network := range lb.Networks {
+		if network.Type != upcloud.LoadBalancerNetworkTypePrivate || network.UUID == "" {
+			continue
+		}
+		details, err := c.GetNetworkDetails(ctx, &request.GetNetworkDetailsRequest{UUID: network.UUID})
+		if err != nil {
+			return nil, fmt.Errorf("getting network %s: %w", network.Name, err)
+		}
+		networks[network.UUID] = details.Name
+	}
+
+	bundles := make(map[string]upcloud.LoadBalancerCertificateBundle)
+	if usesCertificateBundles(lb) {
+		list, err := c.GetLoadBalancerCertificateBundles(ctx, &request.GetLoadBalancerCertificateBundlesRequest{})
+		if err != nil {
+			return nil, err
+		}
+		for _, bundle := range list {
+			bundles[bundle.UUID] = bundle
+		}
+	}
+	return FromLoadBalancer(lb, networks, bundles)
+}
+
+func usesCertificateBundles(lb *upcloud.LoadBalancer) bool {
+	for _, backend := range lb.Backends {
+		if len(backend.TLSConfigs) > 0 {
+			return true
+		}
+	}
+	for _, frontend := range lb.Frontends {
+		if len(frontend.TLSConfigs) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// FromLoadBalancer converts the load balancer to a document. The networks map contains the names of the private
+// networks by UUID, and the bundles map the certificate bundles by UUID.
+func FromLoadBalancer(lb *upcloud.LoadBalancer, networks map[string]string, bundles map[string]upcloud.LoadBalancerCertificateBundle) (*Document, error) {
+	doc := &Document{
+		Version:          DocumentVersion,
+		Name:             lb.Name,
+		Zone:             lb.Zone,
+		Plan:             lb.Plan,
+		ConfiguredStatus: lb.ConfiguredStatus,
+		MaintenanceDOW:   lb.MaintenanceDOW,
+		MaintenanceTime:  lb.MaintenanceTime,
+		Labels:           slices.SortedFunc(slices.Values(lb.Labels), func(a, b upcloud.Label) int { return cmp.Compare(a.Key, b.Key) }),
+	}
+
+	var errs []error
+	for _, network := range lb.Networks {
+		n := Network{Name: network.Name, Type: network.Type, Family: network.Family}
+		if network.Type == upcloud.LoadBalancerNetworkTypePrivate {
+			name, ok := networks[network.UUID]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%w: name of network %s (%s) is unknown", ErrNetworkNotFound, network.Name, network.UUID))
+			}
+			n.Network = name
+		}
+		doc.Networks = append(doc.Networks, n)
+	}
+
+	for _, resolver := range lb.Resolvers {
+		doc.Resolvers = append(doc.Resolvers, Resolver{
+			Name:         resolver.Name,
+			Nameservers:  resolver.Nameservers,
+			Retries:      resolver.Retries,
+			Timeout:      resolver.Timeout,
+			TimeoutRetry: resolver.TimeoutRetry,
+			CacheValid:   resolver.CacheValid,
+			CacheInvalid: resolver.CacheInvalid,
+		})
+	}
+
+	used := make(map[string]upcloud.LoadBalancerCertificateBundle)
+	bundleName := func(uuid string) string {
+		bundle, ok := bundles[uuid]
+		if !ok {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrCertificateBundleNotFound, uuid))
+			return ""
+		}
+		used[bundle.Name] = bundle
+		return bundle.Name
+	}
+
+	for _, b := range lb.Backends {
+		backend := Backend{Name: b.Name, Resolver: b.Resolver, Properties: b.Properties, Members: []Member{}}
+		for _, m := range b.Members {
+			backend.Members = append(backend.Members, Member{
+				Name:        m.Name,
+				Type:        m.Type,
+				IP:          m.IP,
+				Port:        m.Port,
+				Weight:      m.Weight,
+				MaxSessions: m.MaxSessions,
+				Enabled:     m.Enabled,
+			})
+		}
+		for _, config := range b.TLSConfigs {
+			backend.TLSConfigs = append(backend.TLSConfigs, TLSConfig{Name: config.Name, CertificateBundle: bundleName(config.CertificateBundleUUID)})
+		}
+		sortByName(backend.Members, func(m Member) string { return m.Name })
+		sortByName(backend.TLSConfigs, func(c TLSConfig) string { return c.Name })
+		doc.Backends = append(doc.Backends, backend)
+	}
+
+	for _, f := range lb.Frontends {
+		frontend := Frontend{Name: f.Name, Mode: f.Mode, Port: f.Port, DefaultBackend: f.DefaultBackend, Properties: f.Properties}
+		for _, network := range f.Networks {
+			frontend.Networks = append(frontend.Networks, network.Name)
+		}
+		for _, r := range f.Rules {
+			frontend.Rules = append(frontend.Rules, Rule{
+				Name:              r.Name,
+				Priority:          r.Priority,
+				MatchingCondition: r.MatchingCondition,
+				Matchers:          r.Matchers,
+				Actions:           r.Actions,
+			})
+		}
+		for _, config := range f.TLSConfigs {
+			frontend.TLSConfigs = append(frontend.TLSConfigs, TLSConfig{Name: config.Name, CertificateBundle: bundleName(config.CertificateBundleUUID)})
+		}
+		slices.Sort(frontend.Networks)
+		slices.SortFunc(frontend.Rules, func(a, b Rule) int {
+			return cmp.Or(cmp.Compare(b.Priority, a.Priority), cmp.Compare(a.Name, b.Name))
+		})
+		sortByName(frontend.TLSConfigs, func(c TLSConfig) string { return c.Name })
+		doc.Frontends = append(doc.Frontends, frontend)
+	}
+
+	for _, bundle := range used {
+		doc.CertificateBundles = append(doc.CertificateBundles, CertificateBundle{Name: bundle.Name, Type: bundle.Type, Hostnames: bundle.Hostnames})
+	}
+
+	sortByName(doc.Networks, func(n Network) string { return n.Name })
+	sortByName(doc.Resolvers, func(r Resolver) string { return r.Name })
+	sortByName(doc.Backends, func(b Backend) string { return b.Name })
+	sortByName(doc.Frontends, func(f Frontend) string { return f.Name })
+	sortByName(doc.CertificateBundles, func(b CertificateBundle) string { return b.Name })
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return doc, nil
+}
+
+func sortByName[T any](s []T, name func(T) string) {
+	slices.SortFunc(s, func(a, b T) int { return cmp.Compare(name(a), name(b)) })
+}
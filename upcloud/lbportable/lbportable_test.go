@@ -0,0 +1,260 @@
+package lbportable
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ Client   = (*service.Service)(nil)
+	_ Importer = (*service.Service)(nil)
+)
+
+func testLoadBalancer() *upcloud.LoadBalancer {
+	created := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	return &upcloud.LoadBalancer{
+		UUID:             "lb-1",
+		Name:             "web",
+		Zone:             "fi-hel1",
+		Plan:             "development",
+		ConfiguredStatus: upcloud.LoadBalancerConfiguredStatusStarted,
+		Labels:           []upcloud.Label{{Key: "env", Value: "staging"}, {Key: "app", Value: "web"}},
+		Networks: []upcloud.LoadBalancerNetwork{
+			{UUID: "net-1", Name: "private", Type: upcloud.LoadBalancerNetworkTypePrivate, Family: upcloud.LoadBalancerAddressFamilyIPv4, CreatedAt: created},
+			{Name: "public", Type: upcloud.LoadBalancerNetworkTypePublic, Family: upcloud.LoadBalancerAddressFamilyIPv4, DNSName: "lb.example.com"},
+		},
+		IPAddresses: []upcloud.LoadBalancerFloatingIPAddress{{Address: "192.0.2.10", NetworkName: "public"}},
+		Resolvers:   []upcloud.LoadBalancerResolver{{Name: "dns", Nameservers: []string{"10.0.0.2"}, Retries: 3, CreatedAt: created}},
+		Backends: []upcloud.LoadBalancerBackend{
+			{
+				Name: "web",
+				Members: []upcloud.LoadBalancerBackendMember{
+					{Name: "web-2", IP: "10.0.0.12", Port: 80, Weight: 100, MaxSessions: 1000, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true, CreatedAt: created},
+					{Name: "web-1", IP: "10.0.0.11", Port: 80, Weight: 100, MaxSessions: 1000, Type: upcloud.LoadBalancerBackendMemberTypeStatic},
+				},
+				TLSConfigs: []upcloud.LoadBalancerBackendTLSConfig{{Name: "ca", CertificateBundleUUID: "bundle-ca"}},
+			},
+			{Name: "api", Resolver: "dns", Members: []upcloud.LoadBalancerBackendMember{}},
+		},
+		Frontends: []upcloud.LoadBalancerFrontend{{
+			Name:           "https",
+			Mode:           upcloud.LoadBalancerModeHTTP,
+			Port:           443,
+			DefaultBackend: "web",
+			Networks:       []upcloud.LoadBalancerFrontendNetwork{{Name: "public"}},
+			Rules: []upcloud.LoadBalancerFrontendRule{
+				{Name: "redirect", Priority: 10, Matchers: []upcloud.LoadBalancerMatcher{request.NewLoadBalancerHostMatcher("old.example.com")}, Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerHTTPRedirectAction("https://example.com")}},
+				{Name: "api", Priority: 100, Matchers: []upcloud.LoadBalancerMatcher{request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/api", nil)}, Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("api")}},
+			},
+			TLSConfigs: []upcloud.LoadBalancerFrontendTLSConfig{{Name: "example", CertificateBundleUUID: "bundle-1"}},
+			CreatedAt:  created,
+		}},
+		CreatedAt: created,
+	}
+}
+
+var testBundles = []upcloud.LoadBalancerCertificateBundle{
+	{UUID: "bundle-1", Name: "example", Type: upcloud.LoadBalancerCertificateBundleTypeDynamic, Hostnames: []string{"example.com"}},
+	{UUID: "bundle-ca", Name: "internal-ca", Type: upcloud.LoadBalancerCertificateBundleTypeAuthority},
+	{UUID: "bundle-2", Name: "unused", Type: upcloud.LoadBalancerCertificateBundleTypeManual},
+}
+
+const testYAML = `version: 1
+name: web
+zone: fi-hel1
+plan: development
+configured_status: started
+labels:
+  - key: app
+    value: web
+  - key: env
+    value: staging
+networks:
+  - name: private
+    type: private
+    family: IPv4
+    network: backend-net
+  - name: public
+    type: public
+    family: IPv4
+resolvers:
+  - name: dns
+    nameservers:
+      - 10.0.0.2
+    retries: 3
+backends:
+  - name: api
+    resolver: dns
+    members: []
+  - name: web
+    members:
+      - name: web-1
+        type: static
+        ip: 10.0.0.11
+        port: 80
+        weight: 100
+        max_sessions: 1000
+        enabled: false
+      - name: web-2
+        type: static
+        ip: 10.0.0.12
+        port: 80
+        weight: 100
+        max_sessions: 1000
+        enabled: true
+    tls_configs:
+      - name: ca
+        certificate_bundle: internal-ca
+frontends:
+  - name: https
+    mode: http
+    port: 443
+    default_backend: web
+    networks:
+      - public
+    rules:
+      - name: api
+        priority: 100
+        matchers:
+          - type: path
+            match_path:
+              method: starts
+              value: /api
+        actions:
+          - type: use_backend
+            action_use_backend:
+              backend: api
+      - name: redirect
+        priority: 10
+        matchers:
+          - type: host
+            match_host:
+              value: old.example.com
+        actions:
+          - type: http_redirect
+            action_http_redirect:
+              location: https://example.com
+    tls_configs:
+      - name: example
+        certificate_bundle: example
+certificate_bundles:
+  - name: example
+    type: dynamic
+    hostnames:
+      - example.com
+  - name: internal-ca
+    type: authority
+`
+
+func TestExport(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(fakeservice.Resources{
+		LoadBalancers:      []upcloud.LoadBalancer{*testLoadBalancer()},
+		Networks:           []upcloud.Network{{UUID: "net-1", Name: "backend-net", Type: upcloud.NetworkTypePrivate, Zone: "fi-hel1"}},
+		CertificateBundles: testBundles,
+	})
+	doc, err := Export(context.Background(), c, "lb-1")
+	require.NoError(t, err)
+	b, err := doc.YAML()
+	require.NoError(t, err)
+	assert.Equal(t, testYAML, string(b))
+
+	parsed, err := Parse(b)
+	require.NoError(t, err)
+	assert.Equal(t, doc, parsed)
+
+	b, err = doc.JSON()
+	require.NoError(t, err)
+	parsed, err = Parse(b)
+	require.NoError(t, err)
+	assert.Equal(t, doc, parsed)
+}
+
+func TestFromLoadBalancer_Errors(t *testing.T) {
+	t.Parallel()
+
+	_, err := FromLoadBalancer(testLoadBalancer(), nil, nil)
+	assert.ErrorIs(t, err, ErrNetworkNotFound)
+	assert.ErrorIs(t, err, ErrCertificateBundleNotFound)
+	assert.EqualError(t, err, "network not found: name of network private (net-1) is unknown\n"+
+		"certificate bundle not found: bundle-ca\n"+
+		"certificate bundle not found: bundle-1")
+}
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	_, err := Parse([]byte("version: 2\nname: web\n"))
+	assert.ErrorIs(t, err, ErrUnsupportedVersion)
+
+	_, err = Parse([]byte("version: 1\nname: web\nfrontend: []\n"))
+	assert.EqualError(t, err, `json: unknown field "frontend"`)
+}
+
+func TestImport(t *testing.T) {
+	t.Parallel()
+
+	doc, err := Parse([]byte(testYAML))
+	require.NoError(t, err)
+	c := fakeservice.New(fakeservice.Resources{
+		Networks: []upcloud.Network{
+			{UUID: "net-hel", Name: "backend-net", Type: upcloud.NetworkTypePrivate, Zone: "fi-hel1"},
+			{UUID: "net-de", Name: "prod-net", Type: upcloud.NetworkTypePrivate, Zone: "de-fra1"},
+			{UUID: "net-de-public", Name: "prod-net", Type: upcloud.NetworkTypePublic, Zone: "de-fra1"},
+		},
+		CertificateBundles: []upcloud.LoadBalancerCertificateBundle{
+			{UUID: "prod-1", Name: "example", Type: upcloud.LoadBalancerCertificateBundleTypeDynamic},
+			{UUID: "prod-ca", Name: "prod-ca", Type: upcloud.LoadBalancerCertificateBundleTypeAuthority},
+		},
+	})
+
+	plan, err := Import(context.Background(), c, doc, ImportOptions{
+		Name:               "web-prod",
+		Zone:               "de-fra1",
+		Networks:           map[string]string{"backend-net": "prod-net"},
+		CertificateBundles: map[string]string{"internal-ca": "prod-ca"},
+		DryRun:             true,
+	})
+	require.NoError(t, err)
+	assert.False(t, plan.Applied)
+	requests := c.Requests("ApplyLoadBalancer")
+	require.Len(t, requests, 1)
+	applied := requests[0].(*request.ApplyLoadBalancerRequest)
+	assert.True(t, applied.DryRun)
+
+	lb := applied.LoadBalancer
+	assert.Equal(t, "web-prod", lb.Name)
+	assert.Equal(t, "de-fra1", lb.Zone)
+	assert.Equal(t, []upcloud.LoadBalancerNetwork{
+		{UUID: "net-de", Name: "private", Type: upcloud.LoadBalancerNetworkTypePrivate, Family: upcloud.LoadBalancerAddressFamilyIPv4},
+		{Name: "public", Type: upcloud.LoadBalancerNetworkTypePublic, Family: upcloud.LoadBalancerAddressFamilyIPv4},
+	}, lb.Networks)
+	assert.Equal(t, "prod-ca", lb.Backends[1].TLSConfigs[0].CertificateBundleUUID)
+	assert.Empty(t, lb.Backends[1].Members)
+	assert.Equal(t, map[string][]request.ApplyLoadBalancerBackendMember{"web": {
+		{Name: "web-1", Type: upcloud.LoadBalancerBackendMemberTypeStatic, IP: "10.0.0.11", Port: 80, Weight: upcloud.IntPtr(100), MaxSessions: 1000, Enabled: upcloud.BoolPtr(false)},
+		{Name: "web-2", Type: upcloud.LoadBalancerBackendMemberTypeStatic, IP: "10.0.0.12", Port: 80, Weight: upcloud.IntPtr(100), MaxSessions: 1000, Enabled: upcloud.BoolPtr(true)},
+	}}, applied.BackendMembers)
+	assert.Equal(t, "prod-1", lb.Frontends[0].TLSConfigs[0].CertificateBundleUUID)
+	assert.Equal(t, []string{"api", "redirect"}, []string{lb.Frontends[0].Rules[0].Name, lb.Frontends[0].Rules[1].Name})
+	assert.Equal(t, []upcloud.LoadBalancerFrontendNetwork{{Name: "public"}}, lb.Frontends[0].Networks)
+
+	_, err = Import(context.Background(), c, doc, ImportOptions{Zone: "de-fra1"})
+	assert.ErrorIs(t, err, ErrNetworkNotFound)
+	assert.ErrorIs(t, err, ErrCertificateBundleNotFound)
+	assert.EqualError(t, err, "network not found: backend-net, used by load balancer network private\n"+
+		"certificate bundle not found: internal-ca, used by TLS config ca")
+
+	c.Networks = append(c.Networks, upcloud.Network{UUID: "net-hel-2", Name: "backend-net", Type: upcloud.NetworkTypePrivate, Zone: "fi-hel1"})
+	_, err = Import(context.Background(), c, doc, ImportOptions{})
+	assert.EqualError(t, err, "network name backend-net is not unique in zone fi-hel1")
+}
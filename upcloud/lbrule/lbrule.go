@@ -0,0 +1,339 @@
+// Package lbrule builds load balancer frontend rules fluently and validates them against the mode of the frontend
+// before they are sent to the API.
+//
+//	rule, err := lbrule.Rule("api").
+//		When(lbrule.Path().StartsWith("/api")).
+//		And(lbrule.Method("POST")).
+//		Then(lbrule.UseBackend("api")).
+//		Build(upcloud.LoadBalancerModeHTTP)
+//
+// Regular expressions of matchers and rewrite actions are not checked: the load balancer uses PCRE, which accepts
+// expressions that the regexp package of Go does not, such as lookarounds and backreferences.
+package lbrule
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Builder builds a frontend rule fluently. Errors in the use of the builder are reported by Build.
+type Builder struct {
+	rule request.LoadBalancerFrontendRule
+	errs []string
+}
+
+// Rule starts building a frontend rule with the given name. The priority of the rule defaults to 0.
+func Rule(name string) *Builder {
+	return &Builder{rule: request.LoadBalancerFrontendRule{
+		Name:     name,
+		Matchers: []upcloud.LoadBalancerMatcher{},
+		Actions:  []upcloud.LoadBalancerAction{},
+	}}
+}
+
+// Priority sets the priority of the rule. Rules with higher priority are evaluated first.
+func (b *Builder) Priority(priority int) *Builder {
+	b.rule.Priority = priority
+	return b
+}
+
+// When adds the first matchers of the rule. Multiple matchers must all match, unless they are combined with Or.
+func (b *Builder) When(matchers ...upcloud.LoadBalancerMatcher) *Builder {
+	if len(b.rule.Matchers) > 0 {
+		b.errs = append(b.errs, "When must be called before And and Or")
+	}
+	b.rule.Matchers = append(b.rule.Matchers, matchers...)
+	return b
+}
+
+// And adds matchers that must match in addition to the previous matchers
+func (b *Builder) And(matchers ...upcloud.LoadBalancerMatcher) *Builder {
+	return b.combine("And", upcloud.LoadBalancerMatchingConditionAnd, matchers)
+}
+
+// Or adds matchers of which any, or any of the previous matchers, must match
+func (b *Builder) Or(matchers ...upcloud.LoadBalancerMatcher) *Builder {
+	return b.combine("Or", upcloud.LoadBalancerMatchingConditionOr, matchers)
+}
+
+func (b *Builder) combine(method string, condition upcloud.LoadBalancerMatchingCondition, matchers []upcloud.LoadBalancerMatcher) *Builder {
+	if b.rule.MatchingCondition != "" && b.rule.MatchingCondition != condition {
+		b.errs = append(b.errs, "a rule cannot combine And and Or")
+	}
+	if len(b.rule.Matchers) == 0 {
+		b.errs = append(b.errs, method+" must be preceded by When")
+	}
+	b.rule.MatchingCondition = condition
+	b.rule.Matchers = append(b.rule.Matchers, matchers...)
+	return b
+}
+
+// Then adds actions to the rule
+func (b *Builder) Then(actions ...upcloud.LoadBalancerAction) *Builder {
+	b.rule.Actions = append(b.rule.Actions, actions...)
+	return b
+}
+
+// Build validates the rule against the mode of the frontend it is added to and returns it
+func (b *Builder) Build(mode upcloud.LoadBalancerMode) (request.LoadBalancerFrontendRule, error) {
+	rule := b.rule
+	if rule.MatchingCondition == "" {
+		rule.MatchingCondition = upcloud.LoadBalancerMatchingConditionAnd
+	}
+	if len(b.errs) > 0 {
+		return rule, fmt.Errorf("%w %q: %s", ErrInvalidRule, rule.Name, strings.Join(b.errs, "; "))
+	}
+	return rule, Validate(rule, mode)
+}
+
+// StringMatcherBuilder builds matchers that compare a string, e.g. the request path
+type StringMatcherBuilder struct {
+	matcherType upcloud.LoadBalancerMatcherType
+	name        string
+	ignoreCase  *bool
+}
+
+// Path starts a matcher for the request path
+func Path() *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypePath}
+}
+
+// URL starts a matcher for the request URL
+func URL() *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeURL}
+}
+
+// URLQuery starts a matcher for the query string of the request URL
+func URLQuery() *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeURLQuery}
+}
+
+// Header starts a matcher for a request header
+func Header(name string) *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeRequestHeader, name: name}
+}
+
+// ResponseHeader starts a matcher for a response header
+func ResponseHeader(name string) *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeResponseHeader, name: name}
+}
+
+// Cookie starts a matcher for a request cookie
+func Cookie(name string) *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeCookie, name: name}
+}
+
+// URLParam starts a matcher for a URL query parameter
+func URLParam(name string) *StringMatcherBuilder {
+	return &StringMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeURLParam, name: name}
+}
+
+// IgnoreCase makes the matcher case-insensitive
+func (b *StringMatcherBuilder) IgnoreCase() *StringMatcherBuilder {
+	b.ignoreCase = upcloud.BoolPtr(true)
+	return b
+}
+
+// Equals matches the exact value
+func (b *StringMatcherBuilder) Equals(value string) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerStringMatcherMethodExact, value)
+}
+
+// StartsWith matches values with the prefix
+func (b *StringMatcherBuilder) StartsWith(prefix string) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerStringMatcherMethodStarts, prefix)
+}
+
+// EndsWith matches values with the suffix
+func (b *StringMatcherBuilder) EndsWith(suffix string) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerStringMatcherMethodEnds, suffix)
+}
+
+// Contains matches values containing the substring
+func (b *StringMatcherBuilder) Contains(substring string) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerStringMatcherMethodSubstring, substring)
+}
+
+// Matches matches values matching the regular expression
+func (b *StringMatcherBuilder) Matches(expr string) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerStringMatcherMethodRegexp, expr)
+}
+
+// Exists matches when the header, cookie or parameter is present
+func (b *StringMatcherBuilder) Exists() upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerStringMatcherMethodExists, "")
+}
+
+func (b *StringMatcherBuilder) matcher(method upcloud.LoadBalancerStringMatcherMethod, value string) upcloud.LoadBalancerMatcher {
+	m := upcloud.LoadBalancerMatcher{Type: b.matcherType}
+	s := &upcloud.LoadBalancerMatcherString{Method: method, Value: value, IgnoreCase: b.ignoreCase}
+	a := &upcloud.LoadBalancerMatcherStringWithArgument{Method: method, Name: b.name, Value: value, IgnoreCase: b.ignoreCase}
+	switch b.matcherType {
+	case upcloud.LoadBalancerMatcherTypePath:
+		m.Path = s
+	case upcloud.LoadBalancerMatcherTypeURL:
+		m.URL = s
+	case upcloud.LoadBalancerMatcherTypeURLQuery:
+		m.URLQuery = s
+	case upcloud.LoadBalancerMatcherTypeRequestHeader:
+		m.RequestHeader = a
+	case upcloud.LoadBalancerMatcherTypeResponseHeader:
+		m.ResponseHeader = a
+	case upcloud.LoadBalancerMatcherTypeCookie:
+		m.Cookie = a
+	case upcloud.LoadBalancerMatcherTypeURLParam:
+		m.URLParam = a
+	}
+	return m
+}
+
+// IntegerMatcherBuilder builds matchers that compare an integer, e.g. the source port
+type IntegerMatcherBuilder struct {
+	matcherType upcloud.LoadBalancerMatcherType
+	backend     string
+}
+
+// SrcPort starts a matcher for the source port of the connection
+func SrcPort() *IntegerMatcherBuilder {
+	return &IntegerMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeSrcPort}
+}
+
+// BodySize starts a matcher for the size of the request body
+func BodySize() *IntegerMatcherBuilder {
+	return &IntegerMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeBodySize}
+}
+
+// HTTPStatus starts a matcher for the status code of the response
+func HTTPStatus() *IntegerMatcherBuilder {
+	return &IntegerMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeHTTPStatus}
+}
+
+// NumMembersUp starts a matcher for the number of healthy members of a backend
+func NumMembersUp(backend string) *IntegerMatcherBuilder {
+	return &IntegerMatcherBuilder{matcherType: upcloud.LoadBalancerMatcherTypeNumMembersUp, backend: backend}
+}
+
+// Equals matches the exact value
+func (b *IntegerMatcherBuilder) Equals(value int) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerIntegerMatcherMethodEqual, value, 0, 0)
+}
+
+// GreaterThan matches values greater than value
+func (b *IntegerMatcherBuilder) GreaterThan(value int) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerIntegerMatcherMethodGreater, value, 0, 0)
+}
+
+// GreaterOrEqual matches values greater than or equal to value
+func (b *IntegerMatcherBuilder) GreaterOrEqual(value int) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerIntegerMatcherMethodGreaterOrEqual, value, 0, 0)
+}
+
+// LessThan matches values less than value
+func (b *IntegerMatcherBuilder) LessThan(value int) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerIntegerMatcherMethodLess, value, 0, 0)
+}
+
+// LessOrEqual matches values less than or equal to value
+func (b *IntegerMatcherBuilder) LessOrEqual(value int) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerIntegerMatcherMethodLessOrEqual, value, 0, 0)
+}
+
+// Between matches values between start and end, inclusive
+func (b *IntegerMatcherBuilder) Between(start, end int) upcloud.LoadBalancerMatcher {
+	return b.matcher(upcloud.LoadBalancerIntegerMatcherMethodRange, 0, start, end)
+}
+
+func (b *IntegerMatcherBuilder) matcher(method upcloud.LoadBalancerIntegerMatcherMethod, value, start, end int) upcloud.LoadBalancerMatcher {
+	m := upcloud.LoadBalancerMatcher{Type: b.matcherType}
+	i := &upcloud.LoadBalancerMatcherInteger{Method: method, Value: value, RangeStart: start, RangeEnd: end}
+	switch b.matcherType {
+	case upcloud.LoadBalancerMatcherTypeSrcPort:
+		m.SrcPort = i
+	case upcloud.LoadBalancerMatcherTypeBodySize:
+		m.BodySize = i
+	case upcloud.LoadBalancerMatcherTypeHTTPStatus:
+		m.HTTPStatus = i
+	case upcloud.LoadBalancerMatcherTypeNumMembersUp:
+		// Ranges are reported by the validation, the value of a range is always 0
+		m.NumMembersUp = &upcloud.LoadBalancerMatcherNumMembersUp{Method: method, Value: value, Backend: b.backend}
+	}
+	return m
+}
+
+// Host matches the host name of the request
+func Host(host string) upcloud.LoadBalancerMatcher {
+	return request.NewLoadBalancerHostMatcher(host)
+}
+
+// Method matches the HTTP method of the request, e.g. "POST"
+func Method(method string) upcloud.LoadBalancerMatcher {
+	return request.NewLoadBalancerHTTPMethodMatcher(upcloud.LoadBalancerHTTPMatcherMethod(strings.ToUpper(method)))
+}
+
+// SrcIP matches the source IP address of the connection against an IP address or a CIDR block
+func SrcIP(ipOrCIDR string) upcloud.LoadBalancerMatcher {
+	return request.NewLoadBalancerSrcIPMatcher(ipOrCIDR)
+}
+
+// Not inverts a matcher. Inverting an inverted matcher returns the original matcher.
+func Not(m upcloud.LoadBalancerMatcher) upcloud.LoadBalancerMatcher {
+	if m.Inverse != nil && *m.Inverse {
+		m.Inverse = nil
+		return m
+	}
+	return request.NewLoadBalancerInverseMatcher(m)
+}
+
+// UseBackend routes the request to a backend
+func UseBackend(backend string) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerUseBackendAction(backend)
+}
+
+// Redirect redirects the request to a location
+func Redirect(location string, status int) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerHTTPRedirectActionWithStatus(location, status)
+}
+
+// RedirectScheme redirects the request to the same location with another scheme, e.g. from HTTP to HTTPS
+func RedirectScheme(scheme upcloud.LoadBalancerActionHTTPRedirectScheme, status int) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerHTTPRedirectSchemeActionWithStatus(scheme, status)
+}
+
+// Return responds to the request directly. The payload is base64 encoded by Return.
+func Return(status int, contentType, payload string) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerHTTPReturnAction(status, contentType, base64.StdEncoding.EncodeToString([]byte(payload)))
+}
+
+// Reject closes the connection
+func Reject() upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerTCPRejectAction()
+}
+
+// SetForwardedHeaders adds the X-Forwarded-For, X-Forwarded-Proto and X-Forwarded-Port headers to the request
+func SetForwardedHeaders() upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerSetForwardedHeadersAction()
+}
+
+// SetRequestHeader sets a request header
+func SetRequestHeader(header, value string) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerSetRequestHeaderAction(header, value)
+}
+
+// SetResponseHeader sets a response header
+func SetResponseHeader(header, value string) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerSetResponseHeaderAction(header, value)
+}
+
+// RewritePath replaces the part of the request path matching the regular expression
+func RewritePath(matchPattern, rewriteTo string) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerHTTPRewritePathAction(matchPattern, rewriteTo)
+}
+
+// RewriteURI replaces the part of the request URI matching the regular expression
+func RewriteURI(matchPattern, rewriteTo string) upcloud.LoadBalancerAction {
+	return request.NewLoadBalancerHTTPRewriteURIAction(matchPattern, rewriteTo)
+}
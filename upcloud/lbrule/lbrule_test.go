@@ -0,0 +1,145 @@
+package lbrule
+
+import (
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBuilder(t *testing.T) {
+	t.Parallel()
+
+	rule, err := Rule("api").
+		Priority(10).
+		When(Path().StartsWith("/api")).
+		And(Method("post"), Not(Header("X-Debug").Exists())).
+		Then(UseBackend("api"), SetRequestHeader("X-Api", "1")).
+		Build(upcloud.LoadBalancerModeHTTP)
+	require.NoError(t, err)
+	assert.Equal(t, request.LoadBalancerFrontendRule{
+		Name:              "api",
+		Priority:          10,
+		MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+		Matchers: []upcloud.LoadBalancerMatcher{
+			request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/api", nil),
+			request.NewLoadBalancerHTTPMethodMatcher(upcloud.LoadBalancerHTTPMatcherMethodPost),
+			request.NewLoadBalancerInverseMatcher(request.NewLoadBalancerRequestHeaderMatcher(upcloud.LoadBalancerStringMatcherMethodExists, "X-Debug", "", nil)),
+		},
+		Actions: []upcloud.LoadBalancerAction{
+			request.NewLoadBalancerUseBackendAction("api"),
+			request.NewLoadBalancerSetRequestHeaderAction("X-Api", "1"),
+		},
+	}, rule)
+
+	rule, err = Rule("internal").
+		When(SrcIP("10.0.0.0/8")).
+		Or(SrcPort().Between(1024, 2048)).
+		Then(Reject()).
+		Build(upcloud.LoadBalancerModeTCP)
+	require.NoError(t, err)
+	assert.Equal(t, upcloud.LoadBalancerMatchingConditionOr, rule.MatchingCondition)
+
+	rule, err = Rule("maintenance").
+		When(NumMembersUp("web").LessThan(1)).
+		Then(Return(503, "text/plain", "down")).
+		Build(upcloud.LoadBalancerModeHTTP)
+	require.NoError(t, err)
+	assert.Equal(t, "ZG93bg==", rule.Actions[0].HTTPReturn.Payload)
+
+	assert.Equal(t, request.NewLoadBalancerHostMatcher("example.com"), Not(Not(Host("example.com"))))
+}
+
+func TestBuilder_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		name    string
+		builder *Builder
+		mode    upcloud.LoadBalancerMode
+		want    string
+	}{
+		{
+			name:    "HTTP matcher on TCP frontend",
+			builder: Rule("api").When(Path().StartsWith("/api")).Then(UseBackend("api")),
+			mode:    upcloud.LoadBalancerModeTCP,
+			want:    `invalid load balancer frontend rule "api": matcher 1: path matcher requires a frontend in HTTP mode`,
+		},
+		{
+			name:    "HTTP action on TCP frontend",
+			builder: Rule("redirect").When(SrcIP("10.0.0.1")).Then(RedirectScheme(upcloud.LoadBalancerActionHTTPRedirectSchemeHTTPS, 301)),
+			mode:    upcloud.LoadBalancerModeTCP,
+			want:    `invalid load balancer frontend rule "redirect": action 1: http_redirect action requires a frontend in HTTP mode`,
+		},
+		{
+			name:    "mixed and or",
+			builder: Rule("mixed").When(Host("a.example.com")).And(Path().Equals("/")).Or(Host("b.example.com")).Then(UseBackend("web")),
+			mode:    upcloud.LoadBalancerModeHTTP,
+			want:    `invalid load balancer frontend rule "mixed": a rule cannot combine And and Or`,
+		},
+		{
+			name: "type mismatch",
+			builder: Rule("mismatch").When(upcloud.LoadBalancerMatcher{
+				Type: upcloud.LoadBalancerMatcherTypeHost,
+				Path: &upcloud.LoadBalancerMatcherString{Method: upcloud.LoadBalancerStringMatcherMethodExact, Value: "/"},
+			}).Then(UseBackend("web")),
+			mode: upcloud.LoadBalancerModeHTTP,
+			want: `invalid load balancer frontend rule "mismatch": matcher 1: type host does not match the populated field of type path`,
+		},
+		{
+			name:    "inverse without match field",
+			builder: Rule("inverse").When(upcloud.LoadBalancerMatcher{Inverse: upcloud.BoolPtr(true)}).Then(UseBackend("web")),
+			mode:    upcloud.LoadBalancerModeHTTP,
+			want:    `invalid load balancer frontend rule "inverse": matcher 1: matcher has no match field`,
+		},
+		{
+			name:    "invalid values",
+			builder: Rule("values").Priority(101).When(Method("FETCH"), Path().Matches("")).Then(UseBackend("")),
+			mode:    upcloud.LoadBalancerModeHTTP,
+			want:    `invalid load balancer frontend rule "values": priority 101 is not between 0 and 100; matcher 1: unknown HTTP method "FETCH"; matcher 2: value is required for regexp method; action 1: backend is required`,
+		},
+		{
+			name:    "response matcher with request action",
+			builder: Rule("errors").When(HTTPStatus().Between(500, 599)).Then(UseBackend("fallback")),
+			mode:    upcloud.LoadBalancerModeHTTP,
+			want:    `invalid load balancer frontend rule "errors": action 1: use_backend action cannot be used with response matchers`,
+		},
+		{
+			name:    "no actions",
+			builder: Rule("empty").When(Host("example.com")),
+			mode:    upcloud.LoadBalancerModeHTTP,
+			want:    `invalid load balancer frontend rule "empty": at least one action is required`,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := test.builder.Build(test.mode)
+			assert.ErrorIs(t, err, ErrInvalidRule)
+			assert.EqualError(t, err, test.want)
+		})
+	}
+}
+
+func TestValidate_PCRE(t *testing.T) {
+	t.Parallel()
+
+	// Lookarounds and backreferences are valid PCRE, but not valid in the regexp package of Go
+	_, err := Rule("pcre").
+		When(Path().Matches(`^/(?!internal/)`), Header("X-Pair").Matches(`^(\w+)-\1$`)).
+		Then(RewritePath(`^/(?<version>v\d+)/`, `/`)).
+		Build(upcloud.LoadBalancerModeHTTP)
+	assert.NoError(t, err)
+}
+
+func TestValidate_Payload(t *testing.T) {
+	t.Parallel()
+
+	err := Validate(request.LoadBalancerFrontendRule{
+		Name:    "return",
+		Actions: []upcloud.LoadBalancerAction{request.NewLoadBalancerHTTPReturnAction(200, "text/plain", "not base64!")},
+	}, "")
+	assert.EqualError(t, err, `invalid load balancer frontend rule "return": action 1: payload must be base64 encoded`)
+}
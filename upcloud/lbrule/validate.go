@@ -0,0 +1,322 @@
+package lbrule
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// ErrInvalidRule is returned when a frontend rule is rejected by the local validation
+var ErrInvalidRule = errors.New("invalid load balancer frontend rule")
+
+// tcpMatcherTypes are the matcher types that can be used on frontends in TCP mode
+var tcpMatcherTypes = []upcloud.LoadBalancerMatcherType{
+	upcloud.LoadBalancerMatcherTypeSrcIP,
+	upcloud.LoadBalancerMatcherTypeSrcPort,
+	upcloud.LoadBalancerMatcherTypeNumMembersUp,
+}
+
+// tcpActionTypes are the action types that can be used on frontends in TCP mode
+var tcpActionTypes = []upcloud.LoadBalancerActionType{
+	upcloud.LoadBalancerActionTypeUseBackend,
+	upcloud.LoadBalancerActionTypeTCPReject,
+}
+
+// responseMatcherTypes are the matcher types that are evaluated against the response of a backend
+var responseMatcherTypes = []upcloud.LoadBalancerMatcherType{
+	upcloud.LoadBalancerMatcherTypeHTTPStatus,
+	upcloud.LoadBalancerMatcherTypeResponseHeader,
+}
+
+// responseActionTypes are the action types that can be used in rules with response matchers
+var responseActionTypes = []upcloud.LoadBalancerActionType{
+	upcloud.LoadBalancerActionTypeSetResponseHeader,
+	upcloud.LoadBalancerActionTypeHTTPReturn,
+}
+
+// Validate checks that the matchers and actions of a rule are consistent and supported by a
+// frontend in the given mode. The matcher and action types must match the populated fields, and rules with response
+// matchers can only modify the response. An empty mode skips the mode specific checks. Regular expressions are not
+// compiled, see the package documentation.
+func Validate(rule request.LoadBalancerFrontendRule, mode upcloud.LoadBalancerMode) error {
+	var problems []string
+	if rule.Name == "" {
+		problems = append(problems, "name is required")
+	}
+	if rule.Priority < 0 || rule.Priority > 100 {
+		problems = append(problems, fmt.Sprintf("priority %d is not between 0 and 100", rule.Priority))
+	}
+	switch rule.MatchingCondition {
+	case "", upcloud.LoadBalancerMatchingConditionAnd, upcloud.LoadBalancerMatchingConditionOr:
+	default:
+		problems = append(problems, fmt.Sprintf("unknown matching condition %q", rule.MatchingCondition))
+	}
+
+	responseMatcher := false
+	for i, m := range rule.Matchers {
+		if err := validateMatcher(m); err != nil {
+			problems = append(problems, fmt.Sprintf("matcher %d: %s", i+1, err))
+			continue
+		}
+		if mode == upcloud.LoadBalancerModeTCP && !slices.Contains(tcpMatcherTypes, m.Type) {
+			problems = append(problems, fmt.Sprintf("matcher %d: %s matcher requires a frontend in HTTP mode", i+1, m.Type))
+		}
+		responseMatcher = responseMatcher || slices.Contains(responseMatcherTypes, m.Type)
+	}
+
+	if len(rule.Actions) == 0 {
+		problems = append(problems, "at least one action is required")
+	}
+	for i, a := range rule.Actions {
+		if err := validateAction(a); err != nil {
+			problems = append(problems, fmt.Sprintf("action %d: %s", i+1, err))
+			continue
+		}
+		if mode == upcloud.LoadBalancerModeTCP && !slices.Contains(tcpActionTypes, a.Type) {
+			problems = append(problems, fmt.Sprintf("action %d: %s action requires a frontend in HTTP mode", i+1, a.Type))
+		}
+		if responseMatcher && !slices.Contains(responseActionTypes, a.Type) {
+			problems = append(problems, fmt.Sprintf("action %d: %s action cannot be used with response matchers", i+1, a.Type))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w %q: %s", ErrInvalidRule, rule.Name, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// matcherTypes returns the types of the populated fields of a matcher
+func matcherTypes(m upcloud.LoadBalancerMatcher) []upcloud.LoadBalancerMatcherType {
+	fields := []struct {
+		populated   bool
+		matcherType upcloud.LoadBalancerMatcherType
+	}{
+		{m.SrcIP != nil, upcloud.LoadBalancerMatcherTypeSrcIP},
+		{m.SrcPort != nil, upcloud.LoadBalancerMatcherTypeSrcPort},
+		{m.BodySize != nil, upcloud.LoadBalancerMatcherTypeBodySize},
+		{m.Path != nil, upcloud.LoadBalancerMatcherTypePath},
+		{m.RequestHeader != nil, upcloud.LoadBalancerMatcherTypeRequestHeader},
+		{m.ResponseHeader != nil, upcloud.LoadBalancerMatcherTypeResponseHeader},
+		{m.URL != nil, upcloud.LoadBalancerMatcherTypeURL},
+		{m.URLQuery != nil, upcloud.LoadBalancerMatcherTypeURLQuery},
+		{m.Host != nil, upcloud.LoadBalancerMatcherTypeHost},
+		{m.HTTPMethod != nil, upcloud.LoadBalancerMatcherTypeHTTPMethod},
+		{m.HTTPStatus != nil, upcloud.LoadBalancerMatcherTypeHTTPStatus},
+		{m.Cookie != nil, upcloud.LoadBalancerMatcherTypeCookie},
+		{m.Header != nil, upcloud.LoadBalancerMatcherTypeHeader},
+		{m.URLParam != nil, upcloud.LoadBalancerMatcherTypeURLParam},
+		{m.NumMembersUp != nil, upcloud.LoadBalancerMatcherTypeNumMembersUp},
+	}
+	var types []upcloud.LoadBalancerMatcherType
+	for _, f := range fields {
+		if f.populated {
+			types = append(types, f.matcherType)
+		}
+	}
+	return types
+}
+
+func validateMatcher(m upcloud.LoadBalancerMatcher) error {
+	types := matcherTypes(m)
+	switch {
+	case len(types) == 0:
+		return fmt.Errorf("matcher has no match field")
+	case len(types) > 1:
+		return fmt.Errorf("matcher has multiple match fields: %v", types)
+	case m.Type == "":
+		return fmt.Errorf("type is required, expected %s", types[0])
+	case m.Type != types[0]:
+		return fmt.Errorf("type %s does not match the populated field of type %s", m.Type, types[0])
+	}
+
+	switch {
+	case m.SrcIP != nil:
+		if _, err := netip.ParsePrefix(m.SrcIP.Value); err != nil {
+			if _, err := netip.ParseAddr(m.SrcIP.Value); err != nil {
+				return fmt.Errorf("invalid IP address or CIDR %q", m.SrcIP.Value)
+			}
+		}
+	case m.SrcPort != nil:
+		return validateIntegerMatcher(m.SrcPort)
+	case m.BodySize != nil:
+		return validateIntegerMatcher(m.BodySize)
+	case m.HTTPStatus != nil:
+		return validateIntegerMatcher(m.HTTPStatus)
+	case m.Path != nil:
+		return validateStringMatcher(m.Path.Method, m.Path.Value)
+	case m.URL != nil:
+		return validateStringMatcher(m.URL.Method, m.URL.Value)
+	case m.URLQuery != nil:
+		return validateStringMatcher(m.URLQuery.Method, m.URLQuery.Value)
+	case m.RequestHeader != nil:
+		return validateStringMatcherWithArgument(m.RequestHeader)
+	case m.ResponseHeader != nil:
+		return validateStringMatcherWithArgument(m.ResponseHeader)
+	case m.Header != nil:
+		return validateStringMatcherWithArgument(m.Header)
+	case m.Cookie != nil:
+		return validateStringMatcherWithArgument(m.Cookie)
+	case m.URLParam != nil:
+		return validateStringMatcherWithArgument(m.URLParam)
+	case m.Host != nil:
+		if m.Host.Value == "" {
+			return fmt.Errorf("host is required")
+		}
+	case m.HTTPMethod != nil:
+		if !slices.Contains(httpMethods, m.HTTPMethod.Value) {
+			return fmt.Errorf("unknown HTTP method %q", m.HTTPMethod.Value)
+		}
+	case m.NumMembersUp != nil:
+		if m.NumMembersUp.Backend == "" {
+			return fmt.Errorf("backend is required")
+		}
+		if m.NumMembersUp.Method == upcloud.LoadBalancerIntegerMatcherMethodRange {
+			return fmt.Errorf("range method is not supported")
+		}
+		return validateIntegerMatcher(&upcloud.LoadBalancerMatcherInteger{Method: m.NumMembersUp.Method, Value: m.NumMembersUp.Value})
+	}
+	return nil
+}
+
+var httpMethods = []upcloud.LoadBalancerHTTPMatcherMethod{
+	upcloud.LoadBalancerHTTPMatcherMethodGet,
+	upcloud.LoadBalancerHTTPMatcherMethodHead,
+	upcloud.LoadBalancerHTTPMatcherMethodPost,
+	upcloud.LoadBalancerHTTPMatcherMethodPut,
+	upcloud.LoadBalancerHTTPMatcherMethodPatch,
+	upcloud.LoadBalancerHTTPMatcherMethodDelete,
+	upcloud.LoadBalancerHTTPMatcherMethodConnect,
+	upcloud.LoadBalancerHTTPMatcherMethodOptions,
+	upcloud.LoadBalancerHTTPMatcherMethodTrace,
+}
+
+func validateIntegerMatcher(m *upcloud.LoadBalancerMatcherInteger) error {
+	switch m.Method {
+	case upcloud.LoadBalancerIntegerMatcherMethodEqual,
+		upcloud.LoadBalancerIntegerMatcherMethodGreaterOrEqual,
+		upcloud.LoadBalancerIntegerMatcherMethodGreater,
+		upcloud.LoadBalancerIntegerMatcherMethodLessOrEqual,
+		upcloud.LoadBalancerIntegerMatcherMethodLess:
+	case upcloud.LoadBalancerIntegerMatcherMethodRange:
+		if m.RangeStart > m.RangeEnd {
+			return fmt.Errorf("range start %d is greater than range end %d", m.RangeStart, m.RangeEnd)
+		}
+	default:
+		return fmt.Errorf("unknown integer method %q", m.Method)
+	}
+	return nil
+}
+
+func validateStringMatcher(method upcloud.LoadBalancerStringMatcherMethod, value string) error {
+	switch method {
+	case upcloud.LoadBalancerStringMatcherMethodExists:
+		if value != "" {
+			return fmt.Errorf("exists method does not take a value")
+		}
+		return nil
+	case upcloud.LoadBalancerStringMatcherMethodRegexp,
+		upcloud.LoadBalancerStringMatcherMethodExact,
+		upcloud.LoadBalancerStringMatcherMethodSubstring,
+		upcloud.LoadBalancerStringMatcherMethodStarts,
+		upcloud.LoadBalancerStringMatcherMethodEnds,
+		upcloud.LoadBalancerStringMatcherMethodDomain,
+		upcloud.LoadBalancerStringMatcherMethodIP:
+	default:
+		return fmt.Errorf("unknown string method %q", method)
+	}
+	if value == "" {
+		return fmt.Errorf("value is required for %s method", method)
+	}
+	return nil
+}
+
+func validateStringMatcherWithArgument(m *upcloud.LoadBalancerMatcherStringWithArgument) error {
+	if m.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	return validateStringMatcher(m.Method, m.Value)
+}
+
+func validateAction(a upcloud.LoadBalancerAction) error {
+	fields := []struct {
+		populated  bool
+		actionType upcloud.LoadBalancerActionType
+	}{
+		{a.UseBackend != nil, upcloud.LoadBalancerActionTypeUseBackend},
+		{a.TCPReject != nil, upcloud.LoadBalancerActionTypeTCPReject},
+		{a.HTTPReturn != nil, upcloud.LoadBalancerActionTypeHTTPReturn},
+		{a.HTTPRedirect != nil, upcloud.LoadBalancerActionTypeHTTPRedirect},
+		{a.HTTPRewritePath != nil, upcloud.LoadBalancerActionTypeHTTPRewritePath},
+		{a.HTTPRewriteURI != nil, upcloud.LoadBalancerActionTypeHTTPRewriteURI},
+		{a.SetForwardedHeaders != nil, upcloud.LoadBalancerActionTypeSetForwardedHeaders},
+		{a.SetRequestHeader != nil, upcloud.LoadBalancerActionTypeSetRequestHeader},
+		{a.SetResponseHeader != nil, upcloud.LoadBalancerActionTypeSetResponseHeader},
+	}
+	var types []upcloud.LoadBalancerActionType
+	for _, f := range fields {
+		if f.populated {
+			types = append(types, f.actionType)
+		}
+	}
+	switch {
+	case len(types) == 0:
+		return fmt.Errorf("action has no action field")
+	case len(types) > 1:
+		return fmt.Errorf("action has multiple action fields: %v", types)
+	case a.Type == "":
+		return fmt.Errorf("type is required, expected %s", types[0])
+	case a.Type != types[0]:
+		return fmt.Errorf("type %s does not match the populated field of type %s", a.Type, types[0])
+	}
+
+	switch {
+	case a.UseBackend != nil:
+		if a.UseBackend.Backend == "" {
+			return fmt.Errorf("backend is required")
+		}
+	case a.HTTPReturn != nil:
+		if a.HTTPReturn.Status < 100 || a.HTTPReturn.Status > 599 {
+			return fmt.Errorf("invalid status %d", a.HTTPReturn.Status)
+		}
+		if _, err := base64.StdEncoding.DecodeString(a.HTTPReturn.Payload); err != nil {
+			return fmt.Errorf("payload must be base64 encoded")
+		}
+	case a.HTTPRedirect != nil:
+		if (a.HTTPRedirect.Location == "") == (a.HTTPRedirect.Scheme == "") {
+			return fmt.Errorf("exactly one of location and scheme is required")
+		}
+		if a.HTTPRedirect.Status != 0 && (a.HTTPRedirect.Status < 300 || a.HTTPRedirect.Status > 399) {
+			return fmt.Errorf("invalid redirect status %d", a.HTTPRedirect.Status)
+		}
+	case a.HTTPRewritePath != nil:
+		return validateRewrite(a.HTTPRewritePath.MatchPattern, a.HTTPRewritePath.RewriteTo)
+	case a.HTTPRewriteURI != nil:
+		return validateRewrite(a.HTTPRewriteURI.MatchPattern, a.HTTPRewriteURI.RewriteTo)
+	case a.SetRequestHeader != nil:
+		if a.SetRequestHeader.Header == "" {
+			return fmt.Errorf("header is required")
+		}
+	case a.SetResponseHeader != nil:
+		if a.SetResponseHeader.Header == "" {
+			return fmt.Errorf("header is required")
+		}
+	}
+	return nil
+}
+
+func validateRewrite(matchPattern, rewriteTo string) error {
+	if matchPattern == "" {
+		return fmt.Errorf("match pattern is required")
+	}
+	if rewriteTo == "" {
+		return fmt.Errorf("rewrite target is required")
+	}
+	return nil
+}
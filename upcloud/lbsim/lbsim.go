@@ -0,0 +1,367 @@
+// Package lbsim evaluates the rules of a load balancer frontend against sample requests offline, so that routing
+// tables can be tested without deploying them.
+//
+// Rules are evaluated in descending priority order. The actions of every matching rule are applied in order until an
+// action ends the evaluation: use_backend selects the backend, and http_redirect, http_return and tcp_reject answer
+// the request on the load balancer. When no use_backend action runs, the request goes to the default backend of the
+// frontend. Rules with response matchers (http_status, response_header) are evaluated against Input.Response after a
+// backend is selected.
+//
+// Regular expressions of regexp matchers and rewrite actions are evaluated with the regexp package of Go (RE2), not
+// with PCRE like on the load balancer. Expressions using PCRE only syntax, such as lookarounds and backreferences,
+// are reported as errors, and matching may differ in corner cases.
+package lbsim
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+// DefaultRedirectStatus is the status of redirects without a status, same as in HAProxy
+const DefaultRedirectStatus = http.StatusFound
+
+// Input represents a sample request
+type Input struct {
+	// Request is the request to evaluate. Rewrites and headers are applied to a copy. The body of the request is read
+	// into memory and replaced with a reader of the same content, which the copy shares.
+	Request *http.Request
+	// Source is the address of the client. Request.RemoteAddr is used if Source is not valid.
+	Source netip.AddrPort
+	// MembersUp is the number of healthy members per backend used by num_members_up matchers
+	MembersUp map[string]int
+	// Response is the response of the backend used by rules with response matchers. Rules with response matchers are
+	// skipped if Response is nil.
+	Response *http.Response
+}
+
+// AppliedAction represents an action that was applied to the request
+type AppliedAction struct {
+	Rule   string
+	Action upcloud.LoadBalancerAction
+}
+
+// Response represents a response generated by the load balancer with an http_return or http_redirect action
+type Response struct {
+	Status      int
+	Location    string
+	ContentType string
+	Body        string
+}
+
+// Result represents the outcome of an evaluation
+type Result struct {
+	// Matched contains the names of the matching rules in evaluation order
+	Matched []string
+	// Skipped contains the names of the rules with response matchers that were not evaluated
+	Skipped []string
+	// Actions contains the applied actions in order
+	Actions []AppliedAction
+	// Backend is the backend the request is sent to, or empty if the load balancer answered or rejected the request
+	Backend string
+	// Request is the request as sent to the backend, after rewrites and header changes
+	Request *http.Request
+	// Response is set when the load balancer answered the request itself
+	Response *Response
+	// ResponseHeaders contains the headers set on the response by set_response_header actions
+	ResponseHeaders http.Header
+	// Rejected tells whether the connection was closed by a tcp_reject action
+	Rejected bool
+}
+
+// String returns a summary of the result, e.g. "rules: api; backend: api"
+func (r *Result) String() string {
+	outcome := "backend: " + r.Backend
+	switch {
+	case r.Rejected:
+		outcome = "rejected"
+	case r.Response != nil && r.Response.Location != "":
+		outcome = fmt.Sprintf("redirect %d: %s", r.Response.Status, r.Response.Location)
+	case r.Response != nil:
+		outcome = fmt.Sprintf("return %d", r.Response.Status)
+	case r.Backend == "":
+		outcome = "no backend"
+	}
+	matched := "none"
+	if len(r.Matched) > 0 {
+		matched = strings.Join(r.Matched, ", ")
+	}
+	return fmt.Sprintf("rules: %s; %s", matched, outcome)
+}
+
+type evaluation struct {
+	frontend upcloud.LoadBalancerFrontend
+	input    Input
+	request  *http.Request
+	source   netip.AddrPort
+	result   *Result
+}
+
+// Evaluate evaluates the rules of the frontend against the input. Errors are returned for rules that cannot be
+// evaluated, e.g. rules with invalid regular expressions, and for HTTP matchers when Input.Request is nil.
+func Evaluate(frontend upcloud.LoadBalancerFrontend, input Input) (*Result, error) {
+	e := &evaluation{
+		frontend: frontend,
+		input:    input,
+		source:   input.Source,
+		result:   &Result{ResponseHeaders: make(http.Header)},
+	}
+	if input.Request != nil {
+		e.request = input.Request.Clone(input.Request.Context())
+		e.result.Request = e.request
+		if err := bufferBody(input.Request, e.request); err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+		if !e.source.IsValid() {
+			addrPort, err := netip.ParseAddrPort(input.Request.RemoteAddr)
+			if err != nil {
+				return nil, fmt.Errorf("request has no valid remote address %q and no source is set", input.Request.RemoteAddr)
+			}
+			e.source = addrPort
+		}
+	}
+
+	rules := slices.Clone(frontend.Rules)
+	slices.SortStableFunc(rules, func(a, b upcloud.LoadBalancerFrontendRule) int {
+		return b.Priority - a.Priority
+	})
+
+	var requestRules, responseRules []upcloud.LoadBalancerFrontendRule
+	for _, rule := range rules {
+		if slices.ContainsFunc(rule.Matchers, isResponseMatcher) {
+			responseRules = append(responseRules, rule)
+		} else {
+			requestRules = append(requestRules, rule)
+		}
+	}
+
+	for _, rule := range requestRules {
+		done, err := e.evaluateRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if done && e.result.Backend == "" {
+			// The request was answered or rejected by the load balancer
+			return e.result, nil
+		}
+		if done {
+			break
+		}
+	}
+	if e.result.Backend == "" {
+		e.result.Backend = frontend.DefaultBackend
+	}
+
+	for _, rule := range responseRules {
+		if input.Response == nil {
+			e.result.Skipped = append(e.result.Skipped, rule.Name)
+			continue
+		}
+		done, err := e.evaluateRule(rule)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			break
+		}
+	}
+	return e.result, nil
+}
+
+// evaluateRule applies the actions of the rule if it matches and tells whether an action ended the evaluation
+func (e *evaluation) evaluateRule(rule upcloud.LoadBalancerFrontendRule) (bool, error) {
+	matched, err := e.matchRule(rule)
+	if err != nil {
+		return false, fmt.Errorf("rule %q: %w", rule.Name, err)
+	}
+	if !matched {
+		return false, nil
+	}
+	e.result.Matched = append(e.result.Matched, rule.Name)
+	for _, action := range rule.Actions {
+		done, err := e.apply(action)
+		if err != nil {
+			return false, fmt.Errorf("rule %q: %w", rule.Name, err)
+		}
+		e.result.Actions = append(e.result.Actions, AppliedAction{Rule: rule.Name, Action: action})
+		if done {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (e *evaluation) matchRule(rule upcloud.LoadBalancerFrontendRule) (bool, error) {
+	anyOf := rule.MatchingCondition == upcloud.LoadBalancerMatchingConditionOr
+	for _, m := range rule.Matchers {
+		matched, err := e.match(m)
+		if err != nil {
+			return false, err
+		}
+		if m.Inverse != nil && *m.Inverse {
+			matched = !matched
+		}
+		if matched == anyOf {
+			return anyOf, nil
+		}
+	}
+	// A rule without matchers matches every request
+	return !anyOf || len(rule.Matchers) == 0, nil
+}
+
+// apply applies an action and tells whether the action ended the evaluation
+func (e *evaluation) apply(action upcloud.LoadBalancerAction) (bool, error) {
+	switch {
+	case action.UseBackend != nil:
+		e.result.Backend = action.UseBackend.Backend
+		return true, nil
+	case action.TCPReject != nil:
+		e.result.Rejected = true
+		return true, nil
+	case action.HTTPReturn != nil:
+		body, err := base64.StdEncoding.DecodeString(action.HTTPReturn.Payload)
+		if err != nil {
+			return false, fmt.Errorf("payload of http_return action is not base64 encoded")
+		}
+		e.result.Response = &Response{Status: action.HTTPReturn.Status, ContentType: action.HTTPReturn.ContentType, Body: string(body)}
+		return true, nil
+	case action.HTTPRedirect != nil:
+		return true, e.redirect(action.HTTPRedirect)
+	case action.HTTPRewritePath != nil:
+		if err := e.needsRequest(action.Type); err != nil {
+			return false, err
+		}
+		path, err := rewrite(action.HTTPRewritePath.MatchPattern, action.HTTPRewritePath.RewriteTo, e.request.URL.Path)
+		if err != nil {
+			return false, err
+		}
+		e.request.URL.Path = path
+		e.request.URL.RawPath = ""
+		return false, nil
+	case action.HTTPRewriteURI != nil:
+		if err := e.needsRequest(action.Type); err != nil {
+			return false, err
+		}
+		uri, err := rewrite(action.HTTPRewriteURI.MatchPattern, action.HTTPRewriteURI.RewriteTo, e.request.URL.RequestURI())
+		if err != nil {
+			return false, err
+		}
+		u, err := url.ParseRequestURI(uri)
+		if err != nil {
+			return false, fmt.Errorf("rewritten URI %q is not valid", uri)
+		}
+		e.request.URL.Path, e.request.URL.RawPath, e.request.URL.RawQuery = u.Path, u.RawPath, u.RawQuery
+		e.request.RequestURI = uri
+		return false, nil
+	case action.SetForwardedHeaders != nil:
+		if err := e.needsRequest(action.Type); err != nil {
+			return false, err
+		}
+		proto := "http"
+		if len(e.frontend.TLSConfigs) > 0 || e.request.TLS != nil {
+			proto = "https"
+		}
+		e.request.Header.Add("X-Forwarded-For", e.source.Addr().String())
+		e.request.Header.Set("X-Forwarded-Proto", proto)
+		e.request.Header.Set("X-Forwarded-Port", strconv.Itoa(e.frontend.Port))
+		return false, nil
+	case action.SetRequestHeader != nil:
+		if err := e.needsRequest(action.Type); err != nil {
+			return false, err
+		}
+		e.request.Header.Set(action.SetRequestHeader.Header, action.SetRequestHeader.Value)
+		return false, nil
+	case action.SetResponseHeader != nil:
+		e.result.ResponseHeaders.Set(action.SetResponseHeader.Header, action.SetResponseHeader.Value)
+		return false, nil
+	}
+	return false, fmt.Errorf("unsupported action type %q", action.Type)
+}
+
+func (e *evaluation) redirect(redirect *upcloud.LoadBalancerActionHTTPRedirect) error {
+	status := redirect.Status
+	if status == 0 {
+		status = DefaultRedirectStatus
+	}
+	location := redirect.Location
+	if redirect.Scheme != "" {
+		if err := e.needsRequest(upcloud.LoadBalancerActionTypeHTTPRedirect); err != nil {
+			return err
+		}
+		u := *e.request.URL
+		u.Scheme = string(redirect.Scheme)
+		u.Host = e.request.Host
+		location = u.String()
+	}
+	e.result.Response = &Response{Status: status, Location: location}
+	return nil
+}
+
+func (e *evaluation) needsRequest(what any) error {
+	if e.request == nil {
+		return fmt.Errorf("%s requires a request", what)
+	}
+	return nil
+}
+
+// haproxyBackreference matches the \1 style back-references of HAProxy rewrites
+var haproxyBackreference = regexp.MustCompile(`\\([0-9])`)
+
+func rewrite(pattern, replacement, s string) (string, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return "", fmt.Errorf("invalid match pattern %q", pattern)
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return s, nil
+	}
+	// Like HAProxy, the whole value is replaced with the expanded replacement when the pattern matches
+	template := haproxyBackreference.ReplaceAllString(strings.ReplaceAll(replacement, "$", "$$"), "$${$1}")
+	return string(re.ExpandString(nil, template, s, loc)), nil
+}
+
+func isResponseMatcher(m upcloud.LoadBalancerMatcher) bool {
+	return m.HTTPStatus != nil || m.ResponseHeader != nil
+}
+
+// bufferBody reads the body of the request once and gives the request and its copy, which shares the body, readers of
+// the same content. The content length of the copy is set if it is not known.
+func bufferBody(r, clone *http.Request) error {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	_ = r.Body.Close()
+	getBody := func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(body)), nil }
+	for _, req := range []*http.Request{r, clone} {
+		req.Body, _ = getBody()
+		req.GetBody = getBody
+	}
+	if clone.ContentLength < 0 {
+		clone.ContentLength = int64(len(body))
+	}
+	return nil
+}
+
+// requestHost returns the host of the request without a port
+func requestHost(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.Host); err == nil {
+		return host
+	}
+	return r.Host
+}
@@ -0,0 +1,234 @@
+package lbsim
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/lbrule"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func rule(t *testing.T, b *lbrule.Builder) upcloud.LoadBalancerFrontendRule {
+	t.Helper()
+
+	r, err := b.Build(upcloud.LoadBalancerModeHTTP)
+	require.NoError(t, err)
+	return upcloud.LoadBalancerFrontendRule{
+		Name:              r.Name,
+		Priority:          r.Priority,
+		MatchingCondition: r.MatchingCondition,
+		Matchers:          r.Matchers,
+		Actions:           r.Actions,
+	}
+}
+
+func exampleFrontend(t *testing.T) upcloud.LoadBalancerFrontend {
+	return upcloud.LoadBalancerFrontend{
+		Name:           "https",
+		Mode:           upcloud.LoadBalancerModeHTTP,
+		Port:           443,
+		DefaultBackend: "web",
+		TLSConfigs:     []upcloud.LoadBalancerFrontendTLSConfig{{Name: "example"}},
+		Rules: []upcloud.LoadBalancerFrontendRule{
+			rule(t, lbrule.Rule("forwarded").Priority(100).Then(lbrule.SetForwardedHeaders())),
+			rule(t, lbrule.Rule("blocked").Priority(90).
+				When(lbrule.Path().StartsWith("/admin")).
+				And(lbrule.Not(lbrule.SrcIP("10.0.0.0/8"))).
+				Then(lbrule.Return(403, "text/plain", "forbidden"))),
+			rule(t, lbrule.Rule("old").Priority(80).
+				When(lbrule.Host("old.example.com")).
+				Then(lbrule.Redirect("https://example.com/", 301))),
+			rule(t, lbrule.Rule("api").Priority(50).
+				When(lbrule.Path().StartsWith("/api/")).
+				Then(lbrule.RewritePath(`^/api(/.*)$`, `\1`), lbrule.SetRequestHeader("X-Api", "1"), lbrule.UseBackend("api"))),
+			rule(t, lbrule.Rule("beta").Priority(60).
+				When(lbrule.Cookie("beta").Equals("1")).
+				Or(lbrule.Header("X-Beta").Exists()).
+				Then(lbrule.UseBackend("beta"))),
+			rule(t, lbrule.Rule("fallback").Priority(70).
+				When(lbrule.NumMembersUp("web").LessThan(1)).
+				Then(lbrule.UseBackend("fallback"))),
+			rule(t, lbrule.Rule("errors").Priority(10).
+				When(lbrule.HTTPStatus().GreaterOrEqual(500)).
+				Then(lbrule.SetResponseHeader("Cache-Control", "no-store"))),
+		},
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	t.Parallel()
+
+	frontend := exampleFrontend(t)
+	source := netip.MustParseAddrPort("192.0.2.10:40000")
+	membersUp := map[string]int{"web": 2}
+
+	for _, test := range []struct {
+		name   string
+		req    *http.Request
+		want   string
+		verify func(t *testing.T, result *Result)
+	}{
+		{
+			name: "default backend",
+			req:  httptest.NewRequest(http.MethodGet, "https://example.com/", nil),
+			want: "rules: forwarded; backend: web",
+			verify: func(t *testing.T, result *Result) {
+				assert.Equal(t, "192.0.2.10", result.Request.Header.Get("X-Forwarded-For"))
+				assert.Equal(t, "https", result.Request.Header.Get("X-Forwarded-Proto"))
+				assert.Equal(t, "443", result.Request.Header.Get("X-Forwarded-Port"))
+				assert.Equal(t, []string{"errors"}, result.Skipped)
+			},
+		},
+		{
+			name: "return",
+			req:  httptest.NewRequest(http.MethodGet, "https://example.com/admin/users", nil),
+			want: "rules: forwarded, blocked; return 403",
+			verify: func(t *testing.T, result *Result) {
+				assert.Equal(t, &Response{Status: 403, ContentType: "text/plain", Body: "forbidden"}, result.Response)
+				assert.Empty(t, result.Backend)
+			},
+		},
+		{
+			name: "redirect",
+			req:  httptest.NewRequest(http.MethodGet, "https://old.example.com:443/docs", nil),
+			want: "rules: forwarded, old; redirect 301: https://example.com/",
+		},
+		{
+			name: "rewrite",
+			req:  httptest.NewRequest(http.MethodPost, "https://example.com/api/v1/users?page=2", nil),
+			want: "rules: forwarded, api; backend: api",
+			verify: func(t *testing.T, result *Result) {
+				assert.Equal(t, "/v1/users", result.Request.URL.Path)
+				assert.Equal(t, "page=2", result.Request.URL.RawQuery)
+				assert.Equal(t, "1", result.Request.Header.Get("X-Api"))
+				require.Len(t, result.Actions, 4)
+				assert.Equal(t, AppliedAction{Rule: "api", Action: lbrule.UseBackend("api")}, result.Actions[3])
+			},
+		},
+		{
+			name: "or condition",
+			req: func() *http.Request {
+				r := httptest.NewRequest(http.MethodGet, "https://example.com/api/v1", nil)
+				r.AddCookie(&http.Cookie{Name: "beta", Value: "1"})
+				return r
+			}(),
+			want: "rules: forwarded, beta; backend: beta",
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			t.Parallel()
+
+			result, err := Evaluate(frontend, Input{Request: test.req, Source: source, MembersUp: membersUp})
+			require.NoError(t, err)
+			assert.Equal(t, test.want, result.String())
+			if test.verify != nil {
+				test.verify(t, result)
+			}
+		})
+	}
+}
+
+func TestEvaluate_MembersAndResponse(t *testing.T) {
+	t.Parallel()
+
+	frontend := exampleFrontend(t)
+	req := httptest.NewRequest(http.MethodGet, "https://example.com/", nil)
+	req.RemoteAddr = "10.0.0.5:1234"
+
+	result, err := Evaluate(frontend, Input{
+		Request:  req,
+		Response: &http.Response{StatusCode: 503, Header: http.Header{}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "rules: forwarded, fallback, errors; backend: fallback", result.String())
+	assert.Equal(t, "no-store", result.ResponseHeaders.Get("Cache-Control"))
+	assert.Equal(t, "10.0.0.5", result.Request.Header.Get("X-Forwarded-For"))
+	assert.Empty(t, req.Header.Get("X-Forwarded-For"), "input request must not be modified")
+}
+
+func TestEvaluate_Body(t *testing.T) {
+	t.Parallel()
+
+	frontend := upcloud.LoadBalancerFrontend{
+		Name:           "https",
+		Mode:           upcloud.LoadBalancerModeHTTP,
+		DefaultBackend: "web",
+		Rules: []upcloud.LoadBalancerFrontendRule{
+			rule(t, lbrule.Rule("upload").When(lbrule.BodySize().GreaterThan(5)).Then(lbrule.UseBackend("upload"))),
+		},
+	}
+	req := httptest.NewRequest(http.MethodPost, "https://example.com/", io.NopCloser(strings.NewReader("hello world")))
+	req.ContentLength = -1
+	req.RemoteAddr = "10.0.0.5:1234"
+
+	result, err := Evaluate(frontend, Input{Request: req})
+	require.NoError(t, err)
+	assert.Equal(t, "upload", result.Backend)
+
+	// The body is not consumed by the evaluation
+	for _, r := range []*http.Request{req, result.Request} {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Equal(t, "hello world", string(body))
+	}
+}
+
+func TestEvaluate_TCP(t *testing.T) {
+	t.Parallel()
+
+	frontend := upcloud.LoadBalancerFrontend{
+		Name:           "db",
+		Mode:           upcloud.LoadBalancerModeTCP,
+		DefaultBackend: "db",
+		Rules: []upcloud.LoadBalancerFrontendRule{{
+			Name:     "external",
+			Matchers: []upcloud.LoadBalancerMatcher{lbrule.Not(lbrule.SrcIP("10.0.0.0/8"))},
+			Actions:  []upcloud.LoadBalancerAction{lbrule.Reject()},
+		}},
+	}
+	result, err := Evaluate(frontend, Input{Source: netip.MustParseAddrPort("192.0.2.1:5000")})
+	require.NoError(t, err)
+	assert.True(t, result.Rejected)
+	assert.Equal(t, "rules: external; rejected", result.String())
+
+	result, err = Evaluate(frontend, Input{Source: netip.MustParseAddrPort("10.1.2.3:5000")})
+	require.NoError(t, err)
+	assert.Equal(t, "rules: none; backend: db", result.String())
+
+	frontend.Rules[0].Matchers = []upcloud.LoadBalancerMatcher{lbrule.Path().Equals("/")}
+	_, err = Evaluate(frontend, Input{Source: netip.MustParseAddrPort("10.1.2.3:5000")})
+	assert.EqualError(t, err, `rule "external": path matcher requires a request`)
+}
+
+func TestMatchString(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		method  upcloud.LoadBalancerStringMatcherMethod
+		pattern string
+		value   string
+		want    bool
+	}{
+		{upcloud.LoadBalancerStringMatcherMethodDomain, "example.com", "www.example.com", true},
+		{upcloud.LoadBalancerStringMatcherMethodDomain, "example.com", "myexample.com", false},
+		{upcloud.LoadBalancerStringMatcherMethodDomain, "api", "/v1/api/users", true},
+		{upcloud.LoadBalancerStringMatcherMethodIP, "192.0.2.0/24", "192.0.2.7", true},
+		{upcloud.LoadBalancerStringMatcherMethodIP, "192.0.2.0/24", "not-an-ip", false},
+		{upcloud.LoadBalancerStringMatcherMethodRegexp, `^/v[0-9]+/`, "/v2/users", true},
+		{upcloud.LoadBalancerStringMatcherMethodEnds, ".css", "/style.CSS", false},
+	} {
+		got, err := matchString(test.method, test.pattern, nil, test.value)
+		require.NoError(t, err)
+		assert.Equal(t, test.want, got, "%s %s %s", test.method, test.pattern, test.value)
+	}
+
+	got, err := matchString(upcloud.LoadBalancerStringMatcherMethodEnds, ".css", upcloud.BoolPtr(true), "/style.CSS")
+	require.NoError(t, err)
+	assert.True(t, got)
+}
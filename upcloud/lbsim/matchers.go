@@ -0,0 +1,146 @@
+package lbsim
+
+import (
+	"fmt"
+	"net/netip"
+	"regexp"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+// match evaluates a matcher without its inverse flag
+func (e *evaluation) match(m upcloud.LoadBalancerMatcher) (bool, error) {
+	switch {
+	case m.SrcIP != nil:
+		return matchIP(m.SrcIP.Value, e.source.Addr())
+	case m.SrcPort != nil:
+		return m.SrcPort.Matches(int(e.source.Port())), nil
+	case m.NumMembersUp != nil:
+		up := e.input.MembersUp[m.NumMembersUp.Backend]
+		return m.NumMembersUp.Matches(up), nil
+	case m.HTTPStatus != nil:
+		return m.HTTPStatus.Matches(e.input.Response.StatusCode), nil
+	case m.ResponseHeader != nil:
+		return matchStringWithArgument(m.ResponseHeader, e.input.Response.Header.Values(m.ResponseHeader.Name))
+	}
+
+	if err := e.needsRequest(m.Type + " matcher"); err != nil {
+		return false, err
+	}
+	r := e.request
+	switch {
+	case m.BodySize != nil:
+		return m.BodySize.Matches(int(max(r.ContentLength, 0))), nil
+	case m.Path != nil:
+		return matchString(m.Path.Method, m.Path.Value, m.Path.IgnoreCase, r.URL.Path)
+	case m.URL != nil:
+		return matchString(m.URL.Method, m.URL.Value, m.URL.IgnoreCase, r.URL.RequestURI())
+	case m.URLQuery != nil:
+		if m.URLQuery.Method == upcloud.LoadBalancerStringMatcherMethodExists {
+			return r.URL.RawQuery != "", nil
+		}
+		return matchString(m.URLQuery.Method, m.URLQuery.Value, m.URLQuery.IgnoreCase, r.URL.RawQuery)
+	case m.Host != nil:
+		return strings.EqualFold(requestHost(r), m.Host.Value), nil
+	case m.HTTPMethod != nil:
+		return r.Method == string(m.HTTPMethod.Value), nil
+	case m.RequestHeader != nil:
+		return matchStringWithArgument(m.RequestHeader, r.Header.Values(m.RequestHeader.Name))
+	case m.Header != nil:
+		return matchStringWithArgument(m.Header, r.Header.Values(m.Header.Name))
+	case m.Cookie != nil:
+		var values []string
+		for _, c := range r.Cookies() {
+			if c.Name == m.Cookie.Name {
+				values = append(values, c.Value)
+			}
+		}
+		return matchStringWithArgument(m.Cookie, values)
+	case m.URLParam != nil:
+		return matchStringWithArgument(m.URLParam, r.URL.Query()[m.URLParam.Name])
+	}
+	return false, fmt.Errorf("unsupported matcher type %q", m.Type)
+}
+
+// matchStringWithArgument matches any of the values of a header, cookie or URL parameter
+func matchStringWithArgument(m *upcloud.LoadBalancerMatcherStringWithArgument, values []string) (bool, error) {
+	if m.Method == upcloud.LoadBalancerStringMatcherMethodExists {
+		return len(values) > 0, nil
+	}
+	for _, v := range values {
+		matched, err := matchString(m.Method, m.Value, m.IgnoreCase, v)
+		if matched || err != nil {
+			return matched, err
+		}
+	}
+	return false, nil
+}
+
+func matchString(method upcloud.LoadBalancerStringMatcherMethod, pattern string, ignoreCase *bool, s string) (bool, error) {
+	if ignoreCase != nil && *ignoreCase && method != upcloud.LoadBalancerStringMatcherMethodRegexp {
+		pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	}
+	switch method {
+	case upcloud.LoadBalancerStringMatcherMethodExact, "":
+		return s == pattern, nil
+	case upcloud.LoadBalancerStringMatcherMethodSubstring:
+		return strings.Contains(s, pattern), nil
+	case upcloud.LoadBalancerStringMatcherMethodStarts:
+		return strings.HasPrefix(s, pattern), nil
+	case upcloud.LoadBalancerStringMatcherMethodEnds:
+		return strings.HasSuffix(s, pattern), nil
+	case upcloud.LoadBalancerStringMatcherMethodDomain:
+		return matchDomain(pattern, s), nil
+	case upcloud.LoadBalancerStringMatcherMethodExists:
+		return true, nil
+	case upcloud.LoadBalancerStringMatcherMethodIP:
+		addr, err := netip.ParseAddr(s)
+		if err != nil {
+			return false, nil
+		}
+		return matchIP(pattern, addr)
+	case upcloud.LoadBalancerStringMatcherMethodRegexp:
+		if ignoreCase != nil && *ignoreCase {
+			pattern = "(?i)" + pattern
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return false, fmt.Errorf("invalid regular expression %q", pattern)
+		}
+		return re.MatchString(s), nil
+	}
+	return false, fmt.Errorf("unsupported string matching method %q", method)
+}
+
+// matchDomain tells whether the pattern is found in s delimited by slashes, question marks, dots, colons or the
+// boundaries of s, like the dom match method of HAProxy
+func matchDomain(pattern, s string) bool {
+	isDelimiter := func(i int) bool {
+		return i < 0 || i >= len(s) || strings.ContainsRune("/?.:", rune(s[i]))
+	}
+	for offset := 0; pattern != "" && offset <= len(s); {
+		i := strings.Index(s[offset:], pattern)
+		if i < 0 {
+			return false
+		}
+		start := offset + i
+		if isDelimiter(start-1) && isDelimiter(start+len(pattern)) {
+			return true
+		}
+		offset = start + 1
+	}
+	return false
+}
+
+// matchIP tells whether the address equals the IP address or belongs to the CIDR block in value
+func matchIP(value string, addr netip.Addr) (bool, error) {
+	if prefix, err := netip.ParsePrefix(value); err == nil {
+		return prefix.Contains(addr.Unmap()), nil
+	}
+	ip, err := netip.ParseAddr(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid IP address or CIDR %q", value)
+	}
+	return ip.Unmap() == addr.Unmap(), nil
+}
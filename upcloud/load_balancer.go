@@ -1,6 +1,8 @@
 package upcloud
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -24,6 +26,9 @@ type (
 	LoadBalancerAddressFamily                     string
 	LoadBalancerNodeOperationalState              string
 	LoadBalancerMaintenanceDOW                    string
+	LoadBalancerChangeAction                      string
+	LoadBalancerResourceType                      string
+	LoadBalancerMemberStatus                      string
 )
 
 const (
@@ -145,6 +150,27 @@ const (
 	LoadBalancerMaintenanceDOWFriday    LoadBalancerMaintenanceDOW = "friday"
 	LoadBalancerMaintenanceOWSaturday   LoadBalancerMaintenanceDOW = "saturday"
 	LoadBalancerMaintenanceDOWSunday    LoadBalancerMaintenanceDOW = "sunday"
+
+	LoadBalancerChangeActionCreate LoadBalancerChangeAction = "create"
+	LoadBalancerChangeActionModify LoadBalancerChangeAction = "modify"
+	LoadBalancerChangeActionDelete LoadBalancerChangeAction = "delete"
+
+	LoadBalancerResourceTypeLoadBalancer      LoadBalancerResourceType = "load-balancer"
+	LoadBalancerResourceTypeResolver          LoadBalancerResourceType = "resolver"
+	LoadBalancerResourceTypeBackend           LoadBalancerResourceType = "backend"
+	LoadBalancerResourceTypeBackendMember     LoadBalancerResourceType = "backend-member"
+	LoadBalancerResourceTypeBackendTLSConfig  LoadBalancerResourceType = "backend-tls-config"
+	LoadBalancerResourceTypeFrontend          LoadBalancerResourceType = "frontend"
+	LoadBalancerResourceTypeFrontendRule      LoadBalancerResourceType = "frontend-rule"
+	LoadBalancerResourceTypeFrontendTLSConfig LoadBalancerResourceType = "frontend-tls-config"
+)
+
+const (
+	LoadBalancerMemberStatusDown     LoadBalancerMemberStatus = "down"
+	LoadBalancerMemberStatusDisabled LoadBalancerMemberStatus = "disabled"
+	// LoadBalancerMemberStatusUnknown is the status of enabled members that are not known to be down. The API does
+	// not report health check results, so no member is known to be up.
+	LoadBalancerMemberStatusUnknown LoadBalancerMemberStatus = "unknown"
 )
 
 // LoadBalancerPlan represents load balancer plan details
@@ -322,6 +348,11 @@ type LoadBalancerMatcherNumMembersUp struct {
 	Backend string                           `json:"backend,omitempty"`
 }
 
+// Matches tells whether the number of members up in the backend matches the matcher
+func (m LoadBalancerMatcherNumMembersUp) Matches(membersUp int) bool {
+	return LoadBalancerMatcherInteger{Method: m.Method, Value: m.Value}.Matches(membersUp)
+}
+
 // LoadBalancerMatcherHTTPMethod represents 'http_method' matcher
 type LoadBalancerMatcherHTTPMethod struct {
 	Value LoadBalancerHTTPMatcherMethod `json:"value,omitempty"`
@@ -335,6 +366,23 @@ type LoadBalancerMatcherInteger struct {
 	RangeEnd   int                              `json:"range_end,omitempty"`
 }
 
+// Matches tells whether the value matches the matcher
+func (m LoadBalancerMatcherInteger) Matches(v int) bool {
+	switch m.Method {
+	case LoadBalancerIntegerMatcherMethodGreater:
+		return v > m.Value
+	case LoadBalancerIntegerMatcherMethodGreaterOrEqual:
+		return v >= m.Value
+	case LoadBalancerIntegerMatcherMethodLess:
+		return v < m.Value
+	case LoadBalancerIntegerMatcherMethodLessOrEqual:
+		return v <= m.Value
+	case LoadBalancerIntegerMatcherMethodRange:
+		return v >= m.RangeStart && v <= m.RangeEnd
+	}
+	return v == m.Value
+}
+
 // LoadBalancerMatcherString represents string matcher
 type LoadBalancerMatcherString struct {
 	Method     LoadBalancerStringMatcherMethod `json:"method,omitempty"`
@@ -465,3 +513,144 @@ type LoadBalancerFrontendNetwork struct {
 type LoadBalancerDNSChallengeDomain struct {
 	Domain string `json:"domain"`
 }
+
+// LoadBalancerChange represents a single entry in a load balancer change plan
+type LoadBalancerChange struct {
+	Action   LoadBalancerChangeAction
+	Resource LoadBalancerResourceType
+	// Parent is the name of the backend or frontend the resource belongs to, if any
+	Parent string
+	Name   string
+	// Fields contains the JSON names of the changed fields of a modified resource
+	Fields []string
+}
+
+// String describes the change in a diff-like format, e.g. "~ backend-member api/web-1: weight, enabled"
+func (c LoadBalancerChange) String() string {
+	symbol := "~"
+	switch c.Action {
+	case LoadBalancerChangeActionCreate:
+		symbol = "+"
+	case LoadBalancerChangeActionDelete:
+		symbol = "-"
+	}
+	name := c.Name
+	if c.Parent != "" {
+		name = c.Parent + "/" + c.Name
+	}
+	s := fmt.Sprintf("%s %s %s", symbol, c.Resource, name)
+	if len(c.Fields) > 0 {
+		s += ": " + strings.Join(c.Fields, ", ")
+	}
+	return s
+}
+
+// LoadBalancerChangePlan represents the changes needed to turn the current configuration of a load balancer into
+// the desired configuration. Changes are listed in the order they are applied.
+type LoadBalancerChangePlan struct {
+	// LoadBalancerUUID is empty if the load balancer does not exist yet
+	LoadBalancerUUID string
+	Name             string
+	Changes          []LoadBalancerChange
+	// Applied tells whether all changes were made
+	Applied bool
+}
+
+// HasChanges tells whether the plan contains any changes
+func (p *LoadBalancerChangePlan) HasChanges() bool {
+	return len(p.Changes) > 0
+}
+
+// String returns the plan with one change per line
+func (p *LoadBalancerChangePlan) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Load balancer %s", p.Name)
+	if p.LoadBalancerUUID != "" {
+		fmt.Fprintf(&sb, " (%s)", p.LoadBalancerUUID)
+	}
+	sb.WriteString(":\n")
+	if !p.HasChanges() {
+		sb.WriteString("  no changes\n")
+	}
+	for _, change := range p.Changes {
+		sb.WriteString(change.String() + "\n")
+	}
+	return sb.String()
+}
+
+// LoadBalancerHealth represents a flattened health report of a load balancer
+type LoadBalancerHealth struct {
+	UUID             string
+	Name             string
+	ConfiguredStatus LoadBalancerConfiguredStatus
+	OperationalState LoadBalancerOperationalState
+	Nodes            []LoadBalancerNodeHealth
+	Backends         []LoadBalancerBackendHealth
+	Frontends        []LoadBalancerFrontendHealth
+	// UnavailableFrontends contains the names of the frontends whose default backend has no members, or only
+	// members that are down or disabled
+	UnavailableFrontends []string
+}
+
+// DownMembers returns the enabled backend members that are down, in the order of the backends
+func (h *LoadBalancerHealth) DownMembers() []LoadBalancerMemberHealth {
+	var members []LoadBalancerMemberHealth
+	for _, backend := range h.Backends {
+		for _, member := range backend.Members {
+			if member.Status == LoadBalancerMemberStatusDown {
+				members = append(members, member)
+			}
+		}
+	}
+	return members
+}
+
+// LoadBalancerNodeHealth represents the health of a load balancer node
+type LoadBalancerNodeHealth struct {
+	OperationalState LoadBalancerNodeOperationalState
+	// IPAddresses contains the addresses of the node in all networks
+	IPAddresses []string
+}
+
+// LoadBalancerBackendHealth represents the health of a backend and its members
+type LoadBalancerBackendHealth struct {
+	Name    string
+	Members []LoadBalancerMemberHealth
+	// MembersUnknown is the number of members that are neither down nor disabled
+	MembersUnknown int
+}
+
+// LoadBalancerMemberHealth represents the status of a backend member
+type LoadBalancerMemberHealth struct {
+	Name   string
+	Type   LoadBalancerBackendMemberType
+	IP     string
+	Port   int
+	Status LoadBalancerMemberStatus
+	// Reason explains why the member is down
+	Reason string
+}
+
+// LoadBalancerFrontendHealth represents the health of a frontend
+type LoadBalancerFrontendHealth struct {
+	Name                         string
+	DefaultBackend               string
+	DefaultBackendMembersUnknown int
+	// NumMembersUpMatchers contains the num_members_up matchers of the frontend rules evaluated against the
+	// members that may be up
+	NumMembersUpMatchers []LoadBalancerNumMembersUpMatcherHealth
+}
+
+// LoadBalancerNumMembersUpMatcherHealth represents a num_members_up matcher of a frontend rule and whether it
+// currently matches
+type LoadBalancerNumMembersUpMatcherHealth struct {
+	Rule           string
+	Backend        string
+	Method         LoadBalancerIntegerMatcherMethod
+	Value          int
+	Inverse        bool
+	MembersUnknown int
+	// Matches tells whether the matcher matches, taking Inverse into account. It is nil if the result depends on the
+	// members of unknown status. The actions of the rule are taken if the other matchers of the rule match as well.
+	Matches *bool
+}
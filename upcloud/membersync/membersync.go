@@ -0,0 +1,383 @@
+// Package membersync keeps the static members of a load balancer backend in sync with a set of servers.
+//
+// The servers are selected by labels, by server group or by Kubernetes node group. Each selected server becomes a
+// member named after its UUID and a name prefix, with the private IP address of the server in the network the load
+// balancer is attached to. Members are added for new servers, their address is updated when the address of the
+// server changes, and members of servers that are no longer selected are removed. The weight and enabled state of
+// existing members are left as they are, so members can still be disabled and weighted by other tools.
+package membersync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+const (
+	DefaultWeight      = 100
+	DefaultMaxSessions = 1000
+)
+
+const (
+	// maxMemberNameLength is the longest member name the API accepts
+	maxMemberNameLength = 64
+	// uuidLength is the length of the server UUIDs appended to the name prefix
+	uuidLength = 36
+)
+
+// ErrNoServers is returned when the selector matches no servers and Config.AllowEmpty is not set
+var ErrNoServers = errors.New("selector matches no servers")
+
+// Client is the client needed to sync the members.
+type Client interface {
+	GetLoadBalancer(ctx context.Context, r *request.GetLoadBalancerRequest) (*upcloud.LoadBalancer, error)
+	GetServersWithFilters(ctx context.Context, r *request.GetServersWithFiltersRequest) (*upcloud.Servers, error)
+	GetServerGroup(ctx context.Context, r *request.GetServerGroupRequest) (*upcloud.ServerGroup, error)
+	GetKubernetesNodeGroup(ctx context.Context, r *request.GetKubernetesNodeGroupRequest) (*upcloud.KubernetesNodeGroupDetails, error)
+	GetServerDetails(ctx context.Context, r *request.GetServerDetailsRequest) (*upcloud.ServerDetails, error)
+	CreateLoadBalancerBackendMember(ctx context.Context, r *request.CreateLoadBalancerBackendMemberRequest) (*upcloud.LoadBalancerBackendMember, error)
+	ModifyLoadBalancerBackendMember(ctx context.Context, r *request.ModifyLoadBalancerBackendMemberRequest) (*upcloud.LoadBalancerBackendMember, error)
+	DeleteLoadBalancerBackendMember(ctx context.Context, r *request.DeleteLoadBalancerBackendMemberRequest) error
+}
+
+// Selector selects the servers of the backend. Exactly one of the labels, the server group and the Kubernetes node
+// group must be set.
+type Selector struct {
+	// Labels selects the servers that have all the labels
+	Labels []request.FilterLabel
+	// ServerGroupUUID selects the servers of a server group
+	ServerGroupUUID string
+	// KubernetesClusterUUID and KubernetesNodeGroup select the nodes of a Kubernetes node group. Nodes that are
+	// terminating are not selected.
+	KubernetesClusterUUID string
+	KubernetesNodeGroup   string
+}
+
+func (s Selector) validate() error {
+	set := 0
+	if len(s.Labels) > 0 {
+		set++
+	}
+	if s.ServerGroupUUID != "" {
+		set++
+	}
+	if s.KubernetesClusterUUID != "" || s.KubernetesNodeGroup != "" {
+		if s.KubernetesClusterUUID == "" || s.KubernetesNodeGroup == "" {
+			return errors.New("both Kubernetes cluster UUID and node group are required")
+		}
+		set++
+	}
+	if set != 1 {
+		return errors.New("exactly one of labels, server group and Kubernetes node group must be selected")
+	}
+	return nil
+}
+
+// Config represents the backend to sync
+type Config struct {
+	LoadBalancerUUID string
+	Backend          string
+	Selector         Selector
+	// Port is the port of the members
+	Port int
+	// Network is the name of the private network of the load balancer the members are reached through. It can be
+	// left empty if the load balancer has only one private network.
+	Network string
+	// NamePrefix is prepended to the server UUID to name the members. It is required: only static members with the
+	// prefix are managed, and other static members in the same backend are kept as they are. The prefix can have at
+	// most 28 characters, as member names are limited to 64 characters.
+	NamePrefix string
+	// Weight and MaxSessions are used for new members. Default to DefaultWeight and DefaultMaxSessions.
+	Weight      int
+	MaxSessions int
+	// AllowEmpty allows removing all the members when the selector matches no servers
+	AllowEmpty bool
+}
+
+// ChangeType is the type of a member change
+type ChangeType string
+
+const (
+	ChangeTypeAdd    ChangeType = "add"
+	ChangeTypeUpdate ChangeType = "update"
+	ChangeTypeRemove ChangeType = "remove"
+)
+
+// Change represents a member that differs from the selected servers
+type Change struct {
+	Type ChangeType
+	// Member is the member after the change, or the removed member
+	Member     upcloud.LoadBalancerBackendMember
+	ServerUUID string
+	// Current is the member before an update
+	Current *upcloud.LoadBalancerBackendMember
+	// Applied tells whether the change was made
+	Applied bool
+	Error   error
+}
+
+// String describes the change
+func (c Change) String() string {
+	s := fmt.Sprintf("%s %s: %s:%d", c.Type, c.Member.Name, c.Member.IP, c.Member.Port)
+	if c.Current != nil {
+		s = fmt.Sprintf("%s %s: %s:%d -> %s:%d", c.Type, c.Member.Name, c.Current.IP, c.Current.Port, c.Member.IP, c.Member.Port)
+	}
+	if c.Error != nil {
+		s += ": " + c.Error.Error()
+	}
+	return s
+}
+
+// UnresolvedServer represents a selected server without an address in the network of the load balancer
+type UnresolvedServer struct {
+	ServerUUID string
+	Reason     string
+}
+
+// Result represents the outcome of a sync
+type Result struct {
+	// Changes contains the additions, updates and removals in the order they are made
+	Changes []Change
+	// Unresolved contains the selected servers that could not be added. Existing members of these servers are kept.
+	Unresolved []UnresolvedServer
+}
+
+// Sync adds, updates and removes the static members of the backend to match the selected servers. With dryRun set,
+// the changes are only reported. Members are added before they are removed so that the backend is not left without
+// members in between. Errors of individual changes are recorded in the changes and returned joined together.
+func Sync(ctx context.Context, c Client, config Config, dryRun bool) (*Result, error) {
+	if config.LoadBalancerUUID == "" || config.Backend == "" {
+		return nil, errors.New("load balancer UUID and backend are required")
+	}
+	if config.NamePrefix == "" {
+		return nil, errors.New("name prefix is required")
+	}
+	if len(config.NamePrefix)+uuidLength > maxMemberNameLength {
+		return nil, fmt.Errorf("name prefix %q is too long, it can have at most %d characters", config.NamePrefix, maxMemberNameLength-uuidLength)
+	}
+	if config.Port < 1 || config.Port > 65535 {
+		return nil, fmt.Errorf("port %d is not valid", config.Port)
+	}
+	if err := config.Selector.validate(); err != nil {
+		return nil, err
+	}
+	if config.Weight == 0 {
+		config.Weight = DefaultWeight
+	}
+	if config.MaxSessions == 0 {
+		config.MaxSessions = DefaultMaxSessions
+	}
+
+	lb, err := c.GetLoadBalancer(ctx, &request.GetLoadBalancerRequest{UUID: config.LoadBalancerUUID})
+	if err != nil {
+		return nil, err
+	}
+	network, err := privateNetwork(lb, config.Network)
+	if err != nil {
+		return nil, err
+	}
+	idx := slices.IndexFunc(lb.Backends, func(b upcloud.LoadBalancerBackend) bool { return b.Name == config.Backend })
+	if idx < 0 {
+		return nil, fmt.Errorf("load balancer %s has no backend %s", lb.Name, config.Backend)
+	}
+	backend := lb.Backends[idx]
+
+	serverUUIDs, err := selectServers(ctx, c, config.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if len(serverUUIDs) == 0 && !config.AllowEmpty {
+		return nil, ErrNoServers
+	}
+
+	result := &Result{}
+	desired := make(map[string]upcloud.LoadBalancerBackendMember, len(serverUUIDs))
+	for _, uuid := range serverUUIDs {
+		details, err := c.GetServerDetails(ctx, &request.GetServerDetailsRequest{UUID: uuid})
+		if err != nil {
+			return nil, fmt.Errorf("getting server %s: %w", uuid, err)
+		}
+		ip, ok := privateAddress(upcloud.Networking(details.Networking), network.UUID)
+		if !ok {
+			result.Unresolved = append(result.Unresolved, UnresolvedServer{
+				ServerUUID: uuid,
+				Reason:     fmt.Sprintf("no IPv4 address in network %s", network.Name),
+			})
+			continue
+		}
+		name := config.NamePrefix + uuid
+		desired[name] = upcloud.LoadBalancerBackendMember{
+			Name:        name,
+			IP:          ip,
+			Port:        config.Port,
+			Weight:      config.Weight,
+			MaxSessions: config.MaxSessions,
+			Type:        upcloud.LoadBalancerBackendMemberTypeStatic,
+			Enabled:     true,
+		}
+	}
+
+	var additions, updates, removals []Change
+	current := make(map[string]upcloud.LoadBalancerBackendMember)
+	for _, m := range backend.Members {
+		if m.Type != upcloud.LoadBalancerBackendMemberTypeStatic || !strings.HasPrefix(m.Name, config.NamePrefix) {
+			continue
+		}
+		current[m.Name] = m
+		serverUUID := strings.TrimPrefix(m.Name, config.NamePrefix)
+		want, ok := desired[m.Name]
+		switch {
+		case !ok && slices.ContainsFunc(result.Unresolved, func(u UnresolvedServer) bool { return u.ServerUUID == serverUUID }):
+			// The server is still selected, but its address cannot be resolved at the moment
+		case !ok:
+			removals = append(removals, Change{Type: ChangeTypeRemove, Member: m, ServerUUID: serverUUID})
+		case m.IP != want.IP || m.Port != want.Port:
+			updated := m
+			updated.IP, updated.Port = want.IP, want.Port
+			updates = append(updates, Change{Type: ChangeTypeUpdate, Member: updated, ServerUUID: serverUUID, Current: &m})
+		}
+	}
+	for name, m := range desired {
+		if _, ok := current[name]; !ok {
+			additions = append(additions, Change{Type: ChangeTypeAdd, Member: m, ServerUUID: strings.TrimPrefix(name, config.NamePrefix)})
+		}
+	}
+	for _, changes := range [][]Change{additions, updates, removals} {
+		slices.SortFunc(changes, func(a, b Change) int { return strings.Compare(a.Member.Name, b.Member.Name) })
+		result.Changes = append(result.Changes, changes...)
+	}
+
+	var errs []error
+	for i := range result.Changes {
+		change := &result.Changes[i]
+		if dryRun {
+			continue
+		}
+		change.Error = apply(ctx, c, config, change)
+		if change.Error != nil {
+			errs = append(errs, fmt.Errorf("%s member %s: %w", change.Type, change.Member.Name, change.Error))
+			continue
+		}
+		change.Applied = true
+	}
+	return result, errors.Join(errs...)
+}
+
+func apply(ctx context.Context, c Client, config Config, change *Change) error {
+	m := change.Member
+	switch change.Type {
+	case ChangeTypeAdd:
+		_, err := c.CreateLoadBalancerBackendMember(ctx, &request.CreateLoadBalancerBackendMemberRequest{
+			ServiceUUID: config.LoadBalancerUUID,
+			BackendName: config.Backend,
+			Member: request.LoadBalancerBackendMember{
+				Name:        m.Name,
+				Weight:      m.Weight,
+				MaxSessions: m.MaxSessions,
+				Enabled:     m.Enabled,
+				Type:        m.Type,
+				IP:          m.IP,
+				Port:        m.Port,
+			},
+		})
+		return err
+	case ChangeTypeUpdate:
+		_, err := c.ModifyLoadBalancerBackendMember(ctx, &request.ModifyLoadBalancerBackendMemberRequest{
+			ServiceUUID: config.LoadBalancerUUID,
+			BackendName: config.Backend,
+			Name:        m.Name,
+			Member: request.ModifyLoadBalancerBackendMember{
+				IP:   &m.IP,
+				Port: m.Port,
+			},
+		})
+		return err
+	case ChangeTypeRemove:
+		return c.DeleteLoadBalancerBackendMember(ctx, &request.DeleteLoadBalancerBackendMemberRequest{
+			ServiceUUID: config.LoadBalancerUUID,
+			BackendName: config.Backend,
+			Name:        m.Name,
+		})
+	}
+	return fmt.Errorf("unknown change type %q", change.Type)
+}
+
+// selectServers returns the UUIDs of the selected servers in a stable order
+func selectServers(ctx context.Context, c Client, selector Selector) ([]string, error) {
+	var uuids []string
+	switch {
+	case len(selector.Labels) > 0:
+		filters := make([]request.QueryFilter, 0, len(selector.Labels))
+		for _, label := range selector.Labels {
+			filters = append(filters, label)
+		}
+		servers, err := c.GetServersWithFilters(ctx, &request.GetServersWithFiltersRequest{Filters: filters})
+		if err != nil {
+			return nil, err
+		}
+		for _, server := range servers.Servers {
+			uuids = append(uuids, server.UUID)
+		}
+	case selector.ServerGroupUUID != "":
+		group, err := c.GetServerGroup(ctx, &request.GetServerGroupRequest{UUID: selector.ServerGroupUUID})
+		if err != nil {
+			return nil, err
+		}
+		uuids = slices.Clone(group.Members)
+	default:
+		group, err := c.GetKubernetesNodeGroup(ctx, &request.GetKubernetesNodeGroupRequest{
+			ClusterUUID: selector.KubernetesClusterUUID,
+			Name:        selector.KubernetesNodeGroup,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, node := range group.Nodes {
+			if node.UUID != "" && node.State != upcloud.KubernetesNodeStateTerminating {
+				uuids = append(uuids, node.UUID)
+			}
+		}
+	}
+	slices.Sort(uuids)
+	return slices.Compact(uuids), nil
+}
+
+// privateNetwork returns the private network of the load balancer with the given name, or the only private network
+// if name is empty
+func privateNetwork(lb *upcloud.LoadBalancer, name string) (upcloud.LoadBalancerNetwork, error) {
+	var networks []upcloud.LoadBalancerNetwork
+	for _, n := range lb.Networks {
+		if n.Type == upcloud.LoadBalancerNetworkTypePrivate && (name == "" || n.Name == name) {
+			networks = append(networks, n)
+		}
+	}
+	switch {
+	case len(networks) == 0 && name != "":
+		return upcloud.LoadBalancerNetwork{}, fmt.Errorf("load balancer %s has no private network %s", lb.Name, name)
+	case len(networks) == 0:
+		return upcloud.LoadBalancerNetwork{}, fmt.Errorf("load balancer %s has no private network", lb.Name)
+	case len(networks) > 1:
+		return upcloud.LoadBalancerNetwork{}, fmt.Errorf("load balancer %s has several private networks, network name is required", lb.Name)
+	}
+	return networks[0], nil
+}
+
+// privateAddress returns the first IPv4 address of the server in the network
+func privateAddress(networking upcloud.Networking, networkUUID string) (string, bool) {
+	for _, iface := range networking.Interfaces {
+		if iface.Type != upcloud.NetworkTypePrivate || iface.Network != networkUUID {
+			continue
+		}
+		for _, ip := range iface.IPAddresses {
+			if ip.Family == upcloud.IPAddressFamilyIPv4 && ip.Address != "" {
+				return ip.Address, true
+			}
+		}
+	}
+	return "", false
+}
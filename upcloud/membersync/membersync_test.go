@@ -0,0 +1,201 @@
+package membersync
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Client = (*service.Service)(nil)
+
+var webLabel = request.FilterLabel{Label: upcloud.Label{Key: "role", Value: "web"}}
+
+var testResources = fakeservice.Resources{
+	LoadBalancers: []upcloud.LoadBalancer{{
+		UUID: "lb",
+		Name: "lb",
+		Networks: []upcloud.LoadBalancerNetwork{
+			{Name: "public", Type: upcloud.LoadBalancerNetworkTypePublic},
+			{Name: "private", Type: upcloud.LoadBalancerNetworkTypePrivate, UUID: "net-1"},
+		},
+		Backends: []upcloud.LoadBalancerBackend{{
+			Name: "web",
+			Members: []upcloud.LoadBalancerBackendMember{
+				{Name: "srv-a", IP: "10.0.0.1", Port: 80, Weight: 50, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: false},
+				{Name: "srv-b", IP: "10.0.0.99", Port: 80, Weight: 100, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+				{Name: "srv-old", IP: "10.0.0.5", Port: 80, Weight: 100, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+				{Name: "manual", IP: "10.0.0.200", Port: 80, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+				{Name: "web-1", IP: "10.0.0.201", Port: 80, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+				{Name: "srv-dns", Port: 80, Type: upcloud.LoadBalancerBackendMemberTypeDynamic, Enabled: true},
+			},
+		}},
+	}},
+	Servers: []upcloud.ServerDetails{
+		server("c", "net-1", "10.0.0.3"),
+		server("a", "net-1", "10.0.0.1"),
+		server("b", "net-1", "10.0.0.2"),
+		server("d", "net-2", "10.1.0.4"),
+	},
+	ServerGroups: []upcloud.ServerGroup{{UUID: "group", Members: upcloud.ServerUUIDSlice{"b", "a"}}},
+	KubernetesNodeGroups: map[string]upcloud.KubernetesNodeGroupDetails{
+		"cluster/workers": {Nodes: []upcloud.KubernetesNode{
+			{UUID: "a", State: upcloud.KubernetesNodeStateRunning},
+			{UUID: "c", State: upcloud.KubernetesNodeStateTerminating},
+		}},
+	},
+}
+
+func server(uuid, network, ip string) upcloud.ServerDetails {
+	return upcloud.ServerDetails{
+		Server: upcloud.Server{UUID: uuid},
+		Labels: upcloud.LabelSlice{webLabel.Label},
+		Networking: upcloud.ServerNetworking{Interfaces: upcloud.ServerInterfaceSlice{
+			{Type: upcloud.NetworkTypePublic, IPAddresses: upcloud.IPAddressSlice{{Family: upcloud.IPAddressFamilyIPv4, Address: "198.51.100.1"}}},
+			{Type: upcloud.NetworkTypePrivate, Network: network, IPAddresses: upcloud.IPAddressSlice{{Family: upcloud.IPAddressFamilyIPv4, Address: ip}}},
+		}},
+	}
+}
+
+// memberCalls returns the calls that modified the members of the backend
+func memberCalls(c *fakeservice.Service) []string {
+	return c.Calls("CreateLoadBalancerBackendMember", "ModifyLoadBalancerBackendMember", "DeleteLoadBalancerBackendMember")
+}
+
+// members returns the address of each member of the backend
+func members(c *fakeservice.Service) map[string]string {
+	members := map[string]string{}
+	for _, m := range c.LoadBalancers[0].Backends[0].Members {
+		members[m.Name] = m.IP
+	}
+	return members
+}
+
+func testConfig(selector Selector) Config {
+	return Config{LoadBalancerUUID: "lb", Backend: "web", Port: 80, NamePrefix: "srv-", Selector: selector}
+}
+
+func changeStrings(changes []Change) []string {
+	s := make([]string, 0, len(changes))
+	for _, c := range changes {
+		s = append(s, c.String())
+	}
+	return s
+}
+
+func TestSync(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	result, err := Sync(context.Background(), c, testConfig(Selector{Labels: []request.FilterLabel{webLabel}}), false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"GetServersWithFilters label=role=web"}, c.Calls("GetServersWithFilters"))
+	assert.Equal(t, []string{
+		"add srv-c: 10.0.0.3:80",
+		"update srv-b: 10.0.0.99:80 -> 10.0.0.2:80",
+		"remove srv-old: 10.0.0.5:80",
+	}, changeStrings(result.Changes))
+	assert.Equal(t, []UnresolvedServer{{ServerUUID: "d", Reason: "no IPv4 address in network private"}}, result.Unresolved)
+	assert.Equal(t, []string{
+		"CreateLoadBalancerBackendMember lb/web/srv-c",
+		"ModifyLoadBalancerBackendMember lb/web/srv-b",
+		"DeleteLoadBalancerBackendMember lb/web/srv-old",
+	}, memberCalls(c))
+	assert.Equal(t, map[string]string{
+		"srv-a":   "10.0.0.1",
+		"srv-b":   "10.0.0.2",
+		"srv-c":   "10.0.0.3",
+		"manual":  "10.0.0.200",
+		"web-1":   "10.0.0.201",
+		"srv-dns": "",
+	}, members(c))
+	for _, change := range result.Changes {
+		assert.True(t, change.Applied)
+	}
+	assert.Equal(t, upcloud.LoadBalancerBackendMember{
+		Name:        "srv-c",
+		IP:          "10.0.0.3",
+		Port:        80,
+		Weight:      DefaultWeight,
+		MaxSessions: DefaultMaxSessions,
+		Type:        upcloud.LoadBalancerBackendMemberTypeStatic,
+		Enabled:     true,
+	}, result.Changes[0].Member)
+}
+
+func TestSync_UnmanagedMembers(t *testing.T) {
+	t.Parallel()
+
+	// Removing all managed members keeps the static members without the prefix, e.g. hand-added ones
+	c := fakeservice.New(testResources)
+	config := testConfig(Selector{Labels: []request.FilterLabel{{Label: upcloud.Label{Key: "role", Value: "db"}}}})
+	config.AllowEmpty = true
+	result, err := Sync(context.Background(), c, config, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"remove srv-a: 10.0.0.1:80",
+		"remove srv-b: 10.0.0.99:80",
+		"remove srv-old: 10.0.0.5:80",
+	}, changeStrings(result.Changes))
+	assert.Contains(t, members(c), "manual")
+	assert.Contains(t, members(c), "web-1")
+}
+
+func TestSync_Selectors(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	result, err := Sync(context.Background(), c, testConfig(Selector{ServerGroupUUID: "group"}), true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"update srv-b: 10.0.0.99:80 -> 10.0.0.2:80",
+		"remove srv-old: 10.0.0.5:80",
+	}, changeStrings(result.Changes))
+	assert.Empty(t, memberCalls(c), "dry run must not modify members")
+	assert.False(t, result.Changes[0].Applied)
+
+	result, err = Sync(context.Background(), c, testConfig(Selector{KubernetesClusterUUID: "cluster", KubernetesNodeGroup: "workers"}), true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"remove srv-b: 10.0.0.99:80",
+		"remove srv-old: 10.0.0.5:80",
+	}, changeStrings(result.Changes))
+}
+
+func TestSync_Errors(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	c.Errors = map[string]error{"CreateLoadBalancerBackendMember": errors.New("conflict")}
+	result, err := Sync(context.Background(), c, testConfig(Selector{Labels: []request.FilterLabel{webLabel}}), false)
+	assert.EqualError(t, err, "add member srv-c: conflict")
+	assert.Equal(t, "add srv-c: 10.0.0.3:80: conflict", result.Changes[0].String())
+	assert.False(t, result.Changes[0].Applied)
+	assert.True(t, result.Changes[1].Applied)
+
+	_, err = Sync(context.Background(), c, testConfig(Selector{Labels: []request.FilterLabel{{Label: upcloud.Label{Key: "role", Value: "db"}}}}), true)
+	assert.ErrorIs(t, err, ErrNoServers)
+
+	config := testConfig(Selector{ServerGroupUUID: "group"})
+	config.NamePrefix = ""
+	_, err = Sync(context.Background(), c, config, true)
+	assert.EqualError(t, err, "name prefix is required")
+
+	config.NamePrefix = strings.Repeat("a", 29)
+	_, err = Sync(context.Background(), c, config, true)
+	assert.EqualError(t, err, `name prefix "aaaaaaaaaaaaaaaaaaaaaaaaaaaaa" is too long, it can have at most 28 characters`)
+
+	_, err = Sync(context.Background(), c, testConfig(Selector{ServerGroupUUID: "group", KubernetesClusterUUID: "cluster", KubernetesNodeGroup: "workers"}), true)
+	assert.EqualError(t, err, "exactly one of labels, server group and Kubernetes node group must be selected")
+
+	c.LoadBalancers[0].Networks = append(c.LoadBalancers[0].Networks, upcloud.LoadBalancerNetwork{Name: "other", Type: upcloud.LoadBalancerNetworkTypePrivate})
+	_, err = Sync(context.Background(), c, testConfig(Selector{ServerGroupUUID: "group"}), true)
+	assert.EqualError(t, err, "load balancer lb has several private networks, network name is required")
+}
@@ -0,0 +1,155 @@
+package netplan
+
+import (
+	"context"
+	"fmt"
+	"net/netip"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// NextFreeSubnet returns the first subnet with the given prefix length inside the supernet that does not overlap
+// any of the used prefixes.
+func NextFreeSubnet(supernet netip.Prefix, bits int, used []netip.Prefix) (netip.Prefix, error) {
+	if !supernet.IsValid() || supernet != supernet.Masked() {
+		return netip.Prefix{}, fmt.Errorf("invalid supernet %s", supernet)
+	}
+	if bits < supernet.Bits() || bits > supernet.Addr().BitLen() {
+		return netip.Prefix{}, fmt.Errorf("invalid subnet size /%d for supernet %s", bits, supernet)
+	}
+
+	candidate := netip.PrefixFrom(supernet.Addr(), bits)
+	for supernet.Contains(candidate.Addr()) {
+		next := lastAddr(candidate)
+		free := true
+		for _, u := range used {
+			if candidate.Overlaps(u) {
+				free = false
+				// Either the used prefix is inside the candidate or the candidate is inside the used prefix, so
+				// the address after the larger one is always aligned to the subnet size.
+				if last := lastAddr(u.Masked()); next.Less(last) {
+					next = last
+				}
+			}
+		}
+		if free {
+			return candidate, nil
+		}
+		next = next.Next()
+		if !next.IsValid() {
+			break
+		}
+		candidate = netip.PrefixFrom(next, bits)
+	}
+	return netip.Prefix{}, fmt.Errorf("%w of size /%d in %s", ErrNoFreeSubnet, bits, supernet)
+}
+
+// NextFreeSubnetInZone returns the first subnet with the given prefix length inside the supernet that does not
+// overlap the networks in the zone or the networks connected by network peerings.
+func NextFreeSubnetInZone(ctx context.Context, c NetworkValidator, zone string, supernet netip.Prefix, bits int) (netip.Prefix, error) {
+	used, err := UsedPrefixes(ctx, c, zone)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	prefixes := make([]netip.Prefix, len(used))
+	for i, u := range used {
+		prefixes[i] = u.Prefix
+	}
+	return NextFreeSubnet(supernet, bits, prefixes)
+}
+
+// FreeAddresses returns the given number of the lowest usable addresses of the prefix that are not used. The
+// network address and, in IPv4 networks, the broadcast address are never returned.
+func FreeAddresses(prefix netip.Prefix, used []netip.Addr, count int) ([]netip.Addr, error) {
+	prefix = prefix.Masked()
+	inUse := make(map[netip.Addr]bool, len(used))
+	for _, addr := range used {
+		inUse[addr] = true
+	}
+
+	addrs := make([]netip.Addr, 0, count)
+	for addr := prefix.Addr(); len(addrs) < count && addr.IsValid() && prefix.Contains(addr); addr = addr.Next() {
+		if usableAddress(prefix, addr) && !inUse[addr] {
+			addrs = append(addrs, addr)
+		}
+	}
+	if len(addrs) < count {
+		return nil, fmt.Errorf("%w in %s, requested %d, found %d", ErrNotEnoughFreeAddresses, prefix, count, len(addrs))
+	}
+	return addrs, nil
+}
+
+// PickStaticIPAddresses returns addresses for a CreateNetworkInterfaceRequest that are not used by the gateway,
+// the DHCP DNS servers or the network interfaces of the servers attached to the network. The addresses are picked
+// from the IP network of the given family.
+//
+// The result is a snapshot: an address may be taken by DHCP or another client before the interface is created.
+func PickStaticIPAddresses(ctx context.Context, c NetworkAddressLister, networkUUID, family string, count int) (request.CreateNetworkInterfaceIPAddressSlice, error) {
+	network, err := c.GetNetworkDetails(ctx, &request.GetNetworkDetailsRequest{UUID: networkUUID})
+	if err != nil {
+		return nil, err
+	}
+
+	var prefix netip.Prefix
+	var used []netip.Addr
+	for _, ipNetwork := range network.IPNetworks {
+		p, err := netip.ParsePrefix(ipNetwork.Address)
+		if err != nil || prefixFamily(p) != family {
+			continue
+		}
+		prefix = p.Masked()
+		for _, s := range append([]string{ipNetwork.Gateway}, ipNetwork.DHCPDns...) {
+			if addr, err := netip.ParseAddr(s); err == nil {
+				used = append(used, addr)
+			}
+		}
+		break
+	}
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("network %s has no %s IP network", networkUUID, family)
+	}
+
+	for _, server := range network.Servers {
+		networking, err := c.GetServerNetworks(ctx, &request.GetServerNetworksRequest{ServerUUID: server.ServerUUID})
+		if err != nil {
+			return nil, err
+		}
+		for _, iface := range networking.Interfaces {
+			if iface.Network != networkUUID {
+				continue
+			}
+			for _, ip := range iface.IPAddresses {
+				if addr, err := netip.ParseAddr(ip.Address); err == nil {
+					used = append(used, addr)
+				}
+			}
+		}
+	}
+
+	addrs, err := FreeAddresses(prefix, used, count)
+	if err != nil {
+		return nil, err
+	}
+	ipAddresses := make(request.CreateNetworkInterfaceIPAddressSlice, len(addrs))
+	for i, addr := range addrs {
+		ipAddresses[i] = request.CreateNetworkInterfaceIPAddress{Family: family, Address: addr.String()}
+	}
+	return ipAddresses, nil
+}
+
+// lastAddr returns the last address of the prefix
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	prefix = prefix.Masked()
+	if prefix.Addr().Is4() {
+		b := prefix.Addr().As4()
+		for i := prefix.Bits(); i < 32; i++ {
+			b[i/8] |= 1 << (7 - i%8)
+		}
+		return netip.AddrFrom4(b)
+	}
+	b := prefix.Addr().As16()
+	for i := prefix.Bits(); i < 128; i++ {
+		b[i/8] |= 1 << (7 - i%8)
+	}
+	return netip.AddrFrom16(b)
+}
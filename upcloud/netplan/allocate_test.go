@@ -0,0 +1,91 @@
+package netplan
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNextFreeSubnet(t *testing.T) {
+	t.Parallel()
+
+	supernet := netip.MustParsePrefix("10.0.0.0/16")
+	used := []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/24"),
+		netip.MustParsePrefix("10.0.1.128/25"),
+		netip.MustParsePrefix("10.0.2.0/23"),
+		netip.MustParsePrefix("192.168.0.0/24"),
+		netip.MustParsePrefix("fd00::/64"),
+	}
+
+	subnet, err := NextFreeSubnet(supernet, 24, used)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.4.0/24"), subnet)
+
+	subnet, err = NextFreeSubnet(supernet, 25, used)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.1.0/25"), subnet)
+
+	subnet, err = NextFreeSubnet(netip.MustParsePrefix("fd00::/48"), 64, used)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("fd00:0:0:1::/64"), subnet)
+
+	_, err = NextFreeSubnet(netip.MustParsePrefix("10.0.0.0/22"), 24, used)
+	assert.ErrorIs(t, err, ErrNoFreeSubnet)
+
+	_, err = NextFreeSubnet(netip.MustParsePrefix("10.0.0.0/8"), 24, []netip.Prefix{netip.MustParsePrefix("0.0.0.0/0")})
+	assert.ErrorIs(t, err, ErrNoFreeSubnet)
+
+	_, err = NextFreeSubnet(supernet, 8, used)
+	assert.Error(t, err)
+	_, err = NextFreeSubnet(netip.MustParsePrefix("10.0.0.1/16"), 24, used)
+	assert.Error(t, err)
+}
+
+func TestNextFreeSubnetInZone(t *testing.T) {
+	t.Parallel()
+
+	subnet, err := NextFreeSubnetInZone(context.Background(), fakeservice.New(testResources), "fi-hel1", netip.MustParsePrefix("10.0.0.0/16"), 24)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.1.0/24"), subnet)
+
+	subnet, err = NextFreeSubnetInZone(context.Background(), fakeservice.New(testResources), "fi-hel1", netip.MustParsePrefix("10.0.0.0/16"), 22)
+	require.NoError(t, err)
+	assert.Equal(t, netip.MustParsePrefix("10.0.8.0/22"), subnet)
+}
+
+func TestFreeAddresses(t *testing.T) {
+	t.Parallel()
+
+	prefix := netip.MustParsePrefix("192.168.0.0/30")
+	addrs, err := FreeAddresses(prefix, []netip.Addr{netip.MustParseAddr("192.168.0.1")}, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("192.168.0.2")}, addrs)
+
+	_, err = FreeAddresses(prefix, []netip.Addr{netip.MustParseAddr("192.168.0.1")}, 2)
+	assert.ErrorIs(t, err, ErrNotEnoughFreeAddresses)
+
+	addrs, err = FreeAddresses(netip.MustParsePrefix("fd00::/127"), nil, 1)
+	require.NoError(t, err)
+	assert.Equal(t, []netip.Addr{netip.MustParseAddr("fd00::1")}, addrs)
+}
+
+func TestPickStaticIPAddresses(t *testing.T) {
+	t.Parallel()
+
+	ipAddresses, err := PickStaticIPAddresses(context.Background(), fakeservice.New(testResources), "net-1", upcloud.IPAddressFamilyIPv4, 2)
+	require.NoError(t, err)
+	assert.Equal(t, request.CreateNetworkInterfaceIPAddressSlice{
+		{Family: upcloud.IPAddressFamilyIPv4, Address: "10.0.0.5"},
+		{Family: upcloud.IPAddressFamilyIPv4, Address: "10.0.0.6"},
+	}, ipAddresses)
+
+	_, err = PickStaticIPAddresses(context.Background(), fakeservice.New(testResources), "net-1", upcloud.IPAddressFamilyIPv6, 1)
+	assert.EqualError(t, err, "network net-1 has no IPv6 IP network")
+}
@@ -0,0 +1,253 @@
+// Package netplan contains IP address management helpers for private networks built on net/netip.
+//
+// The offline helpers validate IP networks, find free subnets and addresses, and report overlapping prefixes. The
+// helpers that take a client only need the narrow interfaces defined in this package, which *service.Service
+// satisfies.
+package netplan
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// ZoneNetworkLister lists the networks of a zone
+type ZoneNetworkLister interface {
+	GetNetworksInZone(ctx context.Context, r *request.GetNetworksInZoneRequest) (*upcloud.Networks, error)
+}
+
+// NetworkPeeringLister lists network peerings
+type NetworkPeeringLister interface {
+	GetNetworkPeerings(ctx context.Context, f ...request.QueryFilter) (upcloud.NetworkPeerings, error)
+}
+
+// NetworkValidator is the client needed to validate a network against the existing networks
+type NetworkValidator interface {
+	ZoneNetworkLister
+	NetworkPeeringLister
+}
+
+// NetworkAddressLister is the client needed to find the addresses in use in a network
+type NetworkAddressLister interface {
+	GetNetworkDetails(ctx context.Context, r *request.GetNetworkDetailsRequest) (*upcloud.Network, error)
+	GetServerNetworks(ctx context.Context, r *request.GetServerNetworksRequest) (*upcloud.Networking, error)
+}
+
+// ErrNoFreeSubnet is returned when a supernet has no unused subnet of the requested size
+var ErrNoFreeSubnet = errors.New("no free subnet")
+
+// ErrNotEnoughFreeAddresses is returned when a network does not have the requested number of unused addresses
+var ErrNotEnoughFreeAddresses = errors.New("not enough free addresses")
+
+// UsedPrefix represents a prefix that is in use by an existing network
+type UsedPrefix struct {
+	Prefix      netip.Prefix
+	NetworkUUID string
+	NetworkName string
+	// PeeringUUID is set when the prefix is reachable through a network peering
+	PeeringUUID string
+}
+
+// String returns the prefix with the network using it
+func (u UsedPrefix) String() string {
+	s := u.Prefix.String()
+	switch {
+	case u.NetworkName != "" && u.NetworkUUID != "":
+		s += fmt.Sprintf(" of network %s (%s)", u.NetworkName, u.NetworkUUID)
+	case u.NetworkName != "":
+		s += fmt.Sprintf(" of network %s", u.NetworkName)
+	case u.NetworkUUID != "":
+		s += fmt.Sprintf(" of network %s", u.NetworkUUID)
+	}
+	if u.PeeringUUID != "" {
+		s += fmt.Sprintf(" in network peering %s", u.PeeringUUID)
+	}
+	return s
+}
+
+// OverlapError is returned when a prefix overlaps a prefix that is already in use
+type OverlapError struct {
+	Prefix netip.Prefix
+	Used   UsedPrefix
+}
+
+// Error implements error
+func (e *OverlapError) Error() string {
+	return fmt.Sprintf("%s overlaps %s", e.Prefix, e.Used)
+}
+
+// ParsePrefix parses the address of an IP network. The address must be in CIDR notation and must not have host
+// bits set.
+func ParsePrefix(n upcloud.IPNetwork) (netip.Prefix, error) {
+	prefix, err := netip.ParsePrefix(n.Address)
+	if err != nil {
+		return netip.Prefix{}, fmt.Errorf("invalid IP network address %q: %w", n.Address, err)
+	}
+	if prefix != prefix.Masked() {
+		return netip.Prefix{}, fmt.Errorf("invalid IP network address %q: host bits are set, use %s", n.Address, prefix.Masked())
+	}
+	return prefix, nil
+}
+
+// ValidateIPNetwork validates a single IP network. It checks that the address is a valid prefix of the given
+// family, that the gateway is a usable address inside the prefix, and that the DHCP routes and DNS servers are
+// valid.
+func ValidateIPNetwork(n upcloud.IPNetwork) error {
+	prefix, err := ParsePrefix(n)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if family := prefixFamily(prefix); n.Family != "" && n.Family != family {
+		errs = append(errs, fmt.Errorf("IP network %s is an %s network but family is %s", prefix, family, n.Family))
+	}
+	if n.Gateway != "" {
+		gateway, err := netip.ParseAddr(n.Gateway)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("invalid gateway %q: %w", n.Gateway, err))
+		case !prefix.Contains(gateway):
+			errs = append(errs, fmt.Errorf("gateway %s is not in IP network %s", gateway, prefix))
+		case !usableAddress(prefix, gateway):
+			errs = append(errs, fmt.Errorf("gateway %s is a reserved address of IP network %s", gateway, prefix))
+		}
+	}
+	for _, route := range n.DHCPRoutes {
+		if _, err := netip.ParsePrefix(route); err != nil {
+			errs = append(errs, fmt.Errorf("invalid DHCP route %q: %w", route, err))
+		}
+	}
+	for _, dns := range n.DHCPDns {
+		if _, err := netip.ParseAddr(dns); err != nil {
+			errs = append(errs, fmt.Errorf("invalid DHCP DNS server %q: %w", dns, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// CheckOverlap returns an *OverlapError for each used prefix that overlaps the prefix
+func CheckOverlap(prefix netip.Prefix, used []UsedPrefix) error {
+	return errors.Join(overlapErrors(prefix, used)...)
+}
+
+func overlapErrors(prefix netip.Prefix, used []UsedPrefix) []error {
+	var errs []error
+	for _, u := range used {
+		if prefix.Overlaps(u.Prefix) {
+			errs = append(errs, &OverlapError{Prefix: prefix, Used: u})
+		}
+	}
+	return errs
+}
+
+// NetworkPrefixes returns the prefixes of the IP networks of the networks. Addresses that cannot be parsed are
+// skipped.
+func NetworkPrefixes(networks []upcloud.Network) []UsedPrefix {
+	var used []UsedPrefix
+	for _, network := range networks {
+		for _, ipNetwork := range network.IPNetworks {
+			prefix, err := netip.ParsePrefix(ipNetwork.Address)
+			if err != nil {
+				continue
+			}
+			used = append(used, UsedPrefix{Prefix: prefix.Masked(), NetworkUUID: network.UUID, NetworkName: network.Name})
+		}
+	}
+	return used
+}
+
+// PeeringPrefixes returns the prefixes of both sides of the network peerings. Addresses that cannot be parsed are
+// skipped.
+func PeeringPrefixes(peerings upcloud.NetworkPeerings) []UsedPrefix {
+	var used []UsedPrefix
+	for _, peering := range peerings {
+		for _, network := range []upcloud.NetworkPeeringNetwork{peering.Network, peering.PeerNetwork} {
+			for _, ipNetwork := range network.IPNetworks {
+				prefix, err := netip.ParsePrefix(ipNetwork.Address)
+				if err != nil {
+					continue
+				}
+				used = append(used, UsedPrefix{Prefix: prefix.Masked(), NetworkUUID: network.UUID, PeeringUUID: peering.UUID})
+			}
+		}
+	}
+	return used
+}
+
+// UsedPrefixes returns the prefixes of the networks in the zone and of the network peerings of those networks.
+// The peered networks are included because they may be in another zone or account and still be reachable from
+// the networks in the zone. Peerings between networks of other zones are left out.
+func UsedPrefixes(ctx context.Context, c NetworkValidator, zone string) ([]UsedPrefix, error) {
+	networks, err := c.GetNetworksInZone(ctx, &request.GetNetworksInZoneRequest{Zone: zone})
+	if err != nil {
+		return nil, err
+	}
+	peerings, err := c.GetNetworkPeerings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	inZone := make(map[string]bool, len(networks.Networks))
+	for _, network := range networks.Networks {
+		inZone[network.UUID] = true
+	}
+	var zonePeerings upcloud.NetworkPeerings
+	for _, peering := range peerings {
+		if inZone[peering.Network.UUID] || inZone[peering.PeerNetwork.UUID] {
+			zonePeerings = append(zonePeerings, peering)
+		}
+	}
+	return append(NetworkPrefixes(networks.Networks), PeeringPrefixes(zonePeerings)...), nil
+}
+
+// ValidateCreateNetworkRequest validates the IP networks of the request. In addition to the checks done by
+// ValidateIPNetwork, the IP networks must not overlap each other, the other networks in the same zone, or the
+// networks connected by network peerings.
+func ValidateCreateNetworkRequest(ctx context.Context, c NetworkValidator, r *request.CreateNetworkRequest) error {
+	var errs []error
+	var prefixes []UsedPrefix
+	for _, n := range r.IPNetworks {
+		if err := ValidateIPNetwork(n); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		prefix, _ := ParsePrefix(n)
+		errs = append(errs, overlapErrors(prefix, prefixes)...)
+		prefixes = append(prefixes, UsedPrefix{Prefix: prefix, NetworkName: r.Name})
+	}
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+
+	used, err := UsedPrefixes(ctx, c, r.Zone)
+	if err != nil {
+		return err
+	}
+	for _, p := range prefixes {
+		errs = append(errs, overlapErrors(p.Prefix, used)...)
+	}
+	return errors.Join(errs...)
+}
+
+func prefixFamily(prefix netip.Prefix) string {
+	if prefix.Addr().Is4() {
+		return upcloud.IPAddressFamilyIPv4
+	}
+	return upcloud.IPAddressFamilyIPv6
+}
+
+// usableAddress tells whether the address can be assigned to a host, i.e. it is not the network address or, in an
+// IPv4 network, the broadcast address.
+func usableAddress(prefix netip.Prefix, addr netip.Addr) bool {
+	if addr == prefix.Addr() {
+		return false
+	}
+	if addr.Is4() && prefix.Bits() < 31 && addr == lastAddr(prefix) {
+		return false
+	}
+	return true
+}
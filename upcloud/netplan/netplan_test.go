@@ -0,0 +1,137 @@
+package netplan
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	_ NetworkValidator     = (*service.Service)(nil)
+	_ NetworkAddressLister = (*service.Service)(nil)
+)
+
+var testResources = fakeservice.Resources{
+	Networks: []upcloud.Network{
+		{
+			UUID: "net-1",
+			Name: "backend",
+			Zone: "fi-hel1",
+			IPNetworks: upcloud.IPNetworkSlice{
+				{Address: "10.0.0.0/24", Family: upcloud.IPAddressFamilyIPv4, Gateway: "10.0.0.1", DHCPDns: []string{"10.0.0.2"}},
+			},
+			Servers: upcloud.NetworkServerSlice{{ServerUUID: "server-1"}, {ServerUUID: "server-2"}},
+		},
+		{
+			UUID:       "net-2",
+			Name:       "frontend",
+			Zone:       "fi-hel1",
+			IPNetworks: upcloud.IPNetworkSlice{{Address: "10.0.2.0/23", Family: upcloud.IPAddressFamilyIPv4}},
+		},
+	},
+	NetworkPeerings: upcloud.NetworkPeerings{
+		{
+			UUID: "peering-1",
+			Network: upcloud.NetworkPeeringNetwork{
+				UUID:       "net-1",
+				IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.0.0.0/24", Family: upcloud.NetworkPeeringIPNetworkFamilyIPv4}},
+			},
+			PeerNetwork: upcloud.NetworkPeeringNetwork{
+				UUID:       "remote",
+				IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.0.4.0/24", Family: upcloud.NetworkPeeringIPNetworkFamilyIPv4}},
+			},
+		},
+		{
+			UUID: "peering-2",
+			Network: upcloud.NetworkPeeringNetwork{
+				UUID:       "net-ams",
+				IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.0.6.0/24", Family: upcloud.NetworkPeeringIPNetworkFamilyIPv4}},
+			},
+			PeerNetwork: upcloud.NetworkPeeringNetwork{
+				UUID:       "remote-ams",
+				IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.0.7.0/24", Family: upcloud.NetworkPeeringIPNetworkFamilyIPv4}},
+			},
+		},
+	},
+	Servers: []upcloud.ServerDetails{
+		{
+			Server: upcloud.Server{UUID: "server-1"},
+			Networking: upcloud.ServerNetworking{Interfaces: upcloud.ServerInterfaceSlice{
+				{Network: "net-1", IPAddresses: upcloud.IPAddressSlice{{Address: "10.0.0.3"}}},
+				{Network: "public", IPAddresses: upcloud.IPAddressSlice{{Address: "10.0.0.5"}}},
+			}},
+		},
+		{
+			Server: upcloud.Server{UUID: "server-2"},
+			Networking: upcloud.ServerNetworking{Interfaces: upcloud.ServerInterfaceSlice{
+				{Network: "net-1", IPAddresses: upcloud.IPAddressSlice{{Address: "10.0.0.4"}}},
+			}},
+		},
+	},
+}
+
+func TestValidateIPNetwork(t *testing.T) {
+	t.Parallel()
+
+	assert.NoError(t, ValidateIPNetwork(upcloud.IPNetwork{Address: "10.0.0.0/24", Family: upcloud.IPAddressFamilyIPv4, Gateway: "10.0.0.1"}))
+	assert.NoError(t, ValidateIPNetwork(upcloud.IPNetwork{Address: "fd00::/64", Family: upcloud.IPAddressFamilyIPv6, Gateway: "fd00::1"}))
+
+	for address, n := range map[string]upcloud.IPNetwork{
+		"invalid address":         {Address: "10.0.0.0"},
+		"host bits set":           {Address: "10.0.0.1/24"},
+		"family mismatch":         {Address: "10.0.0.0/24", Family: upcloud.IPAddressFamilyIPv6},
+		"gateway outside prefix":  {Address: "10.0.0.0/24", Gateway: "10.0.1.1"},
+		"gateway network address": {Address: "10.0.0.0/24", Gateway: "10.0.0.0"},
+		"gateway broadcast":       {Address: "10.0.0.0/24", Gateway: "10.0.0.255"},
+		"invalid DHCP route":      {Address: "10.0.0.0/24", DHCPRoutes: []string{"192.168.0.0"}},
+		"invalid DHCP DNS":        {Address: "10.0.0.0/24", DHCPDns: []string{"dns.example.com"}},
+	} {
+		assert.Error(t, ValidateIPNetwork(n), address)
+	}
+}
+
+func TestValidateCreateNetworkRequest(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	r := &request.CreateNetworkRequest{
+		Name: "new",
+		Zone: "fi-hel1",
+		IPNetworks: upcloud.IPNetworkSlice{
+			{Address: "10.0.8.0/24", Family: upcloud.IPAddressFamilyIPv4, Gateway: "10.0.8.1"},
+		},
+	}
+	require.NoError(t, ValidateCreateNetworkRequest(context.Background(), c, r))
+	assert.Equal(t, []string{"GetNetworksInZone fi-hel1"}, c.Calls("GetNetworksInZone"))
+
+	r.IPNetworks[0] = upcloud.IPNetwork{Address: "10.0.0.0/16", Family: upcloud.IPAddressFamilyIPv4}
+	err := ValidateCreateNetworkRequest(context.Background(), c, r)
+	var overlaps []string
+	for _, e := range err.(interface{ Unwrap() []error }).Unwrap() {
+		var overlapErr *OverlapError
+		require.ErrorAs(t, e, &overlapErr)
+		overlaps = append(overlaps, overlapErr.Used.String())
+	}
+	assert.Equal(t, []string{
+		"10.0.0.0/24 of network backend (net-1)",
+		"10.0.2.0/23 of network frontend (net-2)",
+		"10.0.0.0/24 of network net-1 in network peering peering-1",
+		"10.0.4.0/24 of network remote in network peering peering-1",
+	}, overlaps)
+
+	r.IPNetworks = upcloud.IPNetworkSlice{
+		{Address: "10.1.0.0/24", Family: upcloud.IPAddressFamilyIPv4},
+		{Address: "10.1.0.128/25", Family: upcloud.IPAddressFamilyIPv4},
+	}
+	var overlapErr *OverlapError
+	require.ErrorAs(t, ValidateCreateNetworkRequest(context.Background(), c, r), &overlapErr)
+	assert.Equal(t, netip.MustParsePrefix("10.1.0.128/25"), overlapErr.Prefix)
+	assert.Equal(t, "10.1.0.128/25 overlaps 10.1.0.0/24 of network new", overlapErr.Error())
+}
@@ -90,3 +90,22 @@ type CreateFirewallRulesRequest struct {
 func (r *CreateFirewallRulesRequest) RequestURL() string {
 	return fmt.Sprintf("/server/%s/firewall_rule", r.ServerUUID)
 }
+
+// ReconcileFirewallRequest represents a request to make the firewall rules of a server match the desired rule set
+type ReconcileFirewallRequest struct {
+	ServerUUID    string
+	FirewallRules []upcloud.FirewallRule
+	// DryRun computes the change plan without modifying the rules of the server
+	DryRun bool
+}
+
+// ApplyFirewallPolicyRequest represents a request to apply a firewall policy to all servers matching the filters
+type ApplyFirewallPolicyRequest struct {
+	Policy upcloud.FirewallPolicy
+	// Filters select the servers the policy is applied to, e.g. FilterLabel values
+	Filters []QueryFilter
+	// Overrides contains server specific changes to the policy keyed by server UUID
+	Overrides map[string]upcloud.FirewallPolicyOverride
+	// DryRun only reports the servers whose rules differ from the policy without modifying them
+	DryRun bool
+}
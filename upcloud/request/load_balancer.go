@@ -104,6 +104,37 @@ type WaitForLoadBalancerOperationalStateRequest struct {
 	DesiredState upcloud.LoadBalancerOperationalState
 }
 
+// ApplyLoadBalancerRequest represents a request to make a load balancer match the desired configuration
+type ApplyLoadBalancerRequest struct {
+	// LoadBalancer is the desired configuration. The load balancer is looked up by UUID, or by name if the UUID is
+	// empty, and created if it does not exist. The members of its backends must be empty; they are set in
+	// BackendMembers.
+	LoadBalancer upcloud.LoadBalancer
+	// BackendMembers are the desired members of the backends by backend name. A backend without members in the map
+	// has its members deleted.
+	BackendMembers map[string][]ApplyLoadBalancerBackendMember
+	// DryRun computes the change plan without modifying the load balancer
+	DryRun bool
+}
+
+// ApplyLoadBalancerBackendMember represents a desired backend member of ApplyLoadBalancerRequest. A weight of zero
+// and a disabled member are valid configurations, so Weight and Enabled are only compared with the existing member
+// when set. New members default to a weight of 100 and to being enabled.
+type ApplyLoadBalancerBackendMember struct {
+	Name        string
+	Type        upcloud.LoadBalancerBackendMemberType
+	IP          string
+	Port        int
+	Weight      *int
+	MaxSessions int
+	Enabled     *bool
+}
+
+// GetLoadBalancerHealthRequest represents a request to get the health report of a load balancer
+type GetLoadBalancerHealthRequest struct {
+	UUID string
+}
+
 // WaitForLoadBalancerDeletionRequest represents a request to wait for a load balancer instance to be deleted
 type WaitForLoadBalancerDeletionRequest struct {
 	UUID string `json:"-"`
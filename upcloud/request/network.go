@@ -430,3 +430,10 @@ type DeleteRouterRequest struct {
 func (r *DeleteRouterRequest) RequestURL() string {
 	return fmt.Sprintf("/router/%s", r.UUID)
 }
+
+// EnsureRouterStaticRoutesRequest represents a request to make the user managed static routes of a router match the
+// desired routes. Service managed routes of the router are kept as they are.
+type EnsureRouterStaticRoutesRequest struct {
+	RouterUUID   string
+	StaticRoutes []upcloud.StaticRoute
+}
@@ -278,6 +278,10 @@ func (r StartServerRequest) MarshalJSON() ([]byte, error) {
 	return json.Marshal(&v)
 }
 
+// DefaultSoftStopTimeout is the default time given to a soft stop to complete before the API stops the server
+// forcibly, used when the SoftStopTimeout of a request is not set.
+const DefaultSoftStopTimeout = 5 * time.Minute
+
 // StopServerRequest represents a request to stop a server
 type StopServerRequest struct {
 	UUID string `json:"-"`
@@ -454,3 +458,61 @@ type RelocateServerRequest struct {
 func (r *RelocateServerRequest) RequestURL() string {
 	return fmt.Sprintf("/server/%s/relocate", r.UUID)
 }
+
+// CloneServerRequest represents a request to clone a server including its storages and networking.
+// Fields left empty are copied from the source server.
+type CloneServerRequest struct {
+	UUID string
+	// TargetZone is the zone the clone is created in. Cloning across zones is done by templatizing the
+	// source storages and creating the new server from the templates.
+	TargetZone string
+	Title      string
+	Hostname   string
+	Labels     *upcloud.LabelSlice
+	// Networking replaces the networking copied from the source server. It is required when the source
+	// server has private network interfaces and the clone is created in another zone.
+	Networking *CreateServerNetworking
+	// StorageTier overrides the tier of the cloned storages.
+	StorageTier string
+	// KeepTemplates disables the removal of the intermediate templates created when cloning across zones.
+	KeepTemplates bool
+	// StopSource stops a started source server while its storages are copied and starts it again afterwards.
+	// The storages of a running server are copied while they are in use, so without it the file systems of the
+	// clone are in the state they would be in after a crash of the source server.
+	StopSource bool
+	// StopType is the type of the stop used with StopSource. Defaults to soft stop.
+	StopType string
+	// SoftStopTimeout is the time given to a soft stop of the source server to complete before the API stops it
+	// forcibly. Defaults to DefaultSoftStopTimeout.
+	SoftStopTimeout time.Duration
+}
+
+// EnsureServerStateRequest represents a request to move a server to the desired state
+type EnsureServerStateRequest struct {
+	UUID string
+	// DesiredState is either upcloud.ServerStateStarted or upcloud.ServerStateStopped
+	DesiredState string
+	// StopType is the type of the stop used when the server needs to be stopped. Defaults to soft stop.
+	StopType string
+	// SoftStopTimeout is the time given to a soft stop to complete before the API stops the server forcibly.
+	// Defaults to DefaultSoftStopTimeout.
+	SoftStopTimeout time.Duration
+}
+
+// ResizeServerRequest represents a request to change the plan or the custom configuration of a server
+type ResizeServerRequest struct {
+	UUID string
+	// Plan is the name of the target plan. Leave it empty, or set it to "custom", and use CoreNumber and MemoryAmount
+	// instead to resize to a custom configuration.
+	Plan         string
+	CoreNumber   int
+	MemoryAmount int
+	// StorageSize is the new size of the OS disk in gigabytes. The disk and its filesystem are grown only when the
+	// size is larger than the current size of the disk.
+	StorageSize int
+	// StopType and SoftStopTimeout control how the server is stopped, see EnsureServerStateRequest.
+	StopType        string
+	SoftStopTimeout time.Duration
+	// DryRun validates the request and calculates the price difference without modifying the server.
+	DryRun bool
+}
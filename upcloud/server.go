@@ -243,3 +243,12 @@ func (s *ServerDetails) setHostID(hostID int64) {
 		s.Host = int(hostID)
 	}
 }
+
+// ServerResize represents the outcome of a server resize
+type ServerResize struct {
+	Server   *ServerDetails
+	FromPlan string
+	ToPlan   string
+	// PriceDifference is the change in the hourly price of the server in the server's zone, in the same unit as Price.Price.
+	PriceDifference float64
+}
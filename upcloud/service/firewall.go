@@ -2,6 +2,8 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
@@ -13,6 +15,25 @@ type Firewall interface {
 	CreateFirewallRule(ctx context.Context, r *request.CreateFirewallRuleRequest) (*upcloud.FirewallRule, error)
 	CreateFirewallRules(ctx context.Context, r *request.CreateFirewallRulesRequest) error
 	DeleteFirewallRule(ctx context.Context, r *request.DeleteFirewallRuleRequest) error
+	ReconcileFirewall(ctx context.Context, r *request.ReconcileFirewallRequest) (*upcloud.FirewallRulesChangePlan, error)
+	ApplyFirewallPolicy(ctx context.Context, r *request.ApplyFirewallPolicyRequest) (*upcloud.FirewallPolicyResult, error)
+}
+
+// FirewallPolicyError describes a server a firewall policy could not be applied to.
+type FirewallPolicyError struct {
+	PolicyName string
+	ServerUUID string
+	Err        error
+}
+
+// Error implements the error interface
+func (e *FirewallPolicyError) Error() string {
+	return fmt.Sprintf("applying firewall policy %q to server %s failed: %s", e.PolicyName, e.ServerUUID, e.Err)
+}
+
+// Unwrap returns the error of the failed request
+func (e *FirewallPolicyError) Unwrap() error {
+	return e.Err
 }
 
 // GetFirewallRules returns the firewall rules for the specified server
@@ -42,3 +63,138 @@ func (s *Service) CreateFirewallRules(ctx context.Context, r *request.CreateFire
 func (s *Service) DeleteFirewallRule(ctx context.Context, r *request.DeleteFirewallRuleRequest) error {
 	return s.delete(ctx, r)
 }
+
+// ReconcileFirewall compares the desired firewall rules to the current rules of the server and, if they differ,
+// replaces the whole rule set with the desired rules in a single request. Rules are compared in their normalized
+// form, so differences in formatting, e.g. an omitted port range end, do not cause changes. The returned plan
+// describes which rules are kept, added and removed.
+func (s *Service) ReconcileFirewall(ctx context.Context, r *request.ReconcileFirewallRequest) (*upcloud.FirewallRulesChangePlan, error) {
+	current, err := s.GetFirewallRules(ctx, &request.GetFirewallRulesRequest{ServerUUID: r.ServerUUID})
+	if err != nil {
+		return nil, err
+	}
+
+	desired := make([]upcloud.FirewallRule, len(r.FirewallRules))
+	for i, rule := range r.FirewallRules {
+		desired[i] = rule.Normalize()
+	}
+
+	plan := &upcloud.FirewallRulesChangePlan{
+		ServerUUID: r.ServerUUID,
+		Changes:    diffFirewallRules(current.FirewallRules, desired),
+	}
+	if r.DryRun || !plan.HasChanges() {
+		return plan, nil
+	}
+
+	if err := s.CreateFirewallRules(ctx, &request.CreateFirewallRulesRequest{
+		ServerUUID:    r.ServerUUID,
+		FirewallRules: desired,
+	}); err != nil {
+		return plan, err
+	}
+	plan.Applied = true
+
+	return plan, nil
+}
+
+// ApplyFirewallPolicy reconciles the firewall rules of every server matching the filters with the rules of the
+// policy and the server specific override, if any. Servers are processed one at a time and a failure on one server
+// does not stop the others; the failures are returned joined together as FirewallPolicyError values. The rules of
+// servers whose firewall is off are reconciled as well, but they are not enforced; these servers are flagged in the
+// result. With DryRun set, the servers whose rules have drifted from the policy can be found with the Drifted method
+// of the result.
+func (s *Service) ApplyFirewallPolicy(ctx context.Context, r *request.ApplyFirewallPolicyRequest) (*upcloud.FirewallPolicyResult, error) {
+	servers, err := s.GetServersWithFilters(ctx, &request.GetServersWithFiltersRequest{Filters: r.Filters})
+	if err != nil {
+		return nil, err
+	}
+
+	result := &upcloud.FirewallPolicyResult{PolicyName: r.Policy.Name}
+	var errs []error
+	for _, server := range servers.Servers {
+		var override *upcloud.FirewallPolicyOverride
+		if o, ok := r.Overrides[server.UUID]; ok {
+			if o.Exclude {
+				result.Excluded = append(result.Excluded, server.UUID)
+				continue
+			}
+			override = &o
+		}
+
+		serverResult := upcloud.FirewallPolicyServerResult{
+			ServerUUID: server.UUID,
+			Hostname:   server.Hostname,
+		}
+		details, err := s.GetServerDetails(ctx, &request.GetServerDetailsRequest{UUID: server.UUID})
+		if err == nil {
+			serverResult.FirewallDisabled = details.Firewall != "on"
+			serverResult.Plan, err = s.ReconcileFirewall(ctx, &request.ReconcileFirewallRequest{
+				ServerUUID:    server.UUID,
+				FirewallRules: r.Policy.RulesFor(override),
+				DryRun:        r.DryRun,
+			})
+		}
+		if err != nil {
+			errs = append(errs, &FirewallPolicyError{PolicyName: r.Policy.Name, ServerUUID: server.UUID, Err: err})
+		}
+		result.Servers = append(result.Servers, serverResult)
+	}
+
+	return result, errors.Join(errs...)
+}
+
+// diffFirewallRules returns the changes between two rule sets. Rule order is significant, so the rules common to both
+// sets are found with the longest common subsequence of the normalized rules.
+func diffFirewallRules(current, desired []upcloud.FirewallRule) []upcloud.FirewallRuleChange {
+	normalized := make([]upcloud.FirewallRule, len(current))
+	for i, rule := range current {
+		normalized[i] = rule.Normalize()
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of normalized[i:] and desired[j:]
+	lcs := make([][]int, len(normalized)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(desired)+1)
+	}
+	for i := len(normalized) - 1; i >= 0; i-- {
+		for j := len(desired) - 1; j >= 0; j-- {
+			if normalized[i] == desired[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	var changes []upcloud.FirewallRuleChange
+	i, j := 0, 0
+	for i < len(normalized) || j < len(desired) {
+		switch {
+		case i < len(normalized) && j < len(desired) && normalized[i] == desired[j]:
+			changes = append(changes, upcloud.FirewallRuleChange{
+				Action:          upcloud.FirewallRuleChangeActionKeep,
+				Rule:            desired[j],
+				CurrentPosition: i + 1,
+				DesiredPosition: j + 1,
+			})
+			i++
+			j++
+		case i < len(normalized) && (j == len(desired) || lcs[i+1][j] >= lcs[i][j+1]):
+			changes = append(changes, upcloud.FirewallRuleChange{
+				Action:          upcloud.FirewallRuleChangeActionRemove,
+				Rule:            normalized[i],
+				CurrentPosition: i + 1,
+			})
+			i++
+		default:
+			changes = append(changes, upcloud.FirewallRuleChange{
+				Action:          upcloud.FirewallRuleChangeActionAdd,
+				Rule:            desired[j],
+				DesiredPosition: j + 1,
+			})
+			j++
+		}
+	}
+	return changes
+}
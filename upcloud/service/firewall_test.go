@@ -3,9 +3,13 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
+	"sync"
 	"testing"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/client"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
 	"github.com/dnaeon/go-vcr/recorder"
 	"github.com/stretchr/testify/assert"
@@ -117,3 +121,194 @@ func TestFirewallRules(t *testing.T) {
 		assert.Len(t, firewallRulesPostDelete.FirewallRules, 2)
 	})
 }
+
+// TestReconcileFirewall ensures that ReconcileFirewall() computes the changes between the current and the
+// desired rules, and replaces the rule set only when there are changes.
+func TestReconcileFirewall(t *testing.T) {
+	t.Parallel()
+
+	var replaced []string
+	srv, svc := setupTestServerAndService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, fmt.Sprintf("/%s/server/uuid/firewall_rule", client.APIVersion), r.URL.Path)
+		switch r.Method {
+		case http.MethodGet:
+			_, _ = fmt.Fprint(w, `{"firewall_rules": {"firewall_rule": [
+				{"action": "accept", "direction": "in", "family": "IPv4", "position": "1", "protocol": "tcp", "destination_port_start": "22", "destination_port_end": "22"},
+				{"action": "accept", "direction": "in", "family": "IPv4", "position": "2", "protocol": "tcp", "destination_port_start": "80", "destination_port_end": "80"},
+				{"action": "drop", "direction": "in", "family": "IPv4", "position": "3"}
+			]}}`)
+		case http.MethodPut:
+			body, _ := io.ReadAll(r.Body)
+			replaced = append(replaced, string(body))
+		default:
+			t.Errorf("unexpected method %s", r.Method)
+		}
+	}))
+	defer srv.Close()
+
+	desired := []upcloud.FirewallRule{
+		{Action: "accept", Direction: "in", Protocol: "tcp", DestinationPortStart: "22"},
+		{Action: "accept", Direction: "in", Protocol: "tcp", DestinationPortStart: "443"},
+		{Action: "drop", Direction: "in"},
+	}
+
+	plan, err := svc.ReconcileFirewall(context.Background(), &request.ReconcileFirewallRequest{
+		ServerUUID:    "uuid",
+		FirewallRules: desired,
+		DryRun:        true,
+	})
+	require.NoError(t, err)
+	assert.False(t, plan.Applied)
+	assert.Empty(t, replaced)
+	actions := make([]upcloud.FirewallRuleChangeAction, 0, len(plan.Changes))
+	for _, change := range plan.Changes {
+		actions = append(actions, change.Action)
+	}
+	assert.Equal(t, []upcloud.FirewallRuleChangeAction{
+		upcloud.FirewallRuleChangeActionKeep,
+		upcloud.FirewallRuleChangeActionRemove,
+		upcloud.FirewallRuleChangeActionAdd,
+		upcloud.FirewallRuleChangeActionKeep,
+	}, actions)
+	assert.Equal(t, 2, plan.Changes[1].CurrentPosition)
+	assert.Equal(t, 2, plan.Changes[2].DesiredPosition)
+
+	plan, err = svc.ReconcileFirewall(context.Background(), &request.ReconcileFirewallRequest{
+		ServerUUID:    "uuid",
+		FirewallRules: desired,
+	})
+	require.NoError(t, err)
+	assert.True(t, plan.Applied)
+	require.Len(t, replaced, 1)
+	assert.JSONEq(t, `{"firewall_rules": {"firewall_rule": [
+		{"action": "accept", "direction": "in", "family": "IPv4", "protocol": "tcp", "destination_port_start": "22", "destination_port_end": "22"},
+		{"action": "accept", "direction": "in", "family": "IPv4", "protocol": "tcp", "destination_port_start": "443", "destination_port_end": "443"},
+		{"action": "drop", "direction": "in", "family": "IPv4"}
+	]}}`, replaced[0])
+
+	plan, err = svc.ReconcileFirewall(context.Background(), &request.ReconcileFirewallRequest{
+		ServerUUID:    "uuid",
+		FirewallRules: desired[:1:1],
+	})
+	require.NoError(t, err)
+	assert.True(t, plan.Applied)
+
+	plan, err = svc.ReconcileFirewall(context.Background(), &request.ReconcileFirewallRequest{
+		ServerUUID: "uuid",
+		FirewallRules: []upcloud.FirewallRule{
+			{Action: "accept", Direction: "in", Protocol: "tcp", DestinationPortEnd: "22"},
+			{Action: "accept", Direction: "in", Protocol: "tcp", DestinationPortStart: "80", DestinationPortEnd: "80", Family: "IPv4"},
+			{Action: "drop", Direction: "in", Family: "IPv4"},
+		},
+	})
+	require.NoError(t, err)
+	assert.False(t, plan.HasChanges())
+	assert.False(t, plan.Applied)
+	assert.Len(t, replaced, 2)
+}
+
+// TestApplyFirewallPolicy ensures that ApplyFirewallPolicy() reconciles the rules of the servers matching the label
+// filter, honours server specific overrides and reports drift, servers with the firewall off and per-server errors.
+func TestApplyFirewallPolicy(t *testing.T) {
+	t.Parallel()
+
+	var mu sync.Mutex
+	replaced := map[string]string{}
+	rules := `{"firewall_rules": {"firewall_rule": [
+		{"action": "accept", "direction": "in", "family": "IPv4", "position": "1", "protocol": "tcp", "destination_port_start": "22", "destination_port_end": "22"},
+		{"action": "drop", "direction": "in", "family": "IPv4", "position": "2"}
+	]}}`
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "role=web", r.URL.Query().Get("label"))
+		_, _ = fmt.Fprint(w, `{"servers": {"server": [
+			{"uuid": "in-sync", "hostname": "web1"},
+			{"uuid": "drifted", "hostname": "web2"},
+			{"uuid": "override", "hostname": "web3"},
+			{"uuid": "excluded", "hostname": "web4"},
+			{"uuid": "broken", "hostname": "web5"}
+		]}}`)
+	})
+	for _, uuid := range []string{"in-sync", "drifted", "override", "broken"} {
+		mux.HandleFunc(fmt.Sprintf("GET /%s/server/%s", client.APIVersion, uuid), func(w http.ResponseWriter, _ *http.Request) {
+			firewall := "on"
+			if uuid == "override" {
+				firewall = "off"
+			}
+			_, _ = fmt.Fprintf(w, `{"server": {"uuid": %q, "firewall": %q}}`, uuid, firewall)
+		})
+	}
+	for _, uuid := range []string{"in-sync", "drifted", "override"} {
+		mux.HandleFunc(fmt.Sprintf("GET /%s/server/%s/firewall_rule", client.APIVersion, uuid), func(w http.ResponseWriter, _ *http.Request) {
+			if uuid == "drifted" {
+				_, _ = fmt.Fprint(w, `{"firewall_rules": {"firewall_rule": []}}`)
+				return
+			}
+			_, _ = fmt.Fprint(w, rules)
+		})
+		mux.HandleFunc(fmt.Sprintf("PUT /%s/server/%s/firewall_rule", client.APIVersion, uuid), func(_ http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			defer mu.Unlock()
+			replaced[uuid] = string(body)
+		})
+	}
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/broken/firewall_rule", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = fmt.Fprint(w, `{"error": {"error_code": "SERVER_NOT_FOUND", "error_message": "The server does not exist."}}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	r := &request.ApplyFirewallPolicyRequest{
+		Policy: upcloud.FirewallPolicy{
+			Name: "web",
+			Rules: []upcloud.FirewallRule{
+				{Action: "accept", Direction: "in", Protocol: "tcp", DestinationPortStart: "22"},
+				{Action: "drop", Direction: "in"},
+			},
+		},
+		Filters: []request.QueryFilter{
+			request.FilterLabel{Label: upcloud.Label{Key: "role", Value: "web"}},
+		},
+		Overrides: map[string]upcloud.FirewallPolicyOverride{
+			"override": {RulesBefore: []upcloud.FirewallRule{
+				{Action: "accept", Direction: "in", Protocol: "tcp", DestinationPortStart: "443"},
+			}},
+			"excluded": {Exclude: true},
+		},
+		DryRun: true,
+	}
+
+	result, err := svc.ApplyFirewallPolicy(context.Background(), r)
+	var problem *upcloud.Problem
+	require.ErrorAs(t, err, &problem)
+	assert.Equal(t, upcloud.ErrCodeServerNotFound, problem.ErrorCode())
+	assert.Equal(t, "web", result.PolicyName)
+	assert.Equal(t, []string{"excluded"}, result.Excluded)
+	require.Len(t, result.Servers, 4)
+	assert.Equal(t, "broken", result.Servers[3].ServerUUID)
+	assert.Nil(t, result.Servers[3].Plan)
+	var policyErr *FirewallPolicyError
+	require.ErrorAs(t, err, &policyErr)
+	assert.Equal(t, "broken", policyErr.ServerUUID)
+	require.Len(t, result.FirewallDisabled(), 1)
+	assert.Equal(t, "override", result.FirewallDisabled()[0].ServerUUID)
+	drifted := make([]string, 0)
+	for _, server := range result.Drifted() {
+		drifted = append(drifted, server.Hostname)
+	}
+	assert.Equal(t, []string{"web2", "web3"}, drifted)
+	assert.Empty(t, replaced)
+
+	r.DryRun = false
+	_, err = svc.ApplyFirewallPolicy(context.Background(), r)
+	assert.Error(t, err)
+	assert.Len(t, replaced, 2)
+	assert.JSONEq(t, `{"firewall_rules": {"firewall_rule": [
+		{"action": "accept", "direction": "in", "family": "IPv4", "protocol": "tcp", "destination_port_start": "443", "destination_port_end": "443"},
+		{"action": "accept", "direction": "in", "family": "IPv4", "protocol": "tcp", "destination_port_start": "22", "destination_port_end": "22"},
+		{"action": "drop", "direction": "in", "family": "IPv4"}
+	]}}`, replaced["override"])
+	assert.Contains(t, replaced, "drifted")
+}
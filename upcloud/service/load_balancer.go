@@ -17,6 +17,8 @@ type LoadBalancer interface {
 	DeleteLoadBalancer(ctx context.Context, r *request.DeleteLoadBalancerRequest) error
 	WaitForLoadBalancerOperationalState(ctx context.Context, r *request.WaitForLoadBalancerOperationalStateRequest) (*upcloud.LoadBalancer, error)
 	WaitForLoadBalancerDeletion(ctx context.Context, r *request.WaitForLoadBalancerDeletionRequest) error
+	ApplyLoadBalancer(ctx context.Context, r *request.ApplyLoadBalancerRequest) (*upcloud.LoadBalancerChangePlan, error)
+	GetLoadBalancerHealth(ctx context.Context, r *request.GetLoadBalancerHealthRequest) (*upcloud.LoadBalancerHealth, error)
 	// Backends
 	GetLoadBalancerBackends(ctx context.Context, r *request.GetLoadBalancerBackendsRequest) ([]upcloud.LoadBalancerBackend, error)
 	GetLoadBalancerBackend(ctx context.Context, r *request.GetLoadBalancerBackendRequest) (*upcloud.LoadBalancerBackend, error)
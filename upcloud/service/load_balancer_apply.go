@@ -0,0 +1,872 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// ErrInvalidLoadBalancerConfiguration is returned when a desired load balancer configuration refers to resources it
+// does not contain.
+var ErrInvalidLoadBalancerConfiguration = errors.New("invalid load balancer configuration")
+
+// loadBalancerApplyPhase orders the changes of a plan so that resources are created before the resources referring
+// to them and deleted after them, e.g. backends are created before the rules using them and deleted after the rules.
+type loadBalancerApplyPhase int
+
+const (
+	loadBalancerApplyPhaseLoadBalancer loadBalancerApplyPhase = iota
+	loadBalancerApplyPhaseUpsertResolvers
+	loadBalancerApplyPhaseUpsertBackends
+	loadBalancerApplyPhaseDeleteRules
+	loadBalancerApplyPhaseDeleteFrontends
+	loadBalancerApplyPhaseUpsertFrontends
+	loadBalancerApplyPhaseUpsertRules
+	loadBalancerApplyPhaseDeleteBackends
+	loadBalancerApplyPhaseDeleteResolvers
+	loadBalancerApplyPhaseCount
+)
+
+type loadBalancerOperation struct {
+	change upcloud.LoadBalancerChange
+	// apply is nil for resources that are created together with their parent
+	apply func(ctx context.Context) error
+}
+
+type loadBalancerPlanner struct {
+	s    *Service
+	uuid string
+	// members are the desired backend members by backend name
+	members map[string][]request.ApplyLoadBalancerBackendMember
+	phases  [loadBalancerApplyPhaseCount][]loadBalancerOperation
+}
+
+// defaultLoadBalancerBackendMemberWeight is the weight of new backend members without a weight
+const defaultLoadBalancerBackendMemberWeight = 100
+
+// ApplyLoadBalancer makes a load balancer match the desired configuration. The current configuration is fetched and
+// compared to the desired one by resource name, and only the resources that differ are created, modified or
+// deleted. A load balancer that does not exist is created with a single request.
+//
+// Fields left to their zero value in the desired configuration are not compared, with the exception of backend
+// Resolver and frontend rules, which are compared as a whole. Backend members are given separately in
+// BackendMembers, where Weight and Enabled are compared only when set. Networks and floating IP addresses of an
+// existing load balancer are not modified, and certificate bundles are referred to by UUID and managed separately.
+//
+// The returned plan lists the changes in the order they are applied. Unless DryRun is set, the method waits for the
+// load balancer to be running after the changes have been made.
+func (s *Service) ApplyLoadBalancer(ctx context.Context, r *request.ApplyLoadBalancerRequest) (*upcloud.LoadBalancerChangePlan, error) {
+	desired := r.LoadBalancer
+	if err := validateLoadBalancerReferences(&desired, r.BackendMembers); err != nil {
+		return nil, err
+	}
+
+	current, err := s.findLoadBalancer(ctx, &desired)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &loadBalancerPlanner{s: s, members: r.BackendMembers}
+	name := desired.Name
+	configuredStatus := desired.ConfiguredStatus
+	if current == nil {
+		p.planCreate(&desired)
+	} else {
+		p.uuid = current.UUID
+		if name == "" {
+			name = current.Name
+		}
+		if configuredStatus == "" {
+			configuredStatus = current.ConfiguredStatus
+		}
+		p.planLoadBalancer(current, &desired)
+		p.planResolvers(current.Resolvers, desired.Resolvers)
+		p.planBackends(current.Backends, desired.Backends)
+		p.planFrontends(current.Frontends, desired.Frontends)
+	}
+
+	operations := p.operations()
+	plan := &upcloud.LoadBalancerChangePlan{LoadBalancerUUID: p.uuid, Name: name}
+	for _, op := range operations {
+		plan.Changes = append(plan.Changes, op.change)
+	}
+	if r.DryRun || !plan.HasChanges() {
+		return plan, nil
+	}
+
+	for _, op := range operations {
+		if op.apply == nil {
+			continue
+		}
+		if err := op.apply(ctx); err != nil {
+			plan.LoadBalancerUUID = p.uuid
+			return plan, fmt.Errorf("applying change %q failed: %w", op.change, err)
+		}
+	}
+	plan.LoadBalancerUUID = p.uuid
+	plan.Applied = true
+
+	if configuredStatus == upcloud.LoadBalancerConfiguredStatusStopped {
+		return plan, nil
+	}
+	_, err = s.WaitForLoadBalancerOperationalState(ctx, &request.WaitForLoadBalancerOperationalStateRequest{
+		UUID:         p.uuid,
+		DesiredState: upcloud.LoadBalancerOperationalStateRunning,
+	})
+	return plan, err
+}
+
+// findLoadBalancer returns the current configuration of the desired load balancer, or nil if a load balancer with
+// the desired name does not exist
+func (s *Service) findLoadBalancer(ctx context.Context, desired *upcloud.LoadBalancer) (*upcloud.LoadBalancer, error) {
+	if desired.UUID != "" {
+		return s.GetLoadBalancer(ctx, &request.GetLoadBalancerRequest{UUID: desired.UUID})
+	}
+	if desired.Name == "" {
+		return nil, fmt.Errorf("%w: load balancer UUID or name is required", ErrInvalidLoadBalancerConfiguration)
+	}
+
+	loadBalancers, err := s.GetLoadBalancers(ctx, &request.GetLoadBalancersRequest{})
+	if err != nil {
+		return nil, err
+	}
+	uuid := ""
+	for _, lb := range loadBalancers {
+		if lb.Name != desired.Name {
+			continue
+		}
+		if uuid != "" {
+			return nil, fmt.Errorf("%w: multiple load balancers are named %q", ErrInvalidLoadBalancerConfiguration, desired.Name)
+		}
+		uuid = lb.UUID
+	}
+	if uuid == "" {
+		return nil, nil
+	}
+	return s.GetLoadBalancer(ctx, &request.GetLoadBalancerRequest{UUID: uuid})
+}
+
+// validateLoadBalancerReferences checks that resource names are unique and that the backends and resolvers referred
+// to exist in the configuration
+func validateLoadBalancerReferences(lb *upcloud.LoadBalancer, members map[string][]request.ApplyLoadBalancerBackendMember) error {
+	var errs []error
+	duplicates := func(resource upcloud.LoadBalancerResourceType, list []string) {
+		seen := make(map[string]bool, len(list))
+		for _, name := range list {
+			if seen[name] {
+				errs = append(errs, fmt.Errorf("%w: duplicate %s %q", ErrInvalidLoadBalancerConfiguration, resource, name))
+			}
+			seen[name] = true
+		}
+	}
+
+	resolvers := make([]string, 0, len(lb.Resolvers))
+	for _, resolver := range lb.Resolvers {
+		resolvers = append(resolvers, resolver.Name)
+	}
+	duplicates(upcloud.LoadBalancerResourceTypeResolver, resolvers)
+
+	backends := make([]string, 0, len(lb.Backends))
+	for _, backend := range lb.Backends {
+		backends = append(backends, backend.Name)
+		if backend.Resolver != "" && !slices.Contains(resolvers, backend.Resolver) {
+			errs = append(errs, fmt.Errorf("%w: backend %q refers to unknown resolver %q", ErrInvalidLoadBalancerConfiguration, backend.Name, backend.Resolver))
+		}
+		if len(backend.Members) > 0 {
+			errs = append(errs, fmt.Errorf("%w: members of backend %q must be set in BackendMembers", ErrInvalidLoadBalancerConfiguration, backend.Name))
+		}
+		duplicates(upcloud.LoadBalancerResourceTypeBackendMember, names(members[backend.Name], func(m request.ApplyLoadBalancerBackendMember) string { return m.Name }))
+	}
+	duplicates(upcloud.LoadBalancerResourceTypeBackend, backends)
+	for _, backend := range slices.Sorted(maps.Keys(members)) {
+		if !slices.Contains(backends, backend) {
+			errs = append(errs, fmt.Errorf("%w: members refer to unknown backend %q", ErrInvalidLoadBalancerConfiguration, backend))
+		}
+	}
+
+	frontends := make([]string, 0, len(lb.Frontends))
+	for _, frontend := range lb.Frontends {
+		frontends = append(frontends, frontend.Name)
+		if frontend.DefaultBackend != "" && !slices.Contains(backends, frontend.DefaultBackend) {
+			errs = append(errs, fmt.Errorf("%w: frontend %q refers to unknown backend %q", ErrInvalidLoadBalancerConfiguration, frontend.Name, frontend.DefaultBackend))
+		}
+		for _, rule := range frontend.Rules {
+			for _, backend := range loadBalancerRuleBackends(rule) {
+				if !slices.Contains(backends, backend) {
+					errs = append(errs, fmt.Errorf("%w: rule %q of frontend %q refers to unknown backend %q", ErrInvalidLoadBalancerConfiguration, rule.Name, frontend.Name, backend))
+				}
+			}
+		}
+		duplicates(upcloud.LoadBalancerResourceTypeFrontendRule, names(frontend.Rules, func(r upcloud.LoadBalancerFrontendRule) string { return r.Name }))
+	}
+	duplicates(upcloud.LoadBalancerResourceTypeFrontend, frontends)
+
+	return errors.Join(errs...)
+}
+
+// loadBalancerRuleBackends returns the names of the backends the rule refers to
+func loadBalancerRuleBackends(rule upcloud.LoadBalancerFrontendRule) []string {
+	var backends []string
+	for _, matcher := range rule.Matchers {
+		if matcher.NumMembersUp != nil && matcher.NumMembersUp.Backend != "" {
+			backends = append(backends, matcher.NumMembersUp.Backend)
+		}
+	}
+	for _, action := range rule.Actions {
+		if action.UseBackend != nil && action.UseBackend.Backend != "" {
+			backends = append(backends, action.UseBackend.Backend)
+		}
+	}
+	return backends
+}
+
+func (p *loadBalancerPlanner) add(phase loadBalancerApplyPhase, change upcloud.LoadBalancerChange, apply func(ctx context.Context) error) {
+	p.phases[phase] = append(p.phases[phase], loadBalancerOperation{change: change, apply: apply})
+}
+
+func (p *loadBalancerPlanner) operations() []loadBalancerOperation {
+	var operations []loadBalancerOperation
+	for _, phase := range p.phases {
+		operations = append(operations, phase...)
+	}
+	return operations
+}
+
+func (p *loadBalancerPlanner) planCreate(desired *upcloud.LoadBalancer) {
+	phase := loadBalancerApplyPhaseLoadBalancer
+	p.add(phase, upcloud.LoadBalancerChange{
+		Action:   upcloud.LoadBalancerChangeActionCreate,
+		Resource: upcloud.LoadBalancerResourceTypeLoadBalancer,
+		Name:     desired.Name,
+	}, func(ctx context.Context) error {
+		lb, err := p.s.CreateLoadBalancer(ctx, createLoadBalancerPayload(desired, p.members))
+		if err != nil {
+			return err
+		}
+		p.uuid = lb.UUID
+		return nil
+	})
+
+	for _, resolver := range desired.Resolvers {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeResolver, "", resolver.Name), nil)
+	}
+	for _, backend := range desired.Backends {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeBackend, "", backend.Name), nil)
+		p.addBackendChildren(phase, backend)
+	}
+	for _, frontend := range desired.Frontends {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeFrontend, "", frontend.Name), nil)
+		p.addFrontendChildren(phase, frontend)
+	}
+}
+
+// addBackendChildren lists the members and TLS configs created together with the backend
+func (p *loadBalancerPlanner) addBackendChildren(phase loadBalancerApplyPhase, backend upcloud.LoadBalancerBackend) {
+	for _, member := range p.members[backend.Name] {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeBackendMember, backend.Name, member.Name), nil)
+	}
+	for _, config := range backend.TLSConfigs {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeBackendTLSConfig, backend.Name, config.Name), nil)
+	}
+}
+
+// addFrontendChildren lists the rules and TLS configs created together with the frontend
+func (p *loadBalancerPlanner) addFrontendChildren(phase loadBalancerApplyPhase, frontend upcloud.LoadBalancerFrontend) {
+	for _, rule := range frontend.Rules {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeFrontendRule, frontend.Name, rule.Name), nil)
+	}
+	for _, config := range frontend.TLSConfigs {
+		p.add(phase, createChange(upcloud.LoadBalancerResourceTypeFrontendTLSConfig, frontend.Name, config.Name), nil)
+	}
+}
+
+func (p *loadBalancerPlanner) planLoadBalancer(current, desired *upcloud.LoadBalancer) {
+	var fields []string
+	modify := request.ModifyLoadBalancerRequest{}
+	if desired.UUID != "" && desired.Name != "" && desired.Name != current.Name {
+		fields = append(fields, "name")
+		modify.Name = desired.Name
+	}
+	if desired.Plan != "" && desired.Plan != current.Plan {
+		fields = append(fields, "plan")
+		modify.Plan = desired.Plan
+	}
+	if desired.ConfiguredStatus != "" && desired.ConfiguredStatus != current.ConfiguredStatus {
+		fields = append(fields, "configured_status")
+		modify.ConfiguredStatus = string(desired.ConfiguredStatus)
+	}
+	if desired.Labels != nil && !sameLabels(current.Labels, desired.Labels) {
+		fields = append(fields, "labels")
+		modify.Labels = &desired.Labels
+	}
+	if desired.MaintenanceDOW != "" && desired.MaintenanceDOW != current.MaintenanceDOW {
+		fields = append(fields, "maintenance_dow")
+		modify.MaintenanceDOW = desired.MaintenanceDOW
+	}
+	if desired.MaintenanceTime != "" && desired.MaintenanceTime != current.MaintenanceTime {
+		fields = append(fields, "maintenance_time")
+		modify.MaintenanceTime = desired.MaintenanceTime
+	}
+	if len(fields) == 0 {
+		return
+	}
+
+	p.add(loadBalancerApplyPhaseLoadBalancer, modifyChange(upcloud.LoadBalancerResourceTypeLoadBalancer, "", current.Name, fields), func(ctx context.Context) error {
+		modify.UUID = p.uuid
+		_, err := p.s.ModifyLoadBalancer(ctx, &modify)
+		return err
+	})
+}
+
+func (p *loadBalancerPlanner) planResolvers(current, desired []upcloud.LoadBalancerResolver) {
+	for _, resolver := range desired {
+		payload := loadBalancerResolverPayload(resolver)
+		idx := slices.IndexFunc(current, func(r upcloud.LoadBalancerResolver) bool { return r.Name == resolver.Name })
+		if idx < 0 {
+			p.add(loadBalancerApplyPhaseUpsertResolvers, createChange(upcloud.LoadBalancerResourceTypeResolver, "", resolver.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerResolver(ctx, &request.CreateLoadBalancerResolverRequest{ServiceUUID: p.uuid, Resolver: payload})
+				return err
+			})
+			continue
+		}
+		if fields := changedFields(current[idx], resolver); len(fields) > 0 {
+			p.add(loadBalancerApplyPhaseUpsertResolvers, modifyChange(upcloud.LoadBalancerResourceTypeResolver, "", resolver.Name, fields), func(ctx context.Context) error {
+				_, err := p.s.ModifyLoadBalancerResolver(ctx, &request.ModifyLoadBalancerResolverRequest{ServiceUUID: p.uuid, Name: resolver.Name, Resolver: payload})
+				return err
+			})
+		}
+	}
+	for _, resolver := range current {
+		if slices.ContainsFunc(desired, func(r upcloud.LoadBalancerResolver) bool { return r.Name == resolver.Name }) {
+			continue
+		}
+		p.add(loadBalancerApplyPhaseDeleteResolvers, deleteChange(upcloud.LoadBalancerResourceTypeResolver, "", resolver.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerResolver(ctx, &request.DeleteLoadBalancerResolverRequest{ServiceUUID: p.uuid, Name: resolver.Name})
+		})
+	}
+}
+
+func (p *loadBalancerPlanner) planBackends(current, desired []upcloud.LoadBalancerBackend) {
+	const phase = loadBalancerApplyPhaseUpsertBackends
+	for _, backend := range desired {
+		idx := slices.IndexFunc(current, func(b upcloud.LoadBalancerBackend) bool { return b.Name == backend.Name })
+		if idx < 0 {
+			p.add(phase, createChange(upcloud.LoadBalancerResourceTypeBackend, "", backend.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerBackend(ctx, &request.CreateLoadBalancerBackendRequest{
+					ServiceUUID: p.uuid,
+					Backend:     loadBalancerBackendPayload(backend, p.members[backend.Name]),
+				})
+				return err
+			})
+			p.addBackendChildren(phase, backend)
+			continue
+		}
+
+		existing := current[idx]
+		var fields []string
+		modify := request.ModifyLoadBalancerBackend{}
+		if backend.Resolver != existing.Resolver {
+			fields = append(fields, "resolver")
+			modify.Resolver = &backend.Resolver
+		}
+		if backend.Properties != nil {
+			var currentProperties upcloud.LoadBalancerBackendProperties
+			if existing.Properties != nil {
+				currentProperties = *existing.Properties
+			}
+			if changed := changedFields(currentProperties, *backend.Properties); len(changed) > 0 {
+				for _, field := range changed {
+					fields = append(fields, "properties."+field)
+				}
+				modify.Properties = backend.Properties
+			}
+		}
+		if len(fields) > 0 {
+			p.add(phase, modifyChange(upcloud.LoadBalancerResourceTypeBackend, "", backend.Name, fields), func(ctx context.Context) error {
+				_, err := p.s.ModifyLoadBalancerBackend(ctx, &request.ModifyLoadBalancerBackendRequest{ServiceUUID: p.uuid, Name: backend.Name, Backend: modify})
+				return err
+			})
+		}
+		p.planBackendMembers(backend.Name, existing.Members, p.members[backend.Name])
+		p.planBackendTLSConfigs(backend.Name, existing.TLSConfigs, backend.TLSConfigs)
+	}
+
+	for _, backend := range current {
+		if slices.ContainsFunc(desired, func(b upcloud.LoadBalancerBackend) bool { return b.Name == backend.Name }) {
+			continue
+		}
+		p.add(loadBalancerApplyPhaseDeleteBackends, deleteChange(upcloud.LoadBalancerResourceTypeBackend, "", backend.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerBackend(ctx, &request.DeleteLoadBalancerBackendRequest{ServiceUUID: p.uuid, Name: backend.Name})
+		})
+	}
+}
+
+func (p *loadBalancerPlanner) planBackendMembers(backendName string, current []upcloud.LoadBalancerBackendMember, desired []request.ApplyLoadBalancerBackendMember) {
+	const phase = loadBalancerApplyPhaseUpsertBackends
+	for _, member := range desired {
+		idx := slices.IndexFunc(current, func(m upcloud.LoadBalancerBackendMember) bool { return m.Name == member.Name })
+		if idx < 0 {
+			p.add(phase, createChange(upcloud.LoadBalancerResourceTypeBackendMember, backendName, member.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerBackendMember(ctx, &request.CreateLoadBalancerBackendMemberRequest{
+					ServiceUUID: p.uuid,
+					BackendName: backendName,
+					Member:      loadBalancerBackendMemberPayload(member),
+				})
+				return err
+			})
+			continue
+		}
+
+		existing := current[idx]
+		var fields []string
+		if member.IP != "" && member.IP != existing.IP {
+			fields = append(fields, "ip")
+		}
+		if member.Port != 0 && member.Port != existing.Port {
+			fields = append(fields, "port")
+		}
+		if member.Weight != nil && *member.Weight != existing.Weight {
+			fields = append(fields, "weight")
+		}
+		if member.MaxSessions != 0 && member.MaxSessions != existing.MaxSessions {
+			fields = append(fields, "max_sessions")
+		}
+		if member.Type != "" && member.Type != existing.Type {
+			fields = append(fields, "type")
+		}
+		if member.Enabled != nil && *member.Enabled != existing.Enabled {
+			fields = append(fields, "enabled")
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		payload := request.ModifyLoadBalancerBackendMember{
+			Type:    member.Type,
+			Weight:  member.Weight,
+			Enabled: member.Enabled,
+			Port:    member.Port,
+		}
+		if member.IP != "" {
+			payload.IP = &member.IP
+		}
+		if member.MaxSessions != 0 {
+			payload.MaxSessions = &member.MaxSessions
+		}
+		p.add(phase, modifyChange(upcloud.LoadBalancerResourceTypeBackendMember, backendName, member.Name, fields), func(ctx context.Context) error {
+			_, err := p.s.ModifyLoadBalancerBackendMember(ctx, &request.ModifyLoadBalancerBackendMemberRequest{
+				ServiceUUID: p.uuid,
+				BackendName: backendName,
+				Name:        member.Name,
+				Member:      payload,
+			})
+			return err
+		})
+	}
+
+	for _, member := range current {
+		if slices.ContainsFunc(desired, func(m request.ApplyLoadBalancerBackendMember) bool { return m.Name == member.Name }) {
+			continue
+		}
+		p.add(phase, deleteChange(upcloud.LoadBalancerResourceTypeBackendMember, backendName, member.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerBackendMember(ctx, &request.DeleteLoadBalancerBackendMemberRequest{ServiceUUID: p.uuid, BackendName: backendName, Name: member.Name})
+		})
+	}
+}
+
+func (p *loadBalancerPlanner) planBackendTLSConfigs(backendName string, current, desired []upcloud.LoadBalancerBackendTLSConfig) {
+	const phase = loadBalancerApplyPhaseUpsertBackends
+	for _, config := range desired {
+		payload := request.LoadBalancerBackendTLSConfig{Name: config.Name, CertificateBundleUUID: config.CertificateBundleUUID}
+		idx := slices.IndexFunc(current, func(c upcloud.LoadBalancerBackendTLSConfig) bool { return c.Name == config.Name })
+		if idx < 0 {
+			p.add(phase, createChange(upcloud.LoadBalancerResourceTypeBackendTLSConfig, backendName, config.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerBackendTLSConfig(ctx, &request.CreateLoadBalancerBackendTLSConfigRequest{ServiceUUID: p.uuid, BackendName: backendName, Config: payload})
+				return err
+			})
+			continue
+		}
+		if current[idx].CertificateBundleUUID != config.CertificateBundleUUID {
+			p.add(phase, modifyChange(upcloud.LoadBalancerResourceTypeBackendTLSConfig, backendName, config.Name, []string{"certificate_bundle_uuid"}), func(ctx context.Context) error {
+				_, err := p.s.ModifyLoadBalancerBackendTLSConfig(ctx, &request.ModifyLoadBalancerBackendTLSConfigRequest{ServiceUUID: p.uuid, BackendName: backendName, Name: config.Name, Config: payload})
+				return err
+			})
+		}
+	}
+
+	for _, config := range current {
+		if slices.ContainsFunc(desired, func(c upcloud.LoadBalancerBackendTLSConfig) bool { return c.Name == config.Name }) {
+			continue
+		}
+		p.add(phase, deleteChange(upcloud.LoadBalancerResourceTypeBackendTLSConfig, backendName, config.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerBackendTLSConfig(ctx, &request.DeleteLoadBalancerBackendTLSConfigRequest{ServiceUUID: p.uuid, BackendName: backendName, Name: config.Name})
+		})
+	}
+}
+
+func (p *loadBalancerPlanner) planFrontends(current, desired []upcloud.LoadBalancerFrontend) {
+	const phase = loadBalancerApplyPhaseUpsertFrontends
+	for _, frontend := range desired {
+		idx := slices.IndexFunc(current, func(f upcloud.LoadBalancerFrontend) bool { return f.Name == frontend.Name })
+		if idx < 0 {
+			p.add(phase, createChange(upcloud.LoadBalancerResourceTypeFrontend, "", frontend.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerFrontend(ctx, &request.CreateLoadBalancerFrontendRequest{
+					ServiceUUID: p.uuid,
+					Frontend:    loadBalancerFrontendPayload(frontend),
+				})
+				return err
+			})
+			p.addFrontendChildren(phase, frontend)
+			continue
+		}
+
+		existing := current[idx]
+		var fields []string
+		modify := request.ModifyLoadBalancerFrontend{}
+		if frontend.Mode != "" && frontend.Mode != existing.Mode {
+			fields = append(fields, "mode")
+			modify.Mode = frontend.Mode
+		}
+		if frontend.Port != 0 && frontend.Port != existing.Port {
+			fields = append(fields, "port")
+			modify.Port = frontend.Port
+		}
+		if frontend.DefaultBackend != "" && frontend.DefaultBackend != existing.DefaultBackend {
+			fields = append(fields, "default_backend")
+			modify.DefaultBackend = frontend.DefaultBackend
+		}
+		if frontend.Networks != nil && !sameFrontendNetworks(existing.Networks, frontend.Networks) {
+			fields = append(fields, "networks")
+			modify.Networks = frontend.Networks
+		}
+		if frontend.Properties != nil {
+			var currentProperties upcloud.LoadBalancerFrontendProperties
+			if existing.Properties != nil {
+				currentProperties = *existing.Properties
+			}
+			if changed := changedFields(currentProperties, *frontend.Properties); len(changed) > 0 {
+				for _, field := range changed {
+					fields = append(fields, "properties."+field)
+				}
+				modify.Properties = frontend.Properties
+			}
+		}
+		if len(fields) > 0 {
+			p.add(phase, modifyChange(upcloud.LoadBalancerResourceTypeFrontend, "", frontend.Name, fields), func(ctx context.Context) error {
+				_, err := p.s.ModifyLoadBalancerFrontend(ctx, &request.ModifyLoadBalancerFrontendRequest{ServiceUUID: p.uuid, Name: frontend.Name, Frontend: modify})
+				return err
+			})
+		}
+		p.planFrontendTLSConfigs(frontend.Name, existing.TLSConfigs, frontend.TLSConfigs)
+		p.planFrontendRules(frontend.Name, existing.Rules, frontend.Rules)
+	}
+
+	for _, frontend := range current {
+		if slices.ContainsFunc(desired, func(f upcloud.LoadBalancerFrontend) bool { return f.Name == frontend.Name }) {
+			continue
+		}
+		p.add(loadBalancerApplyPhaseDeleteFrontends, deleteChange(upcloud.LoadBalancerResourceTypeFrontend, "", frontend.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerFrontend(ctx, &request.DeleteLoadBalancerFrontendRequest{ServiceUUID: p.uuid, Name: frontend.Name})
+		})
+	}
+}
+
+func (p *loadBalancerPlanner) planFrontendTLSConfigs(frontendName string, current, desired []upcloud.LoadBalancerFrontendTLSConfig) {
+	const phase = loadBalancerApplyPhaseUpsertFrontends
+	for _, config := range desired {
+		payload := request.LoadBalancerFrontendTLSConfig{Name: config.Name, CertificateBundleUUID: config.CertificateBundleUUID}
+		idx := slices.IndexFunc(current, func(c upcloud.LoadBalancerFrontendTLSConfig) bool { return c.Name == config.Name })
+		if idx < 0 {
+			p.add(phase, createChange(upcloud.LoadBalancerResourceTypeFrontendTLSConfig, frontendName, config.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerFrontendTLSConfig(ctx, &request.CreateLoadBalancerFrontendTLSConfigRequest{ServiceUUID: p.uuid, FrontendName: frontendName, Config: payload})
+				return err
+			})
+			continue
+		}
+		if current[idx].CertificateBundleUUID != config.CertificateBundleUUID {
+			p.add(phase, modifyChange(upcloud.LoadBalancerResourceTypeFrontendTLSConfig, frontendName, config.Name, []string{"certificate_bundle_uuid"}), func(ctx context.Context) error {
+				_, err := p.s.ModifyLoadBalancerFrontendTLSConfig(ctx, &request.ModifyLoadBalancerFrontendTLSConfigRequest{ServiceUUID: p.uuid, FrontendName: frontendName, Name: config.Name, Config: payload})
+				return err
+			})
+		}
+	}
+
+	for _, config := range current {
+		if slices.ContainsFunc(desired, func(c upcloud.LoadBalancerFrontendTLSConfig) bool { return c.Name == config.Name }) {
+			continue
+		}
+		p.add(phase, deleteChange(upcloud.LoadBalancerResourceTypeFrontendTLSConfig, frontendName, config.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerFrontendTLSConfig(ctx, &request.DeleteLoadBalancerFrontendTLSConfigRequest{ServiceUUID: p.uuid, FrontendName: frontendName, Name: config.Name})
+		})
+	}
+}
+
+func (p *loadBalancerPlanner) planFrontendRules(frontendName string, current, desired []upcloud.LoadBalancerFrontendRule) {
+	const phase = loadBalancerApplyPhaseUpsertRules
+	for _, rule := range desired {
+		payload := loadBalancerFrontendRulePayload(rule)
+		idx := slices.IndexFunc(current, func(r upcloud.LoadBalancerFrontendRule) bool { return r.Name == rule.Name })
+		if idx < 0 {
+			p.add(phase, createChange(upcloud.LoadBalancerResourceTypeFrontendRule, frontendName, rule.Name), func(ctx context.Context) error {
+				_, err := p.s.CreateLoadBalancerFrontendRule(ctx, &request.CreateLoadBalancerFrontendRuleRequest{ServiceUUID: p.uuid, FrontendName: frontendName, Rule: payload})
+				return err
+			})
+			continue
+		}
+		if fields := changedRuleFields(current[idx], rule); len(fields) > 0 {
+			p.add(phase, modifyChange(upcloud.LoadBalancerResourceTypeFrontendRule, frontendName, rule.Name, fields), func(ctx context.Context) error {
+				_, err := p.s.ReplaceLoadBalancerFrontendRule(ctx, &request.ReplaceLoadBalancerFrontendRuleRequest{ServiceUUID: p.uuid, FrontendName: frontendName, Name: rule.Name, Rule: payload})
+				return err
+			})
+		}
+	}
+
+	for _, rule := range current {
+		if slices.ContainsFunc(desired, func(r upcloud.LoadBalancerFrontendRule) bool { return r.Name == rule.Name }) {
+			continue
+		}
+		p.add(loadBalancerApplyPhaseDeleteRules, deleteChange(upcloud.LoadBalancerResourceTypeFrontendRule, frontendName, rule.Name), func(ctx context.Context) error {
+			return p.s.DeleteLoadBalancerFrontendRule(ctx, &request.DeleteLoadBalancerFrontendRuleRequest{ServiceUUID: p.uuid, FrontendName: frontendName, Name: rule.Name})
+		})
+	}
+}
+
+func createChange(resource upcloud.LoadBalancerResourceType, parent, name string) upcloud.LoadBalancerChange {
+	return upcloud.LoadBalancerChange{Action: upcloud.LoadBalancerChangeActionCreate, Resource: resource, Parent: parent, Name: name}
+}
+
+func modifyChange(resource upcloud.LoadBalancerResourceType, parent, name string, fields []string) upcloud.LoadBalancerChange {
+	return upcloud.LoadBalancerChange{Action: upcloud.LoadBalancerChangeActionModify, Resource: resource, Parent: parent, Name: name, Fields: fields}
+}
+
+func deleteChange(resource upcloud.LoadBalancerResourceType, parent, name string) upcloud.LoadBalancerChange {
+	return upcloud.LoadBalancerChange{Action: upcloud.LoadBalancerChangeActionDelete, Resource: resource, Parent: parent, Name: name}
+}
+
+// changedFields returns the JSON names of the fields that are set in desired and differ in current. Both must be
+// structs of the same type.
+func changedFields[T any](current, desired T) []string {
+	cv := reflect.ValueOf(current)
+	dv := reflect.ValueOf(desired)
+	var fields []string
+	for i := 0; i < dv.NumField(); i++ {
+		if dv.Field(i).IsZero() || reflect.DeepEqual(dv.Field(i).Interface(), cv.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(dv.Type().Field(i).Tag.Get("json"), ",")
+		fields = append(fields, name)
+	}
+	return fields
+}
+
+// changedRuleFields returns the JSON names of the fields that differ between two rules. Matchers and actions are
+// compared as a whole, without the defaults filled in by the API.
+func changedRuleFields(current, desired upcloud.LoadBalancerFrontendRule) []string {
+	current = normalizeLoadBalancerRule(current)
+	desired = normalizeLoadBalancerRule(desired)
+	var fields []string
+	if current.Priority != desired.Priority {
+		fields = append(fields, "priority")
+	}
+	if current.MatchingCondition != desired.MatchingCondition {
+		fields = append(fields, "matching_condition")
+	}
+	if !reflect.DeepEqual(current.Matchers, desired.Matchers) {
+		fields = append(fields, "matchers")
+	}
+	if !reflect.DeepEqual(current.Actions, desired.Actions) {
+		fields = append(fields, "actions")
+	}
+	return fields
+}
+
+// normalizeLoadBalancerRule returns a copy of the rule with false boolean options and an empty matching condition
+// replaced by the API defaults
+func normalizeLoadBalancerRule(rule upcloud.LoadBalancerFrontendRule) upcloud.LoadBalancerFrontendRule {
+	if rule.MatchingCondition == "" {
+		rule.MatchingCondition = upcloud.LoadBalancerMatchingConditionAnd
+	}
+	if len(rule.Actions) == 0 {
+		rule.Actions = nil
+	}
+	if len(rule.Matchers) == 0 {
+		rule.Matchers = nil
+		return rule
+	}
+
+	unsetFalse := func(b *bool) *bool {
+		if b != nil && !*b {
+			return nil
+		}
+		return b
+	}
+	matchers := make([]upcloud.LoadBalancerMatcher, len(rule.Matchers))
+	for i, m := range rule.Matchers {
+		m.Inverse = unsetFalse(m.Inverse)
+		for _, s := range []**upcloud.LoadBalancerMatcherString{&m.Path, &m.URL, &m.URLQuery} {
+			if *s != nil {
+				c := **s
+				c.IgnoreCase = unsetFalse(c.IgnoreCase)
+				*s = &c
+			}
+		}
+		for _, s := range []**upcloud.LoadBalancerMatcherStringWithArgument{&m.RequestHeader, &m.ResponseHeader, &m.Cookie, &m.Header, &m.URLParam} {
+			if *s != nil {
+				c := **s
+				c.IgnoreCase = unsetFalse(c.IgnoreCase)
+				*s = &c
+			}
+		}
+		matchers[i] = m
+	}
+	rule.Matchers = matchers
+	return rule
+}
+
+func sameLabels(a, b []upcloud.Label) bool {
+	compare := func(x, y upcloud.Label) int {
+		return strings.Compare(x.Key+"="+x.Value, y.Key+"="+y.Value)
+	}
+	a = slices.SortedFunc(slices.Values(a), compare)
+	b = slices.SortedFunc(slices.Values(b), compare)
+	return slices.Equal(a, b)
+}
+
+func sameFrontendNetworks(a, b []upcloud.LoadBalancerFrontendNetwork) bool {
+	name := func(n upcloud.LoadBalancerFrontendNetwork) string { return n.Name }
+	return slices.Equal(slices.Sorted(slices.Values(names(a, name))), slices.Sorted(slices.Values(names(b, name))))
+}
+
+func names[T any](items []T, name func(T) string) []string {
+	s := make([]string, len(items))
+	for i, item := range items {
+		s[i] = name(item)
+	}
+	return s
+}
+
+func createLoadBalancerPayload(lb *upcloud.LoadBalancer, members map[string][]request.ApplyLoadBalancerBackendMember) *request.CreateLoadBalancerRequest {
+	r := &request.CreateLoadBalancerRequest{
+		Name:             lb.Name,
+		Plan:             lb.Plan,
+		Zone:             lb.Zone,
+		NetworkUUID:      lb.NetworkUUID,
+		ConfiguredStatus: lb.ConfiguredStatus,
+		Frontends:        make([]request.LoadBalancerFrontend, 0, len(lb.Frontends)),
+		Backends:         make([]request.LoadBalancerBackend, 0, len(lb.Backends)),
+		Resolvers:        make([]request.LoadBalancerResolver, 0, len(lb.Resolvers)),
+		Labels:           lb.Labels,
+		MaintenanceDOW:   lb.MaintenanceDOW,
+		MaintenanceTime:  lb.MaintenanceTime,
+	}
+	if r.ConfiguredStatus == "" {
+		r.ConfiguredStatus = upcloud.LoadBalancerConfiguredStatusStarted
+	}
+	for _, n := range lb.Networks {
+		r.Networks = append(r.Networks, request.LoadBalancerNetwork{Name: n.Name, Type: n.Type, Family: n.Family, UUID: n.UUID})
+	}
+	for _, ip := range lb.IPAddresses {
+		r.IPAddresses = append(r.IPAddresses, request.LoadBalancerIPAddress{Address: ip.Address, NetworkName: ip.NetworkName})
+	}
+	for _, resolver := range lb.Resolvers {
+		r.Resolvers = append(r.Resolvers, loadBalancerResolverPayload(resolver))
+	}
+	for _, backend := range lb.Backends {
+		r.Backends = append(r.Backends, loadBalancerBackendPayload(backend, members[backend.Name]))
+	}
+	for _, frontend := range lb.Frontends {
+		r.Frontends = append(r.Frontends, loadBalancerFrontendPayload(frontend))
+	}
+	return r
+}
+
+func loadBalancerResolverPayload(r upcloud.LoadBalancerResolver) request.LoadBalancerResolver {
+	return request.LoadBalancerResolver{
+		Name:         r.Name,
+		Nameservers:  r.Nameservers,
+		Retries:      r.Retries,
+		Timeout:      r.Timeout,
+		TimeoutRetry: r.TimeoutRetry,
+		CacheValid:   r.CacheValid,
+		CacheInvalid: r.CacheInvalid,
+	}
+}
+
+func loadBalancerBackendPayload(b upcloud.LoadBalancerBackend, members []request.ApplyLoadBalancerBackendMember) request.LoadBalancerBackend {
+	backend := request.LoadBalancerBackend{
+		Name:       b.Name,
+		Resolver:   b.Resolver,
+		Members:    make([]request.LoadBalancerBackendMember, 0, len(members)),
+		Properties: b.Properties,
+	}
+	for _, member := range members {
+		backend.Members = append(backend.Members, loadBalancerBackendMemberPayload(member))
+	}
+	for _, config := range b.TLSConfigs {
+		backend.TLSConfigs = append(backend.TLSConfigs, request.LoadBalancerBackendTLSConfig{Name: config.Name, CertificateBundleUUID: config.CertificateBundleUUID})
+	}
+	return backend
+}
+
+func loadBalancerBackendMemberPayload(m request.ApplyLoadBalancerBackendMember) request.LoadBalancerBackendMember {
+	member := request.LoadBalancerBackendMember{
+		Name:        m.Name,
+		Weight:      defaultLoadBalancerBackendMemberWeight,
+		MaxSessions: m.MaxSessions,
+		Enabled:     true,
+		Type:        m.Type,
+		IP:          m.IP,
+		Port:        m.Port,
+	}
+	if m.Weight != nil {
+		member.Weight = *m.Weight
+	}
+	if m.Enabled != nil {
+		member.Enabled = *m.Enabled
+	}
+	if member.Type == "" {
+		member.Type = upcloud.LoadBalancerBackendMemberTypeStatic
+	}
+	return member
+}
+
+func loadBalancerFrontendPayload(f upcloud.LoadBalancerFrontend) request.LoadBalancerFrontend {
+	frontend := request.LoadBalancerFrontend{
+		Name:           f.Name,
+		Mode:           f.Mode,
+		Port:           f.Port,
+		DefaultBackend: f.DefaultBackend,
+		Properties:     f.Properties,
+		Networks:       f.Networks,
+	}
+	for _, rule := range f.Rules {
+		frontend.Rules = append(frontend.Rules, loadBalancerFrontendRulePayload(rule))
+	}
+	for _, config := range f.TLSConfigs {
+		frontend.TLSConfigs = append(frontend.TLSConfigs, request.LoadBalancerFrontendTLSConfig{Name: config.Name, CertificateBundleUUID: config.CertificateBundleUUID})
+	}
+	return frontend
+}
+
+func loadBalancerFrontendRulePayload(r upcloud.LoadBalancerFrontendRule) request.LoadBalancerFrontendRule {
+	rule := request.LoadBalancerFrontendRule{
+		Name:              r.Name,
+		Priority:          r.Priority,
+		MatchingCondition: r.MatchingCondition,
+		Matchers:          r.Matchers,
+		Actions:           r.Actions,
+	}
+	if rule.Matchers == nil {
+		rule.Matchers = []upcloud.LoadBalancerMatcher{}
+	}
+	if rule.Actions == nil {
+		rule.Actions = []upcloud.LoadBalancerAction{}
+	}
+	return rule
+}
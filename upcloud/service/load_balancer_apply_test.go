@@ -0,0 +1,225 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/client"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestApplyLoadBalancer(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/load-balancer/lb-1", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `{
+			"uuid": "lb-1",
+			"name": "web",
+			"plan": "development",
+			"configured_status": "started",
+			"operational_state": "running",
+			"resolvers": [{"name": "dns", "nameservers": ["10.0.0.1"], "retries": 5}],
+			"backends": [
+				{"name": "old", "members": [{"name": "m1", "ip": "10.0.0.5", "port": 80, "weight": 100, "type": "static", "enabled": true}]},
+				{"name": "web", "resolver": "dns", "members": [
+					{"name": "w1", "ip": "10.0.0.10", "port": 80, "weight": 100, "max_sessions": 1000, "type": "static", "enabled": true},
+					{"name": "w2", "ip": "10.0.0.11", "port": 80, "weight": 100, "max_sessions": 1000, "type": "static", "enabled": true}
+				]}
+			],
+			"frontends": [
+				{"name": "admin", "mode": "http", "port": 8080, "default_backend": "old"},
+				{"name": "https", "mode": "http", "port": 443, "default_backend": "web", "rules": [
+					{"name": "legacy", "priority": 10, "matching_condition": "and",
+						"matchers": [{"type": "path", "inverse": false, "match_path": {"method": "starts", "value": "/legacy", "ignore_case": false}}],
+						"actions": [{"type": "use_backend", "action_use_backend": {"backend": "old"}}]},
+					{"name": "internal", "priority": 20, "matching_condition": "and",
+						"matchers": [{"type": "src_ip", "inverse": false, "match_src_ip": {"value": "10.0.0.0/8"}}],
+						"actions": [{"type": "use_backend", "action_use_backend": {"backend": "web"}}]}
+				]}
+			]
+		}`)
+	})
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.Method+" "+r.URL.Path)
+		_, _ = fmt.Fprint(w, `{}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	desired := upcloud.LoadBalancer{
+		UUID:      "lb-1",
+		Resolvers: []upcloud.LoadBalancerResolver{{Name: "dns", Nameservers: []string{"10.0.0.1"}, Retries: 5}},
+		Backends:  []upcloud.LoadBalancerBackend{{Name: "web", Resolver: "dns"}, {Name: "api"}},
+		Frontends: []upcloud.LoadBalancerFrontend{
+			{Name: "https", Mode: upcloud.LoadBalancerModeHTTP, Port: 443, DefaultBackend: "web", Rules: []upcloud.LoadBalancerFrontendRule{
+				{
+					Name:     "internal",
+					Priority: 20,
+					Matchers: []upcloud.LoadBalancerMatcher{request.NewLoadBalancerSrcIPMatcher("10.0.0.0/8")},
+					Actions:  []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("web")},
+				},
+				{
+					Name:     "api",
+					Priority: 30,
+					Matchers: []upcloud.LoadBalancerMatcher{request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodStarts, "/api", nil)},
+					Actions:  []upcloud.LoadBalancerAction{request.NewLoadBalancerUseBackendAction("api")},
+				},
+			}},
+		},
+	}
+
+	members := map[string][]request.ApplyLoadBalancerBackendMember{
+		"web": {
+			{Name: "w1", IP: "10.0.0.10", Port: 80, Weight: upcloud.IntPtr(50), MaxSessions: 1000},
+			{Name: "w3", IP: "10.0.0.12", Port: 80, Weight: upcloud.IntPtr(50), MaxSessions: 1000},
+		},
+		"api": {
+			{Name: "a1", IP: "10.0.0.20", Port: 8000, MaxSessions: 1000},
+		},
+	}
+
+	plan, err := svc.ApplyLoadBalancer(context.Background(), &request.ApplyLoadBalancerRequest{LoadBalancer: desired, BackendMembers: members, DryRun: true})
+	require.NoError(t, err)
+	assert.False(t, plan.Applied)
+	assert.Empty(t, calls)
+	assert.Equal(t, `Load balancer web (lb-1):
+~ backend-member web/w1: weight
++ backend-member web/w3
+- backend-member web/w2
++ backend api
++ backend-member api/a1
+- frontend-rule https/legacy
+- frontend admin
++ frontend-rule https/api
+- backend old
+`, plan.String())
+
+	plan, err = svc.ApplyLoadBalancer(context.Background(), &request.ApplyLoadBalancerRequest{LoadBalancer: desired, BackendMembers: members})
+	require.NoError(t, err)
+	assert.True(t, plan.Applied)
+	assert.Equal(t, []string{
+		"PATCH /1.3/load-balancer/lb-1/backends/web/members/w1",
+		"POST /1.3/load-balancer/lb-1/backends/web/members",
+		"DELETE /1.3/load-balancer/lb-1/backends/web/members/w2",
+		"POST /1.3/load-balancer/lb-1/backends",
+		"DELETE /1.3/load-balancer/lb-1/frontends/https/rules/legacy",
+		"DELETE /1.3/load-balancer/lb-1/frontends/admin",
+		"POST /1.3/load-balancer/lb-1/frontends/https/rules",
+		"DELETE /1.3/load-balancer/lb-1/backends/old",
+	}, calls)
+
+	desired.Backends = []upcloud.LoadBalancerBackend{{Name: "web", Members: []upcloud.LoadBalancerBackendMember{{Name: "w1"}}}}
+	_, err = svc.ApplyLoadBalancer(context.Background(), &request.ApplyLoadBalancerRequest{LoadBalancer: desired, BackendMembers: members})
+	assert.ErrorIs(t, err, ErrInvalidLoadBalancerConfiguration)
+	assert.EqualError(t, err, `invalid load balancer configuration: members of backend "web" must be set in BackendMembers`+"\n"+
+		`invalid load balancer configuration: members refer to unknown backend "api"`+"\n"+
+		`invalid load balancer configuration: rule "api" of frontend "https" refers to unknown backend "api"`)
+}
+
+func TestApplyLoadBalancer_Create(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/load-balancer", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `[{"uuid": "lb-1", "name": "other"}]`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	plan, err := svc.ApplyLoadBalancer(context.Background(), &request.ApplyLoadBalancerRequest{
+		LoadBalancer: upcloud.LoadBalancer{
+			Name:      "web",
+			Zone:      "fi-hel1",
+			Plan:      "development",
+			Backends:  []upcloud.LoadBalancerBackend{{Name: "web"}},
+			Frontends: []upcloud.LoadBalancerFrontend{{Name: "http", Mode: upcloud.LoadBalancerModeHTTP, Port: 80, DefaultBackend: "web"}},
+		},
+		BackendMembers: map[string][]request.ApplyLoadBalancerBackendMember{"web": {{Name: "w1", IP: "10.0.0.10", Port: 80}}},
+		DryRun:         true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, `Load balancer web:
++ load-balancer web
++ backend web
++ backend-member web/w1
++ frontend http
+`, plan.String())
+}
+
+func TestPlanBackendMembers(t *testing.T) {
+	t.Parallel()
+
+	current := []upcloud.LoadBalancerBackendMember{
+		{Name: "w1", IP: "10.0.0.10", Port: 80, Weight: 100, MaxSessions: 1000, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+		{Name: "w2", IP: "10.0.0.11", Port: 80, Weight: 100, MaxSessions: 1000, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+		{Name: "w3", IP: "10.0.0.12", Port: 80, Weight: 100, MaxSessions: 1000, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+	}
+	desired := []request.ApplyLoadBalancerBackendMember{
+		// IP, Port, MaxSessions and Enabled left out are not compared or sent
+		{Name: "w1", Weight: upcloud.IntPtr(50)},
+		{Name: "w2", IP: "10.0.0.21", Port: 8080, MaxSessions: 500},
+		// A weight of zero and a disabled member are compared when set
+		{Name: "w3", IP: "10.0.0.12", Port: 80, Weight: upcloud.IntPtr(0), Enabled: upcloud.BoolPtr(false)},
+	}
+
+	var bodies []string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		bodies = append(bodies, r.Method+" "+r.URL.Path+" "+string(body))
+		_, _ = fmt.Fprint(w, `{}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	p := &loadBalancerPlanner{s: svc, uuid: "lb-1"}
+	p.planBackendMembers("web", current, desired)
+	var changes []string
+	for _, op := range p.operations() {
+		changes = append(changes, op.change.String())
+		require.NoError(t, op.apply(context.Background()))
+	}
+	assert.Equal(t, []string{
+		"~ backend-member web/w1: weight",
+		"~ backend-member web/w2: ip, port, max_sessions",
+		"~ backend-member web/w3: weight, enabled",
+	}, changes)
+	assert.Equal(t, []string{
+		fmt.Sprintf(`PATCH /%s/load-balancer/lb-1/backends/web/members/w1 {"weight":50}`, client.APIVersion),
+		fmt.Sprintf(`PATCH /%s/load-balancer/lb-1/backends/web/members/w2 {"max_sessions":500,"ip":"10.0.0.21","port":8080}`, client.APIVersion),
+		fmt.Sprintf(`PATCH /%s/load-balancer/lb-1/backends/web/members/w3 {"weight":0,"enabled":false,"ip":"10.0.0.12","port":80}`, client.APIVersion),
+	}, bodies)
+}
+
+func TestChangedRuleFields(t *testing.T) {
+	t.Parallel()
+
+	current := upcloud.LoadBalancerFrontendRule{
+		Name:              "rule",
+		Priority:          10,
+		MatchingCondition: upcloud.LoadBalancerMatchingConditionAnd,
+		Matchers:          []upcloud.LoadBalancerMatcher{request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodExact, "/", upcloud.BoolPtr(false))},
+		Actions:           []upcloud.LoadBalancerAction{request.NewLoadBalancerTCPRejectAction()},
+	}
+	current.Matchers[0].Inverse = upcloud.BoolPtr(false)
+	desired := upcloud.LoadBalancerFrontendRule{
+		Name:     "rule",
+		Priority: 10,
+		Matchers: []upcloud.LoadBalancerMatcher{request.NewLoadBalancerPathMatcher(upcloud.LoadBalancerStringMatcherMethodExact, "/", nil)},
+		Actions:  []upcloud.LoadBalancerAction{request.NewLoadBalancerTCPRejectAction()},
+	}
+	assert.Empty(t, changedRuleFields(current, desired))
+	assert.False(t, *current.Matchers[0].Path.IgnoreCase)
+
+	desired.Priority = 20
+	desired.Matchers = []upcloud.LoadBalancerMatcher{request.NewLoadBalancerInverseMatcher(desired.Matchers[0])}
+	assert.Equal(t, []string{"priority", "matchers"}, changedRuleFields(current, desired))
+}
@@ -0,0 +1,134 @@
+package service
+
+import (
+	"context"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// GetLoadBalancerHealth returns a flattened health report of the load balancer: the state of each node, the status
+// of each backend member, the number of members of unknown status per backend, the num_members_up matchers of the
+// frontend rules evaluated against those numbers, and the frontends whose default backend has no available members.
+//
+// The API does not report the results of the health checks of backend members, so a member is only known to be
+// down or disabled from its configuration and the state of the load balancer: a member that is not enabled is
+// disabled, and an enabled member is down if the load balancer or all of its nodes are not running, or if a dynamic
+// member has no IP address. The status of other enabled members is unknown.
+func (s *Service) GetLoadBalancerHealth(ctx context.Context, r *request.GetLoadBalancerHealthRequest) (*upcloud.LoadBalancerHealth, error) {
+	lb, err := s.GetLoadBalancer(ctx, &request.GetLoadBalancerRequest{UUID: r.UUID})
+	if err != nil {
+		return nil, err
+	}
+	return loadBalancerHealth(lb), nil
+}
+
+func loadBalancerHealth(lb *upcloud.LoadBalancer) *upcloud.LoadBalancerHealth {
+	health := &upcloud.LoadBalancerHealth{
+		UUID:             lb.UUID,
+		Name:             lb.Name,
+		ConfiguredStatus: lb.ConfiguredStatus,
+		OperationalState: lb.OperationalState,
+	}
+
+	nodesRunning := 0
+	for _, node := range lb.Nodes {
+		nodeHealth := upcloud.LoadBalancerNodeHealth{OperationalState: node.OperationalState}
+		for _, network := range node.Networks {
+			for _, address := range network.IPAddresses {
+				nodeHealth.IPAddresses = append(nodeHealth.IPAddresses, address.Address)
+			}
+		}
+		if node.OperationalState == upcloud.LoadBalancerNodeOperationalStateRunning {
+			nodesRunning++
+		}
+		health.Nodes = append(health.Nodes, nodeHealth)
+	}
+
+	// reason is the reason for enabled members to be down, if the load balancer can not route traffic to any member
+	reason := ""
+	switch {
+	case lb.OperationalState != upcloud.LoadBalancerOperationalStateRunning:
+		reason = "load balancer is " + string(lb.OperationalState)
+	case len(lb.Nodes) > 0 && nodesRunning == 0:
+		reason = "no load balancer node is running"
+	}
+
+	backends := make(map[string]upcloud.LoadBalancerBackendHealth)
+	for _, backend := range lb.Backends {
+		backendHealth := upcloud.LoadBalancerBackendHealth{Name: backend.Name}
+		for _, member := range backend.Members {
+			memberHealth := loadBalancerMemberHealth(member, reason)
+			if memberHealth.Status == upcloud.LoadBalancerMemberStatusUnknown {
+				backendHealth.MembersUnknown++
+			}
+			backendHealth.Members = append(backendHealth.Members, memberHealth)
+		}
+		backends[backend.Name] = backendHealth
+		health.Backends = append(health.Backends, backendHealth)
+	}
+
+	for _, frontend := range lb.Frontends {
+		frontendHealth := upcloud.LoadBalancerFrontendHealth{
+			Name:                         frontend.Name,
+			DefaultBackend:               frontend.DefaultBackend,
+			DefaultBackendMembersUnknown: backends[frontend.DefaultBackend].MembersUnknown,
+		}
+		if frontend.DefaultBackend != "" && frontendHealth.DefaultBackendMembersUnknown == 0 {
+			health.UnavailableFrontends = append(health.UnavailableFrontends, frontend.Name)
+		}
+		for _, rule := range frontend.Rules {
+			for _, matcher := range rule.Matchers {
+				if matcher.NumMembersUp == nil {
+					continue
+				}
+				backend := backends[matcher.NumMembersUp.Backend]
+				m := upcloud.LoadBalancerNumMembersUpMatcherHealth{
+					Rule:           rule.Name,
+					Backend:        matcher.NumMembersUp.Backend,
+					Method:         matcher.NumMembersUp.Method,
+					Value:          matcher.NumMembersUp.Value,
+					Inverse:        matcher.Inverse != nil && *matcher.Inverse,
+					MembersUnknown: backend.MembersUnknown,
+				}
+				m.Matches = matchLoadBalancerMembersUp(*matcher.NumMembersUp, backend, m.Inverse)
+				frontendHealth.NumMembersUpMatchers = append(frontendHealth.NumMembersUpMatchers, m)
+			}
+		}
+		health.Frontends = append(health.Frontends, frontendHealth)
+	}
+	return health
+}
+
+func loadBalancerMemberHealth(member upcloud.LoadBalancerBackendMember, reason string) upcloud.LoadBalancerMemberHealth {
+	health := upcloud.LoadBalancerMemberHealth{
+		Name:   member.Name,
+		Type:   member.Type,
+		IP:     member.IP,
+		Port:   member.Port,
+		Status: upcloud.LoadBalancerMemberStatusUnknown,
+	}
+	switch {
+	case !member.Enabled:
+		health.Status = upcloud.LoadBalancerMemberStatusDisabled
+	case reason != "":
+		health.Status = upcloud.LoadBalancerMemberStatusDown
+		health.Reason = reason
+	case member.Type == upcloud.LoadBalancerBackendMemberTypeDynamic && member.IP == "":
+		health.Status = upcloud.LoadBalancerMemberStatusDown
+		health.Reason = "dynamic member has no IP address"
+	}
+	return health
+}
+
+// matchLoadBalancerMembersUp evaluates the matcher for every possible number of members up, from none to all members
+// of unknown status. It returns nil if the results differ.
+func matchLoadBalancerMembersUp(matcher upcloud.LoadBalancerMatcherNumMembersUp, backend upcloud.LoadBalancerBackendHealth, inverse bool) *bool {
+	matches := matcher.Matches(0) != inverse
+	for up := 1; up <= backend.MembersUnknown; up++ {
+		if matcher.Matches(up) != inverse != matches {
+			return nil
+		}
+	}
+	return &matches
+}
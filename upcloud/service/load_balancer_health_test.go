@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/client"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetLoadBalancerHealth(t *testing.T) {
+	t.Parallel()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/load-balancer/lb-1", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `{
+			"uuid": "lb-1",
+			"name": "web",
+			"configured_status": "started",
+			"operational_state": "running",
+			"nodes": [
+				{"operational_state": "running", "networks": [{"name": "public", "type": "public", "ip_addresses": [{"address": "192.0.2.1", "listen": true}]}]},
+				{"operational_state": "failing", "networks": [{"name": "public", "type": "public", "ip_addresses": [{"address": "192.0.2.2", "listen": true}]}]}
+			],
+			"backends": [
+				{"name": "web", "members": [
+					{"name": "w1", "ip": "10.0.0.10", "port": 80, "weight": 100, "type": "static", "enabled": true},
+					{"name": "w2", "ip": "10.0.0.11", "port": 80, "weight": 100, "type": "static", "enabled": false},
+					{"name": "d1", "ip": "", "port": 80, "weight": 100, "type": "dynamic", "enabled": true}
+				]},
+				{"name": "maintenance", "members": [
+					{"name": "m1", "ip": "10.0.0.20", "port": 80, "weight": 100, "type": "static", "enabled": false}
+				]}
+			],
+			"frontends": [
+				{"name": "https", "mode": "http", "port": 443, "default_backend": "web", "rules": [
+					{"name": "sorry", "priority": 10, "matching_condition": "and",
+						"matchers": [{"type": "num_members_up", "inverse": false, "match_num_members_up": {"method": "less", "value": 2, "backend": "web"}}],
+						"actions": [{"type": "use_backend", "action_use_backend": {"backend": "maintenance"}}]},
+					{"name": "healthy", "priority": 20, "matching_condition": "and",
+						"matchers": [{"type": "num_members_up", "inverse": true, "match_num_members_up": {"method": "less", "value": 2, "backend": "web"}}],
+						"actions": [{"type": "set_forwarded_headers", "action_set_forwarded_headers": {}}]}
+				]},
+				{"name": "admin", "mode": "http", "port": 8080, "default_backend": "maintenance"}
+			]
+		}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	health, err := svc.GetLoadBalancerHealth(context.Background(), &request.GetLoadBalancerHealthRequest{UUID: "lb-1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, []upcloud.LoadBalancerNodeHealth{
+		{OperationalState: upcloud.LoadBalancerNodeOperationalStateRunning, IPAddresses: []string{"192.0.2.1"}},
+		{OperationalState: upcloud.LoadBalancerNodeOperationalStateFailing, IPAddresses: []string{"192.0.2.2"}},
+	}, health.Nodes)
+	require.Len(t, health.Backends, 2)
+	assert.Equal(t, 1, health.Backends[0].MembersUnknown)
+	assert.Equal(t, []upcloud.LoadBalancerMemberHealth{
+		{Name: "w1", Type: upcloud.LoadBalancerBackendMemberTypeStatic, IP: "10.0.0.10", Port: 80, Status: upcloud.LoadBalancerMemberStatusUnknown},
+		{Name: "w2", Type: upcloud.LoadBalancerBackendMemberTypeStatic, IP: "10.0.0.11", Port: 80, Status: upcloud.LoadBalancerMemberStatusDisabled},
+		{Name: "d1", Type: upcloud.LoadBalancerBackendMemberTypeDynamic, Port: 80, Status: upcloud.LoadBalancerMemberStatusDown, Reason: "dynamic member has no IP address"},
+	}, health.Backends[0].Members)
+
+	require.Len(t, health.Frontends, 2)
+	assert.Equal(t, []upcloud.LoadBalancerNumMembersUpMatcherHealth{
+		{Rule: "sorry", Backend: "web", Method: upcloud.LoadBalancerIntegerMatcherMethodLess, Value: 2, MembersUnknown: 1, Matches: upcloud.BoolPtr(true)},
+		{Rule: "healthy", Backend: "web", Method: upcloud.LoadBalancerIntegerMatcherMethodLess, Value: 2, Inverse: true, MembersUnknown: 1, Matches: upcloud.BoolPtr(false)},
+	}, health.Frontends[0].NumMembersUpMatchers)
+	assert.Equal(t, []string{"admin"}, health.UnavailableFrontends)
+	require.Len(t, health.DownMembers(), 1)
+	assert.Equal(t, "d1", health.DownMembers()[0].Name)
+}
+
+func TestLoadBalancerHealth_NotRunning(t *testing.T) {
+	t.Parallel()
+
+	health := loadBalancerHealth(&upcloud.LoadBalancer{
+		OperationalState: upcloud.LoadBalancerOperationalStateSetupLB,
+		Backends: []upcloud.LoadBalancerBackend{{Name: "web", Members: []upcloud.LoadBalancerBackendMember{
+			{Name: "w1", IP: "10.0.0.10", Port: 80, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+		}}},
+		// Frontends without a default backend route by their rules only
+		Frontends: []upcloud.LoadBalancerFrontend{{Name: "https", DefaultBackend: "web"}, {Name: "rules"}},
+	})
+	assert.Equal(t, upcloud.LoadBalancerMemberStatusDown, health.Backends[0].Members[0].Status)
+	assert.Equal(t, "load balancer is setup-lb", health.Backends[0].Members[0].Reason)
+	assert.Equal(t, []string{"https"}, health.UnavailableFrontends)
+
+	health = loadBalancerHealth(&upcloud.LoadBalancer{
+		OperationalState: upcloud.LoadBalancerOperationalStateRunning,
+		Nodes:            []upcloud.LoadBalancerNode{{OperationalState: upcloud.LoadBalancerNodeOperationalStateRunning}},
+		Backends: []upcloud.LoadBalancerBackend{{Name: "web", Members: []upcloud.LoadBalancerBackendMember{
+			{Name: "w1", IP: "10.0.0.10", Port: 80, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+			{Name: "w2", IP: "10.0.0.11", Port: 80, Type: upcloud.LoadBalancerBackendMemberTypeStatic, Enabled: true},
+		}}},
+		Frontends: []upcloud.LoadBalancerFrontend{{Name: "https", DefaultBackend: "web", Rules: []upcloud.LoadBalancerFrontendRule{{
+			Name:     "sorry",
+			Matchers: []upcloud.LoadBalancerMatcher{request.NewLoadBalancerNumMembersUpMatcher(upcloud.LoadBalancerIntegerMatcherMethodLess, 2, "web")},
+		}}}},
+	})
+	// Without health check results, the members are not reported as up
+	assert.Equal(t, upcloud.LoadBalancerMemberStatusUnknown, health.Backends[0].Members[0].Status)
+	assert.Equal(t, 2, health.Frontends[0].DefaultBackendMembersUnknown)
+	assert.Empty(t, health.UnavailableFrontends)
+	assert.Empty(t, health.DownMembers())
+	// Whether less than 2 members are up depends on the members of unknown status
+	assert.Nil(t, health.Frontends[0].NumMembersUpMatchers[0].Matches)
+}
@@ -2,6 +2,10 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
@@ -27,8 +31,12 @@ type Network interface {
 	CreateRouter(ctx context.Context, r *request.CreateRouterRequest) (*upcloud.Router, error)
 	ModifyRouter(ctx context.Context, r *request.ModifyRouterRequest) (*upcloud.Router, error)
 	DeleteRouter(ctx context.Context, r *request.DeleteRouterRequest) error
+	EnsureRouterStaticRoutes(ctx context.Context, r *request.EnsureRouterStaticRoutesRequest) (*upcloud.Router, error)
 }
 
+// ErrInvalidRouterStaticRoute is returned when a desired static route cannot be added to a router.
+var ErrInvalidRouterStaticRoute = errors.New("invalid router static route")
+
 // GetNetworks returns the all the available networks
 func (s *Service) GetNetworks(ctx context.Context, f ...request.QueryFilter) (*upcloud.Networks, error) {
 	req := request.GetNetworksRequest{Filters: f}
@@ -136,3 +144,127 @@ func (s *Service) ModifyRouter(ctx context.Context, r *request.ModifyRouterReque
 func (s *Service) DeleteRouter(ctx context.Context, r *request.DeleteRouterRequest) error {
 	return s.delete(ctx, r)
 }
+
+// EnsureRouterStaticRoutes makes the user managed static routes of the router match the desired routes. Because
+// ModifyRouter replaces the static routes wholesale, the service managed routes of the router, e.g. the routes of
+// a gateway, are sent back unchanged along with the desired routes. Each next hop must be inside an IP network of
+// a network attached to the router. The router is modified only if the routes differ; the order of the routes and
+// the names of desired routes without a name are not compared. The returned router is the current router if
+// nothing was changed.
+func (s *Service) EnsureRouterStaticRoutes(ctx context.Context, r *request.EnsureRouterStaticRoutesRequest) (*upcloud.Router, error) {
+	router, err := s.GetRouterDetails(ctx, &request.GetRouterDetailsRequest{UUID: r.RouterUUID})
+	if err != nil {
+		return nil, err
+	}
+
+	var prefixes []netip.Prefix
+	for _, network := range router.AttachedNetworks {
+		details, err := s.GetNetworkDetails(ctx, &request.GetNetworkDetailsRequest{UUID: network.NetworkUUID})
+		if err != nil {
+			return nil, err
+		}
+		for _, ipNetwork := range details.IPNetworks {
+			if prefix, err := netip.ParsePrefix(ipNetwork.Address); err == nil {
+				prefixes = append(prefixes, prefix.Masked())
+			}
+		}
+	}
+
+	var serviceRoutes, userRoutes []upcloud.StaticRoute
+	for _, route := range router.StaticRoutes {
+		if route.Type == upcloud.RouterStaticRouteTypeService {
+			serviceRoutes = append(serviceRoutes, route)
+		} else {
+			userRoutes = append(userRoutes, route)
+		}
+	}
+
+	desired, err := validateRouterStaticRoutes(r.StaticRoutes, serviceRoutes, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	if sameStaticRoutes(userRoutes, desired) {
+		return router, nil
+	}
+
+	routes := make([]upcloud.StaticRoute, 0, len(serviceRoutes)+len(desired))
+	routes = append(routes, serviceRoutes...)
+	routes = append(routes, desired...)
+	return s.ModifyRouter(ctx, &request.ModifyRouterRequest{
+		UUID:         router.UUID,
+		Name:         router.Name,
+		StaticRoutes: &routes,
+	})
+}
+
+// validateRouterStaticRoutes returns the desired routes in canonical form, or an error for each route that has an
+// invalid destination, a next hop outside the attached networks, or a destination used by another route.
+func validateRouterStaticRoutes(desired, serviceRoutes []upcloud.StaticRoute, attached []netip.Prefix) ([]upcloud.StaticRoute, error) {
+	var errs []error
+	var routes []upcloud.StaticRoute
+	managed := make(map[netip.Prefix]bool)
+	for _, route := range serviceRoutes {
+		if prefix, err := netip.ParsePrefix(route.Route); err == nil {
+			managed[prefix.Masked()] = true
+		}
+	}
+	seen := make(map[netip.Prefix]bool)
+
+	for _, route := range desired {
+		if route.Type == upcloud.RouterStaticRouteTypeService {
+			errs = append(errs, fmt.Errorf("%w: route %s is service managed", ErrInvalidRouterStaticRoute, route.Route))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(route.Route)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: %w", ErrInvalidRouterStaticRoute, err))
+			continue
+		}
+		prefix = prefix.Masked()
+		if managed[prefix] {
+			errs = append(errs, fmt.Errorf("%w: route %s conflicts with a service managed route", ErrInvalidRouterStaticRoute, prefix))
+			continue
+		}
+		if seen[prefix] {
+			errs = append(errs, fmt.Errorf("%w: route %s is defined more than once", ErrInvalidRouterStaticRoute, prefix))
+			continue
+		}
+		seen[prefix] = true
+		nexthop, err := netip.ParseAddr(route.Nexthop)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%w: route %s: %w", ErrInvalidRouterStaticRoute, prefix, err))
+			continue
+		}
+		if !slices.ContainsFunc(attached, func(p netip.Prefix) bool { return p.Contains(nexthop) }) {
+			errs = append(errs, fmt.Errorf("%w: next hop %s of route %s is not in a network attached to the router", ErrInvalidRouterStaticRoute, nexthop, prefix))
+			continue
+		}
+		routes = append(routes, upcloud.StaticRoute{
+			Name:    route.Name,
+			Route:   prefix.String(),
+			Nexthop: nexthop.String(),
+			Type:    route.Type,
+		})
+	}
+	return routes, errors.Join(errs...)
+}
+
+// sameStaticRoutes tells whether the current user routes match the desired routes regardless of the order
+func sameStaticRoutes(current, desired []upcloud.StaticRoute) bool {
+	if len(current) != len(desired) {
+		return false
+	}
+	for _, d := range desired {
+		if !slices.ContainsFunc(current, func(c upcloud.StaticRoute) bool {
+			prefix, err := netip.ParsePrefix(c.Route)
+			if err != nil || prefix.Masked().String() != d.Route {
+				return false
+			}
+			nexthop, err := netip.ParseAddr(c.Nexthop)
+			return err == nil && nexthop.String() == d.Nexthop && (d.Name == "" || d.Name == c.Name)
+		}) {
+			return false
+		}
+	}
+	return true
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"testing"
 	"time"
@@ -951,3 +952,97 @@ func TestRouterLabelsAndFilters(t *testing.T) {
 		require.NoError(t, svc.DeleteRouter(ctx, &request.DeleteRouterRequest{UUID: r2.UUID}))
 	})
 }
+
+// TestEnsureRouterStaticRoutes ensures that EnsureRouterStaticRoutes() keeps the service managed routes, validates
+// the next hops and modifies the router only when the user routes change.
+func TestEnsureRouterStaticRoutes(t *testing.T) {
+	t.Parallel()
+
+	var modified []string
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/router/router-1", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `{"router": {
+			"uuid": "router-1",
+			"name": "main",
+			"attached_networks": {"network": [{"uuid": "net-1"}]},
+			"static_routes": [
+				{"name": "vpn", "route": "172.20.0.0/16", "nexthop": "10.0.0.254", "type": "service"},
+				{"name": "backup", "route": "192.168.0.0/24", "nexthop": "10.0.0.10", "type": "user"}
+			]
+		}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/network/net-1", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `{"network": {"uuid": "net-1", "ip_networks": {"ip_network": [{"address": "10.0.0.0/24", "family": "IPv4"}]}}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("PATCH /%s/router/router-1", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		modified = append(modified, string(body))
+		_, _ = fmt.Fprint(w, `{"router": {"uuid": "router-1", "name": "main"}}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	router, err := svc.EnsureRouterStaticRoutes(context.Background(), &request.EnsureRouterStaticRoutesRequest{
+		RouterUUID:   "router-1",
+		StaticRoutes: []upcloud.StaticRoute{{Route: "192.168.0.1/24", Nexthop: "10.0.0.10"}},
+	})
+	require.NoError(t, err)
+	assert.Len(t, router.StaticRoutes, 2)
+	assert.Empty(t, modified)
+
+	_, err = svc.EnsureRouterStaticRoutes(context.Background(), &request.EnsureRouterStaticRoutesRequest{
+		RouterUUID: "router-1",
+		StaticRoutes: []upcloud.StaticRoute{
+			{Name: "backup", Route: "192.168.0.0/24", Nexthop: "10.0.0.10"},
+			{Name: "office", Route: "10.10.0.0/16", Nexthop: "10.0.0.11"},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, modified, 1)
+	assert.JSONEq(t, `{"router": {"name": "main", "static_routes": [
+		{"name": "vpn", "route": "172.20.0.0/16", "nexthop": "10.0.0.254", "type": "service"},
+		{"name": "backup", "route": "192.168.0.0/24", "nexthop": "10.0.0.10"},
+		{"name": "office", "route": "10.10.0.0/16", "nexthop": "10.0.0.11"}
+	]}}`, modified[0])
+
+	_, err = svc.EnsureRouterStaticRoutes(context.Background(), &request.EnsureRouterStaticRoutesRequest{
+		RouterUUID: "router-1",
+		StaticRoutes: []upcloud.StaticRoute{
+			{Route: "10.10.0.0/16", Nexthop: "10.1.0.1"},
+			{Route: "172.20.0.0/16", Nexthop: "10.0.0.1"},
+			{Route: "10.11.0.0/16", Nexthop: "10.0.0.1"},
+			{Route: "10.11.0.0/16", Nexthop: "10.0.0.2"},
+		},
+	})
+	assert.ErrorIs(t, err, ErrInvalidRouterStaticRoute)
+	assert.EqualError(t, err, "invalid router static route: next hop 10.1.0.1 of route 10.10.0.0/16 is not in a network attached to the router\n"+
+		"invalid router static route: route 172.20.0.0/16 conflicts with a service managed route\n"+
+		"invalid router static route: route 10.11.0.0/16 is defined more than once")
+	assert.Len(t, modified, 1)
+}
+
+func TestEnsureRouterStaticRoutesRemovesLastRoute(t *testing.T) {
+	t.Parallel()
+
+	var modified []string
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/router/router-1", client.APIVersion), func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = fmt.Fprint(w, `{"router": {
+			"uuid": "router-1",
+			"name": "main",
+			"static_routes": [{"name": "backup", "route": "192.168.0.0/24", "nexthop": "10.0.0.10", "type": "user"}]
+		}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("PATCH /%s/router/router-1", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		modified = append(modified, string(body))
+		_, _ = fmt.Fprint(w, `{"router": {"uuid": "router-1", "name": "main"}}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	_, err := svc.EnsureRouterStaticRoutes(context.Background(), &request.EnsureRouterStaticRoutesRequest{RouterUUID: "router-1"})
+	require.NoError(t, err)
+	require.Len(t, modified, 1)
+	assert.JSONEq(t, `{"router": {"name": "main", "static_routes": []}}`, modified[0])
+}
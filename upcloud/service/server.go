@@ -2,6 +2,9 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"slices"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
@@ -20,6 +23,29 @@ type Server interface {
 	DeleteServer(ctx context.Context, r *request.DeleteServerRequest) error
 	DeleteServerAndStorages(ctx context.Context, r *request.DeleteServerAndStoragesRequest) error
 	RelocateServer(ctx context.Context, r *request.RelocateServerRequest) (*upcloud.ServerDetails, error)
+	CloneServer(ctx context.Context, r *request.CloneServerRequest) (*upcloud.ServerDetails, error)
+	EnsureServerState(ctx context.Context, r *request.EnsureServerStateRequest) (*upcloud.ServerDetails, error)
+	ResizeServer(ctx context.Context, r *request.ResizeServerRequest) (*upcloud.ServerResize, error)
+}
+
+// ErrInvalidServerStateTransition is returned when a server cannot be moved to the requested state.
+var ErrInvalidServerStateTransition = errors.New("invalid server state transition")
+
+// ServerStateTransitionError describes a server state transition that cannot be performed.
+type ServerStateTransitionError struct {
+	UUID string
+	From string
+	To   string
+}
+
+// Error implements the error interface
+func (e *ServerStateTransitionError) Error() string {
+	return fmt.Sprintf("%s: server %s cannot be moved from %q to %q", ErrInvalidServerStateTransition, e.UUID, e.From, e.To)
+}
+
+// Unwrap allows matching the error with errors.Is(err, ErrInvalidServerStateTransition)
+func (e *ServerStateTransitionError) Unwrap() error {
+	return ErrInvalidServerStateTransition
 }
 
 // GetServerConfigurations returns the available pre-configured server configurations
@@ -124,3 +150,511 @@ func (s *Service) RelocateServer(ctx context.Context, r *request.RelocateServerR
 	serverDetails := upcloud.ServerDetails{}
 	return &serverDetails, s.create(ctx, r, &serverDetails)
 }
+
+// EnsureServerState moves the specified server to the desired state, which is either started or stopped. Servers
+// in maintenance state are waited out before any transitions are made. Soft stops that do not complete within the
+// soft stop timeout are turned into hard stops by the API. Impossible transitions, such as those from or to the error state,
+// are reported with a ServerStateTransitionError.
+func (s *Service) EnsureServerState(ctx context.Context, r *request.EnsureServerStateRequest) (*upcloud.ServerDetails, error) {
+	details, err := s.GetServerDetails(ctx, &request.GetServerDetailsRequest{UUID: r.UUID})
+	if err != nil {
+		return nil, err
+	}
+
+	if r.DesiredState != upcloud.ServerStateStarted && r.DesiredState != upcloud.ServerStateStopped {
+		return nil, &ServerStateTransitionError{UUID: r.UUID, From: details.State, To: r.DesiredState}
+	}
+
+	if details.State == upcloud.ServerStateMaintenance {
+		details, err = s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+			UUID:           r.UUID,
+			UndesiredState: upcloud.ServerStateMaintenance,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case details.State == r.DesiredState:
+		return details, nil
+	case details.State == upcloud.ServerStateStarted && r.DesiredState == upcloud.ServerStateStopped:
+		return s.stopServerAndWait(ctx, r)
+	case details.State == upcloud.ServerStateStopped && r.DesiredState == upcloud.ServerStateStarted:
+		if _, err := s.StartServer(ctx, &request.StartServerRequest{UUID: r.UUID}); err != nil {
+			return nil, err
+		}
+		return s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+			UUID:         r.UUID,
+			DesiredState: upcloud.ServerStateStarted,
+		})
+	default:
+		return nil, &ServerStateTransitionError{UUID: r.UUID, From: details.State, To: r.DesiredState}
+	}
+}
+
+// stopServerAndWait stops the server and waits for it to reach the stopped state. Soft stops are sent with the
+// soft stop timeout, after which the API stops the server forcibly.
+func (s *Service) stopServerAndWait(ctx context.Context, r *request.EnsureServerStateRequest) (*upcloud.ServerDetails, error) {
+	stop := &request.StopServerRequest{UUID: r.UUID, StopType: r.StopType}
+	if stop.StopType == "" {
+		stop.StopType = request.ServerStopTypeSoft
+	}
+	if stop.StopType == request.ServerStopTypeSoft {
+		stop.Timeout = r.SoftStopTimeout
+		if stop.Timeout <= 0 {
+			stop.Timeout = request.DefaultSoftStopTimeout
+		}
+	}
+
+	if _, err := s.StopServer(ctx, stop); err != nil {
+		return nil, err
+	}
+	return s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+		UUID:         r.UUID,
+		DesiredState: upcloud.ServerStateStopped,
+	})
+}
+
+// ResizeServer changes the plan, or the custom core and memory configuration, of the specified server. The target
+// configuration is validated against the plans and server configurations available in the server's zone before the
+// server is stopped, modified, optionally given a larger OS disk, and returned to its original state. A server that
+// was started is started again also when the resize fails after stopping it; errors of the start are joined.
+func (s *Service) ResizeServer(ctx context.Context, r *request.ResizeServerRequest) (*upcloud.ServerResize, error) {
+	server, err := s.GetServerDetails(ctx, &request.GetServerDetailsRequest{UUID: r.UUID})
+	if err != nil {
+		return nil, err
+	}
+
+	plan := r.Plan
+	if plan == "" {
+		plan = "custom"
+	}
+	if plan != "custom" && (r.CoreNumber != 0 || r.MemoryAmount != 0) {
+		return nil, fmt.Errorf("core number and memory amount cannot be specified with plan %s", plan)
+	}
+	if plan == "custom" && (r.CoreNumber == 0 || r.MemoryAmount == 0) {
+		return nil, errors.New("either plan or both core number and memory amount must be specified")
+	}
+
+	prices, err := s.GetPricesByZone(ctx)
+	if err != nil {
+		return nil, err
+	}
+	zonePrices := (*prices)[server.Zone]
+
+	if plan == "custom" {
+		// Custom configurations are available in the zones that price cores and memory
+		_, core := zonePrices["server_core"]
+		_, memory := zonePrices["server_memory"]
+		if zonePrices != nil && (!core || !memory) {
+			return nil, fmt.Errorf("custom server configurations are not available in zone %s", server.Zone)
+		}
+		configurations, err := s.GetServerConfigurations(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(configurations.ServerConfigurations, upcloud.ServerConfiguration{CoreNumber: r.CoreNumber, MemoryAmount: r.MemoryAmount}) {
+			return nil, fmt.Errorf("server configuration with %d cores and %d MB of memory is not available in zone %s", r.CoreNumber, r.MemoryAmount, server.Zone)
+		}
+	} else {
+		plans, err := s.GetPlans(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if !slices.ContainsFunc(plans.Plans, func(p upcloud.Plan) bool { return p.Name == plan }) {
+			return nil, fmt.Errorf("plan %s does not exist", plan)
+		}
+		if _, ok := zonePrices[serverPlanPriceItem(plan)]; zonePrices != nil && !ok {
+			return nil, fmt.Errorf("plan %s is not available in zone %s", plan, server.Zone)
+		}
+	}
+
+	osDisk := serverOSDisk(server)
+	growDisk := r.StorageSize > 0 && osDisk != nil && r.StorageSize > osDisk.Size
+	if r.StorageSize > 0 && osDisk == nil {
+		return nil, fmt.Errorf("server %s does not have an OS disk", server.UUID)
+	}
+	if r.StorageSize > 0 && r.StorageSize < osDisk.Size {
+		return nil, fmt.Errorf("OS disk cannot be shrunk from %d GB to %d GB", osDisk.Size, r.StorageSize)
+	}
+
+	result := &upcloud.ServerResize{
+		Server:   server,
+		FromPlan: server.Plan,
+		ToPlan:   plan,
+		PriceDifference: serverPrice(zonePrices, plan, r.CoreNumber, r.MemoryAmount) -
+			serverPrice(zonePrices, server.Plan, server.CoreNumber, server.MemoryAmount),
+	}
+	if growDisk {
+		storagePrice := zonePrices["storage_"+osDisk.Tier]
+		if storagePrice.Amount > 0 {
+			result.PriceDifference += float64(r.StorageSize-osDisk.Size) * storagePrice.Price / float64(storagePrice.Amount)
+		}
+	}
+	if r.DryRun {
+		return result, nil
+	}
+
+	originalState := server.State
+	if originalState == upcloud.ServerStateMaintenance {
+		// Restore the state the server has after the ongoing maintenance
+		waited, err := s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+			UUID:           server.UUID,
+			UndesiredState: upcloud.ServerStateMaintenance,
+		})
+		if err != nil {
+			return nil, err
+		}
+		originalState = waited.State
+	}
+
+	if _, err := s.EnsureServerState(ctx, &request.EnsureServerStateRequest{
+		UUID:            server.UUID,
+		DesiredState:    upcloud.ServerStateStopped,
+		StopType:        r.StopType,
+		SoftStopTimeout: r.SoftStopTimeout,
+	}); err != nil {
+		return nil, err
+	}
+
+	var osDiskUUID string
+	if growDisk {
+		osDiskUUID = osDisk.UUID
+	}
+	if result.Server, err = s.resizeStoppedServer(ctx, r, plan, osDiskUUID); err != nil {
+		if originalState == upcloud.ServerStateStarted {
+			// Best effort: bring the server back up even if the context of the resize was cancelled
+			_, startErr := s.EnsureServerState(context.WithoutCancel(ctx), &request.EnsureServerStateRequest{
+				UUID:         server.UUID,
+				DesiredState: upcloud.ServerStateStarted,
+			})
+			err = errors.Join(err, startErr)
+		}
+		return nil, err
+	}
+
+	if originalState == upcloud.ServerStateStarted {
+		if result.Server, err = s.EnsureServerState(ctx, &request.EnsureServerStateRequest{
+			UUID:         server.UUID,
+			DesiredState: upcloud.ServerStateStarted,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+// resizeStoppedServer modifies the stopped server and grows the OS disk with the given UUID, if any.
+func (s *Service) resizeStoppedServer(ctx context.Context, r *request.ResizeServerRequest, plan, osDiskUUID string) (*upcloud.ServerDetails, error) {
+	modifyRequest := &request.ModifyServerRequest{UUID: r.UUID, Plan: plan}
+	if plan == "custom" {
+		modifyRequest.CoreNumber = r.CoreNumber
+		modifyRequest.MemoryAmount = r.MemoryAmount
+	}
+	server, err := s.ModifyServer(ctx, modifyRequest)
+	if err != nil || osDiskUUID == "" {
+		return server, err
+	}
+
+	if _, err := s.ModifyStorage(ctx, &request.ModifyStorageRequest{UUID: osDiskUUID, Size: r.StorageSize}); err != nil {
+		return nil, err
+	}
+	waitRequest := &request.WaitForStorageStateRequest{UUID: osDiskUUID, DesiredState: upcloud.StorageStateOnline}
+	if _, err := s.WaitForStorageState(ctx, waitRequest); err != nil {
+		return nil, err
+	}
+	if _, err := s.ResizeStorageFilesystem(ctx, &request.ResizeStorageFilesystemRequest{UUID: osDiskUUID}); err != nil {
+		return nil, err
+	}
+	if _, err := s.WaitForStorageState(ctx, waitRequest); err != nil {
+		return nil, err
+	}
+	return server, nil
+}
+
+// serverOSDisk returns the boot disk of the server, or the first disk if no disk is marked as the boot disk.
+func serverOSDisk(server *upcloud.ServerDetails) *upcloud.ServerStorageDevice {
+	var first *upcloud.ServerStorageDevice
+	for i, device := range server.StorageDevices {
+		if device.Type != upcloud.StorageTypeDisk {
+			continue
+		}
+		if device.BootDisk == 1 {
+			return &server.StorageDevices[i]
+		}
+		if first == nil {
+			first = &server.StorageDevices[i]
+		}
+	}
+	return first
+}
+
+func serverPlanPriceItem(plan string) string {
+	return "server_plan_" + plan
+}
+
+// serverPrice returns the hourly price of a plan, or of a custom configuration, from the zone prices.
+func serverPrice(zonePrices map[string]upcloud.Price, plan string, coreNumber, memoryAmount int) float64 {
+	if plan != "custom" {
+		return zonePrices[serverPlanPriceItem(plan)].Price
+	}
+
+	var price float64
+	if core := zonePrices["server_core"]; core.Amount > 0 {
+		price += float64(coreNumber) * core.Price / float64(core.Amount)
+	}
+	if memory := zonePrices["server_memory"]; memory.Amount > 0 {
+		price += float64(memoryAmount) * memory.Price / float64(memory.Amount)
+	}
+	return price
+}
+
+// CloneServer creates a copy of the specified server. Disk storages are cloned and the plan, firewall, NIC model,
+// networking and labels are copied from the source server unless overridden in the request. A clone in the source
+// server's zone is made from storage clones, a clone in another zone is created from templates of the source storages.
+//
+// The storages of a started source server are copied while they are in use, which leaves the clone with crash
+// consistent file systems only. Set StopSource in the request to stop the source server for the duration of the
+// copy and start it again afterwards.
+func (s *Service) CloneServer(ctx context.Context, r *request.CloneServerRequest) (*upcloud.ServerDetails, error) {
+	source, err := s.GetServerDetails(ctx, &request.GetServerDetailsRequest{UUID: r.UUID})
+	if err != nil {
+		return nil, err
+	}
+
+	createRequest, err := newCloneServerCreateRequest(source, r)
+	if err != nil {
+		return nil, err
+	}
+
+	var firewallRules []upcloud.FirewallRule
+	if source.Firewall == "on" {
+		rules, err := s.GetFirewallRules(ctx, &request.GetFirewallRulesRequest{ServerUUID: source.UUID})
+		if err != nil {
+			return nil, err
+		}
+		for _, rule := range rules.FirewallRules {
+			// Positions are assigned by the API when the rule set is created
+			rule.Position = 0
+			firewallRules = append(firewallRules, rule)
+		}
+	}
+
+	restart := r.StopSource && source.State == upcloud.ServerStateStarted
+	if restart {
+		if _, err := s.EnsureServerState(ctx, &request.EnsureServerStateRequest{
+			UUID:            source.UUID,
+			DesiredState:    upcloud.ServerStateStopped,
+			StopType:        r.StopType,
+			SoftStopTimeout: r.SoftStopTimeout,
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	clones, templates, err := s.copyServerStorages(ctx, source, createRequest, r.StorageTier)
+	if restart {
+		startCtx := ctx
+		if err != nil {
+			// Best effort: bring the source server back up even if the context of the clone was cancelled
+			startCtx = context.WithoutCancel(ctx)
+		}
+		if _, startErr := s.EnsureServerState(startCtx, &request.EnsureServerStateRequest{
+			UUID:         source.UUID,
+			DesiredState: upcloud.ServerStateStarted,
+		}); startErr != nil {
+			err = errors.Join(err, startErr, s.deleteStorages(ctx, append(clones, templates...)))
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	server, err := s.CreateServer(ctx, createRequest)
+	if err != nil {
+		return nil, errors.Join(err, s.deleteStorages(ctx, append(clones, templates...)))
+	}
+
+	if len(firewallRules) > 0 {
+		if err := s.CreateFirewallRules(ctx, &request.CreateFirewallRulesRequest{
+			ServerUUID:    server.UUID,
+			FirewallRules: firewallRules,
+		}); err != nil {
+			return server, fmt.Errorf("unable to copy firewall rules to server %s: %w", server.UUID, err)
+		}
+	}
+
+	if len(templates) > 0 && !r.KeepTemplates {
+		// Templates can be removed only after the new storages have been cloned from them
+		if _, err := s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+			UUID:           server.UUID,
+			UndesiredState: upcloud.ServerStateMaintenance,
+		}); err != nil {
+			return server, err
+		}
+		if err := s.deleteStorages(ctx, templates); err != nil {
+			return server, fmt.Errorf("unable to delete intermediate templates: %w", err)
+		}
+	}
+
+	return server, nil
+}
+
+// copyServerStorages copies the disk storages of the source server and adds them to the create request. Storages
+// are cloned within the zone of the source server and templatized for a clone in another zone. The UUIDs of the
+// created clones and templates are returned; on error the storages created so far have been deleted.
+func (s *Service) copyServerStorages(ctx context.Context, source *upcloud.ServerDetails, createRequest *request.CreateServerRequest, storageTier string) ([]string, []string, error) {
+	crossZone := createRequest.Zone != source.Zone
+	var clones, templates []string
+	for _, device := range source.StorageDevices {
+		if device.Type != upcloud.StorageTypeDisk {
+			continue
+		}
+
+		tier := device.Tier
+		if storageTier != "" {
+			tier = storageTier
+		}
+
+		if crossZone {
+			template, err := s.templatizeAndWait(ctx, device)
+			if template != nil {
+				templates = append(templates, template.UUID)
+			}
+			if err != nil {
+				return nil, nil, errors.Join(err, s.deleteStorages(ctx, templates))
+			}
+			createRequest.StorageDevices = append(createRequest.StorageDevices, request.CreateServerStorageDevice{
+				Action:  request.CreateServerStorageDeviceActionClone,
+				Address: device.Address,
+				Storage: template.UUID,
+				Title:   device.Title,
+				Size:    device.Size,
+				Tier:    tier,
+			})
+			continue
+		}
+
+		clone, err := s.CloneStorage(ctx, &request.CloneStorageRequest{
+			UUID:      device.UUID,
+			Encrypted: device.Encrypted,
+			Zone:      source.Zone,
+			Tier:      tier,
+			Title:     device.Title,
+		})
+		if err != nil {
+			return nil, nil, errors.Join(err, s.deleteStorages(ctx, clones))
+		}
+		clones = append(clones, clone.UUID)
+		if _, err := s.WaitForStorageState(ctx, &request.WaitForStorageStateRequest{
+			UUID:         clone.UUID,
+			DesiredState: upcloud.StorageStateOnline,
+		}); err != nil {
+			return nil, nil, errors.Join(err, s.deleteStorages(ctx, clones))
+		}
+		createRequest.StorageDevices = append(createRequest.StorageDevices, request.CreateServerStorageDevice{
+			Action:  request.CreateServerStorageDeviceActionAttach,
+			Address: device.Address,
+			Storage: clone.UUID,
+		})
+	}
+
+	return clones, templates, nil
+}
+
+// templatizeAndWait creates a template of the specified storage device and waits for the template to come online.
+func (s *Service) templatizeAndWait(ctx context.Context, device upcloud.ServerStorageDevice) (*upcloud.StorageDetails, error) {
+	template, err := s.TemplatizeStorage(ctx, &request.TemplatizeStorageRequest{
+		UUID:  device.UUID,
+		Title: fmt.Sprintf("%s (clone template)", device.Title),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = s.WaitForStorageState(ctx, &request.WaitForStorageStateRequest{
+		UUID:         template.UUID,
+		DesiredState: upcloud.StorageStateOnline,
+	})
+	return template, err
+}
+
+// deleteStorages deletes the specified storages, e.g. to clean up after a failed operation.
+func (s *Service) deleteStorages(ctx context.Context, uuids []string) error {
+	var errs []error
+	for _, uuid := range uuids {
+		if err := s.DeleteStorage(ctx, &request.DeleteStorageRequest{UUID: uuid}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// newCloneServerCreateRequest builds the create request for a clone of the source server without storage devices.
+func newCloneServerCreateRequest(source *upcloud.ServerDetails, r *request.CloneServerRequest) (*request.CreateServerRequest, error) {
+	createRequest := &request.CreateServerRequest{
+		BootOrder:    source.BootOrder,
+		Firewall:     source.Firewall,
+		Hostname:     source.Hostname,
+		Labels:       r.Labels,
+		Metadata:     source.Metadata,
+		NICModel:     source.NICModel,
+		Networking:   r.Networking,
+		Plan:         source.Plan,
+		SimpleBackup: source.SimpleBackup,
+		TimeZone:     source.Timezone,
+		Title:        r.Title,
+		VideoModel:   source.VideoModel,
+		Zone:         source.Zone,
+	}
+
+	if source.Plan == "custom" {
+		createRequest.CoreNumber = source.CoreNumber
+		createRequest.MemoryAmount = source.MemoryAmount
+	}
+	if r.TargetZone != "" {
+		createRequest.Zone = r.TargetZone
+	}
+	if r.Hostname != "" {
+		createRequest.Hostname = r.Hostname
+	}
+	if createRequest.Title == "" {
+		createRequest.Title = fmt.Sprintf("%s (clone)", source.Title)
+	}
+	if createRequest.Labels == nil && len(source.Labels) > 0 {
+		labels := append(upcloud.LabelSlice{}, source.Labels...)
+		createRequest.Labels = &labels
+	}
+
+	if createRequest.Networking == nil {
+		networking := &request.CreateServerNetworking{}
+		for _, iface := range source.Networking.Interfaces {
+			if iface.Type == upcloud.NetworkTypePrivate && createRequest.Zone != source.Zone {
+				return nil, fmt.Errorf("server %s has private network interfaces which cannot be copied to zone %s, networking must be specified in the request", source.UUID, createRequest.Zone)
+			}
+
+			createInterface := request.CreateServerInterface{
+				Index:             iface.Index,
+				Type:              iface.Type,
+				SourceIPFiltering: iface.SourceIPFiltering,
+				Bootable:          iface.Bootable,
+			}
+			if iface.Type == upcloud.NetworkTypePrivate {
+				createInterface.Network = iface.Network
+			}
+			for _, ip := range iface.IPAddresses {
+				// Addresses are not copied, the clone gets new addresses from the same families
+				if ip.Floating.Bool() {
+					continue
+				}
+				createInterface.IPAddresses = append(createInterface.IPAddresses, request.CreateServerIPAddress{Family: ip.Family})
+			}
+			networking.Interfaces = append(networking.Interfaces, createInterface)
+		}
+		createRequest.Networking = networking
+	}
+
+	return createRequest, nil
+}
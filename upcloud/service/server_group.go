@@ -2,6 +2,11 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
@@ -15,6 +20,34 @@ type ServerGroup interface {
 	DeleteServerGroup(ctx context.Context, r *request.DeleteServerGroupRequest) error
 	AddServerToServerGroup(ctx context.Context, r *request.AddServerToServerGroupRequest) error
 	RemoveServerFromServerGroup(ctx context.Context, r *request.RemoveServerFromServerGroupRequest) error
+	RollingRestartServerGroup(ctx context.Context, groupUUID string, opts RollingRestartOptions) error
+}
+
+// ErrServerInErrorState is returned for server group members that are in error state and can not be restarted
+var ErrServerInErrorState = errors.New("server is in error state")
+
+// ErrServerGroupMembersUnavailable is returned when the members of a server group that are not started leave no
+// room for a rolling restart within MaxUnavailable
+var ErrServerGroupMembersUnavailable = errors.New("too many server group members are unavailable")
+
+// defaultRestartObserveTimeout is the default of RollingRestartOptions.RestartObserveTimeout
+const defaultRestartObserveTimeout = time.Minute
+
+// RollingRestartOptions represents the options of RollingRestartServerGroup
+type RollingRestartOptions struct {
+	// MaxUnavailable is the number of members that may be unavailable at the same time, including the members
+	// that are not started to begin with. Defaults to one.
+	MaxUnavailable int
+	// StopType, Timeout and TimeoutAction are passed to the restart request of each member.
+	StopType      string
+	Timeout       time.Duration
+	TimeoutAction string
+	// HealthCheck is called for each member after it has been started again. The next batch is restarted only
+	// after the health check has succeeded for every member of the current batch.
+	HealthCheck func(ctx context.Context, server *upcloud.ServerDetails) error
+	// RestartObserveTimeout is how long to wait for a restarted member to leave the started state. A member still
+	// started after the timeout is assumed to have been restarted between two polls. Defaults to one minute.
+	RestartObserveTimeout time.Duration
 }
 
 // GetServerGroups retrieves a list of server groups with context (EXPERIMENTAL).
@@ -62,3 +95,123 @@ func (s *Service) AddServerToServerGroup(ctx context.Context, r *request.AddServ
 func (s *Service) RemoveServerFromServerGroup(ctx context.Context, r *request.RemoveServerFromServerGroupRequest) error {
 	return s.delete(ctx, r)
 }
+
+// RollingRestartServerGroup restarts the started members of a server group in batches. Members that are not
+// started are left as they are but count against MaxUnavailable, so each batch holds at most MaxUnavailable
+// servers minus the unavailable members. ErrServerGroupMembersUnavailable is returned before any member is
+// restarted if that leaves no room for a batch. Each batch must be started again, and pass the optional health
+// check, before the next batch is restarted. The restart is stopped on the first failure. Members in error state
+// are reported with ErrServerInErrorState.
+func (s *Service) RollingRestartServerGroup(ctx context.Context, groupUUID string, opts RollingRestartOptions) error {
+	group, err := s.GetServerGroup(ctx, &request.GetServerGroupRequest{UUID: groupUUID})
+	if err != nil {
+		return err
+	}
+
+	if opts.MaxUnavailable < 1 {
+		opts.MaxUnavailable = 1
+	}
+	if opts.RestartObserveTimeout <= 0 {
+		opts.RestartObserveTimeout = defaultRestartObserveTimeout
+	}
+
+	var members, unavailable []string
+	var errs []error
+	for _, uuid := range group.Members {
+		details, err := s.GetServerDetails(ctx, &request.GetServerDetailsRequest{UUID: uuid})
+		if err != nil {
+			return err
+		}
+		if details.State == upcloud.ServerStateMaintenance {
+			details, err = s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+				UUID:           uuid,
+				UndesiredState: upcloud.ServerStateMaintenance,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		switch details.State {
+		case upcloud.ServerStateStarted:
+			members = append(members, uuid)
+		case upcloud.ServerStateError:
+			errs = append(errs, fmt.Errorf("%w: %s", ErrServerInErrorState, uuid))
+			unavailable = append(unavailable, uuid)
+		default:
+			unavailable = append(unavailable, uuid)
+		}
+	}
+
+	batchSize := opts.MaxUnavailable - len(unavailable)
+	if len(members) > 0 && batchSize < 1 {
+		err := fmt.Errorf("%w: %s of at most %d are not started", ErrServerGroupMembersUnavailable,
+			strings.Join(unavailable, ", "), opts.MaxUnavailable)
+		return errors.Join(append(errs, err)...)
+	}
+
+	for start := 0; start < len(members); start += batchSize {
+		batch := members[start:min(start+batchSize, len(members))]
+		if err := s.restartServerBatch(ctx, batch, opts); err != nil {
+			return errors.Join(append(errs, err)...)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// restartServerBatch restarts the specified servers in parallel and waits for them to be started and healthy.
+func (s *Service) restartServerBatch(ctx context.Context, uuids []string, opts RollingRestartOptions) error {
+	errs := make([]error, len(uuids))
+	var wg sync.WaitGroup
+	for i, uuid := range uuids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := s.restartServerAndWait(ctx, uuid, opts); err != nil {
+				errs[i] = fmt.Errorf("rolling restart of server %s failed: %w", uuid, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+func (s *Service) restartServerAndWait(ctx context.Context, uuid string, opts RollingRestartOptions) error {
+	details, err := s.RestartServer(ctx, &request.RestartServerRequest{
+		UUID:          uuid,
+		StopType:      opts.StopType,
+		Timeout:       opts.Timeout,
+		TimeoutAction: opts.TimeoutAction,
+	})
+	if err != nil {
+		return err
+	}
+
+	// The server may stay started until the restart is processed, but the restart may also complete between two
+	// polls, so leaving the started state is only waited for a bounded time.
+	if details.State == upcloud.ServerStateStarted {
+		observeCtx, cancel := context.WithTimeout(ctx, opts.RestartObserveTimeout)
+		_, err := s.WaitForServerState(observeCtx, &request.WaitForServerStateRequest{
+			UUID:           uuid,
+			UndesiredState: upcloud.ServerStateStarted,
+		})
+		cancel()
+		if err != nil && !(errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil) {
+			return err
+		}
+	}
+
+	details, err = s.WaitForServerState(ctx, &request.WaitForServerStateRequest{
+		UUID:         uuid,
+		DesiredState: upcloud.ServerStateStarted,
+	})
+	if err != nil {
+		return err
+	}
+
+	if opts.HealthCheck != nil {
+		return opts.HealthCheck(ctx, details)
+	}
+	return nil
+}
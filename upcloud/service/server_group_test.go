@@ -2,9 +2,15 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/client"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
 	"github.com/dnaeon/go-vcr/recorder"
 	"github.com/stretchr/testify/assert"
@@ -131,6 +137,133 @@ func TestServerGroups(t *testing.T) {
 	})
 }
 
+// rollingRestartTestHandler simulates servers which go through the maintenance state when restarted, or restart
+// between two polls if fast is set.
+func rollingRestartTestHandler(t *testing.T, states map[string]string, fast bool) (http.Handler, *[]string) {
+	var mu sync.Mutex
+	var restarted []string
+	restarting := make(map[string]bool)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server-group/group", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"server_group": {"uuid": "group", "servers": {"server": ["a", "b", "c"]}}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/{uuid}", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		uuid := r.PathValue("uuid")
+		state := states[uuid]
+		if restarting[uuid] {
+			state = upcloud.ServerStateMaintenance
+			restarting[uuid] = false
+		}
+		_, _ = fmt.Fprintf(w, `{"server": {"uuid": "%s", "state": "%s"}}`, uuid, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/{uuid}/restart", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		uuid := r.PathValue("uuid")
+		assert.Equal(t, upcloud.ServerStateStarted, states[uuid])
+		restarted = append(restarted, uuid)
+		restarting[uuid] = !fast
+		_, _ = fmt.Fprintf(w, `{"server": {"uuid": "%s", "state": "started"}}`, uuid)
+	})
+
+	return mux, &restarted
+}
+
+func TestRollingRestartServerGroup(t *testing.T) {
+	t.Parallel()
+
+	handler, restarted := rollingRestartTestHandler(t, map[string]string{
+		"a": upcloud.ServerStateStarted,
+		"b": upcloud.ServerStateStarted,
+		"c": upcloud.ServerStateStopped,
+	}, false)
+	srv, svc := setupTestServerAndService(handler)
+	defer srv.Close()
+
+	var mu sync.Mutex
+	var checked []string
+	err := svc.RollingRestartServerGroup(context.Background(), "group", RollingRestartOptions{
+		MaxUnavailable: 3,
+		StopType:       upcloud.StopTypeSoft,
+		HealthCheck: func(_ context.Context, server *upcloud.ServerDetails) error {
+			mu.Lock()
+			defer mu.Unlock()
+			assert.Equal(t, upcloud.ServerStateStarted, server.State)
+			checked = append(checked, server.UUID)
+			return nil
+		},
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"a", "b"}, *restarted)
+	assert.ElementsMatch(t, []string{"a", "b"}, checked)
+}
+
+func TestRollingRestartServerGroupStopsOnFailedHealthCheck(t *testing.T) {
+	t.Parallel()
+
+	handler, restarted := rollingRestartTestHandler(t, map[string]string{
+		"a": upcloud.ServerStateStarted,
+		"b": upcloud.ServerStateStarted,
+		"c": upcloud.ServerStateStarted,
+	}, false)
+	srv, svc := setupTestServerAndService(handler)
+	defer srv.Close()
+
+	errUnhealthy := errors.New("unhealthy")
+	err := svc.RollingRestartServerGroup(context.Background(), "group", RollingRestartOptions{
+		HealthCheck: func(context.Context, *upcloud.ServerDetails) error {
+			return errUnhealthy
+		},
+	})
+	assert.ErrorIs(t, err, errUnhealthy)
+	assert.Equal(t, []string{"a"}, *restarted)
+}
+
+func TestRollingRestartServerGroupFastRestartAndErrorState(t *testing.T) {
+	t.Parallel()
+
+	handler, restarted := rollingRestartTestHandler(t, map[string]string{
+		"a": upcloud.ServerStateStarted,
+		"b": upcloud.ServerStateError,
+		"c": upcloud.ServerStateStarted,
+	}, true)
+	srv, svc := setupTestServerAndService(handler)
+	defer srv.Close()
+
+	// The restarted server is never observed in another state than started
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	err := svc.RollingRestartServerGroup(ctx, "group", RollingRestartOptions{
+		MaxUnavailable:        2,
+		RestartObserveTimeout: time.Millisecond,
+	})
+	assert.ErrorIs(t, err, ErrServerInErrorState)
+	assert.EqualError(t, err, "server is in error state: b")
+	assert.Equal(t, []string{"a", "c"}, *restarted)
+}
+
+func TestRollingRestartServerGroupUnavailableMembers(t *testing.T) {
+	t.Parallel()
+
+	handler, restarted := rollingRestartTestHandler(t, map[string]string{
+		"a": upcloud.ServerStateStarted,
+		"b": upcloud.ServerStateError,
+		"c": upcloud.ServerStateStopped,
+	}, false)
+	srv, svc := setupTestServerAndService(handler)
+	defer srv.Close()
+
+	err := svc.RollingRestartServerGroup(context.Background(), "group", RollingRestartOptions{MaxUnavailable: 2})
+	assert.ErrorIs(t, err, ErrServerInErrorState)
+	assert.ErrorIs(t, err, ErrServerGroupMembersUnavailable)
+	assert.EqualError(t, err, "server is in error state: b\n"+
+		"too many server group members are unavailable: b, c of at most 2 are not started")
+	assert.Empty(t, *restarted)
+}
+
 // Deletes the specified server group.
 func deleteServerGroup(ctx context.Context, svc *Service, uuid string) error {
 	err := svc.DeleteServerGroup(ctx, &request.DeleteServerGroupRequest{
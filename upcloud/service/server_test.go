@@ -3,13 +3,16 @@ package service
 import (
 	"context"
 	"fmt"
+	"io"
 	"net/http"
 	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/client"
 	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
 	"github.com/dnaeon/go-vcr/recorder"
 	"github.com/stretchr/testify/assert"
@@ -418,6 +421,441 @@ func TestCreateStopRelocateServer(t *testing.T) {
 	})
 }
 
+// resizeServerTestMux returns a mux that serves the catalogue and a started server with a plan and a 25 GB OS disk.
+func resizeServerTestMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/plan", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"plans": {"plan": [{"name": "1xCPU-2GB"}, {"name": "2xCPU-4GB"}, {"name": "GPU-8xCPU-64GB-1xL40S"}]}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server_size", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"server_sizes": {"server_size": [{"core_number": "4", "memory_amount": "8192"}]}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/price", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"prices": {"zone": [{
+			"name": "fi-hel1",
+			"server_core": {"amount": 1, "price": 1.0},
+			"server_memory": {"amount": 256, "price": 0.25},
+			"server_plan_1xCPU-2GB": {"amount": 1, "price": 1.5},
+			"server_plan_2xCPU-4GB": {"amount": 1, "price": 3.0},
+			"storage_maxiops": {"amount": 1, "price": 0.03}
+		}, {
+			"name": "de-fra1",
+			"server_plan_1xCPU-2GB": {"amount": 1, "price": 1.5},
+			"server_plan_2xCPU-4GB": {"amount": 1, "price": 3.0}
+		}]}}`)
+	})
+	return mux
+}
+
+const resizeServerTestServer = `{"server": {"uuid": "uuid", "zone": "fi-hel1", "plan": "1xCPU-2GB", "state": "%s",
+	"storage_devices": {"storage_device": [{"storage": "disk", "storage_size": 25, "storage_tier": "maxiops", "type": "disk", "boot_disk": "1"}]}
+}}`
+
+// TestResizeServerDryRun ensures that ResizeServer() validates the target configuration and calculates the price
+// difference without modifying the server.
+func TestResizeServerDryRun(t *testing.T) {
+	t.Parallel()
+
+	mux := resizeServerTestMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStarted)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/fra", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, strings.ReplaceAll(resizeServerTestServer, "fi-hel1", "de-fra1"), upcloud.ServerStateStarted)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	resize, err := svc.ResizeServer(context.Background(), &request.ResizeServerRequest{
+		UUID:        "uuid",
+		Plan:        "2xCPU-4GB",
+		StorageSize: 50,
+		DryRun:      true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "1xCPU-2GB", resize.FromPlan)
+	assert.Equal(t, "2xCPU-4GB", resize.ToPlan)
+	assert.InDelta(t, 1.5+25*0.03, resize.PriceDifference, 0.0001)
+
+	resize, err = svc.ResizeServer(context.Background(), &request.ResizeServerRequest{
+		UUID:         "uuid",
+		CoreNumber:   4,
+		MemoryAmount: 8192,
+		DryRun:       true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "custom", resize.ToPlan)
+	assert.InDelta(t, 4+8-1.5, resize.PriceDifference, 0.0001)
+
+	for _, r := range []request.ResizeServerRequest{
+		{UUID: "uuid", Plan: "8xCPU-32GB"},
+		{UUID: "uuid", Plan: "GPU-8xCPU-64GB-1xL40S"},
+		{UUID: "uuid", CoreNumber: 3, MemoryAmount: 8192},
+		{UUID: "uuid", Plan: "2xCPU-4GB", StorageSize: 10},
+	} {
+		_, err = svc.ResizeServer(context.Background(), &r)
+		assert.Error(t, err, r)
+	}
+
+	_, err = svc.ResizeServer(context.Background(), &request.ResizeServerRequest{UUID: "uuid", Plan: "2xCPU-4GB", CoreNumber: 4, MemoryAmount: 8192})
+	assert.EqualError(t, err, "core number and memory amount cannot be specified with plan 2xCPU-4GB")
+	_, err = svc.ResizeServer(context.Background(), &request.ResizeServerRequest{UUID: "fra", CoreNumber: 4, MemoryAmount: 8192})
+	assert.EqualError(t, err, "custom server configurations are not available in zone de-fra1")
+	resize, err = svc.ResizeServer(context.Background(), &request.ResizeServerRequest{UUID: "fra", Plan: "2xCPU-4GB", DryRun: true})
+	require.NoError(t, err)
+	assert.InDelta(t, 1.5, resize.PriceDifference, 0.0001)
+}
+
+// TestResizeServer ensures that ResizeServer() stops the server, modifies it and starts it again.
+func TestResizeServer(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	state := upcloud.ServerStateStarted
+	mux := resizeServerTestMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/stop", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "stop")
+		state = upcloud.ServerStateStopped
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStarted)
+	})
+	mux.HandleFunc(fmt.Sprintf("PUT /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"server": {"plan": "custom", "core_number": "4", "memory_amount": "8192"}}`, string(body))
+		calls = append(calls, "modify")
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/start", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "start")
+		state = upcloud.ServerStateStarted
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStopped)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	resize, err := svc.ResizeServer(context.Background(), &request.ResizeServerRequest{
+		UUID:         "uuid",
+		CoreNumber:   4,
+		MemoryAmount: 8192,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, upcloud.ServerStateStarted, resize.Server.State)
+	assert.Equal(t, []string{"stop", "modify", "start"}, calls)
+}
+
+// TestResizeServerGrowDisk ensures that ResizeServer() grows the OS disk and its filesystem while the server is stopped.
+func TestResizeServerGrowDisk(t *testing.T) {
+	t.Parallel()
+
+	var calls []string
+	state := upcloud.ServerStateStarted
+	mux := resizeServerTestMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/stop", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "stop")
+		state = upcloud.ServerStateStopped
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStarted)
+	})
+	mux.HandleFunc(fmt.Sprintf("PUT /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "modify")
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("PUT /%s/storage/disk", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"storage": {"size": "50"}}`, string(body))
+		calls = append(calls, "modify storage")
+		_, _ = fmt.Fprint(w, `{"storage": {"uuid": "disk", "size": 50, "state": "maintenance"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/storage/disk", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"storage": {"uuid": "disk", "size": 50, "state": "online"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/storage/disk/resize", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "resize filesystem")
+		_, _ = fmt.Fprint(w, `{"resize_backup": {"uuid": "backup"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/start", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "start")
+		state = upcloud.ServerStateStarted
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStopped)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	_, err := svc.ResizeServer(context.Background(), &request.ResizeServerRequest{
+		UUID:        "uuid",
+		Plan:        "2xCPU-4GB",
+		StorageSize: 50,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stop", "modify", "modify storage", "resize filesystem", "start"}, calls)
+}
+
+// TestResizeServerRestoresState ensures that ResizeServer() starts the server again when the resize fails after the
+// server is stopped, also when the context of the resize is cancelled.
+func TestResizeServerRestoresState(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var calls []string
+	state := upcloud.ServerStateStarted
+	mux := resizeServerTestMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/stop", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "stop")
+		state = upcloud.ServerStateStopped
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStarted)
+	})
+	mux.HandleFunc(fmt.Sprintf("PUT /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "modify")
+		cancel()
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/start", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, "start")
+		state = upcloud.ServerStateStarted
+		_, _ = fmt.Fprintf(w, resizeServerTestServer, upcloud.ServerStateStopped)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	_, err := svc.ResizeServer(ctx, &request.ResizeServerRequest{UUID: "uuid", Plan: "2xCPU-4GB"})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, []string{"stop", "modify", "start"}, calls)
+	assert.Equal(t, upcloud.ServerStateStarted, state)
+}
+
+// TestCloneServer ensures that CloneServer() clones the storages of the source server and creates
+// a new server with the copied configuration.
+func TestCloneServer(t *testing.T) {
+	t.Parallel()
+
+	var createBody string
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/src", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"server": {
+			"uuid": "src", "title": "production", "hostname": "prod.example.com", "zone": "fi-hel1", "plan": "1xCPU-2GB",
+			"firewall": "on", "nic_model": "virtio", "state": "started",
+			"labels": {"label": [{"key": "env", "value": "prod"}]},
+			"storage_devices": {"storage_device": [
+				{"address": "virtio:0", "storage": "disk1", "storage_size": 20, "storage_tier": "maxiops", "storage_title": "root", "type": "disk"},
+				{"address": "ide:0:0", "storage": "cdrom1", "type": "cdrom"}
+			]},
+			"networking": {"interfaces": {"interface": [
+				{"index": 1, "type": "public", "ip_addresses": {"ip_address": [{"address": "94.237.0.1", "family": "IPv4", "floating": "no"}, {"address": "94.237.0.2", "family": "IPv4", "floating": "yes"}]}},
+				{"index": 2, "type": "private", "network": "net1", "ip_addresses": {"ip_address": [{"address": "10.0.0.2", "family": "IPv4"}]}}
+			]}}
+		}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/src/firewall_rule", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"firewall_rules": {"firewall_rule": [{"action": "accept", "direction": "in", "position": "1", "protocol": "tcp", "destination_port_start": "22", "destination_port_end": "22"}]}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/storage/disk1/clone", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"storage": {"zone": "fi-hel1", "tier": "maxiops", "title": "root"}}`, string(body))
+		_, _ = fmt.Fprint(w, `{"storage": {"uuid": "disk2", "state": "cloning"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/storage/disk2", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"storage": {"uuid": "disk2", "state": "online"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		createBody = string(body)
+		_, _ = fmt.Fprint(w, `{"server": {"uuid": "dst", "title": "production (clone)", "state": "maintenance"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("PUT /%s/server/dst/firewall_rule", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"firewall_rules": {"firewall_rule": [{"action": "accept", "direction": "in", "protocol": "tcp", "destination_port_start": "22", "destination_port_end": "22"}]}}`, string(body))
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	server, err := svc.CloneServer(context.Background(), &request.CloneServerRequest{UUID: "src"})
+	require.NoError(t, err)
+	assert.Equal(t, "dst", server.UUID)
+	assert.JSONEq(t, `{"server": {
+		"firewall": "on", "hostname": "prod.example.com", "metadata": "no", "nic_model": "virtio",
+		"plan": "1xCPU-2GB", "title": "production (clone)", "zone": "fi-hel1", "remote_access_enabled": "no",
+		"labels": {"label": [{"key": "env", "value": "prod"}]},
+		"networking": {"interfaces": {"interface": [
+			{"index": 1, "type": "public", "ip_addresses": {"ip_address": [{"family": "IPv4"}]}},
+			{"index": 2, "type": "private", "network": "net1", "ip_addresses": {"ip_address": [{"family": "IPv4"}]}}
+		]}},
+		"storage_devices": {"storage_device": [{"action": "attach", "address": "virtio:0", "storage": "disk2"}]}
+	}}`, createBody)
+}
+
+// TestCloneServerAcrossZones ensures that CloneServer() creates a clone in another zone from templates of the
+// source storages, keeps the source server stopped while the storages are copied and removes the templates once the
+// clone has been created.
+func TestCloneServerAcrossZones(t *testing.T) {
+	t.Parallel()
+
+	var (
+		mu         sync.Mutex
+		calls      []string
+		state      = upcloud.ServerStateStarted
+		createBody string
+	)
+	record := func(call string) {
+		mu.Lock()
+		defer mu.Unlock()
+		calls = append(calls, call)
+	}
+	writeSource := func(w http.ResponseWriter) {
+		mu.Lock()
+		defer mu.Unlock()
+		_, _ = fmt.Fprintf(w, `{"server": {
+			"uuid": "src", "title": "production", "hostname": "prod.example.com", "zone": "fi-hel1", "plan": "1xCPU-2GB", "state": %q,
+			"storage_devices": {"storage_device": [
+				{"address": "virtio:0", "storage": "disk1", "storage_size": 20, "storage_tier": "maxiops", "storage_title": "root", "type": "disk"}
+			]},
+			"networking": {"interfaces": {"interface": [{"index": 1, "type": "public", "ip_addresses": {"ip_address": [{"family": "IPv4"}]}}]}}
+		}}`, state)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/src", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		writeSource(w)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/src/stop", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		record("stop source")
+		mu.Lock()
+		state = upcloud.ServerStateStopped
+		mu.Unlock()
+		writeSource(w)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/src/start", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		record("start source")
+		mu.Lock()
+		state = upcloud.ServerStateStarted
+		mu.Unlock()
+		writeSource(w)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/storage/disk1/templatize", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		record("templatize disk1")
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, `{"storage": {"title": "root (clone template)"}}`, string(body))
+		_, _ = fmt.Fprint(w, `{"storage": {"uuid": "template1", "state": "maintenance"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/storage/template1", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"storage": {"uuid": "template1", "state": "online"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		record("create")
+		body, _ := io.ReadAll(r.Body)
+		createBody = string(body)
+		_, _ = fmt.Fprint(w, `{"server": {"uuid": "dst", "title": "production (clone)", "state": "maintenance"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/dst", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprint(w, `{"server": {"uuid": "dst", "state": "started"}}`)
+	})
+	mux.HandleFunc(fmt.Sprintf("DELETE /%s/storage/template1", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		record("delete template1")
+		w.WriteHeader(http.StatusNoContent)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	server, err := svc.CloneServer(context.Background(), &request.CloneServerRequest{
+		UUID:        "src",
+		TargetZone:  "de-fra1",
+		StorageTier: "standard",
+		StopSource:  true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "dst", server.UUID)
+	assert.Equal(t, []string{"stop source", "templatize disk1", "start source", "create", "delete template1"}, calls)
+	assert.JSONEq(t, `{"server": {
+		"hostname": "prod.example.com", "metadata": "no", "plan": "1xCPU-2GB", "title": "production (clone)",
+		"zone": "de-fra1", "remote_access_enabled": "no",
+		"networking": {"interfaces": {"interface": [{"index": 1, "type": "public", "ip_addresses": {"ip_address": [{"family": "IPv4"}]}}]}},
+		"storage_devices": {"storage_device": [
+			{"action": "clone", "address": "virtio:0", "storage": "template1", "title": "root", "size": 20, "tier": "standard"}
+		]}
+	}}`, createBody)
+}
+
+// TestCloneServerAcrossZonesWithPrivateNetwork ensures that CloneServer() refuses to guess the networking of a
+// clone in another zone when the source server is attached to private networks.
+func TestCloneServerAcrossZonesWithPrivateNetwork(t *testing.T) {
+	t.Parallel()
+
+	srv, svc := setupTestServerAndService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		assert.Equal(t, fmt.Sprintf("/%s/server/src", client.APIVersion), r.URL.Path)
+		_, _ = fmt.Fprint(w, `{"server": {"uuid": "src", "zone": "fi-hel1", "plan": "1xCPU-2GB",
+			"networking": {"interfaces": {"interface": [{"index": 1, "type": "private", "network": "net1"}]}}
+		}}`)
+	}))
+	defer srv.Close()
+
+	_, err := svc.CloneServer(context.Background(), &request.CloneServerRequest{UUID: "src", TargetZone: "de-fra1"})
+	assert.ErrorContains(t, err, "private network interfaces")
+}
+
+// TestEnsureServerStateSoftStopTimeout ensures that EnsureServerState() sends a single soft stop with the soft stop
+// timeout, leaving the escalation to a hard stop to the API.
+func TestEnsureServerStateSoftStopTimeout(t *testing.T) {
+	t.Parallel()
+
+	var stops []string
+	state := upcloud.ServerStateStarted
+	mux := http.NewServeMux()
+	mux.HandleFunc(fmt.Sprintf("GET /%s/server/uuid", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		_, _ = fmt.Fprintf(w, `{"server": {"uuid": "uuid", "state": "%s"}}`, state)
+	})
+	mux.HandleFunc(fmt.Sprintf("POST /%s/server/uuid/stop", client.APIVersion), func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		stops = append(stops, string(body))
+		// The API stops the server forcibly once the timeout of the soft stop has passed
+		state = upcloud.ServerStateStopped
+		_, _ = fmt.Fprint(w, `{"server": {"uuid": "uuid", "state": "started"}}`)
+	})
+	srv, svc := setupTestServerAndService(mux)
+	defer srv.Close()
+
+	details, err := svc.EnsureServerState(context.Background(), &request.EnsureServerStateRequest{
+		UUID:            "uuid",
+		DesiredState:    upcloud.ServerStateStopped,
+		SoftStopTimeout: time.Minute,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, upcloud.ServerStateStopped, details.State)
+	require.Len(t, stops, 1)
+	assert.JSONEq(t, `{"stop_server": {"stop_type": "soft", "timeout": "60"}}`, stops[0])
+}
+
+// TestEnsureServerStateInvalidTransitions ensures that EnsureServerState() returns typed errors for transitions
+// it cannot perform.
+func TestEnsureServerStateInvalidTransitions(t *testing.T) {
+	t.Parallel()
+
+	srv, svc := setupTestServerAndService(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodGet, r.Method)
+		_, _ = fmt.Fprint(w, `{"server": {"uuid": "uuid", "state": "error"}}`)
+	}))
+	defer srv.Close()
+
+	for _, desired := range []string{upcloud.ServerStateStarted, upcloud.ServerStateMaintenance} {
+		_, err := svc.EnsureServerState(context.Background(), &request.EnsureServerStateRequest{
+			UUID:         "uuid",
+			DesiredState: desired,
+		})
+		assert.ErrorIs(t, err, ErrInvalidServerStateTransition)
+
+		var transitionErr *ServerStateTransitionError
+		require.ErrorAs(t, err, &transitionErr)
+		assert.Equal(t, upcloud.ServerStateError, transitionErr.From)
+		assert.Equal(t, desired, transitionErr.To)
+	}
+}
+
 // Creates a minimal server with a private utility network interface.
 func createMinimalServer(ctx context.Context, rec *recorder.Recorder, svc *Service, name string) (*upcloud.ServerDetails, error) {
 	title := "uploud-go-sdk-integration-test-" + name
@@ -0,0 +1,224 @@
+package topology
+
+import (
+	"context"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+// Client is the client needed to crawl the resources of the topology.
+type Client interface {
+	GetNetworks(ctx context.Context, f ...request.QueryFilter) (*upcloud.Networks, error)
+	GetRouters(ctx context.Context, f ...request.QueryFilter) (*upcloud.Routers, error)
+	GetGateways(ctx context.Context, f ...request.QueryFilter) ([]upcloud.Gateway, error)
+	GetNetworkPeerings(ctx context.Context, f ...request.QueryFilter) (upcloud.NetworkPeerings, error)
+	GetLoadBalancers(ctx context.Context, r *request.GetLoadBalancersRequest) ([]upcloud.LoadBalancer, error)
+	GetServerNetworks(ctx context.Context, r *request.GetServerNetworksRequest) (*upcloud.Networking, error)
+}
+
+// Resources contains the resources a graph is built from
+type Resources struct {
+	// Networks contains the private networks. Public and utility networks are not part of the topology.
+	Networks        []upcloud.Network
+	Routers         []upcloud.Router
+	Gateways        []upcloud.Gateway
+	NetworkPeerings upcloud.NetworkPeerings
+	LoadBalancers   []upcloud.LoadBalancer
+	// ServerNetworks contains the networking of the servers attached to the private networks, keyed by server UUID
+	ServerNetworks map[string]*upcloud.Networking
+}
+
+// Crawl fetches the resources of the topology. The networking of each server attached to a private network is
+// fetched with a separate request.
+func Crawl(ctx context.Context, c Client) (*Resources, error) {
+	networks, err := c.GetNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	routers, err := c.GetRouters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	gateways, err := c.GetGateways(ctx)
+	if err != nil {
+		return nil, err
+	}
+	peerings, err := c.GetNetworkPeerings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	loadBalancers, err := c.GetLoadBalancers(ctx, &request.GetLoadBalancersRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resources{
+		Routers:         routers.Routers,
+		Gateways:        gateways,
+		NetworkPeerings: peerings,
+		LoadBalancers:   loadBalancers,
+		ServerNetworks:  make(map[string]*upcloud.Networking),
+	}
+	for _, network := range networks.Networks {
+		if network.Type != upcloud.NetworkTypePrivate {
+			continue
+		}
+		r.Networks = append(r.Networks, network)
+		for _, server := range network.Servers {
+			if _, ok := r.ServerNetworks[server.ServerUUID]; ok {
+				continue
+			}
+			networking, err := c.GetServerNetworks(ctx, &request.GetServerNetworksRequest{ServerUUID: server.ServerUUID})
+			if err != nil {
+				return nil, err
+			}
+			r.ServerNetworks[server.ServerUUID] = networking
+		}
+	}
+	return r, nil
+}
+
+// Build builds the topology graph from the resources. Resources referenced by other resources but missing from
+// the given resources, e.g. the peer network of a network peering, are added as external nodes.
+func Build(r *Resources) *Graph {
+	g := &Graph{}
+	networks := make(map[string]upcloud.Network, len(r.Networks))
+
+	for _, network := range r.Networks {
+		networks[network.UUID] = network
+		details := make([]string, 0, len(network.IPNetworks))
+		for _, ipNetwork := range network.IPNetworks {
+			details = append(details, ipNetwork.Address)
+		}
+		g.AddNode(Node{ID: network.UUID, Kind: NodeKindNetwork, Name: network.Name, Zone: network.Zone, Details: details})
+	}
+
+	for _, router := range r.Routers {
+		g.AddNode(Node{ID: router.UUID, Kind: NodeKindRouter, Name: router.Name})
+		for _, attached := range router.AttachedNetworks {
+			g.connect(router.UUID, attached.NetworkUUID, NodeKindNetwork, EdgeKindRouterAttachment, "")
+		}
+		for _, route := range router.StaticRoutes {
+			label := route.Route + " via " + route.Nexthop
+			if network, ok := nexthopNetwork(route.Nexthop, router.AttachedNetworks, networks); ok {
+				g.connect(router.UUID, network, NodeKindNetwork, EdgeKindStaticRoute, label)
+				continue
+			}
+			g.AddNode(Node{ID: "nexthop:" + route.Nexthop, Kind: NodeKindNexthop, Name: route.Nexthop, External: true})
+			g.AddEdge(Edge{From: router.UUID, To: "nexthop:" + route.Nexthop, Kind: EdgeKindStaticRoute, Label: label})
+		}
+	}
+
+	for _, gateway := range r.Gateways {
+		details := make([]string, 0, len(gateway.Addresses))
+		for _, address := range gateway.Addresses {
+			details = append(details, address.Address)
+		}
+		g.AddNode(Node{ID: gateway.UUID, Kind: NodeKindGateway, Name: gateway.Name, Zone: gateway.Zone, Details: details})
+		for _, router := range gateway.Routers {
+			g.connect(gateway.UUID, router.UUID, NodeKindRouter, EdgeKindGatewayRouter, "")
+		}
+		for _, connection := range gateway.Connections {
+			id := connection.UUID
+			if id == "" {
+				id = gateway.UUID + "/" + connection.Name
+			}
+			routes := make([]string, 0, len(connection.RemoteRoutes))
+			for _, route := range connection.RemoteRoutes {
+				routes = append(routes, route.StaticNetwork)
+			}
+			g.AddNode(Node{ID: id, Kind: NodeKindGatewayConnection, Name: connection.Name, Zone: gateway.Zone, Details: routes})
+			g.AddEdge(Edge{From: gateway.UUID, To: id, Kind: EdgeKindGatewayConnection, Label: string(connection.Type)})
+		}
+	}
+
+	for _, peering := range r.NetworkPeerings {
+		g.AddNode(Node{ID: peering.UUID, Kind: NodeKindNetworkPeering, Name: peering.Name, Details: []string{string(peering.State)}})
+		for _, network := range []upcloud.NetworkPeeringNetwork{peering.Network, peering.PeerNetwork} {
+			if network.UUID == "" {
+				continue
+			}
+			details := make([]string, 0, len(network.IPNetworks))
+			for _, ipNetwork := range network.IPNetworks {
+				details = append(details, ipNetwork.Address)
+			}
+			g.AddNode(Node{ID: network.UUID, Kind: NodeKindNetwork, Details: details, External: true})
+			g.AddEdge(Edge{From: peering.UUID, To: network.UUID, Kind: EdgeKindPeering})
+		}
+	}
+
+	for _, lb := range r.LoadBalancers {
+		g.AddNode(Node{ID: lb.UUID, Kind: NodeKindLoadBalancer, Name: lb.Name, Zone: lb.Zone})
+		for _, network := range lb.Networks {
+			if network.Type != upcloud.LoadBalancerNetworkTypePrivate || network.UUID == "" {
+				continue
+			}
+			addresses := make([]string, 0, len(network.IPAddresses))
+			for _, address := range network.IPAddresses {
+				addresses = append(addresses, address.Address)
+			}
+			g.connect(lb.UUID, network.UUID, NodeKindNetwork, EdgeKindLoadBalancerNetwork, strings.Join(addresses, ", "))
+		}
+	}
+
+	for _, network := range r.Networks {
+		for _, server := range network.Servers {
+			g.AddNode(Node{ID: server.ServerUUID, Kind: NodeKindServer, Name: server.ServerTitle, Zone: network.Zone})
+			g.AddEdge(Edge{
+				From:  server.ServerUUID,
+				To:    network.UUID,
+				Kind:  EdgeKindServerInterface,
+				Label: interfaceAddresses(r.ServerNetworks[server.ServerUUID], network.UUID),
+			})
+		}
+	}
+
+	return g
+}
+
+// connect adds an edge to the target node, adding the target as an external node if it does not exist yet
+func (g *Graph) connect(from, to string, toKind NodeKind, kind EdgeKind, label string) {
+	if _, ok := g.Node(to); !ok {
+		g.AddNode(Node{ID: to, Kind: toKind, External: true})
+	}
+	g.AddEdge(Edge{From: from, To: to, Kind: kind, Label: label})
+}
+
+// nexthopNetwork returns the UUID of the attached network that contains the next hop
+func nexthopNetwork(nexthop string, attached upcloud.RouterNetworkSlice, networks map[string]upcloud.Network) (string, bool) {
+	addr, err := netip.ParseAddr(nexthop)
+	if err != nil {
+		return "", false
+	}
+	for _, a := range attached {
+		for _, ipNetwork := range networks[a.NetworkUUID].IPNetworks {
+			if prefix, err := netip.ParsePrefix(ipNetwork.Address); err == nil && prefix.Contains(addr) {
+				return a.NetworkUUID, true
+			}
+		}
+	}
+	return "", false
+}
+
+// interfaceAddresses returns the addresses of the server interfaces in the network
+func interfaceAddresses(networking *upcloud.Networking, networkUUID string) string {
+	if networking == nil {
+		return ""
+	}
+	var addresses []string
+	for _, iface := range networking.Interfaces {
+		if iface.Network != networkUUID {
+			continue
+		}
+		for _, ip := range iface.IPAddresses {
+			if !slices.Contains(addresses, ip.Address) {
+				addresses = append(addresses, ip.Address)
+			}
+		}
+	}
+	return strings.Join(addresses, ", ")
+}
@@ -0,0 +1,87 @@
+package topology
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var dotShapes = map[NodeKind]string{
+	NodeKindNetwork:           "ellipse",
+	NodeKindRouter:            "diamond",
+	NodeKindGateway:           "hexagon",
+	NodeKindGatewayConnection: "cds",
+	NodeKindNetworkPeering:    "octagon",
+	NodeKindLoadBalancer:      "component",
+	NodeKindServer:            "box",
+	NodeKindNexthop:           "point",
+}
+
+// mermaidShapes contains the opening and closing brackets of the node shapes
+var mermaidShapes = map[NodeKind][2]string{
+	NodeKindNetwork:           {"([", "])"},
+	NodeKindRouter:            {"{", "}"},
+	NodeKindGateway:           {"{{", "}}"},
+	NodeKindGatewayConnection: {">", "]"},
+	NodeKindNetworkPeering:    {"[[", "]]"},
+	NodeKindLoadBalancer:      {"[/", "/]"},
+	NodeKindServer:            {"[", "]"},
+	NodeKindNexthop:           {"((", "))"},
+}
+
+// DOT returns the graph in Graphviz DOT format
+func (g *Graph) DOT() string {
+	var sb strings.Builder
+	sb.WriteString("graph topology {\n")
+	for _, node := range g.Nodes {
+		style := ""
+		if node.External {
+			style = " style=dashed"
+		}
+		fmt.Fprintf(&sb, "  %s [label=%s shape=%s%s];\n", strconv.Quote(node.ID), strconv.Quote(node.Label()), dotShapes[node.Kind], style)
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&sb, "  %s -- %s", strconv.Quote(edge.From), strconv.Quote(edge.To))
+		if edge.Label != "" {
+			fmt.Fprintf(&sb, " [label=%s]", strconv.Quote(edge.Label))
+		}
+		sb.WriteString(";\n")
+	}
+	sb.WriteString("}\n")
+	return sb.String()
+}
+
+// Mermaid returns the graph as a Mermaid flowchart. Nodes are given short identifiers in the order they were
+// added, because Mermaid identifiers cannot contain all characters used in resource IDs.
+func (g *Graph) Mermaid() string {
+	ids := make(map[string]string, len(g.Nodes))
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	for i, node := range g.Nodes {
+		ids[node.ID] = fmt.Sprintf("n%d", i)
+		shape := mermaidShapes[node.Kind]
+		fmt.Fprintf(&sb, "  %s%s\"%s\"%s\n", ids[node.ID], shape[0], mermaidText(node.Label()), shape[1])
+	}
+	for _, edge := range g.Edges {
+		link := "---"
+		if node, ok := g.Node(edge.To); ok && node.External {
+			link = "-.-"
+		}
+		if edge.Label != "" {
+			fmt.Fprintf(&sb, "  %s %s|\"%s\"| %s\n", ids[edge.From], link, mermaidText(edge.Label), ids[edge.To])
+			continue
+		}
+		fmt.Fprintf(&sb, "  %s %s %s\n", ids[edge.From], link, ids[edge.To])
+	}
+	return sb.String()
+}
+
+// JSON returns the graph as indented JSON
+func (g *Graph) JSON() ([]byte, error) {
+	return json.MarshalIndent(g, "", "  ")
+}
+
+func mermaidText(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "\n", "<br/>").Replace(s)
+}
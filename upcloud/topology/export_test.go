@@ -0,0 +1,61 @@
+package topology
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exampleGraph() *Graph {
+	g := &Graph{}
+	g.AddNode(Node{ID: "net-1", Kind: NodeKindNetwork, Name: "app", Details: []string{"10.0.0.0/24"}})
+	g.AddNode(Node{ID: "router-1", Kind: NodeKindRouter, Name: `main "router"`})
+	g.AddNode(Node{ID: "net-2", Kind: NodeKindNetwork, External: true})
+	g.AddEdge(Edge{From: "router-1", To: "net-1", Kind: EdgeKindRouterAttachment})
+	g.AddEdge(Edge{From: "router-1", To: "net-2", Kind: EdgeKindStaticRoute, Label: "10.1.0.0/24 via 10.0.0.10"})
+	return g
+}
+
+func TestGraphDOT(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `graph topology {
+  "net-1" [label="app\n10.0.0.0/24" shape=ellipse];
+  "router-1" [label="main \"router\"" shape=diamond];
+  "net-2" [label="net-2" shape=ellipse style=dashed];
+  "router-1" -- "net-1";
+  "router-1" -- "net-2" [label="10.1.0.0/24 via 10.0.0.10"];
+}
+`, exampleGraph().DOT())
+}
+
+func TestGraphMermaid(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, `flowchart LR
+  n0(["app<br/>10.0.0.0/24"])
+  n1{"main #quot;router#quot;"}
+  n2(["net-2"])
+  n1 --- n0
+  n1 -.-|"10.1.0.0/24 via 10.0.0.10"| n2
+`, exampleGraph().Mermaid())
+}
+
+func TestGraphJSON(t *testing.T) {
+	t.Parallel()
+
+	b, err := exampleGraph().JSON()
+	require.NoError(t, err)
+	assert.JSONEq(t, `{
+		"nodes": [
+			{"id": "net-1", "kind": "network", "name": "app", "details": ["10.0.0.0/24"]},
+			{"id": "router-1", "kind": "router", "name": "main \"router\""},
+			{"id": "net-2", "kind": "network", "external": true}
+		],
+		"edges": [
+			{"from": "router-1", "to": "net-1", "kind": "router-attachment"},
+			{"from": "router-1", "to": "net-2", "kind": "static-route", "label": "10.1.0.0/24 via 10.0.0.10"}
+		]
+	}`, string(b))
+}
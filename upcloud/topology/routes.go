@@ -0,0 +1,350 @@
+package topology
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+)
+
+// RouteSource tells where a route comes from
+type RouteSource string
+
+const (
+	// RouteSourceConnected is the prefix of the network itself
+	RouteSourceConnected RouteSource = "connected"
+	// RouteSourceRouterConnectedNetwork is the prefix of another network attached to the same router
+	RouteSourceRouterConnectedNetwork = RouteSource(upcloud.NetworkRouteSourceRouterConnectedNetwork)
+	// RouteSourceStaticRoute is a static route of the router
+	RouteSourceStaticRoute = RouteSource(upcloud.NetworkRouteSourceStaticSource)
+	// RouteSourceGateway is a remote route of a gateway connection or the default route of a NAT gateway
+	RouteSourceGateway RouteSource = "gateway"
+	// RouteSourceNetworkPeering is the prefix of a peer network
+	RouteSourceNetworkPeering RouteSource = "network-peering"
+)
+
+// ErrNoRoute is returned when no route matches the destination
+var ErrNoRoute = errors.New("no route")
+
+// Route represents a route in the effective routing table of a network
+type Route struct {
+	Prefix netip.Prefix `json:"prefix"`
+	// Nexthop is the address the traffic is forwarded to. It is not set for the connected routes.
+	Nexthop          netip.Addr               `json:"nexthop,omitzero"`
+	Source           RouteSource              `json:"source"`
+	Type             upcloud.NetworkRouteType `json:"type,omitempty"`
+	SourceResourceID string                   `json:"source_resource_id,omitempty"`
+}
+
+// String returns the route in the format of ip route
+func (r Route) String() string {
+	if !r.Nexthop.IsValid() {
+		return fmt.Sprintf("%s (%s)", r.Prefix, r.Source)
+	}
+	return fmt.Sprintf("%s via %s (%s)", r.Prefix, r.Nexthop, r.Source)
+}
+
+// APISource returns the source the API uses for the route in effective routes. Gateway routes are service managed
+// static routes of the router and peer networks are connected to the router.
+func (r Route) APISource() upcloud.NetworkRouteSource {
+	switch r.Source {
+	case RouteSourceStaticRoute, RouteSourceGateway:
+		return upcloud.NetworkRouteSourceStaticSource
+	default:
+		return upcloud.NetworkRouteSourceRouterConnectedNetwork
+	}
+}
+
+// RoutingTable represents the effective routes of a network
+type RoutingTable struct {
+	NetworkUUID string  `json:"network_uuid"`
+	Routes      []Route `json:"routes"`
+}
+
+// Lookup returns the most specific route that matches the address. Routes added earlier win ties.
+func (t *RoutingTable) Lookup(addr netip.Addr) (Route, bool) {
+	return t.RouteTo(netip.PrefixFrom(addr, addr.BitLen()))
+}
+
+// RouteTo returns the most specific route that covers the whole prefix. Routes added earlier win ties.
+func (t *RoutingTable) RouteTo(prefix netip.Prefix) (Route, bool) {
+	prefix = prefix.Masked()
+	best := -1
+	for i, route := range t.Routes {
+		if route.Prefix.Bits() > prefix.Bits() || !route.Prefix.Contains(prefix.Addr()) {
+			continue
+		}
+		if best < 0 || route.Prefix.Bits() > t.Routes[best].Prefix.Bits() {
+			best = i
+		}
+	}
+	if best < 0 {
+		return Route{}, false
+	}
+	return t.Routes[best], true
+}
+
+// String returns the routes one per line
+func (t *RoutingTable) String() string {
+	var sb strings.Builder
+	for _, route := range t.Routes {
+		sb.WriteString(route.String())
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
+
+func (t *RoutingTable) add(route Route) {
+	if slices.ContainsFunc(t.Routes, func(r Route) bool { return r.Prefix == route.Prefix }) {
+		return
+	}
+	t.Routes = append(t.Routes, route)
+}
+
+// EffectiveRoutes computes the routing table of the network from the resources. The table contains the prefixes
+// of the network itself, the networks attached to the same router, the static routes of the router, the remote
+// routes of the gateway connections and the default route of NAT gateways attached to the router, and the peer
+// networks of the network peerings of the networks attached to the router. Routes to the same prefix from later
+// sources are left out.
+func (r *Resources) EffectiveRoutes(networkUUID string) (*RoutingTable, error) {
+	network, ok := r.network(networkUUID)
+	if !ok {
+		return nil, fmt.Errorf("network %s not found", networkUUID)
+	}
+
+	table := &RoutingTable{NetworkUUID: networkUUID}
+	gateways := make(map[bool]netip.Addr)
+	for _, ipNetwork := range network.IPNetworks {
+		prefix, err := netip.ParsePrefix(ipNetwork.Address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP network address %q in network %s: %w", ipNetwork.Address, networkUUID, err)
+		}
+		table.add(Route{Prefix: prefix.Masked(), Source: RouteSourceConnected, SourceResourceID: networkUUID})
+		if gateway, err := netip.ParseAddr(ipNetwork.Gateway); err == nil {
+			gateways[gateway.Is4()] = gateway
+		}
+	}
+	if network.Router == "" {
+		return table, nil
+	}
+	router, ok := r.router(network.Router)
+	if !ok {
+		return nil, fmt.Errorf("router %s of network %s not found", network.Router, networkUUID)
+	}
+
+	// Routes through the router use the gateway of the IP network of the same family as the next hop
+	viaRouter := func(prefix netip.Prefix, source RouteSource, resourceID string) {
+		gateway, ok := gateways[prefix.Addr().Is4()]
+		if !ok {
+			return
+		}
+		table.add(Route{
+			Prefix:           prefix.Masked(),
+			Nexthop:          gateway,
+			Source:           source,
+			Type:             upcloud.NetworkRouteTypeService,
+			SourceResourceID: resourceID,
+		})
+	}
+
+	for _, attached := range router.AttachedNetworks {
+		other, ok := r.network(attached.NetworkUUID)
+		if !ok || other.UUID == networkUUID {
+			continue
+		}
+		for _, ipNetwork := range other.IPNetworks {
+			if prefix, err := netip.ParsePrefix(ipNetwork.Address); err == nil {
+				viaRouter(prefix, RouteSourceRouterConnectedNetwork, other.UUID)
+			}
+		}
+	}
+
+	for _, staticRoute := range router.StaticRoutes {
+		prefix, err := netip.ParsePrefix(staticRoute.Route)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static route %q in router %s: %w", staticRoute.Route, router.UUID, err)
+		}
+		nexthop, err := netip.ParseAddr(staticRoute.Nexthop)
+		if err != nil {
+			return nil, fmt.Errorf("invalid static route next hop %q in router %s: %w", staticRoute.Nexthop, router.UUID, err)
+		}
+		routeType := upcloud.NetworkRouteType(staticRoute.Type)
+		if routeType == "" {
+			routeType = upcloud.NetworkRouteTypeUser
+		}
+		table.add(Route{Prefix: prefix.Masked(), Nexthop: nexthop, Source: RouteSourceStaticRoute, Type: routeType, SourceResourceID: router.UUID})
+	}
+
+	for _, gateway := range r.Gateways {
+		if !slices.ContainsFunc(gateway.Routers, func(g upcloud.GatewayRouter) bool { return g.UUID == router.UUID }) {
+			continue
+		}
+		for _, connection := range gateway.Connections {
+			for _, remote := range connection.RemoteRoutes {
+				if prefix, err := netip.ParsePrefix(remote.StaticNetwork); err == nil {
+					viaRouter(prefix, RouteSourceGateway, gateway.UUID)
+				}
+			}
+		}
+		if slices.Contains(gateway.Features, upcloud.GatewayFeatureNAT) {
+			viaRouter(netip.MustParsePrefix("0.0.0.0/0"), RouteSourceGateway, gateway.UUID)
+		}
+	}
+
+	for _, peering := range r.NetworkPeerings {
+		if peering.ConfiguredStatus == upcloud.NetworkPeeringConfiguredStatusDisabled ||
+			!slices.ContainsFunc(router.AttachedNetworks, func(n upcloud.RouterNetwork) bool { return n.NetworkUUID == peering.Network.UUID }) {
+			continue
+		}
+		for _, ipNetwork := range peering.PeerNetwork.IPNetworks {
+			if prefix, err := netip.ParsePrefix(ipNetwork.Address); err == nil {
+				viaRouter(prefix, RouteSourceNetworkPeering, peering.UUID)
+			}
+		}
+	}
+
+	return table, nil
+}
+
+// ServerRouteTo returns the route a server uses to reach the prefix, and the UUID of the private network the
+// route belongs to. If the server is attached to multiple private networks, the most specific route wins.
+func (r *Resources) ServerRouteTo(serverUUID string, prefix netip.Prefix) (Route, string, error) {
+	networking, ok := r.ServerNetworks[serverUUID]
+	if !ok {
+		return Route{}, "", fmt.Errorf("networking of server %s not found", serverUUID)
+	}
+
+	var best Route
+	var bestNetwork string
+	for _, iface := range networking.Interfaces {
+		if _, ok := r.network(iface.Network); !ok {
+			continue
+		}
+		table, err := r.EffectiveRoutes(iface.Network)
+		if err != nil {
+			return Route{}, "", err
+		}
+		route, ok := table.RouteTo(prefix)
+		if ok && (bestNetwork == "" || route.Prefix.Bits() > best.Prefix.Bits()) {
+			best, bestNetwork = route, iface.Network
+		}
+	}
+	if bestNetwork == "" {
+		return Route{}, "", fmt.Errorf("%w from server %s to %s", ErrNoRoute, serverUUID, prefix)
+	}
+	return best, bestNetwork, nil
+}
+
+// RouteMismatch represents a difference between the computed routes and the DHCP effective routes reported by the
+// API
+type RouteMismatch struct {
+	Prefix netip.Prefix
+	// Expected is the computed route, nil if the API reports a route that was not computed
+	Expected *Route
+	// Reported is the route reported by the API, nil if a computed route was not reported
+	Reported *upcloud.DHCPEffectiveRoute
+}
+
+// String describes the mismatch
+func (m RouteMismatch) String() string {
+	switch {
+	case m.Reported == nil:
+		return fmt.Sprintf("%s: computed route %s is not reported", m.Prefix, m.Expected)
+	case m.Expected == nil:
+		return fmt.Sprintf("%s: reported route via %s was not computed", m.Prefix, m.Reported.Nexthop)
+	default:
+		return fmt.Sprintf("%s: computed next hop %s, reported %s", m.Prefix, m.Expected.Nexthop, m.Reported.Nexthop)
+	}
+}
+
+// CheckDHCPEffectiveRoutes compares the computed routes of the network, filtered with the effective routes auto
+// population settings of each IP network, to the auto populated DHCP effective routes reported by the API. Next
+// hops are compared only when the API reports one.
+func (r *Resources) CheckDHCPEffectiveRoutes(networkUUID string) ([]RouteMismatch, error) {
+	table, err := r.EffectiveRoutes(networkUUID)
+	if err != nil {
+		return nil, err
+	}
+	network, _ := r.network(networkUUID)
+
+	var mismatches []RouteMismatch
+	for _, ipNetwork := range network.IPNetworks {
+		prefix, _ := netip.ParsePrefix(ipNetwork.Address)
+		population := ipNetwork.DHCPRoutesConfiguration.EffectiveRoutesAutoPopulation
+
+		var expected []Route
+		for _, route := range table.Routes {
+			if route.Source != RouteSourceConnected && route.Prefix.Addr().Is4() == prefix.Addr().Is4() && autoPopulated(population, route) {
+				expected = append(expected, route)
+			}
+		}
+		var reported []upcloud.DHCPEffectiveRoute
+		for _, route := range ipNetwork.DHCPEffectiveRoutes {
+			if route.AutoPopulated.Bool() {
+				reported = append(reported, route)
+			}
+		}
+
+		for i := range expected {
+			j := slices.IndexFunc(reported, func(d upcloud.DHCPEffectiveRoute) bool { return samePrefix(d.Route, expected[i].Prefix) })
+			if j < 0 {
+				mismatches = append(mismatches, RouteMismatch{Prefix: expected[i].Prefix, Expected: &expected[i]})
+				continue
+			}
+			if nexthop, err := netip.ParseAddr(reported[j].Nexthop); err == nil && nexthop != expected[i].Nexthop {
+				mismatches = append(mismatches, RouteMismatch{Prefix: expected[i].Prefix, Expected: &expected[i], Reported: &reported[j]})
+			}
+		}
+		for i := range reported {
+			if !slices.ContainsFunc(expected, func(route Route) bool { return samePrefix(reported[i].Route, route.Prefix) }) {
+				p, _ := netip.ParsePrefix(reported[i].Route)
+				mismatches = append(mismatches, RouteMismatch{Prefix: p.Masked(), Reported: &reported[i]})
+			}
+		}
+	}
+	return mismatches, nil
+}
+
+// autoPopulated tells whether the route is pushed to the servers with DHCP according to the settings
+func autoPopulated(population upcloud.EffectiveRoutesAutoPopulation, route Route) bool {
+	if !population.Enabled.Bool() {
+		return false
+	}
+	if population.ExcludeBySource != nil && slices.Contains(*population.ExcludeBySource, route.APISource()) {
+		return false
+	}
+	if population.FilterByRouteType != nil && !slices.Contains(*population.FilterByRouteType, route.Type) {
+		return false
+	}
+	if population.FilterByDestination != nil && !slices.ContainsFunc(*population.FilterByDestination, func(destination string) bool {
+		prefix, err := netip.ParsePrefix(destination)
+		return err == nil && prefix.Bits() <= route.Prefix.Bits() && prefix.Contains(route.Prefix.Addr())
+	}) {
+		return false
+	}
+	return true
+}
+
+func samePrefix(s string, prefix netip.Prefix) bool {
+	p, err := netip.ParsePrefix(s)
+	return err == nil && p.Masked() == prefix
+}
+
+func (r *Resources) network(uuid string) (upcloud.Network, bool) {
+	i := slices.IndexFunc(r.Networks, func(n upcloud.Network) bool { return n.UUID == uuid })
+	if i < 0 {
+		return upcloud.Network{}, false
+	}
+	return r.Networks[i], true
+}
+
+func (r *Resources) router(uuid string) (upcloud.Router, bool) {
+	i := slices.IndexFunc(r.Routers, func(n upcloud.Router) bool { return n.UUID == uuid })
+	if i < 0 {
+		return upcloud.Router{}, false
+	}
+	return r.Routers[i], true
+}
@@ -0,0 +1,150 @@
+package topology
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func routeResources() *Resources {
+	return &Resources{
+		Networks: []upcloud.Network{
+			{
+				UUID:   "net-app",
+				Router: "router-1",
+				IPNetworks: upcloud.IPNetworkSlice{
+					{
+						Address: "10.0.0.0/24",
+						Gateway: "10.0.0.1",
+						DHCPRoutesConfiguration: upcloud.DHCPRoutesConfiguration{
+							EffectiveRoutesAutoPopulation: upcloud.EffectiveRoutesAutoPopulation{
+								Enabled:         upcloud.True,
+								ExcludeBySource: &[]upcloud.NetworkRouteSource{upcloud.NetworkRouteSourceStaticSource},
+							},
+						},
+						DHCPEffectiveRoutes: upcloud.DHCPEffectiveRouteSlice{
+							{AutoPopulated: upcloud.True, Route: "10.0.1.0/24", Nexthop: "10.0.0.1"},
+							{AutoPopulated: upcloud.True, Route: "10.9.0.0/16", Nexthop: "10.0.0.1"},
+							{AutoPopulated: upcloud.False, Route: "10.8.0.0/16", Nexthop: "10.0.0.1"},
+						},
+					},
+				},
+			},
+			{UUID: "net-db", Router: "router-1", IPNetworks: upcloud.IPNetworkSlice{{Address: "10.0.1.0/24", Gateway: "10.0.1.1"}}},
+			{UUID: "net-lonely", IPNetworks: upcloud.IPNetworkSlice{{Address: "10.0.2.0/24"}}},
+		},
+		Routers: []upcloud.Router{
+			{
+				UUID:             "router-1",
+				AttachedNetworks: upcloud.RouterNetworkSlice{{NetworkUUID: "net-app"}, {NetworkUUID: "net-db"}},
+				StaticRoutes: []upcloud.StaticRoute{
+					{Route: "192.168.0.0/16", Nexthop: "10.0.1.10"},
+					{Route: "172.20.0.0/16", Nexthop: "10.0.0.254", Type: upcloud.RouterStaticRouteTypeService},
+				},
+			},
+		},
+		Gateways: []upcloud.Gateway{
+			{
+				UUID:     "gateway-1",
+				Routers:  []upcloud.GatewayRouter{{UUID: "router-1"}},
+				Features: []upcloud.GatewayFeature{upcloud.GatewayFeatureNAT, upcloud.GatewayFeatureVPN},
+				Connections: []upcloud.GatewayConnection{{
+					RemoteRoutes: []upcloud.GatewayRoute{{StaticNetwork: "172.20.0.0/16"}, {StaticNetwork: "172.21.0.0/16"}},
+				}},
+			},
+		},
+		NetworkPeerings: upcloud.NetworkPeerings{
+			{
+				UUID:        "peering-1",
+				Network:     upcloud.NetworkPeeringNetwork{UUID: "net-db"},
+				PeerNetwork: upcloud.NetworkPeeringNetwork{UUID: "net-remote", IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.1.0.0/24"}}},
+			},
+			{
+				UUID:             "peering-2",
+				ConfiguredStatus: upcloud.NetworkPeeringConfiguredStatusDisabled,
+				Network:          upcloud.NetworkPeeringNetwork{UUID: "net-app"},
+				PeerNetwork:      upcloud.NetworkPeeringNetwork{UUID: "net-other", IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.2.0.0/24"}}},
+			},
+		},
+		ServerNetworks: map[string]*upcloud.Networking{
+			"server-1": {Interfaces: upcloud.ServerInterfaceSlice{
+				{Network: "public"},
+				{Network: "net-lonely"},
+				{Network: "net-app"},
+			}},
+		},
+	}
+}
+
+func TestEffectiveRoutes(t *testing.T) {
+	t.Parallel()
+
+	r := routeResources()
+	table, err := r.EffectiveRoutes("net-app")
+	require.NoError(t, err)
+	assert.Equal(t, `10.0.0.0/24 (connected)
+10.0.1.0/24 via 10.0.0.1 (router-connected-networks)
+192.168.0.0/16 via 10.0.1.10 (static-route)
+172.20.0.0/16 via 10.0.0.254 (static-route)
+172.21.0.0/16 via 10.0.0.1 (gateway)
+0.0.0.0/0 via 10.0.0.1 (gateway)
+10.1.0.0/24 via 10.0.0.1 (network-peering)
+`, table.String())
+	assert.Equal(t, upcloud.NetworkRouteTypeService, table.Routes[3].Type)
+	assert.Equal(t, upcloud.NetworkRouteTypeUser, table.Routes[2].Type)
+
+	route, ok := table.Lookup(netip.MustParseAddr("192.168.10.1"))
+	require.True(t, ok)
+	assert.Equal(t, netip.MustParseAddr("10.0.1.10"), route.Nexthop)
+	route, ok = table.Lookup(netip.MustParseAddr("8.8.8.8"))
+	require.True(t, ok)
+	assert.Equal(t, RouteSourceGateway, route.Source)
+	route, ok = table.RouteTo(netip.MustParsePrefix("10.0.0.128/25"))
+	require.True(t, ok)
+	assert.Equal(t, RouteSourceConnected, route.Source)
+	_, ok = table.RouteTo(netip.MustParsePrefix("fd00::/64"))
+	assert.False(t, ok)
+
+	table, err = r.EffectiveRoutes("net-lonely")
+	require.NoError(t, err)
+	assert.Len(t, table.Routes, 1)
+
+	_, err = r.EffectiveRoutes("missing")
+	assert.Error(t, err)
+}
+
+func TestServerRouteTo(t *testing.T) {
+	t.Parallel()
+
+	r := routeResources()
+	route, network, err := r.ServerRouteTo("server-1", netip.MustParsePrefix("10.1.0.0/26"))
+	require.NoError(t, err)
+	assert.Equal(t, "net-app", network)
+	assert.Equal(t, "peering-1", route.SourceResourceID)
+
+	route, network, err = r.ServerRouteTo("server-1", netip.MustParsePrefix("10.0.2.5/32"))
+	require.NoError(t, err)
+	assert.Equal(t, "net-lonely", network)
+	assert.Equal(t, RouteSourceConnected, route.Source)
+
+	_, _, err = r.ServerRouteTo("server-1", netip.MustParsePrefix("fd00::/64"))
+	assert.ErrorIs(t, err, ErrNoRoute)
+}
+
+func TestCheckDHCPEffectiveRoutes(t *testing.T) {
+	t.Parallel()
+
+	mismatches, err := routeResources().CheckDHCPEffectiveRoutes("net-app")
+	require.NoError(t, err)
+	descriptions := make([]string, 0, len(mismatches))
+	for _, mismatch := range mismatches {
+		descriptions = append(descriptions, mismatch.String())
+	}
+	assert.Equal(t, []string{
+		"10.1.0.0/24: computed route 10.1.0.0/24 via 10.0.0.1 (network-peering) is not reported",
+		"10.9.0.0/16: reported route via 10.0.0.1 was not computed",
+	}, descriptions)
+}
@@ -0,0 +1,148 @@
+// Package topology builds a graph of how private networks, routers, gateways, network peerings, load balancers and
+// servers connect to each other, and exports it as Graphviz DOT, Mermaid or JSON.
+//
+// A graph is built either from resources fetched with Crawl or from resources the caller already has:
+//
+//	resources, err := topology.Crawl(ctx, svc)
+//	if err != nil {
+//		return err
+//	}
+//	graph := topology.Build(resources)
+//	fmt.Println(graph.DOT())
+package topology
+
+import (
+	"slices"
+	"strings"
+)
+
+// NodeKind is the type of the resource a node represents
+type NodeKind string
+
+// EdgeKind is the type of the connection an edge represents
+type EdgeKind string
+
+const (
+	NodeKindNetwork           NodeKind = "network"
+	NodeKindRouter            NodeKind = "router"
+	NodeKindGateway           NodeKind = "gateway"
+	NodeKindGatewayConnection NodeKind = "gateway-connection"
+	NodeKindNetworkPeering    NodeKind = "network-peering"
+	NodeKindLoadBalancer      NodeKind = "load-balancer"
+	NodeKindServer            NodeKind = "server"
+	// NodeKindNexthop represents a static route next hop that is not in any network attached to the router
+	NodeKindNexthop NodeKind = "nexthop"
+
+	// EdgeKindRouterAttachment connects a router to a network attached to it
+	EdgeKindRouterAttachment EdgeKind = "router-attachment"
+	// EdgeKindStaticRoute connects a router to the network or next hop of a static route
+	EdgeKindStaticRoute EdgeKind = "static-route"
+	// EdgeKindGatewayRouter connects a gateway to the router it is attached to
+	EdgeKindGatewayRouter EdgeKind = "gateway-router"
+	// EdgeKindGatewayConnection connects a gateway to one of its connections
+	EdgeKindGatewayConnection EdgeKind = "gateway-connection"
+	// EdgeKindPeering connects a network peering to both of its networks
+	EdgeKindPeering EdgeKind = "peering"
+	// EdgeKindLoadBalancerNetwork connects a load balancer to a private network it is attached to
+	EdgeKindLoadBalancerNetwork EdgeKind = "load-balancer-network"
+	// EdgeKindServerInterface connects a server to a network through a network interface
+	EdgeKindServerInterface EdgeKind = "server-interface"
+)
+
+// Node represents a resource in the graph
+type Node struct {
+	ID   string   `json:"id"`
+	Kind NodeKind `json:"kind"`
+	Name string   `json:"name,omitempty"`
+	Zone string   `json:"zone,omitempty"`
+	// Details are short descriptions shown under the name, e.g. the IP networks of a network
+	Details []string `json:"details,omitempty"`
+	// External is set for nodes that are referenced by other resources but were not part of the crawled
+	// resources, e.g. the peer network of a network peering in another account
+	External bool `json:"external,omitempty"`
+}
+
+// Label returns the name of the node, or the ID if the node has no name, followed by the details
+func (n Node) Label() string {
+	name := n.Name
+	if name == "" {
+		name = n.ID
+	}
+	return strings.Join(append([]string{name}, n.Details...), "\n")
+}
+
+// Edge represents a connection between two nodes
+type Edge struct {
+	From  string   `json:"from"`
+	To    string   `json:"to"`
+	Kind  EdgeKind `json:"kind"`
+	Label string   `json:"label,omitempty"`
+}
+
+// Graph represents the network topology. Nodes and edges are kept in the order they were added.
+type Graph struct {
+	Nodes []Node `json:"nodes"`
+	Edges []Edge `json:"edges"`
+}
+
+// AddNode adds the node to the graph. If a node with the same ID exists, it is replaced, unless the new node is
+// external and the existing one is not.
+func (g *Graph) AddNode(node Node) {
+	if i := slices.IndexFunc(g.Nodes, func(n Node) bool { return n.ID == node.ID }); i >= 0 {
+		if node.External && !g.Nodes[i].External {
+			return
+		}
+		g.Nodes[i] = node
+		return
+	}
+	g.Nodes = append(g.Nodes, node)
+}
+
+// AddEdge adds the edge to the graph
+func (g *Graph) AddEdge(edge Edge) {
+	g.Edges = append(g.Edges, edge)
+}
+
+// Node returns the node with the given ID
+func (g *Graph) Node(id string) (Node, bool) {
+	i := slices.IndexFunc(g.Nodes, func(n Node) bool { return n.ID == id })
+	if i < 0 {
+		return Node{}, false
+	}
+	return g.Nodes[i], true
+}
+
+// EdgesOf returns the edges connected to the node with the given ID
+func (g *Graph) EdgesOf(id string) []Edge {
+	var edges []Edge
+	for _, edge := range g.Edges {
+		if edge.From == id || edge.To == id {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// UnattachedRouters returns the routers that are not attached to any network
+func (g *Graph) UnattachedRouters() []Node {
+	return g.nodesWithout(NodeKindRouter, EdgeKindRouterAttachment)
+}
+
+// IsolatedNetworks returns the networks that are neither attached to a router nor part of a network peering, i.e.
+// the servers in them can only reach each other.
+func (g *Graph) IsolatedNetworks() []Node {
+	return g.nodesWithout(NodeKindNetwork, EdgeKindRouterAttachment, EdgeKindPeering)
+}
+
+func (g *Graph) nodesWithout(kind NodeKind, edgeKinds ...EdgeKind) []Node {
+	var nodes []Node
+	for _, node := range g.Nodes {
+		if node.Kind != kind || node.External {
+			continue
+		}
+		if !slices.ContainsFunc(g.EdgesOf(node.ID), func(e Edge) bool { return slices.Contains(edgeKinds, e.Kind) }) {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes
+}
@@ -0,0 +1,145 @@
+package topology
+
+import (
+	"context"
+	"testing"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Client = (*service.Service)(nil)
+
+var testResources = fakeservice.Resources{
+	Networks: []upcloud.Network{
+		{
+			UUID:       "net-app",
+			Name:       "app",
+			Type:       upcloud.NetworkTypePrivate,
+			Zone:       "fi-hel1",
+			IPNetworks: upcloud.IPNetworkSlice{{Address: "10.0.0.0/24"}},
+			Router:     "router-1",
+			Servers:    upcloud.NetworkServerSlice{{ServerUUID: "server-1", ServerTitle: "web1"}},
+		},
+		{
+			UUID:       "net-db",
+			Name:       "db",
+			Type:       upcloud.NetworkTypePrivate,
+			Zone:       "fi-hel1",
+			IPNetworks: upcloud.IPNetworkSlice{{Address: "10.0.1.0/24"}},
+			Servers:    upcloud.NetworkServerSlice{{ServerUUID: "server-1", ServerTitle: "web1"}},
+		},
+		{
+			UUID:       "net-lonely",
+			Name:       "lonely",
+			Type:       upcloud.NetworkTypePrivate,
+			Zone:       "fi-hel1",
+			IPNetworks: upcloud.IPNetworkSlice{{Address: "10.0.2.0/24"}},
+		},
+		{UUID: "public", Type: upcloud.NetworkTypePublic, Zone: "fi-hel1"},
+	},
+	Routers: []upcloud.Router{
+		{
+			UUID:             "router-1",
+			Name:             "main",
+			AttachedNetworks: upcloud.RouterNetworkSlice{{NetworkUUID: "net-app"}},
+			StaticRoutes: []upcloud.StaticRoute{
+				{Route: "192.168.0.0/24", Nexthop: "10.0.0.10"},
+				{Route: "0.0.0.0/0", Nexthop: "172.16.0.1"},
+			},
+		},
+		{UUID: "router-2", Name: "spare"},
+	},
+	Gateways: []upcloud.Gateway{
+		{
+			UUID:      "gateway-1",
+			Name:      "nat",
+			Zone:      "fi-hel1",
+			Routers:   []upcloud.GatewayRouter{{UUID: "router-1"}},
+			Addresses: []upcloud.GatewayAddress{{Address: "192.0.2.1"}},
+			Connections: []upcloud.GatewayConnection{{
+				Name:         "office",
+				Type:         upcloud.GatewayConnectionTypeIPSec,
+				RemoteRoutes: []upcloud.GatewayRoute{{StaticNetwork: "172.20.0.0/16"}},
+			}},
+		},
+	},
+	NetworkPeerings: upcloud.NetworkPeerings{
+		{
+			UUID:        "peering-1",
+			Name:        "to-other-account",
+			State:       upcloud.NetworkPeeringStateActive,
+			Network:     upcloud.NetworkPeeringNetwork{UUID: "net-db"},
+			PeerNetwork: upcloud.NetworkPeeringNetwork{UUID: "net-remote", IPNetworks: []upcloud.NetworkPeeringIPNetwork{{Address: "10.1.0.0/24"}}},
+		},
+	},
+	LoadBalancers: []upcloud.LoadBalancer{
+		{
+			UUID: "lb-1",
+			Name: "web",
+			Zone: "fi-hel1",
+			Networks: []upcloud.LoadBalancerNetwork{
+				{Type: upcloud.LoadBalancerNetworkTypePublic},
+				{UUID: "net-app", Type: upcloud.LoadBalancerNetworkTypePrivate, IPAddresses: []upcloud.LoadBalancerIPAddress{{Address: "10.0.0.100"}}},
+			},
+		},
+	},
+	Servers: []upcloud.ServerDetails{
+		{
+			Server: upcloud.Server{UUID: "server-1", Title: "web1"},
+			Networking: upcloud.ServerNetworking{Interfaces: upcloud.ServerInterfaceSlice{
+				{Network: "public", IPAddresses: upcloud.IPAddressSlice{{Address: "192.0.2.10"}}},
+				{Network: "net-app", IPAddresses: upcloud.IPAddressSlice{{Address: "10.0.0.10"}}},
+				{Network: "net-db", IPAddresses: upcloud.IPAddressSlice{{Address: "10.0.1.10"}}},
+			}},
+		},
+	},
+}
+
+func TestCrawlAndBuild(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	resources, err := Crawl(context.Background(), c)
+	require.NoError(t, err)
+	assert.Len(t, resources.Networks, 3)
+	assert.Equal(t, []string{"GetServerNetworks server-1"}, c.Calls("GetServerNetworks"))
+
+	g := Build(resources)
+	assert.Equal(t, []Edge{
+		{From: "router-1", To: "net-app", Kind: EdgeKindRouterAttachment},
+		{From: "router-1", To: "net-app", Kind: EdgeKindStaticRoute, Label: "192.168.0.0/24 via 10.0.0.10"},
+		{From: "router-1", To: "nexthop:172.16.0.1", Kind: EdgeKindStaticRoute, Label: "0.0.0.0/0 via 172.16.0.1"},
+		{From: "gateway-1", To: "router-1", Kind: EdgeKindGatewayRouter},
+		{From: "gateway-1", To: "gateway-1/office", Kind: EdgeKindGatewayConnection, Label: "ipsec"},
+		{From: "peering-1", To: "net-db", Kind: EdgeKindPeering},
+		{From: "peering-1", To: "net-remote", Kind: EdgeKindPeering},
+		{From: "lb-1", To: "net-app", Kind: EdgeKindLoadBalancerNetwork, Label: "10.0.0.100"},
+		{From: "server-1", To: "net-app", Kind: EdgeKindServerInterface, Label: "10.0.0.10"},
+		{From: "server-1", To: "net-db", Kind: EdgeKindServerInterface, Label: "10.0.1.10"},
+	}, g.Edges)
+
+	db, ok := g.Node("net-db")
+	require.True(t, ok)
+	assert.False(t, db.External)
+	assert.Equal(t, "db\n10.0.1.0/24", db.Label())
+	remote, ok := g.Node("net-remote")
+	require.True(t, ok)
+	assert.True(t, remote.External)
+	assert.Equal(t, "net-remote\n10.1.0.0/24", remote.Label())
+	connection, _ := g.Node("gateway-1/office")
+	assert.Equal(t, []string{"172.20.0.0/16"}, connection.Details)
+
+	ids := func(nodes []Node) []string {
+		ids := make([]string, 0, len(nodes))
+		for _, node := range nodes {
+			ids = append(ids, node.ID)
+		}
+		return ids
+	}
+	assert.Equal(t, []string{"router-2"}, ids(g.UnattachedRouters()))
+	assert.Equal(t, []string{"net-lonely"}, ids(g.IsolatedNetworks()))
+}
@@ -0,0 +1,372 @@
+// Package trafficshift moves traffic between two sets of members of a load balancer backend in steps, e.g. for
+// blue/green and canary deployments.
+//
+// The load balancer splits the traffic of a backend between its members by weight, so both sets need to be members
+// of the same backend; add the new members to the backend disabled or with weight 0 before shifting. At each step,
+// the shifter sets the weights of the members so that the target set gets the step's percentage of new sessions,
+// waits for the pause and runs the health check. When the health check or an API call fails, the original weights
+// and enabled states of the members are restored.
+//
+// After the last step, the source members can be drained by disabling them and waiting for the existing sessions to
+// end, and then removed from the backend.
+//
+//	shifter, err := trafficshift.NewShifter(svc, trafficshift.Config{
+//		LoadBalancerUUID: lbUUID,
+//		Backend:          "web",
+//		From:             []string{"blue-1", "blue-2"},
+//		To:               []string{"green-1", "green-2"},
+//		Steps:            []int{10, 50, 100},
+//		Pause:            time.Minute,
+//		HealthCheck:      checkErrorRate,
+//		Drain:            true,
+//		Remove:           true,
+//	})
+//	if err != nil {
+//		return err
+//	}
+//	return shifter.Run(ctx)
+package trafficshift
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/request"
+)
+
+const (
+	DefaultPause        = 30 * time.Second
+	DefaultDrainTimeout = 5 * time.Minute
+	// maxWeight is the maximum weight of a backend member
+	maxWeight = 100
+)
+
+// DefaultSteps are the percentages of traffic moved to the target members when no steps are configured
+var DefaultSteps = []int{10, 25, 50, 75, 100}
+
+// EventType is the type of a shifter event
+type EventType string
+
+const (
+	// EventStepStarted is sent before the weights of a step are set
+	EventStepStarted EventType = "step-started"
+	// EventStepCompleted is sent when the health check of a step passes
+	EventStepCompleted EventType = "step-completed"
+	// EventHealthCheckFailed is sent when the health check of a step fails
+	EventHealthCheckFailed EventType = "health-check-failed"
+	// EventRollbackStarted is sent before the original weights and enabled states are restored
+	EventRollbackStarted EventType = "rollback-started"
+	// EventRolledBack is sent when the original weights and enabled states have been restored
+	EventRolledBack EventType = "rolled-back"
+	// EventRollbackFailed is sent when restoring the original state of a member fails
+	EventRollbackFailed EventType = "rollback-failed"
+	// EventDrainStarted is sent when the source members have been disabled
+	EventDrainStarted EventType = "drain-started"
+	// EventDrained is sent when the drain timeout of the source members has passed
+	EventDrained EventType = "drained"
+	// EventMemberRemoved is sent when a drained source member has been removed from the backend
+	EventMemberRemoved EventType = "member-removed"
+)
+
+// ErrHealthCheckFailed is returned by Run when a health check fails and the shift is rolled back
+var ErrHealthCheckFailed = errors.New("health check failed")
+
+// Event represents something that happened during a shift
+type Event struct {
+	Type    EventType
+	Time    time.Time
+	Backend string
+	// Percent is the percentage of traffic of the target members in the current step
+	Percent int
+	// Member is the member the event is about, if any
+	Member string
+	Error  error
+}
+
+// String describes the event
+func (e Event) String() string {
+	s := fmt.Sprintf("%s: %s", e.Backend, e.Type)
+	if e.Member != "" {
+		s += " " + e.Member
+	} else {
+		s += fmt.Sprintf(" %d%%", e.Percent)
+	}
+	if e.Error != nil {
+		s += ": " + e.Error.Error()
+	}
+	return s
+}
+
+// Step represents a step of a shift
+type Step struct {
+	// Index is the 0-based index of the step
+	Index int
+	// Percent is the percentage of traffic of the target members
+	Percent int
+	// FromWeight and ToWeight are the weights set to each source and target member
+	FromWeight int
+	ToWeight   int
+}
+
+// HealthCheck checks the health of the service after a step. A nil error means the shift can continue.
+type HealthCheck func(ctx context.Context, step Step) error
+
+// Client is the client needed by the shifter.
+type Client interface {
+	GetLoadBalancerBackend(ctx context.Context, r *request.GetLoadBalancerBackendRequest) (*upcloud.LoadBalancerBackend, error)
+	ModifyLoadBalancerBackendMember(ctx context.Context, r *request.ModifyLoadBalancerBackendMemberRequest) (*upcloud.LoadBalancerBackendMember, error)
+	DeleteLoadBalancerBackendMember(ctx context.Context, r *request.DeleteLoadBalancerBackendMemberRequest) error
+}
+
+// Config represents the configuration of a shift
+type Config struct {
+	LoadBalancerUUID string
+	Backend          string
+	// From are the names of the members traffic is moved from. Defaults to the members of the backend not in To.
+	From []string
+	// To are the names of the members traffic is moved to
+	To []string
+	// Steps are the increasing percentages of traffic of the target members. Defaults to DefaultSteps.
+	Steps []int
+	// Pause is the time to wait after setting the weights of a step before the health check. Defaults to
+	// DefaultPause.
+	Pause time.Duration
+	// HealthCheck is optional. Without it, the shift only waits for the pause between steps.
+	HealthCheck HealthCheck
+	// Drain disables the source members after the last step, which needs to move all traffic
+	Drain bool
+	// DrainTimeout is the time existing sessions have to end after the source members are disabled. Defaults to
+	// DefaultDrainTimeout.
+	DrainTimeout time.Duration
+	// Remove deletes the source members from the backend after draining them
+	Remove bool
+	// OnEvent is called synchronously for each event
+	OnEvent func(Event)
+}
+
+// Shifter moves traffic between members of a backend
+type Shifter struct {
+	client Client
+	config Config
+	now    func() time.Time
+	sleep  func(ctx context.Context, d time.Duration) error
+
+	// original contains the members of the backend before the shift
+	original map[string]upcloud.LoadBalancerBackendMember
+}
+
+// NewShifter returns a shifter with the given configuration
+func NewShifter(c Client, config Config) (*Shifter, error) {
+	if config.LoadBalancerUUID == "" || config.Backend == "" {
+		return nil, errors.New("load balancer UUID and backend are required")
+	}
+	if len(config.To) == 0 {
+		return nil, errors.New("at least one target member is required")
+	}
+	for _, name := range config.From {
+		if slices.Contains(config.To, name) {
+			return nil, fmt.Errorf("member %s cannot be both a source and a target", name)
+		}
+	}
+	if len(config.Steps) == 0 {
+		config.Steps = DefaultSteps
+	}
+	for i, step := range config.Steps {
+		if step < 1 || step > 100 || (i > 0 && step <= config.Steps[i-1]) {
+			return nil, fmt.Errorf("steps must be increasing percentages between 1 and 100, got %v", config.Steps)
+		}
+	}
+	if config.Drain && config.Steps[len(config.Steps)-1] != 100 {
+		return nil, errors.New("draining requires the last step to move all traffic")
+	}
+	if config.Remove && !config.Drain {
+		return nil, errors.New("removing the source members requires draining them")
+	}
+	if config.Pause <= 0 {
+		config.Pause = DefaultPause
+	}
+	if config.DrainTimeout <= 0 {
+		config.DrainTimeout = DefaultDrainTimeout
+	}
+	return &Shifter{client: c, config: config, now: time.Now, sleep: sleep}, nil
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Run shifts the traffic step by step. When a step fails, including when the context is cancelled, the members are
+// restored to their original weights and enabled states before Run returns. Failures while removing drained
+// members are returned without a rollback, as the traffic has already moved.
+func (s *Shifter) Run(ctx context.Context) error {
+	backend, err := s.client.GetLoadBalancerBackend(ctx, &request.GetLoadBalancerBackendRequest{
+		ServiceUUID: s.config.LoadBalancerUUID,
+		Name:        s.config.Backend,
+	})
+	if err != nil {
+		return err
+	}
+	s.original = make(map[string]upcloud.LoadBalancerBackendMember, len(backend.Members))
+	for _, m := range backend.Members {
+		s.original[m.Name] = m
+	}
+	from := s.config.From
+	if len(from) == 0 {
+		for _, m := range backend.Members {
+			if !slices.Contains(s.config.To, m.Name) {
+				from = append(from, m.Name)
+			}
+		}
+	}
+	if len(from) == 0 {
+		return fmt.Errorf("backend %s has no members to move traffic from", s.config.Backend)
+	}
+	for _, name := range append(slices.Clone(from), s.config.To...) {
+		if _, ok := s.original[name]; !ok {
+			return fmt.Errorf("backend %s has no member %s", s.config.Backend, name)
+		}
+	}
+
+	for i, percent := range s.config.Steps {
+		fromWeight, toWeight := Weights(percent, len(from), len(s.config.To))
+		step := Step{Index: i, Percent: percent, FromWeight: fromWeight, ToWeight: toWeight}
+		s.emit(Event{Type: EventStepStarted, Percent: percent})
+		if err := s.setMembers(ctx, from, fromWeight, true); err != nil {
+			return s.rollback(ctx, step, err)
+		}
+		if err := s.setMembers(ctx, s.config.To, toWeight, true); err != nil {
+			return s.rollback(ctx, step, err)
+		}
+		if err := s.check(ctx, step); err != nil {
+			return s.rollback(ctx, step, err)
+		}
+		s.emit(Event{Type: EventStepCompleted, Percent: percent})
+	}
+	if !s.config.Drain {
+		return nil
+	}
+
+	last := Step{Index: len(s.config.Steps) - 1, Percent: 100}
+	if err := s.setMembers(ctx, from, 0, false); err != nil {
+		return s.rollback(ctx, last, err)
+	}
+	s.emit(Event{Type: EventDrainStarted, Percent: 100})
+	if err := s.sleep(ctx, s.config.DrainTimeout); err != nil {
+		return s.rollback(ctx, last, err)
+	}
+	s.emit(Event{Type: EventDrained, Percent: 100})
+	if !s.config.Remove {
+		return nil
+	}
+
+	for _, name := range from {
+		err := s.client.DeleteLoadBalancerBackendMember(ctx, &request.DeleteLoadBalancerBackendMemberRequest{
+			ServiceUUID: s.config.LoadBalancerUUID,
+			BackendName: s.config.Backend,
+			Name:        name,
+		})
+		if err != nil {
+			return fmt.Errorf("removing drained member %s: %w", name, err)
+		}
+		s.emit(Event{Type: EventMemberRemoved, Percent: 100, Member: name})
+	}
+	return nil
+}
+
+// Weights returns the weights of each source and target member that give the target members the given percentage
+// of the traffic. The weights are as large as possible to keep the rounding error small.
+func Weights(percent, from, to int) (fromWeight, toWeight int) {
+	scale := float64(min(from, to)) * maxWeight / 100
+	toWeight = int(math.Round(float64(percent) * scale / float64(to)))
+	fromWeight = int(math.Round(float64(100-percent) * scale / float64(from)))
+	// A member with weight 0 gets no new sessions, so a share of traffic needs at least weight 1
+	if percent > 0 && toWeight == 0 {
+		toWeight = 1
+	}
+	if percent < 100 && fromWeight == 0 {
+		fromWeight = 1
+	}
+	return fromWeight, toWeight
+}
+
+func (s *Shifter) check(ctx context.Context, step Step) error {
+	if err := s.sleep(ctx, s.config.Pause); err != nil {
+		return err
+	}
+	if s.config.HealthCheck == nil {
+		return nil
+	}
+	if err := s.config.HealthCheck(ctx, step); err != nil {
+		s.emit(Event{Type: EventHealthCheckFailed, Percent: step.Percent, Error: err})
+		return fmt.Errorf("%w at %d%%: %w", ErrHealthCheckFailed, step.Percent, err)
+	}
+	return nil
+}
+
+func (s *Shifter) setMembers(ctx context.Context, names []string, weight int, enabled bool) error {
+	for _, name := range names {
+		if err := s.modifyMember(ctx, name, weight, enabled); err != nil {
+			return fmt.Errorf("modifying member %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func (s *Shifter) modifyMember(ctx context.Context, name string, weight int, enabled bool) error {
+	_, err := s.client.ModifyLoadBalancerBackendMember(ctx, &request.ModifyLoadBalancerBackendMemberRequest{
+		ServiceUUID: s.config.LoadBalancerUUID,
+		BackendName: s.config.Backend,
+		Name:        name,
+		Member: request.ModifyLoadBalancerBackendMember{
+			Weight:  &weight,
+			Enabled: &enabled,
+		},
+	})
+	return err
+}
+
+// rollback restores the original weights and enabled states of the members and returns the error that caused it.
+// The rollback is done even if the context has been cancelled.
+func (s *Shifter) rollback(ctx context.Context, step Step, cause error) error {
+	ctx = context.WithoutCancel(ctx)
+	s.emit(Event{Type: EventRollbackStarted, Percent: step.Percent, Error: cause})
+
+	names := make([]string, 0, len(s.original))
+	for name := range s.original {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	errs := []error{cause}
+	for _, name := range names {
+		m := s.original[name]
+		if err := s.modifyMember(ctx, name, m.Weight, m.Enabled); err != nil {
+			s.emit(Event{Type: EventRollbackFailed, Percent: step.Percent, Member: name, Error: err})
+			errs = append(errs, fmt.Errorf("restoring member %s: %w", name, err))
+		}
+	}
+	if len(errs) == 1 {
+		s.emit(Event{Type: EventRolledBack, Percent: step.Percent})
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Shifter) emit(e Event) {
+	if s.config.OnEvent == nil {
+		return
+	}
+	e.Time = s.now()
+	e.Backend = s.config.Backend
+	s.config.OnEvent(e)
+}
@@ -0,0 +1,199 @@
+package trafficshift
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/internal/fakeservice"
+	"github.com/UpCloudLtd/upcloud-go-api/v8/upcloud/service"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var _ Client = (*service.Service)(nil)
+
+var testResources = fakeservice.Resources{LoadBalancers: []upcloud.LoadBalancer{{
+	UUID: "lb",
+	Backends: []upcloud.LoadBalancerBackend{{
+		Name: "web",
+		Members: []upcloud.LoadBalancerBackendMember{
+			{Name: "blue-1", Weight: 100, Enabled: true},
+			{Name: "blue-2", Weight: 100, Enabled: true},
+			{Name: "green-1", Weight: 0, Enabled: false},
+			{Name: "green-2", Weight: 0, Enabled: false},
+		},
+	}},
+}}}
+
+// weights returns the weight of each member, or -1 for disabled members
+func weights(c *fakeservice.Service) map[string]int {
+	members := c.LoadBalancers[0].Backends[0].Members
+	weights := make(map[string]int, len(members))
+	for _, m := range members {
+		weights[m.Name] = m.Weight
+		if !m.Enabled {
+			weights[m.Name] = -1
+		}
+	}
+	return weights
+}
+
+func newTestShifter(t *testing.T, c *fakeservice.Service, config Config) (*Shifter, *[]Event, *[]time.Duration) {
+	t.Helper()
+
+	var events []Event
+	var sleeps []time.Duration
+	config.LoadBalancerUUID = "lb"
+	config.Backend = "web"
+	config.OnEvent = func(e Event) { events = append(events, e) }
+	shifter, err := NewShifter(c, config)
+	require.NoError(t, err)
+	shifter.now = func() time.Time { return time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC) }
+	shifter.sleep = func(_ context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return nil
+	}
+	return shifter, &events, &sleeps
+}
+
+func eventTypes(events []Event) []EventType {
+	types := make([]EventType, 0, len(events))
+	for _, e := range events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestShifter_Run(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	var steps []map[string]int
+	shifter, events, sleeps := newTestShifter(t, c, Config{
+		To:    []string{"green-1", "green-2"},
+		Steps: []int{25, 100},
+		HealthCheck: func(_ context.Context, step Step) error {
+			steps = append(steps, weights(c))
+			return nil
+		},
+		Drain:        true,
+		DrainTimeout: time.Minute,
+		Remove:       true,
+	})
+	require.NoError(t, shifter.Run(context.Background()))
+
+	assert.Equal(t, []map[string]int{
+		{"blue-1": 75, "blue-2": 75, "green-1": 25, "green-2": 25},
+		{"blue-1": 0, "blue-2": 0, "green-1": 100, "green-2": 100},
+	}, steps)
+	assert.Equal(t, map[string]int{"green-1": 100, "green-2": 100}, weights(c))
+	assert.Equal(t, []string{
+		"DeleteLoadBalancerBackendMember lb/web/blue-1",
+		"DeleteLoadBalancerBackendMember lb/web/blue-2",
+	}, c.Calls("DeleteLoadBalancerBackendMember"))
+	assert.Equal(t, []time.Duration{DefaultPause, DefaultPause, time.Minute}, *sleeps)
+	assert.Equal(t, []EventType{
+		EventStepStarted, EventStepCompleted,
+		EventStepStarted, EventStepCompleted,
+		EventDrainStarted, EventDrained,
+		EventMemberRemoved, EventMemberRemoved,
+	}, eventTypes(*events))
+	assert.Equal(t, "web: member-removed blue-1", (*events)[6].String())
+}
+
+func TestShifter_Rollback(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	shifter, events, _ := newTestShifter(t, c, Config{
+		From:  []string{"blue-1"},
+		To:    []string{"green-1"},
+		Steps: []int{10, 50, 100},
+		HealthCheck: func(_ context.Context, step Step) error {
+			if step.Percent == 50 {
+				return errors.New("error rate 5%")
+			}
+			return nil
+		},
+	})
+	err := shifter.Run(context.Background())
+	require.ErrorIs(t, err, ErrHealthCheckFailed)
+	assert.EqualError(t, err, "health check failed at 50%: error rate 5%")
+	assert.Equal(t, map[string]int{"blue-1": 100, "blue-2": 100, "green-1": -1, "green-2": -1}, weights(c))
+	assert.Equal(t, []EventType{
+		EventStepStarted, EventStepCompleted,
+		EventStepStarted, EventHealthCheckFailed, EventRollbackStarted, EventRolledBack,
+	}, eventTypes(*events))
+}
+
+func TestShifter_RollbackFailure(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	c.Errors = map[string]error{"ModifyLoadBalancerBackendMember lb/web/green-2": errors.New("conflict")}
+	shifter, events, _ := newTestShifter(t, c, Config{To: []string{"green-1", "green-2"}})
+	err := shifter.Run(context.Background())
+	assert.EqualError(t, err, "modifying member green-2: conflict\nrestoring member green-2: conflict")
+	assert.NotErrorIs(t, err, ErrHealthCheckFailed)
+	assert.Equal(t, []EventType{EventStepStarted, EventRollbackStarted, EventRollbackFailed}, eventTypes(*events))
+}
+
+func TestShifter_Cancel(t *testing.T) {
+	t.Parallel()
+
+	c := fakeservice.New(testResources)
+	shifter, _, _ := newTestShifter(t, c, Config{To: []string{"green-1"}, Drain: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	shifter.sleep = func(ctx context.Context, _ time.Duration) error {
+		if weights(c)["blue-1"] != -1 {
+			return nil
+		}
+		cancel()
+		return ctx.Err()
+	}
+	err := shifter.Run(ctx)
+	require.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, map[string]int{"blue-1": 100, "blue-2": 100, "green-1": -1, "green-2": -1}, weights(c))
+}
+
+func TestNewShifter_Invalid(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		config Config
+		want   string
+	}{
+		{Config{Backend: "web", To: []string{"a"}}, "load balancer UUID and backend are required"},
+		{Config{LoadBalancerUUID: "lb", Backend: "web"}, "at least one target member is required"},
+		{Config{LoadBalancerUUID: "lb", Backend: "web", From: []string{"a"}, To: []string{"a"}}, "member a cannot be both a source and a target"},
+		{Config{LoadBalancerUUID: "lb", Backend: "web", To: []string{"a"}, Steps: []int{50, 50}}, "steps must be increasing percentages between 1 and 100, got [50 50]"},
+		{Config{LoadBalancerUUID: "lb", Backend: "web", To: []string{"a"}, Steps: []int{50}, Drain: true}, "draining requires the last step to move all traffic"},
+		{Config{LoadBalancerUUID: "lb", Backend: "web", To: []string{"a"}, Remove: true}, "removing the source members requires draining them"},
+	} {
+		_, err := NewShifter(fakeservice.New(testResources), test.config)
+		assert.EqualError(t, err, test.want)
+	}
+}
+
+func TestWeights(t *testing.T) {
+	t.Parallel()
+
+	for _, test := range []struct {
+		percent, from, to int
+		fromWeight        int
+		toWeight          int
+	}{
+		{10, 1, 1, 90, 10},
+		{25, 3, 1, 25, 25},
+		{50, 1, 4, 50, 13},
+		{1, 1, 4, 99, 1},
+		{100, 2, 2, 0, 100},
+		{99, 4, 1, 1, 99},
+	} {
+		fromWeight, toWeight := Weights(test.percent, test.from, test.to)
+		assert.Equal(t, [2]int{test.fromWeight, test.toWeight}, [2]int{fromWeight, toWeight}, "%+v", test)
+	}
+}